@@ -0,0 +1,163 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/alesr/stdservices/audit/repository"
+	"github.com/alesr/stdservices/pkg/strutil"
+	"github.com/alesr/stdservices/users"
+	"go.uber.org/zap"
+)
+
+// verifyBatchSize is the number of events Verify reads at once
+const verifyBatchSize = 500
+
+var _ Service = (*DefaultService)(nil)
+
+type (
+	// Service defines the service interface
+	Service interface {
+		// Record appends an entry to the audit log.
+		// It satisfies the users service auditor, see users.WithAuditor.
+		Record(ctx context.Context, entry users.AuditEntry) error
+
+		// List returns the events matching the input, newest first
+		List(ctx context.Context, in ListEventsInput) ([]Event, error)
+
+		// Verify checks the hash chain of every event and returns the last event of the chain.
+		// It returns an error wrapping ErrChainBroken when an event was altered, removed or inserted.
+		Verify(ctx context.Context) (*ChainHead, error)
+	}
+
+	repo interface {
+		AppendEvent(ctx context.Context, seal func(lastSeq int64, lastHash string) (*repository.Event, error)) (*repository.Event, error)
+		SelectEvents(ctx context.Context, filter repository.EventFilter) ([]repository.Event, error)
+		SelectEventsAfter(ctx context.Context, seq int64, limit int) ([]repository.Event, error)
+	}
+)
+
+type DefaultService struct {
+	logger *zap.Logger
+	repo   repo
+	now    func() time.Time
+}
+
+// New instantiates a new audit service
+func New(logger *zap.Logger, repo repo) *DefaultService {
+	return &DefaultService{
+		logger: logger,
+		repo:   repo,
+		now:    func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Record appends an entry to the audit log, chained to the previous event by its hash
+func (s *DefaultService) Record(ctx context.Context, entry users.AuditEntry) error {
+	metadata, err := encodeMetadata(entry.Metadata)
+	if err != nil {
+		return fmt.Errorf("could not encode metadata: %s", err)
+	}
+
+	occurredAt := entry.OccurredAt
+	if occurredAt.IsZero() {
+		occurredAt = s.now()
+	}
+
+	// Times are stored with a precision of a microsecond, which the hash must match
+	occurredAt = occurredAt.UTC().Truncate(time.Microsecond)
+
+	if _, err := s.repo.AppendEvent(ctx, func(lastSeq int64, lastHash string) (*repository.Event, error) {
+		prevHash := lastHash
+		if lastSeq == 0 {
+			prevHash = genesisHash
+		}
+
+		e := repository.Event{
+			Seq:        lastSeq + 1,
+			Action:     string(entry.Action),
+			ActorID:    entry.ActorID,
+			SubjectID:  entry.SubjectID,
+			IP:         entry.IP,
+			UserAgent:  strutil.Truncate(entry.UserAgent, maxUserAgentLen),
+			Outcome:    string(entry.Outcome),
+			Reason:     entry.Reason,
+			Metadata:   metadata,
+			OccurredAt: occurredAt,
+			PrevHash:   prevHash,
+		}
+		e.Hash = hashEvent(&e)
+		return &e, nil
+	}); err != nil {
+		return fmt.Errorf("could not append event: %s", err)
+	}
+	return nil
+}
+
+// List returns the events matching the input, newest first
+func (s *DefaultService) List(ctx context.Context, in ListEventsInput) ([]Event, error) {
+	if err := in.validate(); err != nil {
+		return nil, fmt.Errorf("could not validate list events input: %w", err)
+	}
+
+	limit := in.Limit
+	switch {
+	case limit <= 0:
+		limit = defaultListLimit
+	case limit > maxListLimit:
+		limit = maxListLimit
+	}
+
+	storageEvents, err := s.repo.SelectEvents(ctx, repository.EventFilter{
+		ActorID:   in.ActorID,
+		SubjectID: in.SubjectID,
+		Action:    string(in.Action),
+		Outcome:   string(in.Outcome),
+		Since:     sql.NullTime{Time: in.Since.UTC(), Valid: !in.Since.IsZero()},
+		Until:     sql.NullTime{Time: in.Until.UTC(), Valid: !in.Until.IsZero()},
+		BeforeSeq: in.BeforeSeq,
+		Limit:     limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not select events: %s", err)
+	}
+
+	events := make([]Event, 0, len(storageEvents))
+	for i := range storageEvents {
+		event, err := newEventFromRepository(&storageEvents[i])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse storage event %d: %s", storageEvents[i].Seq, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Verify walks the chain from the first event, checking that sequence numbers follow each other,
+// that every event links to the hash of the previous one and that its own hash matches its fields.
+func (s *DefaultService) Verify(ctx context.Context) (*ChainHead, error) {
+	head := ChainHead{Hash: genesisHash}
+
+	for {
+		batch, err := s.repo.SelectEventsAfter(ctx, head.Seq, verifyBatchSize)
+		if err != nil {
+			return nil, fmt.Errorf("could not select events: %s", err)
+		}
+
+		for i := range batch {
+			e := &batch[i]
+
+			if e.Seq != head.Seq+1 || e.PrevHash != head.Hash || hashEvent(e) != e.Hash {
+				s.logger.Error("audit chain is broken", zap.Int64("seq", head.Seq+1))
+				return nil, fmt.Errorf("%w at event %d", ErrChainBroken, head.Seq+1)
+			}
+			head = ChainHead{Seq: e.Seq, Hash: e.Hash}
+		}
+
+		if len(batch) < verifyBatchSize {
+			return &head, nil
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/audit/repository"
+	"github.com/alesr/stdservices/users"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// chainRepository returns a repository mock keeping the appended events in memory
+func chainRepository(events *[]repository.Event) *repositoryMock {
+	return &repositoryMock{
+		appendEventFunc: func(ctx context.Context, seal func(lastSeq int64, lastHash string) (*repository.Event, error)) (*repository.Event, error) {
+			var (
+				lastSeq  int64
+				lastHash string
+			)
+
+			if n := len(*events); n > 0 {
+				lastSeq, lastHash = (*events)[n-1].Seq, (*events)[n-1].Hash
+			}
+
+			e, err := seal(lastSeq, lastHash)
+			if err != nil {
+				return nil, err
+			}
+			*events = append(*events, *e)
+			return e, nil
+		},
+		selectEventsAfterFunc: func(ctx context.Context, seq int64, limit int) ([]repository.Event, error) {
+			var res []repository.Event
+			for _, e := range *events {
+				if e.Seq > seq && len(res) < limit {
+					res = append(res, e)
+				}
+			}
+			return res, nil
+		},
+	}
+}
+
+func TestRecord(t *testing.T) {
+	t.Parallel()
+
+	t.Run("events are chained", func(t *testing.T) {
+		var events []repository.Event
+
+		svc := New(zap.NewNop(), chainRepository(&events))
+
+		occurredAt := time.Date(2022, 6, 15, 12, 0, 0, 123456789, time.UTC)
+
+		require.NoError(t, svc.Record(context.Background(), users.AuditEntry{
+			Action:     users.AuditLogin,
+			SubjectID:  "1",
+			IP:         "192.0.2.1",
+			UserAgent:  "curl/7.79.1",
+			Outcome:    users.AuditOutcomeFailure,
+			Reason:     string(users.CodePasswordInvalid),
+			OccurredAt: occurredAt,
+		}))
+
+		require.NoError(t, svc.Record(context.Background(), users.AuditEntry{
+			Action:     users.AuditRoleChanged,
+			ActorID:    "2",
+			SubjectID:  "1",
+			Outcome:    users.AuditOutcomeSuccess,
+			Metadata:   map[string]string{"role": "admin"},
+			OccurredAt: occurredAt,
+		}))
+
+		require.Len(t, events, 2)
+
+		assert.Equal(t, int64(1), events[0].Seq)
+		assert.Equal(t, genesisHash, events[0].PrevHash)
+		assert.Equal(t, "user.login", events[0].Action)
+		assert.Equal(t, "failure", events[0].Outcome)
+		assert.Equal(t, "user.password_invalid", events[0].Reason)
+		assert.Equal(t, occurredAt.Truncate(time.Microsecond), events[0].OccurredAt)
+		assert.Len(t, events[0].Hash, 64)
+
+		assert.Equal(t, int64(2), events[1].Seq)
+		assert.Equal(t, events[0].Hash, events[1].PrevHash)
+		assert.Equal(t, `{"role":"admin"}`, events[1].Metadata)
+		assert.NotEqual(t, events[0].Hash, events[1].Hash)
+	})
+
+	t.Run("user agent is truncated", func(t *testing.T) {
+		var events []repository.Event
+
+		svc := New(zap.NewNop(), chainRepository(&events))
+
+		require.NoError(t, svc.Record(context.Background(), users.AuditEntry{
+			Action:    users.AuditLogin,
+			UserAgent: "é" + string(make([]byte, maxUserAgentLen)),
+			Outcome:   users.AuditOutcomeSuccess,
+		}))
+
+		require.Len(t, events, 1)
+		assert.Len(t, events[0].UserAgent, maxUserAgentLen)
+		assert.False(t, events[0].OccurredAt.IsZero())
+	})
+
+	t.Run("append error", func(t *testing.T) {
+		svc := New(zap.NewNop(), &repositoryMock{
+			appendEventFunc: func(ctx context.Context, seal func(lastSeq int64, lastHash string) (*repository.Event, error)) (*repository.Event, error) {
+				return nil, errors.New("connection refused")
+			},
+		})
+
+		err := svc.Record(context.Background(), users.AuditEntry{Action: users.AuditLogin})
+		assert.ErrorContains(t, err, "could not append event")
+	})
+}
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	since := time.Date(2022, 6, 15, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		givenInput     ListEventsInput
+		expectedFilter repository.EventFilter
+		expectedError  error
+	}{
+		{
+			name:       "filters",
+			givenInput: ListEventsInput{SubjectID: "1", Action: users.AuditLogin, Outcome: users.AuditOutcomeFailure, Since: since, BeforeSeq: 10},
+			expectedFilter: repository.EventFilter{
+				SubjectID: "1", Action: "user.login", Outcome: "failure", Since: nullTime(since), BeforeSeq: 10, Limit: defaultListLimit,
+			},
+		},
+		{
+			name:           "limit is capped",
+			givenInput:     ListEventsInput{Limit: 5000},
+			expectedFilter: repository.EventFilter{Limit: maxListLimit},
+		},
+		{
+			name:          "invalid action",
+			givenInput:    ListEventsInput{Action: "user.hacked"},
+			expectedError: errActionInvalid,
+		},
+		{
+			name:          "invalid outcome",
+			givenInput:    ListEventsInput{Outcome: "maybe"},
+			expectedError: errOutcomeInvalid,
+		},
+		{
+			name:          "invalid time range",
+			givenInput:    ListEventsInput{Since: since, Until: since},
+			expectedError: errTimeRangeInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := New(zap.NewNop(), &repositoryMock{
+				selectEventsFunc: func(ctx context.Context, filter repository.EventFilter) ([]repository.Event, error) {
+					assert.Equal(t, tc.expectedFilter, filter)
+					return []repository.Event{{Seq: 3, Action: "user.role_changed", Outcome: "success", Metadata: `{"role":"admin"}`}}, nil
+				},
+			})
+
+			actual, err := svc.List(context.Background(), tc.givenInput)
+			if tc.expectedError != nil {
+				assert.ErrorIs(t, err, tc.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, actual, 1)
+			assert.Equal(t, users.AuditRoleChanged, actual[0].Action)
+			assert.Equal(t, map[string]string{"role": "admin"}, actual[0].Metadata)
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	record := func(t *testing.T, n int) []repository.Event {
+		var events []repository.Event
+
+		svc := New(zap.NewNop(), chainRepository(&events))
+		for i := 0; i < n; i++ {
+			require.NoError(t, svc.Record(context.Background(), users.AuditEntry{
+				Action: users.AuditLogin, SubjectID: "1", Outcome: users.AuditOutcomeSuccess,
+			}))
+		}
+		return events
+	}
+
+	testCases := []struct {
+		name          string
+		givenTamper   func(events []repository.Event) []repository.Event
+		expectedSeq   int64
+		expectedError string
+	}{
+		{
+			name:        "intact chain",
+			givenTamper: func(events []repository.Event) []repository.Event { return events },
+			expectedSeq: 3,
+		},
+		{
+			name: "altered event",
+			givenTamper: func(events []repository.Event) []repository.Event {
+				events[1].Outcome = "failure"
+				return events
+			},
+			expectedError: "audit chain is broken at event 2",
+		},
+		{
+			name: "removed event",
+			givenTamper: func(events []repository.Event) []repository.Event {
+				return append(events[:1], events[2:]...)
+			},
+			expectedError: "audit chain is broken at event 2",
+		},
+		{
+			name: "rehashed event",
+			givenTamper: func(events []repository.Event) []repository.Event {
+				events[0].SubjectID = "2"
+				events[0].Hash = hashEvent(&events[0])
+				return events
+			},
+			expectedError: "audit chain is broken at event 2",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			events := tc.givenTamper(record(t, 3))
+			svc := New(zap.NewNop(), chainRepository(&events))
+
+			head, err := svc.Verify(context.Background())
+			if tc.expectedError != "" {
+				assert.ErrorIs(t, err, ErrChainBroken)
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedSeq, head.Seq)
+			assert.Equal(t, events[len(events)-1].Hash, head.Hash)
+		})
+	}
+
+	t.Run("empty chain", func(t *testing.T) {
+		var events []repository.Event
+
+		head, err := New(zap.NewNop(), chainRepository(&events)).Verify(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, &ChainHead{Hash: genesisHash}, head)
+	})
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: true}
+}
@@ -0,0 +1,24 @@
+package audit
+
+type E struct {
+	msg string
+}
+
+func newE(msg string) E {
+	return E{msg: msg}
+}
+
+func (e E) Error() string {
+	return e.msg
+}
+
+// ErrChainBroken is returned by Verify when an audit event was altered, removed or inserted
+var ErrChainBroken = newE("audit chain is broken")
+
+var (
+	// Enumerate service errors
+
+	errActionInvalid    = newE("audit action is invalid")
+	errOutcomeInvalid   = newE("audit outcome is invalid")
+	errTimeRangeInvalid = newE("audit time range is invalid")
+)
@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alesr/stdservices/audit/repository"
+	"github.com/alesr/stdservices/users"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 1000
+
+	// maxUserAgentLen bounds the user agents recorded, which clients choose freely
+	maxUserAgentLen = 512
+)
+
+// genesisHash is the previous hash of the first event of the chain
+var genesisHash = strings.Repeat("0", sha256.Size*2)
+
+// Event represents an audit event and its link in the hash chain
+type Event struct {
+	Seq        int64
+	Action     users.AuditAction
+	ActorID    string
+	SubjectID  string
+	IP         string
+	UserAgent  string
+	Outcome    users.AuditOutcome
+	Reason     string
+	Metadata   map[string]string
+	OccurredAt time.Time
+	PrevHash   string
+	Hash       string
+}
+
+// ChainHead identifies the last event of a verified chain.
+// Keeping it outside the database detects the removal of the latest events, which the chain alone cannot.
+type ChainHead struct {
+	Seq  int64
+	Hash string
+}
+
+// ListEventsInput selects the events returned by Service.List. Empty fields match any event.
+type ListEventsInput struct {
+	ActorID   string
+	SubjectID string
+	Action    users.AuditAction
+	Outcome   users.AuditOutcome
+	// Since and Until bound the time of events, Until excluded. Zero times are unbounded.
+	Since time.Time
+	Until time.Time
+	// BeforeSeq only matches events older than the given sequence number, to page through results
+	BeforeSeq int64
+	// Limit defaults to 50 and is capped at 1000
+	Limit int
+}
+
+func (in *ListEventsInput) validate() error {
+	if in.Action != "" && !validAction(in.Action) {
+		return errActionInvalid
+	}
+
+	if in.Outcome != "" && in.Outcome != users.AuditOutcomeSuccess && in.Outcome != users.AuditOutcomeFailure {
+		return errOutcomeInvalid
+	}
+
+	if !in.Since.IsZero() && !in.Until.IsZero() && !in.Since.Before(in.Until) {
+		return errTimeRangeInvalid
+	}
+	return nil
+}
+
+func validAction(action users.AuditAction) bool {
+	for _, known := range users.AuditActions {
+		if action == known {
+			return true
+		}
+	}
+	return false
+}
+
+// hashEvent returns the hex SHA-256 digest chaining the event to the previous one.
+// Fields are length prefixed, so that moving bytes from a field to the next changes the digest.
+func hashEvent(e *repository.Event) string {
+	h := sha256.New()
+
+	for _, field := range []string{
+		strconv.FormatInt(e.Seq, 10), e.Action, e.ActorID, e.SubjectID, e.IP, e.UserAgent,
+		e.Outcome, e.Reason, e.Metadata, e.OccurredAt.UTC().Format(time.RFC3339Nano), e.PrevHash,
+	} {
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(len(field)))
+		h.Write(size[:])
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// encodeMetadata encodes metadata as JSON, with sorted keys so that the stored bytes are stable
+func encodeMetadata(metadata map[string]string) (string, error) {
+	if len(metadata) == 0 {
+		return "", nil
+	}
+
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func newEventFromRepository(e *repository.Event) (Event, error) {
+	event := Event{
+		Seq:        e.Seq,
+		Action:     users.AuditAction(e.Action),
+		ActorID:    e.ActorID,
+		SubjectID:  e.SubjectID,
+		IP:         e.IP,
+		UserAgent:  e.UserAgent,
+		Outcome:    users.AuditOutcome(e.Outcome),
+		Reason:     e.Reason,
+		OccurredAt: e.OccurredAt,
+		PrevHash:   e.PrevHash,
+		Hash:       e.Hash,
+	}
+
+	if e.Metadata != "" {
+		if err := json.Unmarshal([]byte(e.Metadata), &event.Metadata); err != nil {
+			return Event{}, err
+		}
+	}
+	return event, nil
+}
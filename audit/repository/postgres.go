@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// appendLockID identifies the advisory lock serializing appends to the audit chain
+const appendLockID int64 = 7_361_421_086_513_410_048
+
+// eventColumns lists the audit_events columns in the order scanEvent expects
+const eventColumns string = "seq,action,actor_id,subject_id,ip,user_agent,outcome,reason,metadata,occurred_at,prev_hash,hash"
+
+const (
+	// Enumerate postgresql query strings
+
+	lockAppendQuery string = "SELECT pg_advisory_xact_lock($1);"
+
+	selectLastEventQuery string = "SELECT seq, hash FROM audit_events ORDER BY seq DESC LIMIT 1;"
+
+	insertEventQuery string = "INSERT INTO audit_events (" + eventColumns + `)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12);`
+
+	// selectEventsQuery ignores the filters given as empty strings, NULL times or a zero sequence number
+	selectEventsQuery string = "SELECT " + eventColumns + ` FROM audit_events
+	WHERE ($1 = '' OR actor_id = $1) AND ($2 = '' OR subject_id = $2)
+	AND ($3 = '' OR action = $3) AND ($4 = '' OR outcome = $4)
+	AND ($5::TIMESTAMP IS NULL OR occurred_at >= $5) AND ($6::TIMESTAMP IS NULL OR occurred_at < $6)
+	AND ($7 = 0 OR seq < $7)
+	ORDER BY seq DESC LIMIT $8;`
+
+	selectEventsAfterQuery string = "SELECT " + eventColumns + " FROM audit_events WHERE seq > $1 ORDER BY seq LIMIT $2;"
+)
+
+// Postgres represents an audit repository instance with the given database connection
+type Postgres struct{ *sqlx.DB }
+
+// NewPostgres creates a new audit repository instance
+func NewPostgres(dbConn *sqlx.DB) *Postgres {
+	return &Postgres{dbConn}
+}
+
+// AppendEvent inserts the event returned by seal at the end of the chain and returns it.
+// Seal is given the sequence number and hash of the last event, zero and empty for the first event.
+// Appends hold a transaction level advisory lock, so that concurrent appends cannot fork the chain.
+// The lock is global: appends, and therefore audited logins, are serialized across every process sharing the database.
+func (p *Postgres) AppendEvent(ctx context.Context, seal func(lastSeq int64, lastHash string) (*Event, error)) (*Event, error) {
+	tx, err := p.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, lockAppendQuery, appendLockID); err != nil {
+		return nil, fmt.Errorf("could not lock audit chain: %s", err)
+	}
+
+	var (
+		lastSeq  int64
+		lastHash string
+	)
+
+	if err := tx.QueryRowContext(ctx, selectLastEventQuery).Scan(&lastSeq, &lastHash); err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("could not select last event: %s", err)
+	}
+
+	e, err := seal(lastSeq, lastHash)
+	if err != nil {
+		return nil, fmt.Errorf("could not seal event: %s", err)
+	}
+
+	if _, err := tx.ExecContext(
+		ctx, insertEventQuery, e.Seq, e.Action, e.ActorID, e.SubjectID, e.IP, e.UserAgent,
+		e.Outcome, e.Reason, e.Metadata, e.OccurredAt, e.PrevHash, e.Hash,
+	); err != nil {
+		return nil, fmt.Errorf("could not insert event: %s", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %s", err)
+	}
+	return e, nil
+}
+
+// SelectEvents selects the events matching the filter, newest first
+func (p *Postgres) SelectEvents(ctx context.Context, filter EventFilter) ([]Event, error) {
+	rows, err := p.QueryContext(
+		ctx, selectEventsQuery, filter.ActorID, filter.SubjectID, filter.Action, filter.Outcome,
+		filter.Since, filter.Until, filter.BeforeSeq, filter.Limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not select events: %s", err)
+	}
+	defer rows.Close()
+
+	events, err := scanEvents(rows)
+	if err != nil {
+		return nil, fmt.Errorf("could not scan events: %s", err)
+	}
+	return events, nil
+}
+
+// SelectEventsAfter selects up to limit events following the given sequence number, oldest first
+func (p *Postgres) SelectEventsAfter(ctx context.Context, seq int64, limit int) ([]Event, error) {
+	rows, err := p.QueryContext(ctx, selectEventsAfterQuery, seq, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not select events: %s", err)
+	}
+	defer rows.Close()
+
+	events, err := scanEvents(rows)
+	if err != nil {
+		return nil, fmt.Errorf("could not scan events: %s", err)
+	}
+	return events, nil
+}
+
+func scanEvents(rows *sql.Rows) ([]Event, error) {
+	var events []Event
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(
+			&e.Seq, &e.Action, &e.ActorID, &e.SubjectID, &e.IP, &e.UserAgent,
+			&e.Outcome, &e.Reason, &e.Metadata, &e.OccurredAt, &e.PrevHash, &e.Hash,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Event represents an audit event in the database table
+type Event struct {
+	Seq        int64
+	Action     string
+	ActorID    string
+	SubjectID  string
+	IP         string
+	UserAgent  string
+	Outcome    string
+	Reason     string
+	Metadata   string
+	OccurredAt time.Time
+	PrevHash   string
+	Hash       string
+}
+
+// EventFilter selects audit events. Empty fields match any event.
+type EventFilter struct {
+	ActorID   string
+	SubjectID string
+	Action    string
+	Outcome   string
+	Since     sql.NullTime
+	Until     sql.NullTime
+	// BeforeSeq only matches events older than the given sequence number, if positive
+	BeforeSeq int64
+	Limit     int
+}
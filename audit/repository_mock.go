@@ -0,0 +1,39 @@
+package audit
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alesr/stdservices/audit/repository"
+)
+
+var _ repo = (*repositoryMock)(nil)
+
+type repositoryMock struct {
+	appendEventFunc       func(ctx context.Context, seal func(lastSeq int64, lastHash string) (*repository.Event, error)) (*repository.Event, error)
+	selectEventsFunc      func(ctx context.Context, filter repository.EventFilter) ([]repository.Event, error)
+	selectEventsAfterFunc func(ctx context.Context, seq int64, limit int) ([]repository.Event, error)
+}
+
+func (m *repositoryMock) AppendEvent(
+	ctx context.Context, seal func(lastSeq int64, lastHash string) (*repository.Event, error),
+) (*repository.Event, error) {
+	if m.appendEventFunc == nil {
+		return nil, errors.New("repositoryMock.appendEventFunc is nil")
+	}
+	return m.appendEventFunc(ctx, seal)
+}
+
+func (m *repositoryMock) SelectEvents(ctx context.Context, filter repository.EventFilter) ([]repository.Event, error) {
+	if m.selectEventsFunc == nil {
+		return nil, errors.New("repositoryMock.selectEventsFunc is nil")
+	}
+	return m.selectEventsFunc(ctx, filter)
+}
+
+func (m *repositoryMock) SelectEventsAfter(ctx context.Context, seq int64, limit int) ([]repository.Event, error) {
+	if m.selectEventsAfterFunc == nil {
+		return nil, errors.New("repositoryMock.selectEventsAfterFunc is nil")
+	}
+	return m.selectEventsAfterFunc(ctx, seq, limit)
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alesr/stdservices/audit"
+	"github.com/alesr/stdservices/users"
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+type (
+	// service is the users service as seen by operators
+	service interface {
+		users.Service
+		users.AdminService
+	}
+
+	cli struct {
+		svc     service
+		audit   audit.Service
+		migrate func(ctx context.Context) (int, error)
+		in      io.Reader
+		out     io.Writer
+		format  string
+	}
+
+	userOutput struct {
+		ID            string     `json:"id"`
+		Fullname      string     `json:"fullname"`
+		Username      string     `json:"username"`
+		Birthdate     string     `json:"birthdate"`
+		Email         string     `json:"email"`
+		EmailVerified bool       `json:"email_verified"`
+		Role          string     `json:"role"`
+		Locale        string     `json:"locale"`
+		CreatedAt     time.Time  `json:"created_at"`
+		UpdatedAt     time.Time  `json:"updated_at"`
+		DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+	}
+
+	actionOutput struct {
+		ID     string `json:"id"`
+		Action string `json:"action"`
+	}
+
+	migrateOutput struct {
+		Applied int `json:"applied"`
+	}
+
+	auditEventOutput struct {
+		Seq        int64             `json:"seq"`
+		OccurredAt time.Time         `json:"occurred_at"`
+		Action     string            `json:"action"`
+		ActorID    string            `json:"actor_id"`
+		SubjectID  string            `json:"subject_id"`
+		IP         string            `json:"ip"`
+		UserAgent  string            `json:"user_agent"`
+		Outcome    string            `json:"outcome"`
+		Reason     string            `json:"reason"`
+		Metadata   map[string]string `json:"metadata,omitempty"`
+	}
+
+	auditHeadOutput struct {
+		Seq  int64  `json:"seq"`
+		Hash string `json:"hash"`
+	}
+)
+
+// execute runs the named command with its arguments
+func (c *cli) execute(ctx context.Context, name string, args []string) error {
+	switch name {
+	case "create-user":
+		return c.createUser(ctx, args)
+	case "promote":
+		return c.action(name, args, "promoted", func(id string) error {
+			return c.svc.SetRole(ctx, id, string(users.RoleAdmin))
+		})
+	case "demote":
+		return c.action(name, args, "demoted", func(id string) error {
+			return c.svc.SetRole(ctx, id, string(users.RoleUser))
+		})
+	case "delete":
+		return c.action(name, args, "deleted", func(id string) error {
+			return c.svc.Delete(ctx, id)
+		})
+	case "restore":
+		return c.action(name, args, "restored", func(id string) error {
+			return c.svc.Restore(ctx, id)
+		})
+	case "verify-email":
+		return c.action(name, args, "email verified", func(id string) error {
+			return c.svc.ForceVerifyEmail(ctx, id)
+		})
+	case "reset-password":
+		return c.resetPassword(ctx, args)
+	case "revoke-tokens":
+		return c.action(name, args, "tokens revoked", func(id string) error {
+			return c.svc.RevokeTokens(ctx, id)
+		})
+	case "list":
+		return c.list(ctx, args)
+	case "audit":
+		return c.listAuditEvents(ctx, args)
+	case "audit-verify":
+		return c.verifyAudit(ctx, args)
+	case "migrate":
+		return c.runMigrate(ctx, args)
+	default:
+		return fmt.Errorf("%w: %s", errCommandUnknown, name)
+	}
+}
+
+func (c *cli) createUser(ctx context.Context, args []string) error {
+	flags := newFlagSet("create-user")
+
+	var in users.CreateUserInput
+	flags.StringVar(&in.Fullname, "fullname", "", "full name")
+	flags.StringVar(&in.Username, "username", "", "username")
+	flags.StringVar(&in.Birthdate, "birthdate", "", "birthdate as YYYY-MM-DD")
+	flags.StringVar(&in.Email, "email", "", "email address")
+	flags.StringVar(&in.Password, "password", "", "password, read from stdin if empty")
+	flags.StringVar(&in.Locale, "locale", "", "preferred language as a BCP 47 tag")
+	admin := flags.Bool("admin", false, "grant the admin role")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	password, err := c.password(in.Password)
+	if err != nil {
+		return err
+	}
+	in.Password, in.ConfirmPassword = password, password
+
+	user, err := c.svc.Create(ctx, in)
+	if err != nil {
+		return fmt.Errorf("could not create user: %w", err)
+	}
+
+	if *admin {
+		if err := c.svc.SetRole(ctx, user.ID, string(users.RoleAdmin)); err != nil {
+			return fmt.Errorf("could not promote user %s: %w", user.ID, err)
+		}
+		user.Role = users.RoleAdmin
+	}
+	return c.writeUsers([]users.User{*user})
+}
+
+func (c *cli) resetPassword(ctx context.Context, args []string) error {
+	flags := newFlagSet("reset-password")
+	password := flags.String("password", "", "new password, read from stdin if empty")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	id, err := userID(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	p, err := c.password(*password)
+	if err != nil {
+		return err
+	}
+
+	if err := c.svc.ResetPassword(ctx, id, p); err != nil {
+		return fmt.Errorf("could not reset password: %w", err)
+	}
+	return c.write(actionOutput{ID: id, Action: "password reset"})
+}
+
+func (c *cli) list(ctx context.Context, args []string) error {
+	flags := newFlagSet("list")
+
+	var in users.ListUsersInput
+	flags.StringVar(&in.Query, "q", "", "search the id, username, email and full name")
+	flags.StringVar(&in.Role, "role", "", "only list users with the role")
+	flags.BoolVar(&in.IncludeDeleted, "deleted", false, "include deleted users")
+	flags.IntVar(&in.Limit, "limit", 50, "maximum number of users")
+	flags.IntVar(&in.Offset, "offset", 0, "number of users to skip")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	list, err := c.svc.List(ctx, in)
+	if err != nil {
+		return fmt.Errorf("could not list users: %w", err)
+	}
+	return c.writeUsers(list)
+}
+
+func (c *cli) listAuditEvents(ctx context.Context, args []string) error {
+	flags := newFlagSet("audit")
+
+	var in audit.ListEventsInput
+	flags.StringVar(&in.ActorID, "actor", "", "only list events of the actor")
+	flags.StringVar(&in.SubjectID, "subject", "", "only list events of the user")
+	flags.Func("action", "only list events of the action, such as user.login", func(v string) error {
+		in.Action = users.AuditAction(v)
+		return nil
+	})
+	flags.Func("outcome", "only list events of the outcome: success or failure", func(v string) error {
+		in.Outcome = users.AuditOutcome(v)
+		return nil
+	})
+	flags.Func("since", "only list events at or after the RFC 3339 time", timeFlag(&in.Since))
+	flags.Func("until", "only list events before the RFC 3339 time", timeFlag(&in.Until))
+	flags.Int64Var(&in.BeforeSeq, "before", 0, "only list events older than the sequence number")
+	flags.IntVar(&in.Limit, "limit", 50, "maximum number of events")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	events, err := c.audit.List(ctx, in)
+	if err != nil {
+		return fmt.Errorf("could not list audit events: %w", err)
+	}
+
+	out := make([]auditEventOutput, 0, len(events))
+	for _, e := range events {
+		out = append(out, auditEventOutput{
+			Seq:        e.Seq,
+			OccurredAt: e.OccurredAt,
+			Action:     string(e.Action),
+			ActorID:    e.ActorID,
+			SubjectID:  e.SubjectID,
+			IP:         e.IP,
+			UserAgent:  e.UserAgent,
+			Outcome:    string(e.Outcome),
+			Reason:     e.Reason,
+			Metadata:   e.Metadata,
+		})
+	}
+
+	if c.format == formatJSON {
+		return c.writeJSON(out)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEQ\tTIME\tACTION\tACTOR\tSUBJECT\tIP\tOUTCOME\tREASON")
+
+	for _, e := range out {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Seq, e.OccurredAt.Format(time.RFC3339),
+			e.Action, orDash(e.ActorID), orDash(e.SubjectID), orDash(e.IP), e.Outcome, orDash(e.Reason),
+		)
+	}
+	return tw.Flush()
+}
+
+func (c *cli) verifyAudit(ctx context.Context, args []string) error {
+	if err := newFlagSet("audit-verify").Parse(args); err != nil {
+		return err
+	}
+
+	head, err := c.audit.Verify(ctx)
+	if err != nil {
+		return fmt.Errorf("could not verify audit log: %w", err)
+	}
+	return c.write(auditHeadOutput{Seq: head.Seq, Hash: head.Hash})
+}
+
+func (c *cli) runMigrate(ctx context.Context, args []string) error {
+	if err := newFlagSet("migrate").Parse(args); err != nil {
+		return err
+	}
+
+	applied, err := c.migrate(ctx)
+	if err != nil {
+		return fmt.Errorf("could not migrate database: %w", err)
+	}
+	return c.write(migrateOutput{Applied: applied})
+}
+
+// action runs a command taking a single user id and reports it as done
+func (c *cli) action(name string, args []string, done string, fn func(id string) error) error {
+	flags := newFlagSet(name)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	id, err := userID(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	if err := fn(id); err != nil {
+		return fmt.Errorf("could not %s user %s: %w", name, id, err)
+	}
+	return c.write(actionOutput{ID: id, Action: done})
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ContinueOnError)
+}
+
+// password returns the given password, or the first line of the input if it is empty.
+// Reading from the input keeps passwords out of the shell history.
+func (c *cli) password(given string) (string, error) {
+	if given != "" {
+		return given, nil
+	}
+
+	line, err := bufio.NewReader(c.in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("could not read password: %s", err)
+	}
+
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errPasswordRequired
+	}
+	return password, nil
+}
+
+func (c *cli) writeUsers(list []users.User) error {
+	out := make([]userOutput, 0, len(list))
+	for i := range list {
+		out = append(out, newUserOutput(&list[i]))
+	}
+
+	if c.format == formatJSON {
+		return c.writeJSON(out)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tEMAIL\tVERIFIED\tROLE\tCREATED\tDELETED")
+
+	for _, u := range out {
+		deleted := "-"
+		if u.DeletedAt != nil {
+			deleted = u.DeletedAt.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%s\t%s\t%s\n",
+			u.ID, u.Username, u.Email, u.EmailVerified, u.Role, u.CreatedAt.Format(time.RFC3339), deleted,
+		)
+	}
+	return tw.Flush()
+}
+
+// write writes the result of a command that does not return users
+func (c *cli) write(v interface{}) error {
+	if c.format == formatJSON {
+		return c.writeJSON(v)
+	}
+
+	var err error
+	switch v := v.(type) {
+	case actionOutput:
+		_, err = fmt.Fprintf(c.out, "%s: %s\n", v.ID, v.Action)
+	case migrateOutput:
+		_, err = fmt.Fprintf(c.out, "%d migrations applied\n", v.Applied)
+	case auditHeadOutput:
+		_, err = fmt.Fprintf(c.out, "%d events verified, last hash %s\n", v.Seq, v.Hash)
+	}
+	return err
+}
+
+func (c *cli) writeJSON(v interface{}) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// timeFlag returns a flag parsing an RFC 3339 time into t
+func timeFlag(t *time.Time) func(string) error {
+	return func(v string) error {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return errTimeInvalid
+		}
+		*t = parsed
+		return nil
+	}
+}
+
+// orDash returns s, or a dash if s is empty, so that table columns stay aligned
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func userID(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errIDRequired
+	}
+	return args[0], nil
+}
+
+func newUserOutput(user *users.User) userOutput {
+	out := userOutput{
+		ID:            user.ID,
+		Fullname:      user.Fullname,
+		Username:      user.Username,
+		Birthdate:     user.Birthdate.String(),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Role:          string(user.Role),
+		Locale:        user.Locale,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+	}
+
+	if !user.DeletedAt.IsZero() {
+		deletedAt := user.DeletedAt
+		out.DeletedAt = &deletedAt
+	}
+	return out
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/audit"
+	"github.com/alesr/stdservices/users"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type serviceMock struct {
+	*users.MockService
+	*users.MockAdminService
+}
+
+type auditMock struct {
+	listFunc   func(ctx context.Context, in audit.ListEventsInput) ([]audit.Event, error)
+	verifyFunc func(ctx context.Context) (*audit.ChainHead, error)
+}
+
+func (m *auditMock) Record(ctx context.Context, entry users.AuditEntry) error {
+	return errors.New("auditMock.Record is not implemented")
+}
+
+func (m *auditMock) List(ctx context.Context, in audit.ListEventsInput) ([]audit.Event, error) {
+	if m.listFunc == nil {
+		return nil, errors.New("auditMock.listFunc is nil")
+	}
+	return m.listFunc(ctx, in)
+}
+
+func (m *auditMock) Verify(ctx context.Context) (*audit.ChainHead, error) {
+	if m.verifyFunc == nil {
+		return nil, errors.New("auditMock.verifyFunc is nil")
+	}
+	return m.verifyFunc(ctx)
+}
+
+func TestCLI_createUser(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	birthdate, err := users.ParseBirthdate("2000-01-01")
+	require.NoError(t, err)
+
+	var promoted string
+
+	svc := serviceMock{
+		MockService: &users.MockService{
+			CreateFunc: func(ctx context.Context, in users.CreateUserInput) (*users.User, error) {
+				assert.Equal(t, "password#123", in.Password)
+				assert.Equal(t, "password#123", in.ConfirmPassword)
+
+				return &users.User{
+					ID:        "1",
+					Fullname:  in.Fullname,
+					Username:  in.Username,
+					Birthdate: birthdate,
+					Email:     in.Email,
+					Role:      users.RoleUser,
+					Locale:    "en",
+					CreatedAt: createdAt,
+					UpdatedAt: createdAt,
+				}, nil
+			},
+		},
+		MockAdminService: &users.MockAdminService{
+			SetRoleFunc: func(ctx context.Context, id, r string) error {
+				assert.Equal(t, "admin", r)
+				promoted = id
+				return nil
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	c := &cli{svc: svc, in: strings.NewReader("password#123\n"), out: &out, format: formatJSON}
+
+	err = c.execute(context.Background(), "create-user", []string{
+		"-fullname", "John Doe", "-username", "jdoe", "-birthdate", "2000-01-01", "-email", "jdoe@mail.com", "-admin",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "1", promoted)
+	assert.JSONEq(t, `[{"id":"1","fullname":"John Doe","username":"jdoe","birthdate":"2000-01-01","email":"jdoe@mail.com",`+
+		`"email_verified":false,"role":"admin","locale":"en","created_at":"2022-06-15T12:00:00Z","updated_at":"2022-06-15T12:00:00Z"}]`,
+		out.String(),
+	)
+}
+
+func TestCLI_actions(t *testing.T) {
+	t.Parallel()
+
+	var calls []string
+
+	record := func(call string) error {
+		calls = append(calls, call)
+		return nil
+	}
+
+	svc := serviceMock{
+		MockService: &users.MockService{
+			DeleteFunc: func(ctx context.Context, id string) error {
+				return record("delete " + id)
+			},
+		},
+		MockAdminService: &users.MockAdminService{
+			SetRoleFunc: func(ctx context.Context, id, r string) error {
+				return record("set role " + id + " " + r)
+			},
+			RestoreFunc: func(ctx context.Context, id string) error {
+				return record("restore " + id)
+			},
+			ForceVerifyEmailFunc: func(ctx context.Context, id string) error {
+				return record("verify email " + id)
+			},
+			ResetPasswordFunc: func(ctx context.Context, id, password string) error {
+				return record("reset password " + id + " " + password)
+			},
+			RevokeTokensFunc: func(ctx context.Context, id string) error {
+				return record("revoke tokens " + id)
+			},
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		givenArgs     []string
+		expectedCall  string
+		expectedOut   string
+		expectedError error
+	}{
+		{
+			name:         "promote",
+			givenArgs:    []string{"promote", "1"},
+			expectedCall: "set role 1 admin",
+			expectedOut:  "1: promoted\n",
+		},
+		{
+			name:         "demote",
+			givenArgs:    []string{"demote", "1"},
+			expectedCall: "set role 1 user",
+			expectedOut:  "1: demoted\n",
+		},
+		{
+			name:         "delete",
+			givenArgs:    []string{"delete", "1"},
+			expectedCall: "delete 1",
+			expectedOut:  "1: deleted\n",
+		},
+		{
+			name:         "restore",
+			givenArgs:    []string{"restore", "1"},
+			expectedCall: "restore 1",
+			expectedOut:  "1: restored\n",
+		},
+		{
+			name:         "verify email",
+			givenArgs:    []string{"verify-email", "1"},
+			expectedCall: "verify email 1",
+			expectedOut:  "1: email verified\n",
+		},
+		{
+			name:         "reset password from stdin",
+			givenArgs:    []string{"reset-password", "1"},
+			expectedCall: "reset password 1 password#123",
+			expectedOut:  "1: password reset\n",
+		},
+		{
+			name:         "reset password from flag",
+			givenArgs:    []string{"reset-password", "-password", "password#456", "1"},
+			expectedCall: "reset password 1 password#456",
+			expectedOut:  "1: password reset\n",
+		},
+		{
+			name:         "revoke tokens",
+			givenArgs:    []string{"revoke-tokens", "1"},
+			expectedCall: "revoke tokens 1",
+			expectedOut:  "1: tokens revoked\n",
+		},
+		{
+			name:          "missing id",
+			givenArgs:     []string{"promote"},
+			expectedError: errIDRequired,
+		},
+		{
+			name:          "unknown command",
+			givenArgs:     []string{"drop-users"},
+			expectedError: errCommandUnknown,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls = nil
+
+			var out bytes.Buffer
+			c := &cli{svc: svc, in: strings.NewReader("password#123\n"), out: &out, format: formatTable}
+
+			err := c.execute(context.Background(), tc.givenArgs[0], tc.givenArgs[1:])
+			if tc.expectedError != nil {
+				assert.True(t, errors.Is(err, tc.expectedError))
+				assert.Empty(t, calls)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, []string{tc.expectedCall}, calls)
+			assert.Equal(t, tc.expectedOut, out.String())
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		c := &cli{
+			svc: serviceMock{MockAdminService: &users.MockAdminService{
+				RestoreFunc: func(ctx context.Context, id string) error {
+					return users.ErrNotFound
+				},
+			}},
+			out: &bytes.Buffer{},
+		}
+
+		err := c.execute(context.Background(), "restore", []string{"1"})
+		assert.True(t, errors.Is(err, users.ErrNotFound))
+	})
+
+	t.Run("empty password", func(t *testing.T) {
+		c := &cli{svc: svc, in: strings.NewReader(""), out: &bytes.Buffer{}}
+
+		err := c.execute(context.Background(), "reset-password", []string{"1"})
+		assert.Equal(t, errPasswordRequired, err)
+	})
+}
+
+func TestCLI_list(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	deletedAt := createdAt.Add(time.Hour)
+
+	svc := serviceMock{
+		MockAdminService: &users.MockAdminService{
+			ListFunc: func(ctx context.Context, in users.ListUsersInput) ([]users.User, error) {
+				assert.Equal(t, users.ListUsersInput{Query: "doe", Role: "user", IncludeDeleted: true, Limit: 10, Offset: 20}, in)
+
+				return []users.User{
+					{ID: "1", Username: "jdoe", Email: "jdoe@mail.com", Role: users.RoleUser, CreatedAt: createdAt},
+					{ID: "2", Username: "jane", Email: "jane@mail.com", EmailVerified: true, Role: users.RoleUser, CreatedAt: createdAt, DeletedAt: deletedAt},
+				}, nil
+			},
+		},
+	}
+
+	args := []string{"-q", "doe", "-role", "user", "-deleted", "-limit", "10", "-offset", "20"}
+
+	var out bytes.Buffer
+	c := &cli{svc: svc, out: &out, format: formatTable}
+
+	require.NoError(t, c.execute(context.Background(), "list", args))
+	assert.Equal(t, ""+
+		"ID  USERNAME  EMAIL          VERIFIED  ROLE  CREATED               DELETED\n"+
+		"1   jdoe      jdoe@mail.com  false     user  2022-06-15T12:00:00Z  -\n"+
+		"2   jane      jane@mail.com  true      user  2022-06-15T12:00:00Z  2022-06-15T13:00:00Z\n",
+		out.String(),
+	)
+
+	out.Reset()
+	c.format = formatJSON
+
+	require.NoError(t, c.execute(context.Background(), "list", args))
+	assert.Contains(t, out.String(), `"deleted_at": "2022-06-15T13:00:00Z"`)
+	assert.Equal(t, 1, strings.Count(out.String(), "deleted_at"))
+}
+
+func TestCLI_audit(t *testing.T) {
+	t.Parallel()
+
+	occurredAt := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	c := &cli{
+		audit: &auditMock{
+			listFunc: func(ctx context.Context, in audit.ListEventsInput) ([]audit.Event, error) {
+				assert.Equal(t, audit.ListEventsInput{
+					SubjectID: "1",
+					Outcome:   users.AuditOutcomeFailure,
+					Since:     occurredAt.Add(-time.Hour),
+					Limit:     10,
+				}, in)
+
+				return []audit.Event{
+					{Seq: 2, Action: users.AuditRoleChanged, ActorID: "usersctl:jane", SubjectID: "1", Outcome: users.AuditOutcomeFailure,
+						Reason: "user.not_found", Metadata: map[string]string{"role": "admin"}, OccurredAt: occurredAt},
+					{Seq: 1, Action: users.AuditLogin, IP: "192.0.2.1", Outcome: users.AuditOutcomeFailure,
+						Reason: "user.not_found", OccurredAt: occurredAt},
+				}, nil
+			},
+			verifyFunc: func(ctx context.Context) (*audit.ChainHead, error) {
+				return &audit.ChainHead{Seq: 2, Hash: "ab12"}, nil
+			},
+		},
+		format: formatTable,
+	}
+
+	args := []string{"-subject", "1", "-outcome", "failure", "-since", "2022-06-15T11:00:00Z", "-limit", "10"}
+
+	var out bytes.Buffer
+	c.out = &out
+
+	require.NoError(t, c.execute(context.Background(), "audit", args))
+	assert.Equal(t, ""+
+		"SEQ  TIME                  ACTION             ACTOR          SUBJECT  IP         OUTCOME  REASON\n"+
+		"2    2022-06-15T12:00:00Z  user.role_changed  usersctl:jane  1        -          failure  user.not_found\n"+
+		"1    2022-06-15T12:00:00Z  user.login         -              -        192.0.2.1  failure  user.not_found\n",
+		out.String(),
+	)
+
+	out.Reset()
+	require.NoError(t, c.execute(context.Background(), "audit-verify", nil))
+	assert.Equal(t, "2 events verified, last hash ab12\n", out.String())
+
+	t.Run("invalid time", func(t *testing.T) {
+		err := c.execute(context.Background(), "audit", []string{"-since", "yesterday"})
+		assert.ErrorContains(t, err, errTimeInvalid.Error())
+	})
+
+	t.Run("broken chain", func(t *testing.T) {
+		c := &cli{
+			audit: &auditMock{
+				verifyFunc: func(ctx context.Context) (*audit.ChainHead, error) {
+					return nil, fmt.Errorf("%w at event 2", audit.ErrChainBroken)
+				},
+			},
+			out: &bytes.Buffer{},
+		}
+
+		err := c.execute(context.Background(), "audit-verify", nil)
+		assert.True(t, errors.Is(err, audit.ErrChainBroken))
+	})
+}
+
+func TestCLI_migrate(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	c := &cli{
+		migrate: func(ctx context.Context) (int, error) { return 3, nil },
+		out:     &out,
+		format:  formatJSON,
+	}
+
+	require.NoError(t, c.execute(context.Background(), "migrate", nil))
+	assert.JSONEq(t, `{"applied":3}`, out.String())
+}
+
+func TestRun_errors(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenArgs     []string
+		expectedError error
+	}{
+		{
+			name:          "command required",
+			givenArgs:     []string{"-dsn", "postgres://localhost"},
+			expectedError: errCommandRequired,
+		},
+		{
+			name:          "invalid format",
+			givenArgs:     []string{"-dsn", "postgres://localhost", "-format", "yaml", "list"},
+			expectedError: errFormatInvalid,
+		},
+		{
+			name:          "dsn required",
+			givenArgs:     []string{"list"},
+			expectedError: errDSNRequired,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := run(context.Background(), tc.givenArgs, func(string) string { return "" }, strings.NewReader(""), &out)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import "errors"
+
+var (
+	// List error messages
+
+	errCommandRequired  = errors.New("command is required")
+	errCommandUnknown   = errors.New("unknown command")
+	errDSNRequired      = errors.New("database dsn is required")
+	errFormatInvalid    = errors.New("format must be table or json")
+	errIDRequired       = errors.New("exactly one user id is required")
+	errPasswordRequired = errors.New("password is required")
+	errTimeInvalid      = errors.New("time must be formatted as RFC 3339, such as 2022-06-15T12:00:00Z")
+)
@@ -0,0 +1,119 @@
+// Command usersctl operates the users service directly against its database,
+// for the tasks that on-call engineers would otherwise do by hand with psql.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/alesr/stdservices/audit"
+	auditrepo "github.com/alesr/stdservices/audit/repository"
+	"github.com/alesr/stdservices/migrations"
+	"github.com/alesr/stdservices/pkg/migrate"
+	"github.com/alesr/stdservices/users"
+	usersrepo "github.com/alesr/stdservices/users/repository"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+
+	_ "github.com/jackc/pgx/stdlib"
+)
+
+// connectTimeout bounds the initial connection to the database
+const connectTimeout = 10 * time.Second
+
+const usage = `usage: usersctl [-dsn dsn] [-format table|json] [-actor name] <command> [flags] [args]
+
+commands:
+  create-user    -fullname -username -birthdate -email [-password] [-locale] [-admin]
+  promote        <id>  grant the admin role
+  demote         <id>  revoke the admin role
+  delete         <id>
+  restore        <id>  undo a delete
+  verify-email   <id>  mark the email as verified
+  reset-password [-password] <id>
+  revoke-tokens  <id>  invalidate every token issued so far
+  list           [-q query] [-role role] [-deleted] [-limit n] [-offset n]
+  audit          [-actor id] [-subject id] [-action action] [-outcome outcome]
+                 [-since time] [-until time] [-before seq] [-limit n]
+  audit-verify   check the hash chain of the audit log
+  migrate        apply pending database migrations
+
+Passwords not given as flags are read from the first line of stdin.
+Changes are recorded in the audit log as made by the actor, usersctl:$USER by default.
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+
+	err := run(ctx, os.Args[1:], os.Getenv, os.Stdin, os.Stdout)
+	stop()
+
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "usersctl: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, getenv func(string) string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("usersctl", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), usage) }
+
+	dsn := flags.String("dsn", getenv("USERSD_DATABASE_DSN"), "postgres connection string")
+	format := flags.String("format", formatTable, "output format: table or json")
+	actor := flags.String("actor", "usersctl:"+getenv("USER"), "name of the operator recorded in the audit log")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errCommandRequired
+	}
+
+	if *format != formatTable && *format != formatJSON {
+		return errFormatInvalid
+	}
+
+	if *dsn == "" {
+		return errDSNRequired
+	}
+
+	dbConn, err := sqlx.Open("pgx", *dsn)
+	if err != nil {
+		return fmt.Errorf("could not open database: %s", err)
+	}
+	defer dbConn.Close()
+
+	pingCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	err = dbConn.PingContext(pingCtx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("could not connect to database: %s", err)
+	}
+
+	auditSvc := audit.New(zap.NewNop(), auditrepo.NewPostgres(dbConn))
+
+	// Admin operations never sign tokens, so no signing key is needed
+	svc := users.New(zap.NewNop(), "", usersrepo.NewPostgres(dbConn), users.WithAuditor(auditSvc))
+
+	ctx = users.WithRequestInfo(ctx, users.RequestInfo{ActorID: *actor})
+
+	c := &cli{
+		svc:   svc,
+		audit: auditSvc,
+		migrate: func(ctx context.Context) (int, error) {
+			return migrate.Up(ctx, dbConn.DB, migrations.FS, migrate.WithSteps(migrations.Steps))
+		},
+		in:     in,
+		out:    out,
+		format: *format,
+	}
+	return c.execute(ctx, flags.Arg(0), flags.Args()[1:])
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alesr/stdservices/pkg/email"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix prefixes the environment variables overriding the configuration file
+const envPrefix = "USERSD_"
+
+type config struct {
+	HTTP         httpConfig         `yaml:"http"`
+	GRPC         grpcConfig         `yaml:"grpc"`
+	Database     databaseConfig     `yaml:"database"`
+	JWT          jwtConfig          `yaml:"jwt"`
+	Email        emailConfig        `yaml:"email"`
+	Verification verificationConfig `yaml:"verification"`
+	Webhooks     webhooksConfig     `yaml:"webhooks"`
+	Audit        auditConfig        `yaml:"audit"`
+	UserCache    userCacheConfig    `yaml:"user_cache"`
+	Hashing      hashingConfig      `yaml:"hashing"`
+	Metrics      metricsConfig      `yaml:"metrics"`
+	Log          logConfig          `yaml:"log"`
+}
+
+type httpConfig struct {
+	Addr              string        `yaml:"addr"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+
+	// ShutdownTimeout bounds how long in-flight requests are drained on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type databaseConfig struct {
+	DSN          string `yaml:"dsn"`
+	MaxOpenConns int    `yaml:"max_open_conns"`
+	MaxIdleConns int    `yaml:"max_idle_conns"`
+
+	// Migrate applies the embedded migrations on start
+	Migrate bool `yaml:"migrate"`
+}
+
+type jwtConfig struct {
+	SigningKey string        `yaml:"signing_key"`
+	TTL        time.Duration `yaml:"ttl"`
+
+	// PrivateKeyFile is the path of a PEM encoded ECDSA P-256 key signing tokens with ES256 instead of
+	// SigningKey, so that other services can verify them with its public key, identified by KeyID
+	PrivateKeyFile string `yaml:"private_key_file"`
+	KeyID          string `yaml:"key_id"`
+}
+
+type emailConfig struct {
+	SenderName string `yaml:"sender_name"`
+	SenderAddr string `yaml:"sender_addr"`
+
+	// VerificationEndpoint is the URL of verification links, followed by the token.
+	// Verification emails are disabled when it is empty.
+	VerificationEndpoint string        `yaml:"verification_endpoint"`
+	VerificationTTL      time.Duration `yaml:"verification_ttl"`
+
+	// Queue sends emails in the background through the email_outbox table
+	Queue bool `yaml:"queue"`
+
+	// SMTP is used to send emails. Emails are logged instead when its host is empty.
+	SMTP smtpConfig `yaml:"smtp"`
+}
+
+type smtpConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	TLSMode  string `yaml:"tls_mode"`
+	Auth     string `yaml:"auth"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type verificationConfig struct {
+	Cooldown        time.Duration `yaml:"cooldown"`
+	MaxSends        int           `yaml:"max_sends"`
+	Window          time.Duration `yaml:"window"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+}
+
+type webhooksConfig struct {
+	// Enabled publishes user events to the registered webhook endpoints
+	Enabled bool `yaml:"enabled"`
+}
+
+type grpcConfig struct {
+	// Addr is the listen address of the gRPC server, which is disabled when empty.
+	// Calls are drained within the shutdown timeout of the HTTP server.
+	Addr string `yaml:"addr"`
+}
+
+type auditConfig struct {
+	// Enabled records logins and changes to user accounts in the audit_events table
+	Enabled bool `yaml:"enabled"`
+}
+
+type userCacheConfig struct {
+	// Size bounds the number of users cached for token verification, zero disabling the cache
+	Size int `yaml:"size"`
+
+	// TTL bounds how long other instances may accept the tokens of a user after a revocation
+	TTL time.Duration `yaml:"ttl"`
+}
+
+type hashingConfig struct {
+	// Concurrency bounds the concurrent bcrypt operations, half the CPUs when zero and unbounded when negative
+	Concurrency int `yaml:"concurrency"`
+
+	// Queue bounds the bcrypt operations waiting for a worker, beyond which requests fail with 503
+	Queue int `yaml:"queue"`
+}
+
+type metricsConfig struct {
+	// Enabled serves Prometheus metrics on Path of the HTTP server
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+type logConfig struct {
+	// Development enables human friendly logs
+	Development bool `yaml:"development"`
+}
+
+// defaultConfig returns the configuration used for settings missing from both the file and the environment
+func defaultConfig() config {
+	return config{
+		HTTP: httpConfig{
+			Addr:              ":8080",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      15 * time.Second,
+			IdleTimeout:       time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		GRPC: grpcConfig{
+			Addr: ":9090",
+		},
+		Database: databaseConfig{
+			MaxOpenConns: 20,
+			MaxIdleConns: 5,
+			Migrate:      true,
+		},
+		JWT: jwtConfig{
+			TTL: 24 * time.Hour,
+		},
+		Email: emailConfig{
+			VerificationTTL: 24 * time.Hour,
+			SMTP: smtpConfig{
+				TLSMode: string(email.TLSModeStartTLS),
+				Auth:    string(email.AuthPlain),
+			},
+		},
+		Verification: verificationConfig{
+			Cooldown:        time.Minute,
+			MaxSends:        5,
+			Window:          time.Hour,
+			CleanupInterval: time.Hour,
+		},
+		UserCache: userCacheConfig{
+			TTL: 30 * time.Second,
+		},
+		Hashing: hashingConfig{
+			Queue: 256,
+		},
+		Metrics: metricsConfig{
+			Path: "/metrics",
+		},
+	}
+}
+
+// loadConfig reads the configuration from the YAML file at path, if any, then
+// overrides it with the environment variables returned by getenv
+func loadConfig(path string, getenv func(string) string) (*config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read config file: %s", err)
+		}
+
+		if err := yaml.Unmarshal(content, &cfg); err != nil {
+			return nil, fmt.Errorf("could not parse config file: %s", err)
+		}
+	}
+
+	if err := cfg.loadEnv(getenv); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("could not validate config: %s", err)
+	}
+	return &cfg, nil
+}
+
+// loadEnv overrides settings with the environment variables named after their path in the file,
+// such as USERSD_DATABASE_DSN for database.dsn
+func (c *config) loadEnv(getenv func(string) string) error {
+	vars := map[string]interface{}{
+		"HTTP_ADDR":                     &c.HTTP.Addr,
+		"HTTP_READ_HEADER_TIMEOUT":      &c.HTTP.ReadHeaderTimeout,
+		"HTTP_READ_TIMEOUT":             &c.HTTP.ReadTimeout,
+		"HTTP_WRITE_TIMEOUT":            &c.HTTP.WriteTimeout,
+		"HTTP_IDLE_TIMEOUT":             &c.HTTP.IdleTimeout,
+		"HTTP_SHUTDOWN_TIMEOUT":         &c.HTTP.ShutdownTimeout,
+		"GRPC_ADDR":                     &c.GRPC.Addr,
+		"DATABASE_DSN":                  &c.Database.DSN,
+		"DATABASE_MAX_OPEN_CONNS":       &c.Database.MaxOpenConns,
+		"DATABASE_MAX_IDLE_CONNS":       &c.Database.MaxIdleConns,
+		"DATABASE_MIGRATE":              &c.Database.Migrate,
+		"JWT_SIGNING_KEY":               &c.JWT.SigningKey,
+		"JWT_TTL":                       &c.JWT.TTL,
+		"JWT_PRIVATE_KEY_FILE":          &c.JWT.PrivateKeyFile,
+		"JWT_KEY_ID":                    &c.JWT.KeyID,
+		"EMAIL_SENDER_NAME":             &c.Email.SenderName,
+		"EMAIL_SENDER_ADDR":             &c.Email.SenderAddr,
+		"EMAIL_VERIFICATION_ENDPOINT":   &c.Email.VerificationEndpoint,
+		"EMAIL_VERIFICATION_TTL":        &c.Email.VerificationTTL,
+		"EMAIL_QUEUE":                   &c.Email.Queue,
+		"EMAIL_SMTP_HOST":               &c.Email.SMTP.Host,
+		"EMAIL_SMTP_PORT":               &c.Email.SMTP.Port,
+		"EMAIL_SMTP_TLS_MODE":           &c.Email.SMTP.TLSMode,
+		"EMAIL_SMTP_AUTH":               &c.Email.SMTP.Auth,
+		"EMAIL_SMTP_USERNAME":           &c.Email.SMTP.Username,
+		"EMAIL_SMTP_PASSWORD":           &c.Email.SMTP.Password,
+		"VERIFICATION_COOLDOWN":         &c.Verification.Cooldown,
+		"VERIFICATION_MAX_SENDS":        &c.Verification.MaxSends,
+		"VERIFICATION_WINDOW":           &c.Verification.Window,
+		"VERIFICATION_CLEANUP_INTERVAL": &c.Verification.CleanupInterval,
+		"WEBHOOKS_ENABLED":              &c.Webhooks.Enabled,
+		"AUDIT_ENABLED":                 &c.Audit.Enabled,
+		"USER_CACHE_SIZE":               &c.UserCache.Size,
+		"USER_CACHE_TTL":                &c.UserCache.TTL,
+		"HASHING_CONCURRENCY":           &c.Hashing.Concurrency,
+		"HASHING_QUEUE":                 &c.Hashing.Queue,
+		"METRICS_ENABLED":               &c.Metrics.Enabled,
+		"METRICS_PATH":                  &c.Metrics.Path,
+		"LOG_DEVELOPMENT":               &c.Log.Development,
+	}
+
+	for name, target := range vars {
+		value := getenv(envPrefix + name)
+		if value == "" {
+			continue
+		}
+
+		if err := setValue(target, value); err != nil {
+			return fmt.Errorf("could not parse %s%s: %s", envPrefix, name, err)
+		}
+	}
+	return nil
+}
+
+func setValue(target interface{}, value string) error {
+	switch t := target.(type) {
+	case *string:
+		*t = value
+	case *int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*t = v
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*t = v
+	case *time.Duration:
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*t = v
+	default:
+		return fmt.Errorf("unsupported type %T", target)
+	}
+	return nil
+}
+
+func (c *config) validate() error {
+	switch {
+	case c.Database.DSN == "":
+		return errDSNRequired
+	case c.JWT.SigningKey == "" && c.JWT.PrivateKeyFile == "":
+		return errSigningKeyRequired
+	case c.Email.VerificationEndpoint != "" && c.Email.SenderAddr == "":
+		return errSenderRequired
+	case c.Email.SMTP.Host != "" && c.Email.SMTP.Port == 0:
+		return errSMTPPortRequired
+	case c.Metrics.Enabled && !strings.HasPrefix(c.Metrics.Path, "/"):
+		return errMetricsPathInvalid
+	}
+	return nil
+}
+
+// loadPrivateKey reads a PEM encoded ECDSA key in SEC 1 or PKCS #8 form, such as the output of
+// openssl ecparam -name prime256v1 -genkey -noout
+func loadPrivateKey(path string) (*ecdsa.PrivateKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read private key: %s", err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("could not decode private key: no PEM block found")
+	}
+
+	var key interface{}
+	if block.Type == "EC PRIVATE KEY" {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %s", err)
+	}
+
+	// Tokens are signed with ES256, which requires a P-256 key
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecdsaKey.Curve != elliptic.P256() {
+		return nil, errPrivateKeyCurve
+	}
+	return ecdsaKey, nil
+}
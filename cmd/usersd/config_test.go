@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "usersd.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+http:
+  addr: ":9090"
+  shutdown_timeout: 10s
+grpc:
+  addr: ":9091"
+database:
+  dsn: postgres://file
+  migrate: false
+jwt:
+  signing_key: file-secret
+  ttl: 1h
+email:
+  sender_name: test-app
+  sender_addr: noreply@test-app.com
+  verification_endpoint: https://test-app.com/verify-email
+  smtp:
+    host: smtp.test-app.com
+    port: 465
+    tls_mode: implicit
+verification:
+  max_sends: 3
+`), 0o600))
+
+	env := map[string]string{
+		"USERSD_DATABASE_DSN":        "postgres://env",
+		"USERSD_VERIFICATION_WINDOW": "2h",
+		"USERSD_WEBHOOKS_ENABLED":    "true",
+		"USERSD_AUDIT_ENABLED":       "true",
+		"USERSD_METRICS_ENABLED":     "true",
+		"USERSD_USER_CACHE_SIZE":     "10000",
+		"USERSD_HASHING_CONCURRENCY": "4",
+	}
+
+	actual, err := loadConfig(path, func(key string) string { return env[key] })
+	require.NoError(t, err)
+
+	expected := defaultConfig()
+	expected.HTTP.Addr = ":9090"
+	expected.HTTP.ShutdownTimeout = 10 * time.Second
+	expected.GRPC.Addr = ":9091"
+	expected.Database.DSN = "postgres://env"
+	expected.Database.Migrate = false
+	expected.JWT = jwtConfig{SigningKey: "file-secret", TTL: time.Hour}
+	expected.Email.SenderName = "test-app"
+	expected.Email.SenderAddr = "noreply@test-app.com"
+	expected.Email.VerificationEndpoint = "https://test-app.com/verify-email"
+	expected.Email.SMTP.Host = "smtp.test-app.com"
+	expected.Email.SMTP.Port = 465
+	expected.Email.SMTP.TLSMode = "implicit"
+	expected.Verification.MaxSends = 3
+	expected.Verification.Window = 2 * time.Hour
+	expected.Webhooks.Enabled = true
+	expected.Audit.Enabled = true
+	expected.UserCache.Size = 10000
+	expected.Hashing.Concurrency = 4
+	expected.Metrics.Enabled = true
+
+	assert.Equal(t, &expected, actual)
+}
+
+func TestLoadConfig_errors(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenEnv      map[string]string
+		expectedError string
+	}{
+		{
+			name:          "dsn required",
+			givenEnv:      map[string]string{"USERSD_JWT_SIGNING_KEY": "secret"},
+			expectedError: errDSNRequired.Error(),
+		},
+		{
+			name:          "signing key required",
+			givenEnv:      map[string]string{"USERSD_DATABASE_DSN": "postgres://env"},
+			expectedError: errSigningKeyRequired.Error(),
+		},
+		{
+			name: "sender required",
+			givenEnv: map[string]string{
+				"USERSD_DATABASE_DSN":                "postgres://env",
+				"USERSD_JWT_SIGNING_KEY":             "secret",
+				"USERSD_EMAIL_VERIFICATION_ENDPOINT": "https://test-app.com/verify-email",
+			},
+			expectedError: errSenderRequired.Error(),
+		},
+		{
+			name: "invalid metrics path",
+			givenEnv: map[string]string{
+				"USERSD_DATABASE_DSN":    "postgres://env",
+				"USERSD_JWT_SIGNING_KEY": "secret",
+				"USERSD_METRICS_ENABLED": "true",
+				"USERSD_METRICS_PATH":    "metrics",
+			},
+			expectedError: errMetricsPathInvalid.Error(),
+		},
+		{
+			name: "invalid duration",
+			givenEnv: map[string]string{
+				"USERSD_DATABASE_DSN":    "postgres://env",
+				"USERSD_JWT_SIGNING_KEY": "secret",
+				"USERSD_JWT_TTL":         "forever",
+			},
+			expectedError: "could not parse USERSD_JWT_TTL",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadConfig("", func(key string) string { return tc.givenEnv[key] })
+			assert.ErrorContains(t, err, tc.expectedError)
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := loadConfig(filepath.Join(t.TempDir(), "missing.yaml"), func(string) string { return "" })
+		assert.ErrorContains(t, err, "could not read config file")
+	})
+}
+
+func TestLoadPrivateKey(t *testing.T) {
+	t.Parallel()
+
+	writeKey := func(t *testing.T, curve elliptic.Curve, pkcs8 bool) (string, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		require.NoError(t, err)
+
+		block := pem.Block{Type: "EC PRIVATE KEY"}
+		if pkcs8 {
+			block.Type = "PRIVATE KEY"
+			block.Bytes, err = x509.MarshalPKCS8PrivateKey(key)
+		} else {
+			block.Bytes, err = x509.MarshalECPrivateKey(key)
+		}
+		require.NoError(t, err)
+
+		path := filepath.Join(t.TempDir(), "key.pem")
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&block), 0o600))
+		return path, key
+	}
+
+	t.Run("sec1", func(t *testing.T) {
+		path, expected := writeKey(t, elliptic.P256(), false)
+
+		actual, err := loadPrivateKey(path)
+		require.NoError(t, err)
+		assert.True(t, expected.Equal(actual))
+	})
+
+	t.Run("pkcs8", func(t *testing.T) {
+		path, expected := writeKey(t, elliptic.P256(), true)
+
+		actual, err := loadPrivateKey(path)
+		require.NoError(t, err)
+		assert.True(t, expected.Equal(actual))
+	})
+
+	t.Run("unsupported curve", func(t *testing.T) {
+		path, _ := writeKey(t, elliptic.P384(), false)
+
+		_, err := loadPrivateKey(path)
+		assert.Equal(t, errPrivateKeyCurve, err)
+	})
+
+	t.Run("not a key", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "key.pem")
+		require.NoError(t, os.WriteFile(path, []byte("not a key"), 0o600))
+
+		_, err := loadPrivateKey(path)
+		assert.ErrorContains(t, err, "no PEM block found")
+	})
+}
@@ -0,0 +1,14 @@
+package main
+
+import "errors"
+
+var (
+	// List error messages
+
+	errDSNRequired        = errors.New("database dsn is required")
+	errMetricsPathInvalid = errors.New("metrics path must start with a slash")
+	errPrivateKeyCurve    = errors.New("jwt private key must be an ECDSA P-256 key")
+	errSenderRequired     = errors.New("email sender address is required to send verification emails")
+	errSigningKeyRequired = errors.New("jwt signing key or private key file is required")
+	errSMTPPortRequired   = errors.New("smtp port is required")
+)
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/alesr/stdservices/users"
+	"github.com/alesr/stdservices/users/userspb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type (
+	// grpcServer exposes the users service over gRPC, with the same operations and authorization as server
+	grpcServer struct {
+		userspb.UnimplementedUsersServer
+
+		logger *zap.Logger
+		users  users.Service
+
+		// health reports the server as not serving once shutdown starts, like /readyz
+		health *health.Server
+
+		tracer     trace.Tracer
+		propagator propagation.TextMapPropagator
+	}
+
+	// metadataCarrier reads and writes trace context from gRPC metadata
+	metadataCarrier metadata.MD
+)
+
+func newGRPCServer(logger *zap.Logger, svc users.Service) *grpcServer {
+	return &grpcServer{
+		logger:     logger,
+		users:      svc,
+		health:     health.NewServer(),
+		tracer:     otel.Tracer(tracerName),
+		propagator: otel.GetTextMapPropagator(),
+	}
+}
+
+// server returns a gRPC server with the users and health services registered.
+// Calls are traced, continuing the trace of W3C trace context metadata, and the client address
+// and user agent are passed to the service for the audit log, like the HTTP server does.
+func (s *grpcServer) server() *grpc.Server {
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(s.traced, withGRPCRequestInfo, s.convertErrors))
+	userspb.RegisterUsersServer(srv, s)
+	grpc_health_v1.RegisterHealthServer(srv, s.health)
+	return srv
+}
+
+// drain marks the server as shutting down, failing health checks
+func (s *grpcServer) drain() {
+	s.health.Shutdown()
+}
+
+func (s *grpcServer) CreateUser(ctx context.Context, req *userspb.CreateUserRequest) (*userspb.User, error) {
+	locale := req.Locale
+	if locale == "" {
+		locale = firstMetadata(ctx, "accept-language")
+	}
+
+	user, err := s.users.Create(ctx, users.CreateUserInput{
+		Fullname:        req.Fullname,
+		Username:        req.Username,
+		Birthdate:       req.Birthdate,
+		Email:           req.Email,
+		Password:        req.Password,
+		ConfirmPassword: req.ConfirmPassword,
+		Locale:          locale,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newUserMessage(user), nil
+}
+
+func (s *grpcServer) GetUser(ctx context.Context, req *userspb.GetUserRequest) (*userspb.User, error) {
+	ctx, _, err := s.authorize(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.FetchByID(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return newUserMessage(user), nil
+}
+
+func (s *grpcServer) DeleteUser(ctx context.Context, req *userspb.DeleteUserRequest) (*emptypb.Empty, error) {
+	ctx, _, err := s.authorize(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.users.Delete(ctx, req.Id); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcServer) GetVerificationStatus(
+	ctx context.Context, req *userspb.GetVerificationStatusRequest,
+) (*userspb.VerificationStatus, error) {
+	ctx, _, err := s.authorize(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	verification, err := s.users.GetVerificationStatus(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &userspb.VerificationStatus{
+		EmailVerified: verification.EmailVerified,
+		Pending:       verification.Pending,
+		ExpiresAt:     optionalTimestamp(verification.ExpiresAt),
+		LastSentAt:    optionalTimestamp(verification.LastSentAt),
+		NextSendAt:    optionalTimestamp(verification.NextSendAt),
+	}, nil
+}
+
+func (s *grpcServer) SendEmailVerification(
+	ctx context.Context, req *userspb.SendEmailVerificationRequest,
+) (*emptypb.Empty, error) {
+	ctx, _, err := s.authorize(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.FetchByID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.users.SendEmailVerification(ctx, user.ID, user.Username, user.Email); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcServer) VerifyEmailCode(ctx context.Context, req *userspb.VerifyEmailCodeRequest) (*emptypb.Empty, error) {
+	ctx, caller, err := s.authorize(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the owner of the mailbox can prove it received the code
+	if caller.ID != req.UserId {
+		return nil, users.ErrForbiddenRole
+	}
+
+	if err := s.users.VerifyEmailCode(ctx, req.UserId, req.Code); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcServer) VerifyEmail(ctx context.Context, req *userspb.VerifyEmailRequest) (*emptypb.Empty, error) {
+	if err := s.users.VerifyEmail(ctx, req.Token); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcServer) ListSessions(ctx context.Context, req *userspb.ListSessionsRequest) (*userspb.ListSessionsResponse, error) {
+	ctx, caller, err := s.authorize(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.users.ListSessions(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	currentSessionID := currentSession(caller, req.UserId)
+
+	resp := userspb.ListSessionsResponse{Sessions: make([]*userspb.Session, 0, len(sessions))}
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, &userspb.Session{
+			Id:         session.ID,
+			UserAgent:  session.UserAgent,
+			Ip:         session.IP,
+			CreatedAt:  timestamppb.New(session.CreatedAt),
+			LastSeenAt: timestamppb.New(session.LastSeenAt),
+			ExpiresAt:  timestamppb.New(session.ExpiresAt),
+			Current:    session.ID == currentSessionID,
+		})
+	}
+	return &resp, nil
+}
+
+func (s *grpcServer) RevokeOtherSessions(
+	ctx context.Context, req *userspb.RevokeOtherSessionsRequest,
+) (*emptypb.Empty, error) {
+	ctx, caller, err := s.authorize(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.users.RevokeOtherSessions(ctx, req.UserId, currentSession(caller, req.UserId)); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcServer) RevokeSession(ctx context.Context, req *userspb.RevokeSessionRequest) (*emptypb.Empty, error) {
+	ctx, _, err := s.authorize(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.users.RevokeSession(ctx, req.UserId, req.SessionId); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcServer) GenerateToken(ctx context.Context, req *userspb.GenerateTokenRequest) (*userspb.GenerateTokenResponse, error) {
+	token, err := s.users.GenerateToken(ctx, req.Email, req.Password)
+	if err != nil {
+		return nil, err
+	}
+	return &userspb.GenerateTokenResponse{Token: token}, nil
+}
+
+func (s *grpcServer) VerifyToken(ctx context.Context, req *userspb.VerifyTokenRequest) (*userspb.VerifyTokenResponse, error) {
+	resp, err := s.users.VerifyToken(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &userspb.VerifyTokenResponse{
+		Id:            resp.ID,
+		Username:      resp.Username,
+		Role:          resp.Role,
+		EmailVerified: resp.EmailVerified,
+	}, nil
+}
+
+// authorize verifies the bearer token of the authorization metadata and checks that it belongs
+// to the user with the given id or to an admin. It returns the context recording the caller.
+func (s *grpcServer) authorize(ctx context.Context, id string) (context.Context, *users.VerifyTokenResponse, error) {
+	scheme, token, _ := strings.Cut(firstMetadata(ctx, "authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		token = ""
+	}
+
+	caller, err := s.users.VerifyToken(ctx, strings.TrimSpace(token))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if caller.ID != id && caller.Role != users.RoleAdmin.String() {
+		return nil, nil, users.ErrForbiddenRole
+	}
+
+	info := users.RequestInfoFromContext(ctx)
+	info.ActorID = caller.ID
+	return users.WithRequestInfo(ctx, info), caller, nil
+}
+
+// traced starts a server span for every call, continuing the trace of the caller
+// when the call carries trace context metadata
+func (s *grpcServer) traced(
+	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = s.propagator.Extract(ctx, metadataCarrier(md))
+
+	service, method, _ := strings.Cut(strings.TrimPrefix(info.FullMethod, "/"), "/")
+
+	ctx, span := s.tracer.Start(ctx, info.FullMethod,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("grpc"),
+			semconv.RPCServiceKey.String(service),
+			semconv.RPCMethodKey.String(method),
+		),
+	)
+	defer span.End()
+
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+	if code == grpccodes.Internal || code == grpccodes.Unknown {
+		span.SetStatus(codes.Error, code.String())
+	}
+	return resp, err
+}
+
+// withGRPCRequestInfo attaches the client address and user agent of calls to their context.
+// The address is the peer of the connection: forwarding metadata can be forged by clients.
+func withGRPCRequestInfo(
+	ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (interface{}, error) {
+	var info users.RequestInfo
+
+	if p, ok := peer.FromContext(ctx); ok {
+		info.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(info.IP); err == nil {
+			info.IP = host
+		}
+	}
+	info.UserAgent = firstMetadata(ctx, "user-agent")
+
+	return handler(users.WithRequestInfo(ctx, info), req)
+}
+
+// convertErrors returns the errors of the service with the status of users.GRPCStatus,
+// logging the unexpected ones, whose message is not returned to clients
+func (s *grpcServer) convertErrors(
+	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err == nil {
+		return resp, nil
+	}
+
+	var e users.E
+	if !errors.As(err, &e) {
+		s.logger.Error("could not handle call", zap.String("method", info.FullMethod), zap.Error(err))
+	}
+	return nil, users.GRPCStatus(err).Err()
+}
+
+// currentSession returns the session of the caller among the sessions of the user with the given id.
+// Admins managing the sessions of another user have no current session among them.
+func currentSession(caller *users.VerifyTokenResponse, id string) string {
+	if caller.ID == id {
+		return caller.SessionID
+	}
+	return ""
+}
+
+func firstMetadata(ctx context.Context, key string) string {
+	if values := metadata.ValueFromIncomingContext(ctx, key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func newUserMessage(user *users.User) *userspb.User {
+	return &userspb.User{
+		Id:            user.ID,
+		Fullname:      user.Fullname,
+		Username:      user.Username,
+		Birthdate:     user.Birthdate.String(),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role.String(),
+		Locale:        user.Locale,
+		CreatedAt:     timestamppb.New(user.CreatedAt),
+		UpdatedAt:     timestamppb.New(user.UpdatedAt),
+	}
+}
+
+func optionalTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
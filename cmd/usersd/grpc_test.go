@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users"
+	"github.com/alesr/stdservices/users/userspb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialGRPC serves srv over an in-memory listener and returns a connection to it
+func dialGRPC(t *testing.T, srv *grpcServer) *grpc.ClientConn {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+
+	server := srv.server()
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUserAgent("grpc-test"),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func TestGRPCServer_health(t *testing.T) {
+	t.Parallel()
+
+	srv := newGRPCServer(zap.NewNop(), &users.MockService{})
+	client := grpc_health_v1.NewHealthClient(dialGRPC(t, srv))
+
+	resp, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+
+	srv.drain()
+
+	resp, err = client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, resp.Status)
+}
+
+func TestGRPCServer_createUser(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	birthdate, err := users.ParseBirthdate("2000-01-01")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		givenErr     error
+		expectedCode codes.Code
+	}{
+		{
+			name:         "created",
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "already exists",
+			givenErr:     users.ErrAlreadyExists,
+			expectedCode: codes.AlreadyExists,
+		},
+		{
+			name:         "unexpected error",
+			givenErr:     errors.New("connection refused"),
+			expectedCode: codes.Internal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := &users.MockService{
+				CreateFunc: func(ctx context.Context, in users.CreateUserInput) (*users.User, error) {
+					if tc.givenErr != nil {
+						return nil, tc.givenErr
+					}
+
+					assert.Equal(t, "pt", in.Locale)
+					return &users.User{
+						ID:        "1",
+						Fullname:  in.Fullname,
+						Username:  in.Username,
+						Birthdate: birthdate,
+						Email:     in.Email,
+						Role:      users.RoleUser,
+						Locale:    in.Locale,
+						CreatedAt: createdAt,
+						UpdatedAt: createdAt,
+					}, nil
+				},
+			}
+
+			client := userspb.NewUsersClient(dialGRPC(t, newGRPCServer(zap.NewNop(), svc)))
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "accept-language", "pt")
+
+			user, err := client.CreateUser(ctx, &userspb.CreateUserRequest{
+				Fullname:        "John Doe",
+				Username:        "jdoe",
+				Birthdate:       "2000-01-01",
+				Email:           "jdoe@mail.com",
+				Password:        "password#123",
+				ConfirmPassword: "password#123",
+			})
+			assert.Equal(t, tc.expectedCode, status.Code(err))
+
+			if tc.expectedCode != codes.OK {
+				if tc.expectedCode == codes.Internal {
+					assert.Equal(t, "internal error", status.Convert(err).Message())
+				}
+				return
+			}
+
+			assert.Equal(t, "1", user.Id)
+			assert.Equal(t, "2000-01-01", user.Birthdate)
+			assert.Equal(t, "pt", user.Locale)
+			assert.True(t, createdAt.Equal(user.CreatedAt.AsTime()))
+		})
+	}
+}
+
+func TestGRPCServer_authorization(t *testing.T) {
+	t.Parallel()
+
+	svc := &users.MockService{
+		VerifyTokenFunc: func(ctx context.Context, token string) (*users.VerifyTokenResponse, error) {
+			switch token {
+			case "user-token":
+				return &users.VerifyTokenResponse{ID: "1", Username: "jdoe", Role: "user"}, nil
+			case "admin-token":
+				return &users.VerifyTokenResponse{ID: "2", Username: "admin", Role: "admin"}, nil
+			case "":
+				return nil, users.ErrTokenEmpty
+			default:
+				return nil, users.ErrTokenInvalid
+			}
+		},
+		DeleteFunc: func(ctx context.Context, id string) error {
+			return nil
+		},
+	}
+
+	testCases := []struct {
+		name         string
+		givenID      string
+		givenAuth    string
+		expectedCode codes.Code
+	}{
+		{
+			name:         "own account",
+			givenID:      "1",
+			givenAuth:    "Bearer user-token",
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "admin",
+			givenID:      "1",
+			givenAuth:    "Bearer admin-token",
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "other account",
+			givenID:      "3",
+			givenAuth:    "Bearer user-token",
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "no token",
+			givenID:      "1",
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "invalid token",
+			givenID:      "1",
+			givenAuth:    "Bearer forged",
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "other scheme",
+			givenID:      "1",
+			givenAuth:    "Basic user-token",
+			expectedCode: codes.Unauthenticated,
+		},
+	}
+
+	client := userspb.NewUsersClient(dialGRPC(t, newGRPCServer(zap.NewNop(), svc)))
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.givenAuth != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", tc.givenAuth)
+			}
+
+			_, err := client.DeleteUser(ctx, &userspb.DeleteUserRequest{Id: tc.givenID})
+			assert.Equal(t, tc.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func TestGRPCServer_requestInfo(t *testing.T) {
+	t.Parallel()
+
+	var infos []users.RequestInfo
+
+	svc := &users.MockService{
+		VerifyTokenFunc: func(ctx context.Context, token string) (*users.VerifyTokenResponse, error) {
+			return &users.VerifyTokenResponse{ID: "2", Username: "admin", Role: "admin"}, nil
+		},
+		DeleteFunc: func(ctx context.Context, id string) error {
+			infos = append(infos, users.RequestInfoFromContext(ctx))
+			return nil
+		},
+		GenerateTokenFunc: func(ctx context.Context, email, password string) (string, error) {
+			infos = append(infos, users.RequestInfoFromContext(ctx))
+			return "token", nil
+		},
+	}
+
+	client := userspb.NewUsersClient(dialGRPC(t, newGRPCServer(zap.NewNop(), svc)))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer admin-token")
+	_, err := client.DeleteUser(ctx, &userspb.DeleteUserRequest{Id: "1"})
+	require.NoError(t, err)
+
+	resp, err := client.GenerateToken(context.Background(), &userspb.GenerateTokenRequest{
+		Email:    "jdoe@mail.com",
+		Password: "password#123",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "token", resp.Token)
+
+	// bufconn connections have no IP address, and grpc-go appends its own user agent
+	require.Len(t, infos, 2)
+	assert.Equal(t, "2", infos[0].ActorID)
+	assert.Contains(t, infos[0].UserAgent, "grpc-test")
+	assert.Empty(t, infos[1].ActorID)
+	assert.Contains(t, infos[1].UserAgent, "grpc-test")
+}
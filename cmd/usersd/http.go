@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/alesr/stdservices/users"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// maxBodySize bounds the size of request bodies
+const maxBodySize = 1 << 20
+
+type (
+	// server exposes the users service over HTTP with JSON bodies
+	server struct {
+		logger *zap.Logger
+		users  users.Service
+
+		// ping checks the dependencies of the server, such as the database, for readiness
+		ping func(ctx context.Context) error
+
+		// draining is set once shutdown starts, so that load balancers stop routing requests
+		draining int32
+
+		tracer     trace.Tracer
+		propagator propagation.TextMapPropagator
+	}
+
+	createUserRequest struct {
+		Fullname        string `json:"fullname"`
+		Username        string `json:"username"`
+		Birthdate       string `json:"birthdate"`
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		ConfirmPassword string `json:"confirm_password"`
+		Locale          string `json:"locale"`
+	}
+
+	generateTokenRequest struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	verifyEmailCodeRequest struct {
+		Code string `json:"code"`
+	}
+
+	userResponse struct {
+		ID            string    `json:"id"`
+		Fullname      string    `json:"fullname"`
+		Username      string    `json:"username"`
+		Birthdate     string    `json:"birthdate"`
+		Email         string    `json:"email"`
+		EmailVerified bool      `json:"email_verified"`
+		Role          string    `json:"role"`
+		Locale        string    `json:"locale"`
+		CreatedAt     time.Time `json:"created_at"`
+		UpdatedAt     time.Time `json:"updated_at"`
+	}
+
+	tokenResponse struct {
+		Token string `json:"token"`
+	}
+
+	verifyTokenResponse struct {
+		ID            string `json:"id"`
+		Username      string `json:"username"`
+		Role          string `json:"role"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+
+	sessionResponse struct {
+		ID         string    `json:"id"`
+		UserAgent  string    `json:"user_agent"`
+		IP         string    `json:"ip"`
+		CreatedAt  time.Time `json:"created_at"`
+		LastSeenAt time.Time `json:"last_seen_at"`
+		ExpiresAt  time.Time `json:"expires_at"`
+		// Current is set on the session of the token of the request
+		Current bool `json:"current"`
+	}
+
+	verificationStatusResponse struct {
+		EmailVerified bool       `json:"email_verified"`
+		Pending       bool       `json:"pending"`
+		ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+		LastSentAt    *time.Time `json:"last_sent_at,omitempty"`
+		NextSendAt    *time.Time `json:"next_send_at,omitempty"`
+	}
+
+	internalError struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+)
+
+func newServer(logger *zap.Logger, svc users.Service, ping func(ctx context.Context) error) *server {
+	return &server{
+		logger:     logger,
+		users:      svc,
+		ping:       ping,
+		tracer:     otel.Tracer(tracerName),
+		propagator: otel.GetTextMapPropagator(),
+	}
+}
+
+// handler returns the routes of the server:
+//
+//	GET    /healthz                                  liveness
+//	GET    /readyz                                   readiness
+//	POST   /users                                    create a user
+//	GET    /users/{id}                               fetch a user
+//	DELETE /users/{id}                               delete a user
+//	GET    /users/{id}/email-verification            get the verification status
+//	POST   /users/{id}/email-verification            send a verification email
+//	POST   /users/{id}/email-verification/code       verify the email with a code
+//	GET    /users/{id}/sessions                      list the active sessions
+//	DELETE /users/{id}/sessions                      revoke every session but the caller's
+//	DELETE /users/{id}/sessions/{sid}                revoke a session
+//	GET    /verify-email/{token}                     verify the email with a link
+//	POST   /tokens                                   generate a token
+//	GET    /tokens/verify                            verify the bearer token
+//
+// Routes under /users/{id} require a bearer token of the user or of an admin.
+// Requests other than health checks are traced, continuing the trace of W3C trace context headers.
+// The client address and user agent of requests, and the caller of authorized routes, are passed
+// to the service for the audit log.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
+	mux.Handle("/users", s.traced("/users", s.handleUsers))
+	mux.Handle("/users/", s.traced("/users/", s.handleUser))
+	mux.Handle("/verify-email/", s.traced("/verify-email/", s.handleVerifyEmail))
+	mux.Handle("/tokens", s.traced("/tokens", s.handleTokens))
+	mux.Handle("/tokens/verify", s.traced("/tokens/verify", s.handleVerifyToken))
+	return withRequestInfo(mux)
+}
+
+// drain marks the server as shutting down, failing readiness checks
+func (s *server) drain() {
+	atomic.StoreInt32(&s.draining, 1)
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.draining) == 1 {
+		s.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
+
+	if s.ping != nil {
+		if err := s.ping(r.Context()); err != nil {
+			s.logger.Warn("readiness check failed", zap.Error(err))
+			s.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable"})
+			return
+		}
+	}
+	s.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req createUserRequest
+	if err := s.decode(w, r, &req); err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	if req.Locale == "" {
+		req.Locale = r.Header.Get("Accept-Language")
+	}
+
+	user, err := s.users.Create(r.Context(), users.CreateUserInput{
+		Fullname:        req.Fullname,
+		Username:        req.Username,
+		Birthdate:       req.Birthdate,
+		Email:           req.Email,
+		Password:        req.Password,
+		ConfirmPassword: req.ConfirmPassword,
+		Locale:          req.Locale,
+	})
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, newUserResponse(user))
+}
+
+func (s *server) handleUser(w http.ResponseWriter, r *http.Request) {
+	id, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/users/"), "/")
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	caller, err := s.authorize(r, id)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	r = withActor(r, caller.ID)
+
+	switch sub {
+	case "":
+		s.handleUserByID(w, r, id)
+	case "email-verification":
+		s.handleEmailVerification(w, r, id)
+	case "email-verification/code":
+		s.handleEmailVerificationCode(w, r, id, caller)
+	case "sessions":
+		s.handleSessions(w, r, id, caller)
+	default:
+		if sessionID := strings.TrimPrefix(sub, "sessions/"); sessionID != sub && sessionID != "" {
+			s.handleSession(w, r, id, sessionID)
+			return
+		}
+		http.NotFound(w, r)
+	}
+}
+
+func (s *server) handleUserByID(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		user, err := s.users.FetchByID(r.Context(), id)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, newUserResponse(user))
+	case http.MethodDelete:
+		if err := s.users.Delete(r.Context(), id); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func (s *server) handleEmailVerification(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		status, err := s.users.GetVerificationStatus(r.Context(), id)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		s.writeJSON(w, http.StatusOK, newVerificationStatusResponse(status))
+	case http.MethodPost:
+		user, err := s.users.FetchByID(r.Context(), id)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+
+		if err := s.users.SendEmailVerification(r.Context(), user.ID, user.Username, user.Email); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		s.methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (s *server) handleEmailVerificationCode(w http.ResponseWriter, r *http.Request, id string, caller *users.VerifyTokenResponse) {
+	if r.Method != http.MethodPost {
+		s.methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	// Only the owner of the mailbox can prove it received the code
+	if caller.ID != id {
+		s.writeError(w, users.ErrForbiddenRole)
+		return
+	}
+
+	var req verifyEmailCodeRequest
+	if err := s.decode(w, r, &req); err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	if err := s.users.VerifyEmailCode(r.Context(), id, req.Code); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) handleSessions(w http.ResponseWriter, r *http.Request, id string, caller *users.VerifyTokenResponse) {
+	// Admins managing the sessions of another user have no current session among them
+	var currentSessionID string
+	if caller.ID == id {
+		currentSessionID = caller.SessionID
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := s.users.ListSessions(r.Context(), id)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+
+		resp := make([]sessionResponse, 0, len(sessions))
+		for _, session := range sessions {
+			resp = append(resp, newSessionResponse(session, currentSessionID))
+		}
+		s.writeJSON(w, http.StatusOK, resp)
+	case http.MethodDelete:
+		if err := s.users.RevokeOtherSessions(r.Context(), id, currentSessionID); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func (s *server) handleSession(w http.ResponseWriter, r *http.Request, id, sessionID string) {
+	if r.Method != http.MethodDelete {
+		s.methodNotAllowed(w, http.MethodDelete)
+		return
+	}
+
+	if err := s.users.RevokeSession(r.Context(), id, sessionID); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		s.methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	}
+
+	if err := s.users.VerifyEmail(r.Context(), strings.TrimPrefix(r.URL.Path, "/verify-email/")); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req generateTokenRequest
+	if err := s.decode(w, r, &req); err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	token, err := s.users.GenerateToken(r.Context(), req.Email, req.Password)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, tokenResponse{Token: token})
+}
+
+func (s *server) handleVerifyToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	resp, err := s.users.VerifyToken(r.Context(), bearerToken(r))
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, verifyTokenResponse{
+		ID:            resp.ID,
+		Username:      resp.Username,
+		Role:          resp.Role,
+		EmailVerified: resp.EmailVerified,
+	})
+}
+
+// authorize verifies the bearer token of the request and
+// checks that it belongs to the user with the given id or to an admin
+func (s *server) authorize(r *http.Request, id string) (*users.VerifyTokenResponse, error) {
+	caller, err := s.users.VerifyToken(r.Context(), bearerToken(r))
+	if err != nil {
+		return nil, err
+	}
+
+	if caller.ID != id && caller.Role != users.RoleAdmin.String() {
+		return nil, users.ErrForbiddenRole
+	}
+	return caller, nil
+}
+
+// withRequestInfo attaches the client address and user agent of requests to their context.
+// The address is the peer of the connection: forwarding headers can be forged by clients.
+func withRequestInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		info := users.RequestInfo{IP: ip, UserAgent: r.UserAgent()}
+		next.ServeHTTP(w, r.WithContext(users.WithRequestInfo(r.Context(), info)))
+	})
+}
+
+// withActor returns a copy of the request recording the id of the authorized caller
+func withActor(r *http.Request, actorID string) *http.Request {
+	info := users.RequestInfoFromContext(r.Context())
+	info.ActorID = actorID
+	return r.WithContext(users.WithRequestInfo(r.Context(), info))
+}
+
+func (s *server) decode(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return users.ErrValidation
+	}
+	return nil
+}
+
+func (s *server) writeError(w http.ResponseWriter, err error) {
+	status := users.HTTPStatus(err)
+
+	var e users.E
+	if !errors.As(err, &e) {
+		s.logger.Error("could not handle request", zap.Error(err))
+		s.writeJSON(w, status, internalError{Code: "internal", Message: http.StatusText(status)})
+		return
+	}
+	s.writeJSON(w, status, e)
+}
+
+func (s *server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Error("could not encode response", zap.Error(err))
+	}
+}
+
+func (s *server) methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	s.writeJSON(w, http.StatusMethodNotAllowed, internalError{
+		Code:    "method_not_allowed",
+		Message: http.StatusText(http.StatusMethodNotAllowed),
+	})
+}
+
+func bearerToken(r *http.Request) string {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func newUserResponse(user *users.User) userResponse {
+	return userResponse{
+		ID:            user.ID,
+		Fullname:      user.Fullname,
+		Username:      user.Username,
+		Birthdate:     user.Birthdate.String(),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role.String(),
+		Locale:        user.Locale,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+	}
+}
+
+func newVerificationStatusResponse(status *users.VerificationStatus) verificationStatusResponse {
+	return verificationStatusResponse{
+		EmailVerified: status.EmailVerified,
+		Pending:       status.Pending,
+		ExpiresAt:     optionalTime(status.ExpiresAt),
+		LastSentAt:    optionalTime(status.LastSentAt),
+		NextSendAt:    optionalTime(status.NextSendAt),
+	}
+}
+
+func newSessionResponse(session users.Session, currentSessionID string) sessionResponse {
+	return sessionResponse{
+		ID:         session.ID,
+		UserAgent:  session.UserAgent,
+		IP:         session.IP,
+		CreatedAt:  session.CreatedAt,
+		LastSeenAt: session.LastSeenAt,
+		ExpiresAt:  session.ExpiresAt,
+		Current:    currentSessionID != "" && session.ID == currentSessionID,
+	}
+}
+
+// optionalTime returns nil for the zero time, so that it is omitted from responses
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+func TestServer_health(t *testing.T) {
+	t.Parallel()
+
+	var pingErr error
+
+	srv := newServer(zap.NewNop(), &users.MockService{}, func(ctx context.Context) error { return pingErr })
+	handler := srv.handler()
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get("/healthz"))
+	assert.Equal(t, http.StatusOK, get("/readyz"))
+
+	pingErr = errors.New("connection refused")
+	assert.Equal(t, http.StatusServiceUnavailable, get("/readyz"))
+	assert.Equal(t, http.StatusOK, get("/healthz"))
+
+	pingErr = nil
+	srv.drain()
+	assert.Equal(t, http.StatusServiceUnavailable, get("/readyz"))
+	assert.Equal(t, http.StatusOK, get("/healthz"))
+}
+
+func TestServer_createUser(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	birthdate, err := users.ParseBirthdate("2000-01-01")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name           string
+		givenBody      string
+		givenErr       error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "created",
+			givenBody:      `{"fullname":"John Doe","username":"jdoe","birthdate":"2000-01-01","email":"jdoe@mail.com","password":"password#123","confirm_password":"password#123"}`,
+			expectedStatus: http.StatusCreated,
+			expectedBody: `{"id":"1","fullname":"John Doe","username":"jdoe","birthdate":"2000-01-01","email":"jdoe@mail.com",` +
+				`"email_verified":false,"role":"user","locale":"pt","created_at":"2022-06-15T12:00:00Z","updated_at":"2022-06-15T12:00:00Z"}`,
+		},
+		{
+			name:           "already exists",
+			givenBody:      `{"username":"jdoe"}`,
+			givenErr:       users.ErrAlreadyExists,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"code":"user.already_exists","message":"user already exists"}`,
+		},
+		{
+			name:           "malformed body",
+			givenBody:      `{"username":`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"code":"validation.failed","message":"user input is invalid"}`,
+		},
+		{
+			name:           "unexpected error",
+			givenBody:      `{"username":"jdoe"}`,
+			givenErr:       errors.New("connection refused"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"code":"internal","message":"Internal Server Error"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := &users.MockService{
+				CreateFunc: func(ctx context.Context, in users.CreateUserInput) (*users.User, error) {
+					if tc.givenErr != nil {
+						return nil, tc.givenErr
+					}
+
+					assert.Equal(t, "pt-BR,pt;q=0.9", in.Locale)
+					return &users.User{
+						ID:        "1",
+						Fullname:  in.Fullname,
+						Username:  in.Username,
+						Birthdate: birthdate,
+						Email:     in.Email,
+						Role:      users.RoleUser,
+						Locale:    "pt",
+						CreatedAt: createdAt,
+						UpdatedAt: createdAt,
+					}, nil
+				},
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(tc.givenBody))
+			req.Header.Set("Accept-Language", "pt-BR,pt;q=0.9")
+
+			rec := httptest.NewRecorder()
+			newServer(zap.NewNop(), svc, nil).handler().ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			assert.JSONEq(t, tc.expectedBody, rec.Body.String())
+		})
+	}
+}
+
+func TestServer_authorization(t *testing.T) {
+	t.Parallel()
+
+	svc := &users.MockService{
+		VerifyTokenFunc: func(ctx context.Context, token string) (*users.VerifyTokenResponse, error) {
+			switch token {
+			case "user-token":
+				return &users.VerifyTokenResponse{ID: "1", Username: "jdoe", Role: "user"}, nil
+			case "admin-token":
+				return &users.VerifyTokenResponse{ID: "2", Username: "admin", Role: "admin"}, nil
+			case "":
+				return nil, users.ErrTokenEmpty
+			default:
+				return nil, users.ErrTokenInvalid
+			}
+		},
+		DeleteFunc: func(ctx context.Context, id string) error {
+			return nil
+		},
+		VerifyEmailCodeFunc: func(ctx context.Context, userID, code string) error {
+			assert.Equal(t, "123456", code)
+			return nil
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		givenMethod    string
+		givenPath      string
+		givenToken     string
+		givenBody      string
+		expectedStatus int
+	}{
+		{
+			name:           "own account",
+			givenMethod:    http.MethodDelete,
+			givenPath:      "/users/1",
+			givenToken:     "user-token",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "admin",
+			givenMethod:    http.MethodDelete,
+			givenPath:      "/users/1",
+			givenToken:     "admin-token",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "other account",
+			givenMethod:    http.MethodDelete,
+			givenPath:      "/users/3",
+			givenToken:     "user-token",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "no token",
+			givenMethod:    http.MethodDelete,
+			givenPath:      "/users/1",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "invalid token",
+			givenMethod:    http.MethodDelete,
+			givenPath:      "/users/1",
+			givenToken:     "forged",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "code of own account",
+			givenMethod:    http.MethodPost,
+			givenPath:      "/users/1/email-verification/code",
+			givenToken:     "user-token",
+			givenBody:      `{"code":"123456"}`,
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "admins cannot verify codes for users",
+			givenMethod:    http.MethodPost,
+			givenPath:      "/users/1/email-verification/code",
+			givenToken:     "admin-token",
+			givenBody:      `{"code":"123456"}`,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "method not allowed",
+			givenMethod:    http.MethodPut,
+			givenPath:      "/users/1",
+			givenToken:     "user-token",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "unknown route",
+			givenMethod:    http.MethodGet,
+			givenPath:      "/users/1/unknown",
+			givenToken:     "user-token",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	handler := newServer(zap.NewNop(), svc, nil).handler()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.givenMethod, tc.givenPath, strings.NewReader(tc.givenBody))
+			if tc.givenToken != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.givenToken)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+		})
+	}
+}
+
+func TestServer_emailVerification(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	var sentTo, verifiedToken string
+
+	svc := &users.MockService{
+		VerifyTokenFunc: func(ctx context.Context, token string) (*users.VerifyTokenResponse, error) {
+			return &users.VerifyTokenResponse{ID: "1", Username: "jdoe", Role: "user"}, nil
+		},
+		FetchByIDFunc: func(ctx context.Context, id string) (*users.User, error) {
+			return &users.User{ID: id, Username: "jdoe", Email: "jdoe@mail.com"}, nil
+		},
+		SendEmailVerificationFunc: func(ctx context.Context, userID, username, to string) error {
+			sentTo = to
+			return nil
+		},
+		GetVerificationStatusFunc: func(ctx context.Context, userID string) (*users.VerificationStatus, error) {
+			return &users.VerificationStatus{Pending: true, ExpiresAt: now.Add(time.Hour), LastSentAt: now}, nil
+		},
+		VerifyEmailFunc: func(ctx context.Context, token string) error {
+			verifiedToken = token
+			return nil
+		},
+	}
+
+	handler := newServer(zap.NewNop(), svc, nil).handler()
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer user-token")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "/users/1/email-verification")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "jdoe@mail.com", sentTo)
+
+	rec = serve(http.MethodGet, "/users/1/email-verification")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t,
+		`{"email_verified":false,"pending":true,"expires_at":"2022-06-15T13:00:00Z","last_sent_at":"2022-06-15T12:00:00Z"}`,
+		rec.Body.String(),
+	)
+
+	rec = serve(http.MethodGet, "/verify-email/abc123")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "abc123", verifiedToken)
+}
+
+func TestServer_sessions(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	var revoked, kept []string
+
+	svc := &users.MockService{
+		VerifyTokenFunc: func(ctx context.Context, token string) (*users.VerifyTokenResponse, error) {
+			if token == "admin-token" {
+				return &users.VerifyTokenResponse{ID: "9", Username: "admin", Role: "admin", SessionID: "s9"}, nil
+			}
+			return &users.VerifyTokenResponse{ID: "1", Username: "jdoe", Role: "user", SessionID: "s1"}, nil
+		},
+		ListSessionsFunc: func(ctx context.Context, userID string) ([]users.Session, error) {
+			return []users.Session{
+				{ID: "s1", UserAgent: "curl/7.79.1", IP: "203.0.113.7", CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)},
+				{ID: "s2", UserAgent: "Firefox", IP: "198.51.100.1", CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)},
+			}, nil
+		},
+		RevokeSessionFunc: func(ctx context.Context, userID, sessionID string) error {
+			if sessionID == "unknown" {
+				return users.ErrSessionNotFound
+			}
+			revoked = append(revoked, sessionID)
+			return nil
+		},
+		RevokeOtherSessionsFunc: func(ctx context.Context, userID, currentSessionID string) error {
+			kept = append(kept, currentSessionID)
+			return nil
+		},
+	}
+
+	handler := newServer(zap.NewNop(), svc, nil).handler()
+
+	serve := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "/users/1/sessions", "user-token")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[
+		{"id":"s1","user_agent":"curl/7.79.1","ip":"203.0.113.7","created_at":"2022-06-15T12:00:00Z",
+		"last_seen_at":"2022-06-15T12:00:00Z","expires_at":"2022-06-15T13:00:00Z","current":true},
+		{"id":"s2","user_agent":"Firefox","ip":"198.51.100.1","created_at":"2022-06-15T12:00:00Z",
+		"last_seen_at":"2022-06-15T12:00:00Z","expires_at":"2022-06-15T13:00:00Z","current":false}
+	]`, rec.Body.String())
+
+	rec = serve(http.MethodDelete, "/users/1/sessions/s2", "user-token")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, []string{"s2"}, revoked)
+
+	rec = serve(http.MethodDelete, "/users/1/sessions/unknown", "user-token")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(http.MethodGet, "/users/1/sessions/s2", "user-token")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	// Users keep the session of the request, while admins revoke every session of other users
+	rec = serve(http.MethodDelete, "/users/1/sessions", "user-token")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = serve(http.MethodDelete, "/users/1/sessions", "admin-token")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, []string{"s1", ""}, kept)
+
+	rec = serve(http.MethodGet, "/users/2/sessions", "user-token")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestServer_tokens(t *testing.T) {
+	t.Parallel()
+
+	svc := &users.MockService{
+		GenerateTokenFunc: func(ctx context.Context, email, password string) (string, error) {
+			if password != "password#123" {
+				return "", users.ErrPasswordInvalid
+			}
+			return "user-token", nil
+		},
+		VerifyTokenFunc: func(ctx context.Context, token string) (*users.VerifyTokenResponse, error) {
+			assert.Equal(t, "user-token", token)
+			return &users.VerifyTokenResponse{ID: "1", Username: "jdoe", Role: "user"}, nil
+		},
+	}
+
+	handler := newServer(zap.NewNop(), svc, nil).handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tokens",
+		strings.NewReader(`{"email":"jdoe@mail.com","password":"password#123"}`)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.JSONEq(t, `{"token":"user-token"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tokens",
+		strings.NewReader(`{"email":"jdoe@mail.com","password":"wrong"}`)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/tokens/verify", nil)
+	req.Header.Set("Authorization", "bearer user-token")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":"1","username":"jdoe","role":"user","email_verified":false}`, rec.Body.String())
+}
+
+func TestServer_tracing(t *testing.T) {
+	t.Parallel()
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		userID  = "8b3a2f7e-1c2d-4e5f-8a9b-0c1d2e3f4a5b"
+	)
+
+	var fetchedIn trace.SpanContext
+
+	svc := &users.MockService{
+		VerifyTokenFunc: func(ctx context.Context, token string) (*users.VerifyTokenResponse, error) {
+			return &users.VerifyTokenResponse{ID: userID, Username: "jdoe", Role: "user"}, nil
+		},
+		FetchByIDFunc: func(ctx context.Context, id string) (*users.User, error) {
+			fetchedIn = trace.SpanContextFromContext(ctx)
+			return nil, users.ErrNotFound
+		},
+	}
+
+	exporter := tracetest.NewInMemoryExporter()
+
+	srv := newServer(zap.NewNop(), svc, nil)
+	srv.tracer = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer(tracerName)
+	srv.propagator = propagation.TraceContext{}
+
+	req := httptest.NewRequest(http.MethodGet, "/users/"+userID, nil)
+	req.Header.Set("Authorization", "Bearer user-token")
+	req.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+	rec := httptest.NewRecorder()
+	srv.handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+
+	span := spans[0]
+	assert.Equal(t, "GET /users/", span.Name)
+	assert.Equal(t, traceID, span.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID().String())
+	assert.Equal(t, span.SpanContext.SpanID(), fetchedIn.SpanID())
+	assert.Contains(t, span.Attributes, attribute.Int("http.status_code", http.StatusNotFound))
+	assert.Contains(t, span.Attributes, attribute.String("http.route", "/users/"))
+}
+
+func TestServer_requestInfo(t *testing.T) {
+	t.Parallel()
+
+	var infos []users.RequestInfo
+
+	svc := &users.MockService{
+		VerifyTokenFunc: func(ctx context.Context, token string) (*users.VerifyTokenResponse, error) {
+			return &users.VerifyTokenResponse{ID: "2", Username: "admin", Role: "admin"}, nil
+		},
+		DeleteFunc: func(ctx context.Context, id string) error {
+			infos = append(infos, users.RequestInfoFromContext(ctx))
+			return nil
+		},
+		GenerateTokenFunc: func(ctx context.Context, email, password string) (string, error) {
+			infos = append(infos, users.RequestInfoFromContext(ctx))
+			return "token", nil
+		},
+	}
+
+	handler := newServer(zap.NewNop(), svc, nil).handler()
+
+	req := httptest.NewRequest(http.MethodDelete, "/users/1", nil)
+	req.RemoteAddr = "192.0.2.1:52044"
+	req.Header.Set("Authorization", "Bearer admin-token")
+	req.Header.Set("User-Agent", "curl/7.79.1")
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/tokens", strings.NewReader(`{"email":"jdoe@mail.com","password":"password#123"}`))
+	req.RemoteAddr = "[2001:db8::1]:52045"
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	assert.Equal(t, []users.RequestInfo{
+		{ActorID: "2", IP: "192.0.2.1", UserAgent: "curl/7.79.1"},
+		{IP: "2001:db8::1"},
+	}, infos)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/alesr/stdservices/audit"
+	auditrepo "github.com/alesr/stdservices/audit/repository"
+	"github.com/alesr/stdservices/migrations"
+	"github.com/alesr/stdservices/pkg/email"
+	"github.com/alesr/stdservices/pkg/email/queue"
+	queuerepo "github.com/alesr/stdservices/pkg/email/queue/repository"
+	"github.com/alesr/stdservices/pkg/migrate"
+	"github.com/alesr/stdservices/users"
+	usersrepo "github.com/alesr/stdservices/users/repository"
+	"github.com/alesr/stdservices/webhooks"
+	webhooksrepo "github.com/alesr/stdservices/webhooks/repository"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	_ "github.com/jackc/pgx/stdlib"
+)
+
+// connectTimeout bounds the initial connection to the database
+const connectTimeout = 10 * time.Second
+
+type emailSender interface {
+	Send(from, to string, body []byte) error
+}
+
+func main() {
+	configPath := flag.String("config", os.Getenv(envPrefix+"CONFIG"), "path to a YAML configuration file")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath, os.Getenv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	logger, err := newLogger(cfg.Log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Continue the traces of callers; spans are exported by the global tracer provider, if any
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	err = run(ctx, logger, cfg)
+	stop()
+
+	if err != nil {
+		logger.Error("usersd stopped with an error", zap.Error(err))
+		_ = logger.Sync()
+		os.Exit(1)
+	}
+	_ = logger.Sync()
+}
+
+// run starts the service and blocks until the context is canceled, then shuts down gracefully:
+// readiness checks fail, in-flight requests and gRPC calls are drained, and background workers are stopped.
+func run(ctx context.Context, logger *zap.Logger, cfg *config) error {
+	dbConn, err := sqlx.Open("pgx", cfg.Database.DSN)
+	if err != nil {
+		return fmt.Errorf("could not open database: %s", err)
+	}
+	defer dbConn.Close()
+
+	dbConn.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	dbConn.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+
+	pingCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	err = dbConn.PingContext(pingCtx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("could not connect to database: %s", err)
+	}
+
+	if cfg.Database.Migrate {
+		applied, err := migrate.Up(ctx, dbConn.DB, migrations.FS, migrate.WithSteps(migrations.Steps))
+		if err != nil {
+			return fmt.Errorf("could not migrate database: %s", err)
+		}
+		logger.Info("database migrated", zap.Int("applied", applied))
+	}
+
+	opts := []users.ServiceOption{
+		users.WithTokenTTL(cfg.JWT.TTL),
+		users.WithEmailVerificationTTL(cfg.Email.VerificationTTL),
+		users.WithEmailVerificationRateLimit(cfg.Verification.Cooldown, cfg.Verification.MaxSends, cfg.Verification.Window),
+		users.WithCleanupInterval(cfg.Verification.CleanupInterval),
+		users.WithUserCache(cfg.UserCache.Size, cfg.UserCache.TTL),
+		users.WithHashingLimit(cfg.Hashing.Concurrency, cfg.Hashing.Queue),
+	}
+
+	if cfg.JWT.PrivateKeyFile != "" {
+		key, err := loadPrivateKey(cfg.JWT.PrivateKeyFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, users.WithECDSASigningKey(cfg.JWT.KeyID, key))
+	}
+
+	var workers []func(ctx context.Context)
+
+	if cfg.Email.VerificationEndpoint != "" {
+		sender, closeSender, err := newEmailSender(logger, cfg.Email.SMTP)
+		if err != nil {
+			return err
+		}
+		defer closeSender()
+
+		sender = email.NewTracing(sender, otel.GetTracerProvider())
+
+		if cfg.Email.Queue {
+			outbox := queue.New(logger, queuerepo.NewPostgres(dbConn), sender)
+			workers = append(workers, outbox.Run)
+			sender = outbox
+		}
+
+		opts = append(opts, users.WithEmailVerification(
+			cfg.Email.SenderName, cfg.Email.SenderAddr, cfg.Email.VerificationEndpoint, sender,
+		))
+	}
+
+	if cfg.Webhooks.Enabled {
+		publisher := webhooks.New(logger, webhooksrepo.NewPostgres(dbConn))
+		workers = append(workers, publisher.Run)
+		opts = append(opts, users.WithEventPublisher(publisher))
+	}
+
+	if cfg.Audit.Enabled {
+		opts = append(opts, users.WithAuditor(audit.New(logger, auditrepo.NewPostgres(dbConn))))
+	}
+
+	var (
+		reg     *prometheus.Registry
+		metrics *users.Metrics
+	)
+
+	if cfg.Metrics.Enabled {
+		reg, err = newRegistry(dbConn.DB)
+		if err != nil {
+			return err
+		}
+
+		metrics, err = users.NewMetrics(reg)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, users.WithMetrics(metrics))
+	}
+
+	core := users.New(logger, cfg.JWT.SigningKey, usersrepo.NewPostgres(dbConn), opts...)
+	workers = append(workers, core.Run)
+
+	var svc users.Service = core
+	if metrics != nil {
+		svc = users.NewMetricsService(core, metrics)
+	}
+
+	srv := newServer(logger, svc, dbConn.PingContext)
+
+	handler := srv.handler()
+	if reg != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/", handler)
+		mux.Handle(cfg.Metrics.Path, promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+		handler = mux
+	}
+
+	httpServer := &http.Server{
+		Addr:              cfg.HTTP.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+	}
+
+	// Workers outlive the request context so that they stop only after requests are drained
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	var wg sync.WaitGroup
+	for _, work := range workers {
+		wg.Add(1)
+		go func(work func(ctx context.Context)) {
+			defer wg.Done()
+			work(workersCtx)
+		}(work)
+	}
+
+	// The gRPC listener is opened before serving anything, so that a taken address fails the start
+	var (
+		gsrv    *grpcServer
+		grpcSrv *grpc.Server
+		grpcLis net.Listener
+	)
+
+	if cfg.GRPC.Addr != "" {
+		grpcLis, err = net.Listen("tcp", cfg.GRPC.Addr)
+		if err != nil {
+			stopWorkers()
+			wg.Wait()
+			return fmt.Errorf("could not listen for grpc: %s", err)
+		}
+
+		gsrv = newGRPCServer(logger, svc)
+		grpcSrv = gsrv.server()
+	}
+
+	serveErr := make(chan error, 2)
+	go func() {
+		logger.Info("listening", zap.String("addr", cfg.HTTP.Addr))
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			serveErr <- fmt.Errorf("could not serve http: %s", err)
+		}
+	}()
+
+	if grpcSrv != nil {
+		go func() {
+			logger.Info("listening for grpc", zap.String("addr", cfg.GRPC.Addr))
+			if err := grpcSrv.Serve(grpcLis); err != nil && err != grpc.ErrServerStopped {
+				serveErr <- fmt.Errorf("could not serve grpc: %s", err)
+			}
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		_ = httpServer.Close()
+		if grpcSrv != nil {
+			grpcSrv.Stop()
+		}
+		stopWorkers()
+		wg.Wait()
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down", zap.Duration("timeout", cfg.HTTP.ShutdownTimeout))
+	srv.drain()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+
+	// gRPC calls are drained alongside http requests, and canceled if they outlast the timeout
+	grpcStopped := make(chan struct{})
+	if grpcSrv != nil {
+		gsrv.drain()
+
+		go func() {
+			defer close(grpcStopped)
+			grpcSrv.GracefulStop()
+		}()
+	} else {
+		close(grpcStopped)
+	}
+
+	shutdownErr := httpServer.Shutdown(shutdownCtx)
+
+	var grpcShutdownErr error
+	select {
+	case <-grpcStopped:
+	case <-shutdownCtx.Done():
+		select {
+		case <-grpcStopped:
+		default:
+			grpcSrv.Stop()
+			<-grpcStopped
+			grpcShutdownErr = shutdownCtx.Err()
+		}
+	}
+
+	stopWorkers()
+	wg.Wait()
+
+	if shutdownErr != nil {
+		return fmt.Errorf("could not drain http requests: %s", shutdownErr)
+	}
+	if grpcShutdownErr != nil {
+		return fmt.Errorf("could not drain grpc calls: %s", grpcShutdownErr)
+	}
+	logger.Info("shut down")
+	return nil
+}
+
+// newEmailSender returns an SMTP sender, or a sender logging emails when no SMTP host is configured
+func newEmailSender(logger *zap.Logger, cfg smtpConfig) (emailSender, func(), error) {
+	if cfg.Host == "" {
+		logger.Warn("no smtp host configured, emails are logged instead of sent")
+		return email.NewLog(logger), func() {}, nil
+	}
+
+	sender, err := email.NewSMTP(email.SMTPConfig{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		TLSMode:  email.TLSMode(cfg.TLSMode),
+		Auth:     email.AuthMechanism(cfg.Auth),
+		Username: cfg.Username,
+		Password: cfg.Password,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create smtp sender: %s", err)
+	}
+
+	return sender, func() {
+		if err := sender.Close(); err != nil {
+			logger.Warn("could not close smtp sender", zap.Error(err))
+		}
+	}, nil
+}
+
+// newRegistry returns a Prometheus registry with the Go runtime, process and database pool collectors
+func newRegistry(db *sql.DB) (*prometheus.Registry, error) {
+	reg := prometheus.NewRegistry()
+
+	for _, c := range []prometheus.Collector{
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, "users"),
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("could not register metrics: %s", err)
+		}
+	}
+	return reg, nil
+}
+
+func newLogger(cfg logConfig) (*zap.Logger, error) {
+	if cfg.Development {
+		return zap.NewDevelopment()
+	}
+	return zap.NewProduction()
+}
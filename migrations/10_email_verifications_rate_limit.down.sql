@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS email_verifications_expires_at_idx;
+DROP INDEX IF EXISTS email_verifications_email_idx;
+ALTER TABLE email_verifications DROP COLUMN IF EXISTS invalidated_at;
+ALTER TABLE email_verifications DROP COLUMN IF EXISTS email;
@@ -0,0 +1,13 @@
+-- email is the canonical address the verification was sent to, used to rate limit sends per address
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS email VARCHAR(255);
+
+UPDATE email_verifications SET email = users.email_normalized
+FROM users WHERE users.id = email_verifications.user_id AND email_verifications.email IS NULL;
+
+ALTER TABLE email_verifications ALTER COLUMN email SET NOT NULL;
+
+-- invalidated_at is set when a newer verification is sent to the user
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS invalidated_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS email_verifications_email_idx ON email_verifications (email, created_at);
+CREATE INDEX IF NOT EXISTS email_verifications_expires_at_idx ON email_verifications (expires_at);
@@ -0,0 +1,4 @@
+-- tokens_revoked_at invalidates the tokens of a user issued before it, such as after a password reset
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_revoked_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at, id);
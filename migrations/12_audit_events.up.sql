@@ -0,0 +1,30 @@
+-- audit_events is an append-only log of operations on user accounts. Each event carries the hash
+-- of the previous one, so that altering, removing or inserting events breaks the chain.
+CREATE TABLE IF NOT EXISTS audit_events (
+    seq BIGINT PRIMARY KEY,
+    action VARCHAR(64) NOT NULL,
+    actor_id VARCHAR(255) NOT NULL DEFAULT '',
+    subject_id VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    outcome VARCHAR(16) NOT NULL,
+    reason VARCHAR(64) NOT NULL DEFAULT '',
+    -- metadata is TEXT rather than JSONB, which would not preserve the hashed bytes
+    metadata TEXT NOT NULL DEFAULT '',
+    occurred_at TIMESTAMP NOT NULL,
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id, seq);
+CREATE INDEX IF NOT EXISTS audit_events_subject_id_idx ON audit_events (subject_id, seq);
+CREATE INDEX IF NOT EXISTS audit_events_occurred_at_idx ON audit_events (occurred_at);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_username_normalized_key;
+ALTER TABLE users ALTER COLUMN username_normalized DROP NOT NULL;
@@ -0,0 +1,6 @@
+-- Runs after the Go step of this migration, see migrations.Steps, which backfills the confusable skeleton of
+-- every username, including the ones stored in lower case by earlier versions of migration 6.
+-- Migrating with golang-migrate skips the step: run usersctl migrate instead.
+ALTER TABLE users ALTER COLUMN username_normalized SET NOT NULL;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_username_normalized_key;
+ALTER TABLE users ADD CONSTRAINT users_username_normalized_key UNIQUE (username_normalized);
@@ -0,0 +1,6 @@
+-- Runs after the Go step of this migration, see migrations.NewSteps, which backfills the canonical email of
+-- every user, including the ones stored in lower case by earlier versions of migration 8.
+-- Migrating with golang-migrate skips the step: run usersctl migrate instead.
+ALTER TABLE users ALTER COLUMN email_normalized SET NOT NULL;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_normalized_key;
+ALTER TABLE users ADD CONSTRAINT users_email_normalized_key UNIQUE (email_normalized);
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username_normalized VARCHAR(255);
+
+-- The service stores the confusable skeleton of the username, which SQL cannot compute.
+-- Existing users are backfilled by the Go step of migration 14, which then makes the column unique.
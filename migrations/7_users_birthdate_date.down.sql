@@ -0,0 +1 @@
+ALTER TABLE users ALTER COLUMN birthdate TYPE VARCHAR(10) USING TO_CHAR(birthdate, 'YYYY-MM-DD');
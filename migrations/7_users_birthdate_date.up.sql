@@ -0,0 +1,2 @@
+-- Birthdates were validated in the format YYYY-MM-DD, so they cast directly to dates
+ALTER TABLE users ALTER COLUMN birthdate TYPE DATE USING birthdate::DATE;
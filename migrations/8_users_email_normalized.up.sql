@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_normalized VARCHAR(255);
+
+-- The service stores the canonical email, see validate.EmailPolicy, which SQL cannot compute.
+-- Existing users are backfilled by the Go step of migration 15, which then makes the column unique.
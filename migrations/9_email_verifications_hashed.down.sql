@@ -0,0 +1,11 @@
+-- Raw codes cannot be recovered from their hashes, so outstanding verifications are dropped
+DELETE FROM email_verifications;
+
+DROP INDEX IF EXISTS email_verifications_user_id_idx;
+ALTER TABLE email_verifications DROP COLUMN IF EXISTS verified_at;
+ALTER TABLE email_verifications DROP COLUMN IF EXISTS attempts;
+ALTER TABLE email_verifications DROP COLUMN IF EXISTS code_hash;
+ALTER TABLE email_verifications DROP COLUMN IF EXISTS token_hash;
+
+ALTER TABLE email_verifications ADD COLUMN code VARCHAR(32) NOT NULL PRIMARY KEY;
+CREATE INDEX ON email_verifications(code);
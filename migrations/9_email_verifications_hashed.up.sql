@@ -0,0 +1,14 @@
+-- Codes are replaced by the SHA-256 hash of the link token, so outstanding links keep working.
+-- Verifications created before this migration have no short code.
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS token_hash CHAR(64);
+UPDATE email_verifications SET token_hash = ENCODE(SHA256(CONVERT_TO(code, 'UTF8')), 'hex');
+
+ALTER TABLE email_verifications DROP COLUMN code;
+ALTER TABLE email_verifications ALTER COLUMN token_hash SET NOT NULL;
+ALTER TABLE email_verifications ADD PRIMARY KEY (token_hash);
+
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS code_hash CHAR(64);
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS verified_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS email_verifications_user_id_idx ON email_verifications (user_id, created_at);
@@ -0,0 +1,8 @@
+package migrations
+
+import "embed"
+
+// FS holds the SQL migrations, so that binaries can apply them without the source tree
+//
+//go:embed *.sql
+var FS embed.FS
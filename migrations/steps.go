@@ -0,0 +1,185 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alesr/stdservices/pkg/validate"
+)
+
+const (
+	// Enumerate step query strings
+
+	selectUsernamesQuery string = `SELECT id, username, COALESCE(username_normalized, '') FROM users
+	ORDER BY created_at, id;`
+
+	updateUsernameNormalizedQuery string = "UPDATE users SET username_normalized = $2 WHERE id = $1;"
+
+	selectEmailsQuery string = `SELECT id, email, COALESCE(email_normalized, '') FROM users
+	ORDER BY created_at, id;`
+
+	updateEmailNormalizedQuery string = "UPDATE users SET email_normalized = $2 WHERE id = $1;"
+)
+
+// Steps are the Go steps of the migrations, by version, to pass to migrate.WithSteps,
+// for services using the zero validate.EmailPolicy
+var Steps = NewSteps(validate.EmailPolicy{})
+
+// NewSteps returns the Go steps of the migrations, by version, to pass to migrate.WithSteps.
+// emailPolicy is the policy passed to users.WithEmailPolicy, with which existing emails are canonicalized.
+func NewSteps(emailPolicy validate.EmailPolicy) map[int]func(ctx context.Context, tx *sql.Tx) error {
+	return map[int]func(ctx context.Context, tx *sql.Tx) error{
+		14: backfillUsernameSkeletons,
+		15: func(ctx context.Context, tx *sql.Tx) error {
+			return backfillCanonicalEmails(ctx, tx, emailPolicy)
+		},
+	}
+}
+
+// storedUsername is a username and its stored skeleton
+type storedUsername struct {
+	id         string
+	username   string
+	normalized string
+}
+
+// backfillUsernameSkeletons stores the skeleton the service computes for new users, validate.UsernameSkeleton,
+// for every existing user, so that their confusables cannot be registered
+func backfillUsernameSkeletons(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, selectUsernamesQuery)
+	if err != nil {
+		return fmt.Errorf("could not select usernames: %s", err)
+	}
+	defer rows.Close()
+
+	var usernames []storedUsername
+	for rows.Next() {
+		var u storedUsername
+		if err := rows.Scan(&u.id, &u.username, &u.normalized); err != nil {
+			return fmt.Errorf("could not scan username: %s", err)
+		}
+		usernames = append(usernames, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate usernames: %s", err)
+	}
+
+	if err := storeNormalized(ctx, tx, updateUsernameNormalizedQuery, usernameSkeletons(usernames)); err != nil {
+		return fmt.Errorf("could not store username skeletons: %s", err)
+	}
+	return nil
+}
+
+// usernameSkeletons returns the skeletons to store by user id, leaving out the ones already stored.
+// Users are given in creation order: when existing usernames share a skeleton, the oldest keeps it
+// and the others get it suffixed with their id, so that they still block new confusables.
+func usernameSkeletons(usernames []storedUsername) map[string]string {
+	taken := make(map[string]struct{}, len(usernames))
+	updates := make(map[string]string)
+
+	for _, u := range usernames {
+		normalized := validate.UsernameSkeleton(u.username)
+		if _, ok := taken[normalized]; ok {
+			normalized += "#" + u.id
+		}
+		taken[normalized] = struct{}{}
+
+		if normalized != u.normalized {
+			updates[u.id] = normalized
+		}
+	}
+	return updates
+}
+
+// storedEmail is an email and its stored canonical form
+type storedEmail struct {
+	id         string
+	email      string
+	normalized string
+}
+
+// backfillCanonicalEmails stores the canonical email the service looks users up with, validate.EmailPolicy.Canonical,
+// for every existing user, so that users whose email was stored with a display name or an IDN domain can still log in
+func backfillCanonicalEmails(ctx context.Context, tx *sql.Tx, policy validate.EmailPolicy) error {
+	rows, err := tx.QueryContext(ctx, selectEmailsQuery)
+	if err != nil {
+		return fmt.Errorf("could not select emails: %s", err)
+	}
+	defer rows.Close()
+
+	var emails []storedEmail
+	for rows.Next() {
+		var e storedEmail
+		if err := rows.Scan(&e.id, &e.email, &e.normalized); err != nil {
+			return fmt.Errorf("could not scan email: %s", err)
+		}
+		emails = append(emails, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not iterate emails: %s", err)
+	}
+
+	updates, err := canonicalEmails(emails, policy)
+	if err != nil {
+		return err
+	}
+
+	if err := storeNormalized(ctx, tx, updateEmailNormalizedQuery, updates); err != nil {
+		return fmt.Errorf("could not store canonical emails: %s", err)
+	}
+	return nil
+}
+
+// canonicalEmails returns the canonical emails to store by user id, leaving out the ones already stored.
+// Users sharing a mailbox are the same person, so that they cannot be told apart automatically:
+// they are reported, by id, to be merged before migrating again.
+func canonicalEmails(emails []storedEmail, policy validate.EmailPolicy) (map[string]string, error) {
+	owners := make(map[string][]string, len(emails))
+	updates := make(map[string]string)
+
+	for _, e := range emails {
+		normalized := policy.Canonical(e.email)
+		owners[normalized] = append(owners[normalized], e.id)
+
+		if normalized != e.normalized {
+			updates[e.id] = normalized
+		}
+	}
+
+	var collisions []string
+	for _, ids := range owners {
+		if len(ids) > 1 {
+			collisions = append(collisions, strings.Join(ids, ", "))
+		}
+	}
+
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return nil, fmt.Errorf("users share a mailbox and must be merged before migrating: %s",
+			strings.Join(collisions, "; "))
+	}
+	return updates, nil
+}
+
+// storeNormalized stores normalized values by user id with query, which takes the id and the value.
+// Unique constraints are checked row by row, so the users to update first take their id, which no
+// normalized username or email can be, before taking values another one may still hold.
+func storeNormalized(ctx context.Context, tx *sql.Tx, query string, updates map[string]string) error {
+	for id := range updates {
+		if _, err := tx.ExecContext(ctx, query, id, id); err != nil {
+			return fmt.Errorf("could not reset value: %s", err)
+		}
+	}
+
+	for id, normalized := range updates {
+		if _, err := tx.ExecContext(ctx, query, id, normalized); err != nil {
+			return fmt.Errorf("could not update value: %s", err)
+		}
+	}
+	return nil
+}
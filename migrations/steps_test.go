@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/alesr/stdservices/pkg/validate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsernameSkeletons(t *testing.T) {
+	t.Parallel()
+
+	given := []storedUsername{
+		// Stored in lower case by earlier versions of migration 6
+		{id: "1", username: "adam", normalized: "adam"},
+		{id: "2", username: "JDoe", normalized: "jdoe"},
+		// Added by the service, with its skeleton
+		{id: "3", username: "jane", normalized: "jane"},
+		// Added between migrations 6 and 14
+		{id: "4", username: "bob"},
+		// Confusable of an older user
+		{id: "5", username: "аdam", normalized: "аdam"},
+	}
+
+	expected := map[string]string{
+		"1": "adarn",
+		"4": "bob",
+		"5": "adarn#5",
+	}
+
+	assert.Equal(t, expected, usernameSkeletons(given))
+}
+
+func TestCanonicalEmails(t *testing.T) {
+	t.Parallel()
+
+	t.Run("backfill", func(t *testing.T) {
+		t.Parallel()
+
+		given := []storedEmail{
+			// Stored in lower case by earlier versions of migration 8
+			{id: "1", email: "John Doe <JDoe@Mail.com>", normalized: "john doe <jdoe@mail.com>"},
+			{id: "2", email: "jane@bücher.de", normalized: "jane@bücher.de"},
+			// Added by the service, with its canonical email
+			{id: "3", email: "bob@mail.com", normalized: "bob@mail.com"},
+			// Added between migrations 8 and 15
+			{id: "4", email: "Alice@Mail.com"},
+		}
+
+		expected := map[string]string{
+			"1": "jdoe@mail.com",
+			"2": "jane@xn--bcher-kva.de",
+			"4": "alice@mail.com",
+		}
+
+		actual, err := canonicalEmails(given, validate.EmailPolicy{})
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("shared mailboxes", func(t *testing.T) {
+		t.Parallel()
+
+		given := []storedEmail{
+			{id: "1", email: "john.doe@gmail.com"},
+			{id: "2", email: "johndoe+news@gmail.com"},
+			{id: "3", email: "jane@mail.com"},
+			{id: "4", email: "Jane <JANE@mail.com>"},
+		}
+
+		_, err := canonicalEmails(given, validate.EmailPolicy{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "3, 4")
+		assert.NotContains(t, err.Error(), "1, 2")
+
+		// Provider rules make more addresses share a mailbox
+		_, err = canonicalEmails(given, validate.EmailPolicy{ProviderRules: true})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "1, 2; 3, 4")
+	})
+}
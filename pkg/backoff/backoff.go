@@ -0,0 +1,20 @@
+package backoff
+
+import "time"
+
+// Exponential returns the delay before the next retry after the given number of attempts.
+// The delay starts at base after the first attempt, doubles after every attempt and is capped at max.
+func Exponential(base, max time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+
+	if delay > max {
+		return max
+	}
+	return delay
+}
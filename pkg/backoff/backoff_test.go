@@ -0,0 +1,51 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponential(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenAttempts int
+		expected      time.Duration
+	}{
+		{
+			name:          "no attempts",
+			givenAttempts: 0,
+			expected:      time.Second,
+		},
+		{
+			name:          "first attempt",
+			givenAttempts: 1,
+			expected:      time.Second,
+		},
+		{
+			name:          "doubles",
+			givenAttempts: 4,
+			expected:      8 * time.Second,
+		},
+		{
+			name:          "capped",
+			givenAttempts: 5,
+			expected:      10 * time.Second,
+		},
+		{
+			name:          "many attempts do not overflow",
+			givenAttempts: 200,
+			expected:      10 * time.Second,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := Exponential(time.Second, 10*time.Second, tc.givenAttempts)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dkimAlgorithmRSA     = "rsa-sha256"
+	dkimAlgorithmEd25519 = "ed25519-sha256"
+
+	// dkimLineLen is the length at which the DKIM-Signature header is folded
+	dkimLineLen = 76
+)
+
+// DefaultDKIMHeaders lists the headers signed when DKIMConfig.Headers is empty
+var DefaultDKIMHeaders = []string{
+	"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type",
+}
+
+type emailer interface {
+	Send(from, to string, body []byte) error
+}
+
+// DKIMConfig configures DKIM signing
+type DKIMConfig struct {
+	Domain   string
+	Selector string
+	// PrivateKey is either an *rsa.PrivateKey or an ed25519.PrivateKey
+	PrivateKey crypto.Signer
+	// Headers lists the headers to sign, defaulting to DefaultDKIMHeaders.
+	// Headers missing from a message are not signed.
+	Headers []string
+}
+
+// DKIM is an emailer decorator adding a DKIM-Signature header (RFC 6376) to
+// every message, using relaxed/relaxed canonicalization
+type DKIM struct {
+	next      emailer
+	cfg       DKIMConfig
+	algorithm string
+	now       func() time.Time
+}
+
+// NewDKIM creates a DKIM signer sending signed messages through next
+func NewDKIM(next emailer, cfg DKIMConfig) (*DKIM, error) {
+	if cfg.Domain == "" {
+		return nil, errDKIMDomainRequired
+	}
+
+	if cfg.Selector == "" {
+		return nil, errDKIMSelectorRequired
+	}
+
+	var algorithm string
+	switch cfg.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		algorithm = dkimAlgorithmRSA
+	case ed25519.PrivateKey:
+		algorithm = dkimAlgorithmEd25519
+	default:
+		return nil, errDKIMKeyUnsupported
+	}
+
+	if len(cfg.Headers) == 0 {
+		cfg.Headers = DefaultDKIMHeaders
+	}
+
+	return &DKIM{
+		next:      next,
+		cfg:       cfg,
+		algorithm: algorithm,
+		now:       time.Now,
+	}, nil
+}
+
+// Send signs the message and sends it through the decorated emailer
+func (d *DKIM) Send(from, to string, body []byte) error {
+	return d.SendContext(context.Background(), from, to, body)
+}
+
+// SendContext signs the message and sends it through the decorated emailer with the context,
+// if the emailer supports it
+func (d *DKIM) SendContext(ctx context.Context, from, to string, body []byte) error {
+	signed, err := d.Sign(body)
+	if err != nil {
+		return fmt.Errorf("could not sign message: %s", err)
+	}
+	return sendContext(ctx, d.next, from, to, signed)
+}
+
+// Sign returns the message with a DKIM-Signature header prepended
+func (d *DKIM) Sign(msg []byte) ([]byte, error) {
+	headers, body, err := splitMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyHash := sha256.Sum256(canonicalizeBodyRelaxed(body))
+
+	var (
+		signedNames []string
+		signedData  bytes.Buffer
+	)
+
+	for _, name := range d.cfg.Headers {
+		for _, field := range selectHeaders(headers, name) {
+			signedNames = append(signedNames, strings.ToLower(name))
+			signedData.WriteString(canonicalizeHeaderRelaxed(field))
+		}
+	}
+
+	if len(signedNames) == 0 {
+		return nil, errDKIMNoHeaders
+	}
+
+	tags := []string{
+		"v=1",
+		"a=" + d.algorithm,
+		"c=relaxed/relaxed",
+		"d=" + d.cfg.Domain,
+		"s=" + d.cfg.Selector,
+		"t=" + strconv.FormatInt(d.now().Unix(), 10),
+		"h=" + strings.Join(signedNames, ":"),
+		"bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]),
+		"b=",
+	}
+
+	// The signature covers its own header with an empty b= tag and no trailing CRLF
+	unsigned := "DKIM-Signature: " + strings.Join(tags, "; ")
+	signedData.WriteString(strings.TrimSuffix(canonicalizeHeaderRelaxed(unsigned), "\r\n"))
+
+	digest := sha256.Sum256(signedData.Bytes())
+
+	sig, err := d.sign(digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("could not compute signature: %s", err)
+	}
+
+	tags[len(tags)-1] = "b=" + base64.StdEncoding.EncodeToString(sig)
+
+	var out bytes.Buffer
+	out.WriteString(foldDKIMHeader(tags))
+	out.Write(headers)
+	out.WriteString("\r\n")
+	out.Write(body)
+	return out.Bytes(), nil
+}
+
+func (d *DKIM) sign(digest []byte) ([]byte, error) {
+	if d.algorithm == dkimAlgorithmEd25519 {
+		// Ed25519-SHA256 signs the SHA-256 digest with PureEdDSA (RFC 8463)
+		return d.cfg.PrivateKey.Sign(rand.Reader, digest, crypto.Hash(0))
+	}
+	return d.cfg.PrivateKey.Sign(rand.Reader, digest, crypto.SHA256)
+}
+
+// ParsePrivateKey parses a PEM encoded RSA (PKCS #1 or PKCS #8) or Ed25519 (PKCS #8) private key
+func ParsePrivateKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errDKIMKeyUnsupported
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %s", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errDKIMKeyUnsupported
+	}
+
+	switch signer.(type) {
+	case *rsa.PrivateKey, ed25519.PrivateKey:
+		return signer, nil
+	default:
+		return nil, errDKIMKeyUnsupported
+	}
+}
+
+// splitMessage normalizes line endings to CRLF and splits the header block
+// (including its final CRLF) from the body
+func splitMessage(msg []byte) (headers, body []byte, err error) {
+	normalized := bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
+	normalized = bytes.ReplaceAll(normalized, []byte("\n"), []byte("\r\n"))
+
+	i := bytes.Index(normalized, []byte("\r\n\r\n"))
+	if i == -1 {
+		return nil, nil, errDKIMMalformedMessage
+	}
+	return normalized[:i+2], normalized[i+4:], nil
+}
+
+// selectHeaders returns the raw instances of a header, including folded
+// continuation lines, from the last to the first as required by RFC 6376 5.4.2
+func selectHeaders(headers []byte, name string) []string {
+	var (
+		fields  []string
+		current strings.Builder
+	)
+
+	flush := func() {
+		if current.Len() == 0 {
+			return
+		}
+
+		field := current.String()
+		if fieldName, _, ok := strings.Cut(field, ":"); ok && strings.EqualFold(strings.TrimSpace(fieldName), name) {
+			fields = append(fields, field)
+		}
+		current.Reset()
+	}
+
+	for _, line := range strings.SplitAfter(string(headers), "\r\n") {
+		if line == "" {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			flush()
+		}
+		current.WriteString(line)
+	}
+	flush()
+
+	for i, j := 0, len(fields)-1; i < j; i, j = i+1, j-1 {
+		fields[i], fields[j] = fields[j], fields[i]
+	}
+	return fields
+}
+
+// canonicalizeHeaderRelaxed implements the relaxed header canonicalization of RFC 6376 3.4.2
+func canonicalizeHeaderRelaxed(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value + "\r\n"
+}
+
+// canonicalizeBodyRelaxed implements the relaxed body canonicalization of RFC 6376 3.4.4
+func canonicalizeBodyRelaxed(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+
+	for i, line := range lines {
+		line = strings.TrimRightFunc(line, isWSP)
+		lines[i] = strings.Join(strings.FieldsFunc(line, isWSP), " ")
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			lines[i] = " " + lines[i]
+		}
+	}
+
+	// Ignore empty lines at the end of the body
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// foldDKIMHeader renders the DKIM-Signature header folded at tag boundaries.
+// Only the signature value is split across lines, since whitespace inside
+// other tag values would change the canonicalized header.
+func foldDKIMHeader(tags []string) string {
+	var b strings.Builder
+
+	b.WriteString("DKIM-Signature:")
+	lineLen := b.Len()
+
+	for i, tag := range tags {
+		if i < len(tags)-1 {
+			tag += ";"
+		}
+
+		if lineLen+1+len(tag) > dkimLineLen {
+			b.WriteString("\r\n")
+			lineLen = 0
+		}
+
+		b.WriteString(" ")
+		lineLen++
+
+		if !strings.HasPrefix(tag, "b=") {
+			b.WriteString(tag)
+			lineLen += len(tag)
+			continue
+		}
+
+		for len(tag) > 0 {
+			if lineLen >= dkimLineLen {
+				b.WriteString("\r\n ")
+				lineLen = 1
+			}
+
+			n := dkimLineLen - lineLen
+			if n > len(tag) {
+				n = len(tag)
+			}
+
+			b.WriteString(tag[:n])
+			lineLen += n
+			tag = tag[n:]
+		}
+	}
+
+	b.WriteString("\r\n")
+	return b.String()
+}
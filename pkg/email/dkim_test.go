@@ -0,0 +1,399 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RFC 8463 appendix A test vector
+const (
+	rfc8463Seed      = "nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A="
+	rfc8463PublicKey = "11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+
+	rfc8463Message = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+		" d=football.example.com; i=@football.example.com;\r\n" +
+		" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+		" subject : date : message-id : from : subject : date;\r\n" +
+		" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+		" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+		" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+		"From: Joe SixPack <joe@football.example.com>\r\n" +
+		"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+		"Subject: Is dinner ready?\r\n" +
+		"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+		"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+		"\r\n" +
+		"Hi.\r\n" +
+		"\r\n" +
+		"We lost the game.  Are you hungry yet?\r\n" +
+		"\r\n" +
+		"Joe.\r\n"
+)
+
+var dkimTestMessage = []byte("From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner\r\n\t ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?  \r\n" +
+	"\r\n" +
+	"Joe.\r\n" +
+	"\r\n\r\n")
+
+type emailerMock struct {
+	sendFunc func(from, to string, body []byte) error
+}
+
+func (m *emailerMock) Send(from, to string, body []byte) error {
+	if m.sendFunc == nil {
+		return errors.New("emailerMock.sendFunc is nil")
+	}
+	return m.sendFunc(from, to, body)
+}
+
+func TestNewDKIM(t *testing.T) {
+	t.Parallel()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name              string
+		given             DKIMConfig
+		expectedAlgorithm string
+		expectedError     error
+	}{
+		{
+			name:              "rsa key",
+			given:             DKIMConfig{Domain: "mail.com", Selector: "s1", PrivateKey: rsaKey},
+			expectedAlgorithm: dkimAlgorithmRSA,
+		},
+		{
+			name:              "ed25519 key",
+			given:             DKIMConfig{Domain: "mail.com", Selector: "s1", PrivateKey: edKey},
+			expectedAlgorithm: dkimAlgorithmEd25519,
+		},
+		{
+			name:          "missing domain",
+			given:         DKIMConfig{Selector: "s1", PrivateKey: edKey},
+			expectedError: errDKIMDomainRequired,
+		},
+		{
+			name:          "missing selector",
+			given:         DKIMConfig{Domain: "mail.com", PrivateKey: edKey},
+			expectedError: errDKIMSelectorRequired,
+		},
+		{
+			name:          "missing key",
+			given:         DKIMConfig{Domain: "mail.com", Selector: "s1"},
+			expectedError: errDKIMKeyUnsupported,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := NewDKIM(&emailerMock{}, tc.given)
+			assert.Equal(t, tc.expectedError, err)
+
+			if err == nil {
+				assert.Equal(t, tc.expectedAlgorithm, actual.algorithm)
+				assert.Equal(t, DefaultDKIMHeaders, actual.cfg.Headers)
+			}
+		})
+	}
+}
+
+func TestDKIM_Sign(t *testing.T) {
+	t.Parallel()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		givenKey  crypto.Signer
+		publicKey crypto.PublicKey
+	}{
+		{
+			name:      "rsa-sha256",
+			givenKey:  rsaKey,
+			publicKey: rsaKey.Public(),
+		},
+		{
+			name:      "ed25519-sha256",
+			givenKey:  edKey,
+			publicKey: edKey.Public(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			signer, err := NewDKIM(&emailerMock{}, DKIMConfig{
+				Domain:     "football.example.com",
+				Selector:   "brisbane",
+				PrivateKey: tc.givenKey,
+			})
+			require.NoError(t, err)
+
+			signer.now = func() time.Time { return time.Unix(1528637909, 0) }
+
+			signed, err := signer.Sign(dkimTestMessage)
+			require.NoError(t, err)
+
+			require.True(t, bytes.HasPrefix(signed, []byte("DKIM-Signature: v=1; a="+tc.name+"; c=relaxed/relaxed;")))
+
+			for _, line := range strings.Split(string(signed), "\r\n") {
+				assert.LessOrEqual(t, len(line), 78)
+			}
+
+			tags := verifyDKIM(t, signed, tc.publicKey)
+			assert.Equal(t, "football.example.com", tags["d"])
+			assert.Equal(t, "brisbane", tags["s"])
+			assert.Equal(t, "1528637909", tags["t"])
+			assert.Equal(t, "from:to:subject:date:message-id", tags["h"])
+
+			// Whitespace changes within lines and trailing empty lines survive relaxed canonicalization
+			relayed := bytes.Replace(signed, []byte("the game.  Are"), []byte("the  game. Are"), 1)
+			relayed = bytes.Replace(relayed, []byte("Subject: Is dinner\r\n\t ready?"), []byte("subject:Is dinner ready?"), 1)
+			relayed = bytes.TrimSuffix(relayed, []byte("\r\n\r\n"))
+			verifyDKIM(t, relayed, tc.publicKey)
+		})
+	}
+}
+
+func TestDKIM_Sign_errors(t *testing.T) {
+	t.Parallel()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := NewDKIM(&emailerMock{}, DKIMConfig{
+		Domain:     "mail.com",
+		Selector:   "s1",
+		PrivateKey: edKey,
+		Headers:    []string{"X-Missing"},
+	})
+	require.NoError(t, err)
+
+	_, err = signer.Sign([]byte("From: joe@mail.com"))
+	assert.Equal(t, errDKIMMalformedMessage, err)
+
+	_, err = signer.Sign(dkimTestMessage)
+	assert.Equal(t, errDKIMNoHeaders, err)
+}
+
+func TestDKIM_Send(t *testing.T) {
+	t.Parallel()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	var sent []byte
+
+	next := emailerMock{
+		sendFunc: func(from, to string, body []byte) error {
+			assert.Equal(t, "joe@football.example.com", from)
+			assert.Equal(t, "suzie@shopping.example.net", to)
+			sent = body
+			return nil
+		},
+	}
+
+	signer, err := NewDKIM(&next, DKIMConfig{Domain: "football.example.com", Selector: "brisbane", PrivateKey: edKey})
+	require.NoError(t, err)
+
+	err = signer.Send("joe@football.example.com", "suzie@shopping.example.net", dkimTestMessage)
+	require.NoError(t, err)
+
+	verifyDKIM(t, sent, edKey.Public())
+}
+
+func TestDKIM_rfc8463(t *testing.T) {
+	t.Parallel()
+
+	seed, err := base64.StdEncoding.DecodeString(rfc8463Seed)
+	require.NoError(t, err)
+
+	publicKey, err := base64.StdEncoding.DecodeString(rfc8463PublicKey)
+	require.NoError(t, err)
+
+	key := ed25519.NewKeyFromSeed(seed)
+	require.Equal(t, ed25519.PublicKey(publicKey), key.Public())
+
+	// The published signature verifies with our canonicalization
+	verifyDKIM(t, []byte(rfc8463Message), key.Public())
+
+	// Re-signing the unsigned message reproduces the published body hash
+	signer, err := NewDKIM(&emailerMock{}, DKIMConfig{
+		Domain:     "football.example.com",
+		Selector:   "brisbane",
+		PrivateKey: key,
+		Headers:    []string{"From", "To", "Subject", "Date", "Message-ID"},
+	})
+	require.NoError(t, err)
+
+	signer.now = func() time.Time { return time.Unix(1528637909, 0) }
+
+	_, unsigned, _ := strings.Cut(rfc8463Message, "Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n")
+
+	signed, err := signer.Sign([]byte(unsigned))
+	require.NoError(t, err)
+
+	tags := verifyDKIM(t, signed, key.Public())
+	assert.Equal(t, "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=", tags["bh"])
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	t.Parallel()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+
+	rsaPKCS8DER, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		given         []byte
+		expectedError bool
+	}{
+		{
+			name:  "ed25519 pkcs8",
+			given: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER}),
+		},
+		{
+			name:  "rsa pkcs1",
+			given: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		},
+		{
+			name:  "rsa pkcs8",
+			given: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rsaPKCS8DER}),
+		},
+		{
+			name:          "not pem",
+			given:         []byte("not a key"),
+			expectedError: true,
+		},
+		{
+			name:          "invalid der",
+			given:         pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")}),
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := ParsePrivateKey(tc.given)
+
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, actual)
+			}
+		})
+	}
+}
+
+var dkimSignatureValue = regexp.MustCompile(`(^|;)(\s*b\s*=)[^;]*`)
+
+// verifyDKIM is an independent DKIM verifier following RFC 6376 6.1.
+// It verifies the first DKIM-Signature header and returns its tags.
+func verifyDKIM(t *testing.T, msg []byte, publicKey crypto.PublicKey) map[string]string {
+	t.Helper()
+
+	headers, body, err := splitMessage(msg)
+	require.NoError(t, err)
+
+	sigFields := selectHeaders(headers, "DKIM-Signature")
+	require.NotEmpty(t, sigFields)
+
+	sigField := sigFields[len(sigFields)-1]
+
+	_, rawValue, _ := strings.Cut(sigField, ":")
+
+	tags := map[string]string{}
+	for _, spec := range strings.Split(rawValue, ";") {
+		name, value, ok := strings.Cut(spec, "=")
+		if !ok {
+			continue
+		}
+
+		value = strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, value)
+
+		tags[strings.TrimSpace(name)] = value
+	}
+
+	require.Equal(t, "relaxed/relaxed", tags["c"])
+
+	bodyHash := sha256.Sum256(canonicalizeBodyRelaxed(body))
+	require.Equal(t, tags["bh"], base64.StdEncoding.EncodeToString(bodyHash[:]), "body hash mismatch")
+
+	var data bytes.Buffer
+
+	consumed := map[string]int{}
+	for _, name := range strings.Split(tags["h"], ":") {
+		name = strings.ToLower(name)
+
+		fields := selectHeaders(headers, name)
+		if consumed[name] < len(fields) {
+			data.WriteString(canonicalizeHeaderRelaxed(fields[consumed[name]]))
+		}
+		consumed[name]++
+	}
+
+	unsigned := dkimSignatureValue.ReplaceAllString(sigField, "$1$2")
+	data.WriteString(strings.TrimSuffix(canonicalizeHeaderRelaxed(unsigned), "\r\n"))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	require.NoError(t, err)
+
+	digest := sha256.Sum256(data.Bytes())
+
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		require.Equal(t, "ed25519-sha256", tags["a"])
+		require.True(t, ed25519.Verify(key, digest[:], sig), "invalid ed25519 signature")
+	case *rsa.PublicKey:
+		require.Equal(t, "rsa-sha256", tags["a"])
+		require.NoError(t, rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig))
+	default:
+		t.Fatalf("unsupported public key %T", publicKey)
+	}
+	return tags
+}
@@ -0,0 +1,26 @@
+package email
+
+import "errors"
+
+var (
+	// List error messages
+
+	errFromRequired     = errors.New("message sender is required")
+	errHeaderLineBreak  = errors.New("message header must not contain line breaks")
+	errToRequired       = errors.New("message recipient is required")
+	errTemplateNotFound = errors.New("email template not found")
+
+	errDKIMDomainRequired   = errors.New("dkim domain is required")
+	errDKIMKeyUnsupported   = errors.New("dkim private key must be an rsa or ed25519 key")
+	errDKIMMalformedMessage = errors.New("dkim message has no header and body separator")
+	errDKIMNoHeaders        = errors.New("dkim message has none of the headers to sign")
+	errDKIMSelectorRequired = errors.New("dkim selector is required")
+
+	errAuthMechanismInvalid  = errors.New("smtp auth mechanism is invalid")
+	errAuthUnsupported       = errors.New("smtp server does not support authentication")
+	errHostRequired          = errors.New("smtp host is required")
+	errPortRequired          = errors.New("smtp port is required")
+	errStartTLSUnsupported   = errors.New("smtp server does not support STARTTLS")
+	errTLSModeInvalid        = errors.New("smtp tls mode is invalid")
+	errUnencryptedConnection = errors.New("smtp connection is unencrypted")
+)
@@ -0,0 +1,272 @@
+package email
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTPServer is a minimal in-process SMTP server supporting STARTTLS,
+// implicit TLS and the PLAIN, LOGIN and CRAM-MD5 authentication mechanisms
+type fakeSMTPServer struct {
+	listener net.Listener
+	tlsCfg   *tls.Config
+	certPool *x509.CertPool
+
+	implicitTLS bool
+	startTLS    bool
+	username    string
+	password    string
+	dataDelay   time.Duration
+
+	mu          sync.Mutex
+	connections int
+	messages    []fakeSMTPMessage
+	authed      []string
+}
+
+type fakeSMTPMessage struct {
+	from, to string
+	tls      bool
+	body     string
+}
+
+func newFakeSMTPServer(t *testing.T, configure func(s *fakeSMTPServer)) *fakeSMTPServer {
+	t.Helper()
+
+	cert, pool := newSelfSignedCert(t)
+
+	s := fakeSMTPServer{
+		tlsCfg:   &tls.Config{Certificates: []tls.Certificate{cert}},
+		certPool: pool,
+	}
+
+	if configure != nil {
+		configure(&s)
+	}
+
+	var (
+		listener net.Listener
+		err      error
+	)
+
+	if s.implicitTLS {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", s.tlsCfg)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	require.NoError(t, err)
+
+	s.listener = listener
+
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+
+	return &s
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) clientTLSConfig() *tls.Config {
+	return &tls.Config{RootCAs: s.certPool}
+}
+
+func (s *fakeSMTPServer) stats() (connections int, messages []fakeSMTPMessage, authed []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.connections, append([]fakeSMTPMessage(nil), s.messages...), append([]string(nil), s.authed...)
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.connections++
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	_, isTLS := conn.(*tls.Conn)
+	tp := textproto.NewConn(conn)
+
+	reply := func(line string) {
+		_ = tp.PrintfLine("%s", line)
+	}
+
+	reply("220 fake ESMTP")
+
+	var from, to string
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			lines := []string{"250-fake"}
+			if s.startTLS && !isTLS {
+				lines = append(lines, "250-STARTTLS")
+			}
+			if s.username != "" {
+				lines = append(lines, "250-AUTH PLAIN LOGIN CRAM-MD5")
+			}
+			lines = append(lines, "250 8BITMIME")
+
+			for _, l := range lines {
+				reply(l)
+			}
+		case "STARTTLS":
+			reply("220 ready to start tls")
+
+			tlsConn := tls.Server(conn, s.tlsCfg)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+
+			conn, isTLS = tlsConn, true
+			tp = textproto.NewConn(conn)
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			if s.authenticate(tp, mechanism, initial) {
+				s.mu.Lock()
+				s.authed = append(s.authed, mechanism)
+				s.mu.Unlock()
+
+				reply("235 authenticated")
+			} else {
+				reply("535 authentication failed")
+			}
+		case "MAIL":
+			from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			if i := strings.Index(from, ">"); i != -1 {
+				from = from[:i]
+			}
+			reply("250 ok")
+		case "RCPT":
+			to = strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+
+			body, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+
+			time.Sleep(s.dataDelay)
+
+			s.mu.Lock()
+			s.messages = append(s.messages, fakeSMTPMessage{from: from, to: to, tls: isTLS, body: string(body)})
+			s.mu.Unlock()
+
+			reply("250 queued")
+		case "RSET", "NOOP":
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) authenticate(tp *textproto.Conn, mechanism, initial string) bool {
+	readLine := func() string {
+		line, _ := tp.ReadLine()
+		decoded, _ := base64.StdEncoding.DecodeString(line)
+		return string(decoded)
+	}
+
+	challenge := func(msg string) {
+		_ = tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(msg)))
+	}
+
+	switch mechanism {
+	case "PLAIN":
+		decoded, _ := base64.StdEncoding.DecodeString(initial)
+		parts := strings.Split(string(decoded), "\x00")
+		return len(parts) == 3 && parts[1] == s.username && parts[2] == s.password
+	case "LOGIN":
+		challenge("Username:")
+		username := readLine()
+		challenge("Password:")
+		password := readLine()
+		return username == s.username && password == s.password
+	case "CRAM-MD5":
+		nonce := "<1896.697170952@fake>"
+		challenge(nonce)
+
+		username, digest, _ := strings.Cut(readLine(), " ")
+
+		mac := hmac.New(md5.New, []byte(s.password))
+		mac.Write([]byte(nonce))
+		return username == s.username && digest == hex.EncodeToString(mac.Sum(nil))
+	default:
+		return false
+	}
+}
+
+func newSelfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake smtp"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
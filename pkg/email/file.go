@@ -0,0 +1,119 @@
+package email
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// File is an emailer writing every message as an .eml file to a directory.
+// It is meant for development, where messages can be opened with any mail client.
+type File struct {
+	dir string
+	now func() time.Time
+}
+
+// NewFile creates a file emailer, creating the directory if needed
+func NewFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create directory: %s", err)
+	}
+	return &File{dir: dir, now: time.Now}, nil
+}
+
+// Send writes the message to <dir>/<timestamp>-<random>.eml
+func (f *File) Send(from, to string, body []byte) error {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("could not generate file name: %s", err)
+	}
+
+	name := f.now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(suffix) + ".eml"
+
+	if err := writeFileAtomic(filepath.Join(f.dir, name), f.dir, envelope(from, to, body)); err != nil {
+		return fmt.Errorf("could not write message: %s", err)
+	}
+	return nil
+}
+
+// Maildir is an emailer delivering every message to a maildir, which most mail
+// clients can read directly
+type Maildir struct {
+	dir      string
+	hostname string
+	pid      int
+	now      func() time.Time
+
+	// deliveries makes file names unique within the process
+	deliveries uint64
+}
+
+// NewMaildir creates a maildir emailer, creating the tmp, new and cur directories if needed
+func NewMaildir(dir string) (*Maildir, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("could not create maildir: %s", err)
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	return &Maildir{
+		dir:      dir,
+		hostname: hostname,
+		pid:      os.Getpid(),
+		now:      time.Now,
+	}, nil
+}
+
+// Send writes the message to tmp and moves it to new once complete
+func (m *Maildir) Send(from, to string, body []byte) error {
+	now := m.now()
+	n := atomic.AddUint64(&m.deliveries, 1)
+
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, m.pid, n, m.hostname)
+
+	if err := writeFileAtomic(filepath.Join(m.dir, "new", name), filepath.Join(m.dir, "tmp"), envelope(from, to, body)); err != nil {
+		return fmt.Errorf("could not deliver message: %s", err)
+	}
+	return nil
+}
+
+// envelope prepends the envelope sender and recipient, as a delivery agent would
+func envelope(from, to string, body []byte) []byte {
+	header := "Return-Path: <" + from + ">\r\nDelivered-To: " + to + "\r\n"
+	return append([]byte(header), body...)
+}
+
+// writeFileAtomic writes data to a temporary file in tmpDir and renames it to path,
+// so readers never see a partially written message
+func writeFileAtomic(path, tmpDir string, data []byte) error {
+	tmp, err := os.CreateTemp(tmpDir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile_Send(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "outbox")
+
+	f, err := NewFile(dir)
+	require.NoError(t, err)
+
+	f.now = func() time.Time { return time.Date(2022, 1, 2, 3, 4, 5, 6, time.UTC) }
+
+	require.NoError(t, f.Send("noreply@cafe.app", "joe@mail.com", []byte("Subject: hi\r\n\r\nhello")))
+	require.NoError(t, f.Send("noreply@cafe.app", "joe@mail.com", []byte("Subject: hi again\r\n\r\nhello")))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	for _, entry := range entries {
+		assert.True(t, strings.HasPrefix(entry.Name(), "20220102T030405.000000006-"))
+		assert.True(t, strings.HasSuffix(entry.Name(), ".eml"))
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(string(content), "Return-Path: <noreply@cafe.app>\r\nDelivered-To: joe@mail.com\r\nSubject: hi"))
+}
+
+func TestMaildir_Send(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	m, err := NewMaildir(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, m.Send("noreply@cafe.app", "joe@mail.com", []byte("Subject: hi\r\n\r\nhello")))
+	require.NoError(t, m.Send("noreply@cafe.app", "joe@mail.com", []byte("Subject: hi\r\n\r\nhello")))
+
+	for _, sub := range []string{"tmp", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		require.NoError(t, err)
+		assert.Empty(t, entries, sub)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.NotEqual(t, entries[0].Name(), entries[1].Name())
+
+	content, err := os.ReadFile(filepath.Join(dir, "new", entries[0].Name()))
+	require.NoError(t, err)
+
+	assert.Equal(t, "Return-Path: <noreply@cafe.app>\r\nDelivered-To: joe@mail.com\r\nSubject: hi\r\n\r\nhello", string(content))
+}
@@ -0,0 +1,30 @@
+package email
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLog_Send(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	err := NewLog(zap.New(core)).Send("noreply@cafe.app", "joe@mail.com", []byte("Subject: hi\r\n\r\nverify at https://cafe.app/verify/abc"))
+	require.NoError(t, err)
+
+	entries := logs.All()
+	require.Len(t, entries, 1)
+
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "email sent", entries[0].Message)
+	assert.Equal(t, "noreply@cafe.app", fields["from"])
+	assert.Equal(t, "joe@mail.com", fields["to"])
+	assert.Equal(t, "hi", fields["subject"])
+	assert.Equal(t, []interface{}{"https://cafe.app/verify/abc"}, fields["links"])
+}
@@ -0,0 +1,182 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message represents an email with a plain text and an optional HTML alternative
+type Message struct {
+	From      mail.Address
+	To        []mail.Address
+	Subject   string
+	Text      string
+	HTML      string
+	Date      time.Time
+	MessageID string
+	// Headers holds additional headers, written in key order. Values must already be RFC 2047 encoded
+	// when needed, and neither keys nor values may contain line breaks.
+	Headers map[string]string
+}
+
+// Bytes renders the message as an RFC 5322 document.
+// A multipart/alternative body is built when the message carries an HTML part.
+// Date and Message-ID are generated when not set.
+func (m *Message) Bytes() ([]byte, error) {
+	if m.From.Address == "" {
+		return nil, errFromRequired
+	}
+
+	if len(m.To) == 0 {
+		return nil, errToRequired
+	}
+
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	messageID := m.MessageID
+	if messageID == "" {
+		generated, err := newMessageID(m.From.Address)
+		if err != nil {
+			return nil, fmt.Errorf("could not generate message id: %s", err)
+		}
+		messageID = generated
+	}
+
+	to := make([]string, 0, len(m.To))
+	for i := range m.To {
+		to = append(to, m.To[i].String())
+	}
+
+	headers := []struct {
+		key, value string
+	}{
+		{"From", m.From.String()},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", mime.QEncoding.Encode("UTF-8", m.Subject)},
+		{"Date", date.Format(time.RFC1123Z)},
+		{"Message-ID", messageID},
+		{"MIME-Version", "1.0"},
+	}
+
+	// Additional headers are sorted, so that rendering the same message gives the same bytes
+	keys := make([]string, 0, len(m.Headers))
+	for key := range m.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		headers = append(headers, struct{ key, value string }{key, m.Headers[key]})
+	}
+
+	var buf bytes.Buffer
+
+	for _, h := range headers {
+		if err := writeHeader(&buf, h.key, h.value); err != nil {
+			return nil, err
+		}
+	}
+
+	if m.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+		buf.WriteString("\r\n")
+
+		if err := writeQuotedPrintable(&buf, m.Text); err != nil {
+			return nil, fmt.Errorf("could not encode text body: %s", err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	buf.WriteString("Content-Type: multipart/alternative; boundary=" + mw.Boundary() + "\r\n")
+	buf.WriteString("\r\n")
+
+	// Parts are ordered from the least to the most preferred representation
+	parts := []struct {
+		contentType, content string
+	}{
+		{"text/plain; charset=UTF-8", m.Text},
+		{"text/html; charset=UTF-8", m.HTML},
+	}
+
+	for _, part := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not create mime part: %s", err)
+		}
+
+		var encoded bytes.Buffer
+		if err := writeQuotedPrintable(&encoded, part.content); err != nil {
+			return nil, fmt.Errorf("could not encode mime part: %s", err)
+		}
+
+		if _, err := w.Write(encoded.Bytes()); err != nil {
+			return nil, fmt.Errorf("could not write mime part: %s", err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("could not close multipart writer: %s", err)
+	}
+
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// writeHeader writes a header field. Line breaks are rejected, since they would let
+// a value, such as a subject taken from user input, inject headers or a body.
+func writeHeader(buf *bytes.Buffer, key, value string) error {
+	if strings.ContainsAny(key, "\r\n") || strings.ContainsAny(value, "\r\n") {
+		return errHeaderLineBreak
+	}
+
+	buf.WriteString(key)
+	buf.WriteString(": ")
+	buf.WriteString(value)
+	buf.WriteString("\r\n")
+	return nil
+}
+
+func writeQuotedPrintable(buf *bytes.Buffer, content string) error {
+	// Normalize line endings so the encoder emits CRLF hard line breaks
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.ReplaceAll(content, "\n", "\r\n")
+
+	qp := quotedprintable.NewWriter(buf)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func newMessageID(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at != -1 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">", nil
+}
@@ -0,0 +1,202 @@
+package email
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessage_Bytes(t *testing.T) {
+	t.Parallel()
+
+	givenDate := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("multipart alternative", func(t *testing.T) {
+		given := Message{
+			From:    mail.Address{Name: "Café App", Address: "noreply@cafe.app"},
+			To:      []mail.Address{{Name: "Jöe", Address: "joe@mail.com"}},
+			Subject: "Vérifiez votre adresse",
+			Text:    "Hello\nhttps://cafe.app/verify/abc",
+			HTML:    `<p>Hello <a href="https://cafe.app/verify/abc">verify</a></p>`,
+			Date:    givenDate,
+		}
+
+		b, err := given.Bytes()
+		require.NoError(t, err)
+
+		msg, err := mail.ReadMessage(bytes.NewReader(b))
+		require.NoError(t, err)
+
+		from, err := msg.Header.AddressList("From")
+		require.NoError(t, err)
+		assert.Equal(t, []*mail.Address{{Name: "Café App", Address: "noreply@cafe.app"}}, from)
+
+		subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		require.NoError(t, err)
+		assert.Equal(t, "Vérifiez votre adresse", subject)
+		assert.NotEqual(t, "Vérifiez votre adresse", msg.Header.Get("Subject"))
+
+		date, err := msg.Header.Date()
+		require.NoError(t, err)
+		assert.True(t, givenDate.Equal(date))
+
+		assert.True(t, strings.HasSuffix(msg.Header.Get("Message-ID"), "@cafe.app>"))
+		assert.Equal(t, "1.0", msg.Header.Get("MIME-Version"))
+
+		mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		require.NoError(t, err)
+		require.Equal(t, "multipart/alternative", mediaType)
+
+		mr := multipart.NewReader(msg.Body, params["boundary"])
+
+		textPart, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=UTF-8", textPart.Header.Get("Content-Type"))
+
+		text, err := io.ReadAll(textPart)
+		require.NoError(t, err)
+		assert.Equal(t, "Hello\r\nhttps://cafe.app/verify/abc", string(text))
+
+		htmlPart, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "text/html; charset=UTF-8", htmlPart.Header.Get("Content-Type"))
+
+		html, err := io.ReadAll(htmlPart)
+		require.NoError(t, err)
+		assert.Equal(t, given.HTML, string(html))
+
+		_, err = mr.NextPart()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("plain text", func(t *testing.T) {
+		given := Message{
+			From:      mail.Address{Address: "noreply@app.com"},
+			To:        []mail.Address{{Address: "joe@mail.com"}},
+			Subject:   "Hello",
+			Text:      "Hello",
+			MessageID: "<123@app.com>",
+		}
+
+		b, err := given.Bytes()
+		require.NoError(t, err)
+
+		msg, err := mail.ReadMessage(bytes.NewReader(b))
+		require.NoError(t, err)
+
+		assert.Equal(t, "text/plain; charset=UTF-8", msg.Header.Get("Content-Type"))
+		assert.Equal(t, "<123@app.com>", msg.Header.Get("Message-ID"))
+		assert.Equal(t, "Hello", msg.Header.Get("Subject"))
+		assert.NotEmpty(t, msg.Header.Get("Date"))
+	})
+
+	t.Run("additional headers", func(t *testing.T) {
+		given := Message{
+			From:      mail.Address{Address: "noreply@app.com"},
+			To:        []mail.Address{{Address: "joe@mail.com"}},
+			Text:      "Hello",
+			Date:      givenDate,
+			MessageID: "<123@app.com>",
+			Headers: map[string]string{
+				"X-Mailer":                 "stdservices",
+				"List-Unsubscribe":         "<https://app.com/unsubscribe>",
+				"Auto-Submitted":           "auto-generated",
+				"X-Entity-Ref-ID":          "42",
+				"Precedence":               "bulk",
+				"X-Priority":               "3",
+				"Reply-To":                 "support@app.com",
+				"X-Auto-Response-Suppress": "All",
+			},
+		}
+
+		first, err := given.Bytes()
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			b, err := given.Bytes()
+			require.NoError(t, err)
+			assert.Equal(t, string(first), string(b))
+		}
+
+		assert.Contains(t, string(first), "Auto-Submitted: auto-generated\r\nList-Unsubscribe: ")
+	})
+
+	t.Run("header injection", func(t *testing.T) {
+		testCases := []struct {
+			name         string
+			givenHeaders map[string]string
+			givenID      string
+		}{
+			{
+				name:         "line feed in value",
+				givenHeaders: map[string]string{"X-Campaign": "spring\nBcc: victim@mail.com"},
+			},
+			{
+				name:         "carriage return in value",
+				givenHeaders: map[string]string{"X-Campaign": "spring\rBcc: victim@mail.com"},
+			},
+			{
+				name:         "line break in key",
+				givenHeaders: map[string]string{"Bcc: victim@mail.com\r\nX-Campaign": "spring"},
+			},
+			{
+				name:    "line break in message id",
+				givenID: "<123@app.com>\r\nBcc: victim@mail.com",
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				given := Message{
+					From:      mail.Address{Address: "noreply@app.com"},
+					To:        []mail.Address{{Address: "joe@mail.com"}},
+					Subject:   "Hello\r\nBcc: victim@mail.com",
+					Text:      "Hello",
+					MessageID: tc.givenID,
+					Headers:   tc.givenHeaders,
+				}
+
+				_, err := given.Bytes()
+				assert.Equal(t, errHeaderLineBreak, err)
+			})
+		}
+	})
+
+	t.Run("line break in subject is encoded", func(t *testing.T) {
+		given := Message{
+			From:    mail.Address{Address: "noreply@app.com"},
+			To:      []mail.Address{{Address: "joe@mail.com"}},
+			Subject: "Hello\r\nBcc: victim@mail.com",
+			Text:    "Hello",
+		}
+
+		b, err := given.Bytes()
+		require.NoError(t, err)
+
+		msg, err := mail.ReadMessage(bytes.NewReader(b))
+		require.NoError(t, err)
+		assert.Empty(t, msg.Header.Get("Bcc"))
+	})
+
+	t.Run("missing sender", func(t *testing.T) {
+		given := Message{To: []mail.Address{{Address: "joe@mail.com"}}}
+
+		_, err := given.Bytes()
+		assert.Equal(t, errFromRequired, err)
+	})
+
+	t.Run("missing recipient", func(t *testing.T) {
+		given := Message{From: mail.Address{Address: "noreply@app.com"}}
+
+		_, err := given.Bytes()
+		assert.Equal(t, errToRequired, err)
+	})
+}
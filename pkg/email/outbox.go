@@ -0,0 +1,171 @@
+package email
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var linkPattern = regexp.MustCompile(`https?://[^\s"'<>]+`)
+
+// SentMessage is a message captured by an Outbox. Subject, Text and HTML are
+// decoded from the raw message on a best effort basis.
+type SentMessage struct {
+	From    string
+	To      string
+	Raw     []byte
+	Subject string
+	Text    string
+	HTML    string
+	SentAt  time.Time
+}
+
+// Links returns the distinct http and https links found in the text and HTML parts, in order of appearance
+func (m *SentMessage) Links() []string {
+	var (
+		links []string
+		seen  = map[string]bool{}
+	)
+
+	for _, part := range []string{m.Text, m.HTML} {
+		for _, link := range linkPattern.FindAllString(part, -1) {
+			link = html.UnescapeString(link)
+			if !seen[link] {
+				seen[link] = true
+				links = append(links, link)
+			}
+		}
+	}
+	return links
+}
+
+// Outbox is an emailer capturing messages in memory instead of sending them.
+// It is meant for development and tests, and is safe for concurrent use.
+type Outbox struct {
+	mu       sync.Mutex
+	messages []SentMessage
+	now      func() time.Time
+}
+
+// NewOutbox creates an empty in-memory outbox
+func NewOutbox() *Outbox {
+	return &Outbox{now: time.Now}
+}
+
+// Send captures the message
+func (o *Outbox) Send(from, to string, body []byte) error {
+	msg := parseSentMessage(from, to, body)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	msg.SentAt = o.now()
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+// Messages returns every captured message in the order they were sent
+func (o *Outbox) Messages() []SentMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return append([]SentMessage(nil), o.messages...)
+}
+
+// MessagesTo returns the messages sent to an address, in the order they were sent
+func (o *Outbox) MessagesTo(addr string) []SentMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var messages []SentMessage
+	for _, msg := range o.messages {
+		if strings.EqualFold(msg.To, addr) {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// LastMessageTo returns the last message sent to an address or nil if there is none
+func (o *Outbox) LastMessageTo(addr string) *SentMessage {
+	messages := o.MessagesTo(addr)
+	if len(messages) == 0 {
+		return nil
+	}
+	return &messages[len(messages)-1]
+}
+
+// Reset discards the captured messages
+func (o *Outbox) Reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.messages = nil
+}
+
+// parseSentMessage decodes the subject and the text and HTML parts of a raw message.
+// Messages that cannot be parsed are captured with their raw body only.
+func parseSentMessage(from, to string, body []byte) SentMessage {
+	msg := SentMessage{
+		From: from,
+		To:   to,
+		Raw:  append([]byte(nil), body...),
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(body))
+	if err != nil {
+		msg.Text = string(body)
+		return msg
+	}
+
+	msg.Subject = parsed.Header.Get("Subject")
+	if subject, err := new(mime.WordDecoder).DecodeHeader(msg.Subject); err == nil {
+		msg.Subject = subject
+	}
+
+	readPart(&msg, parsed.Header.Get("Content-Type"), parsed.Header.Get("Content-Transfer-Encoding"), parsed.Body)
+	return msg
+}
+
+func readPart(msg *SentMessage, contentType, transferEncoding string, r io.Reader) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(r, params["boundary"])
+		for {
+			// NextPart decodes quoted-printable parts and removes their Content-Transfer-Encoding
+			part, err := mr.NextPart()
+			if err != nil {
+				return
+			}
+			readPart(msg, part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+		}
+	}
+
+	if strings.EqualFold(transferEncoding, "quoted-printable") {
+		r = quotedprintable.NewReader(r)
+	}
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+
+	switch mediaType {
+	case "text/plain":
+		msg.Text += string(content)
+	case "text/html":
+		msg.HTML += string(content)
+	}
+}
@@ -0,0 +1,84 @@
+package email
+
+import (
+	"fmt"
+	"net/mail"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutbox(t *testing.T) {
+	t.Parallel()
+
+	given := Message{
+		From:    mail.Address{Name: "Café App", Address: "noreply@cafe.app"},
+		To:      []mail.Address{{Name: "Jöe", Address: "joe@mail.com"}},
+		Subject: "Vérifiez votre adresse",
+		Text:    "Hello\nhttps://cafe.app/verify/abc?lang=fr&src=email",
+		HTML:    `<p>Hello <a href="https://cafe.app/verify/abc?lang=fr&amp;src=email">verify</a> <a href="https://cafe.app/help">help</a></p>`,
+	}
+
+	body, err := given.Bytes()
+	require.NoError(t, err)
+
+	outbox := NewOutbox()
+	outbox.now = func() time.Time { return time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	require.NoError(t, outbox.Send("noreply@cafe.app", "someone@mail.com", []byte("Subject: first\r\n\r\nhttp://cafe.app/1")))
+	require.NoError(t, outbox.Send("noreply@cafe.app", "joe@mail.com", []byte("Subject: second\r\n\r\nhttp://cafe.app/2")))
+	require.NoError(t, outbox.Send("noreply@cafe.app", "joe@mail.com", body))
+
+	assert.Len(t, outbox.Messages(), 3)
+	assert.Len(t, outbox.MessagesTo("JOE@mail.com"), 2)
+	assert.Nil(t, outbox.LastMessageTo("nobody@mail.com"))
+
+	actual := outbox.LastMessageTo("joe@mail.com")
+	require.NotNil(t, actual)
+
+	assert.Equal(t, "noreply@cafe.app", actual.From)
+	assert.Equal(t, "joe@mail.com", actual.To)
+	assert.Equal(t, body, actual.Raw)
+	assert.Equal(t, "Vérifiez votre adresse", actual.Subject)
+	assert.Contains(t, actual.Text, "https://cafe.app/verify/abc?lang=fr&src=email")
+	assert.Contains(t, actual.HTML, `<a href="https://cafe.app/help">`)
+	assert.Equal(t, time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC), actual.SentAt)
+	assert.Equal(t, []string{"https://cafe.app/verify/abc?lang=fr&src=email", "https://cafe.app/help"}, actual.Links())
+
+	first := outbox.MessagesTo("someone@mail.com")[0]
+	assert.Equal(t, "first", first.Subject)
+	assert.Equal(t, []string{"http://cafe.app/1"}, first.Links())
+
+	outbox.Reset()
+	assert.Empty(t, outbox.Messages())
+}
+
+func TestOutbox_concurrentSend(t *testing.T) {
+	t.Parallel()
+
+	outbox := NewOutbox()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = outbox.Send("noreply@cafe.app", fmt.Sprintf("user%d@mail.com", i), []byte("Subject: hi\r\n\r\nhi"))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, outbox.Messages(), 10)
+}
+
+func TestParseSentMessage_malformed(t *testing.T) {
+	t.Parallel()
+
+	actual := parseSentMessage("a@mail.com", "b@mail.com", []byte("not a message\r\nhttps://cafe.app/x"))
+
+	assert.Empty(t, actual.Subject)
+	assert.Equal(t, []string{"https://cafe.app/x"}, actual.Links())
+}
@@ -0,0 +1,294 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alesr/stdservices/pkg/backoff"
+	"github.com/alesr/stdservices/pkg/email/queue/repository"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// Enumerate message statuses
+
+	StatusPending Status = "pending"
+	StatusSent    Status = "sent"
+	StatusFailed  Status = "failed"
+
+	defaultWorkers        = 4
+	defaultMaxAttempts    = 6
+	defaultBaseBackoff    = 30 * time.Second
+	defaultMaxBackoff     = time.Hour
+	defaultPollInterval   = 2 * time.Second
+	defaultClaimLease     = 5 * time.Minute
+	defaultEnqueueTimeout = 5 * time.Second
+)
+
+var errMessageNotFound = errors.New("message not found")
+
+type (
+	// Status represents the delivery state of a queued message
+	Status string
+
+	// Message represents a queued email and its delivery state
+	Message struct {
+		ID        string
+		From      string
+		To        string
+		Status    Status
+		Attempts  int
+		LastError string
+		SentAt    *time.Time
+		CreatedAt time.Time
+	}
+
+	repo interface {
+		InsertMessage(ctx context.Context, m *repository.Message) error
+		SelectMessageByID(ctx context.Context, id string) (*repository.Message, error)
+		ClaimDueMessages(ctx context.Context, now, leaseUntil time.Time, limit int) ([]repository.Message, error)
+		UpdateMessage(ctx context.Context, m *repository.Message) error
+	}
+
+	sender interface {
+		Send(from, to string, body []byte) error
+	}
+)
+
+type Option func(*Queue)
+
+// WithWorkers sets how many messages are sent concurrently
+func WithWorkers(n int) Option {
+	return func(q *Queue) {
+		q.workers = n
+	}
+}
+
+// WithRetryPolicy sets the maximum number of send attempts and the exponential backoff bounds
+func WithRetryPolicy(maxAttempts int, baseBackoff, maxBackoff time.Duration) Option {
+	return func(q *Queue) {
+		q.maxAttempts = maxAttempts
+		q.baseBackoff = baseBackoff
+		q.maxBackoff = maxBackoff
+	}
+}
+
+// WithPollInterval sets how often idle workers look for due messages
+func WithPollInterval(interval time.Duration) Option {
+	return func(q *Queue) {
+		q.pollInterval = interval
+	}
+}
+
+// Queue is a persistent email outbox. Messages are stored by Send and
+// delivered through the underlying sender by the workers started with Run.
+type Queue struct {
+	logger       *zap.Logger
+	repo         repo
+	sender       sender
+	workers      int
+	maxAttempts  int
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+	pollInterval time.Duration
+	claimLease   time.Duration
+	now          func() time.Time
+
+	// wake is signaled on enqueue so an idle worker picks the message without waiting for the next poll
+	wake chan struct{}
+}
+
+// New instantiates a new email queue delivering messages through the given sender
+func New(logger *zap.Logger, repo repo, sender sender, opts ...Option) *Queue {
+	q := Queue{
+		logger:       logger,
+		repo:         repo,
+		sender:       sender,
+		workers:      defaultWorkers,
+		maxAttempts:  defaultMaxAttempts,
+		baseBackoff:  defaultBaseBackoff,
+		maxBackoff:   defaultMaxBackoff,
+		pollInterval: defaultPollInterval,
+		claimLease:   defaultClaimLease,
+		now:          func() time.Time { return time.Now().UTC() },
+		wake:         make(chan struct{}, 1),
+	}
+
+	for _, opt := range opts {
+		opt(&q)
+	}
+	return &q
+}
+
+// Send enqueues a message. It satisfies the emailer interface of the services,
+// which then return as soon as the message is stored.
+func (q *Queue) Send(from, to string, body []byte) error {
+	return q.SendContext(context.Background(), from, to, body)
+}
+
+// SendContext enqueues a message within the context of the caller, bounded by the enqueue timeout
+func (q *Queue) SendContext(ctx context.Context, from, to string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultEnqueueTimeout)
+	defer cancel()
+
+	if _, err := q.Enqueue(ctx, from, to, body); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Enqueue stores a message for delivery and returns its id
+func (q *Queue) Enqueue(ctx context.Context, from, to string, body []byte) (string, error) {
+	now := q.now()
+
+	msg := repository.Message{
+		ID:            uuid.NewString(),
+		Sender:        from,
+		Recipient:     to,
+		Body:          body,
+		Status:        string(StatusPending),
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if err := q.repo.InsertMessage(ctx, &msg); err != nil {
+		return "", fmt.Errorf("could not insert message: %s", err)
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return msg.ID, nil
+}
+
+// FetchByID returns a queued message and its delivery state
+func (q *Queue) FetchByID(ctx context.Context, id string) (*Message, error) {
+	storageMsg, err := q.repo.SelectMessageByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("could not select message by id: %s", err)
+	}
+
+	if storageMsg == nil {
+		return nil, errMessageNotFound
+	}
+
+	msg := Message{
+		ID:        storageMsg.ID,
+		From:      storageMsg.Sender,
+		To:        storageMsg.Recipient,
+		Status:    Status(storageMsg.Status),
+		Attempts:  storageMsg.Attempts,
+		LastError: storageMsg.LastError.String,
+		CreatedAt: storageMsg.CreatedAt,
+	}
+
+	if storageMsg.SentAt.Valid {
+		sentAt := storageMsg.SentAt.Time
+		msg.SentAt = &sentAt
+	}
+	return &msg, nil
+}
+
+// Run starts the workers and blocks until the context is canceled and every worker returned
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		processed, err := q.ProcessNext(ctx)
+		if err != nil {
+			q.logger.Error("could not process queued email", zap.Error(err))
+		}
+
+		// Keep draining while there is work
+		if processed && err == nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessNext claims a due message and attempts to send it.
+// It reports whether a message was claimed.
+func (q *Queue) ProcessNext(ctx context.Context) (bool, error) {
+	now := q.now()
+
+	claimed, err := q.repo.ClaimDueMessages(ctx, now, now.Add(q.claimLease), 1)
+	if err != nil {
+		return false, fmt.Errorf("could not claim due messages: %s", err)
+	}
+
+	if len(claimed) == 0 {
+		return false, nil
+	}
+
+	msg := &claimed[0]
+
+	sendErr := q.sender.Send(msg.Sender, msg.Recipient, msg.Body)
+
+	if err := q.recordAttempt(ctx, msg, sendErr); err != nil {
+		return true, fmt.Errorf("could not record send attempt: %s", err)
+	}
+	return true, nil
+}
+
+// recordAttempt updates a message after a send attempt, rescheduling failures
+// until the maximum number of attempts is reached
+func (q *Queue) recordAttempt(ctx context.Context, msg *repository.Message, sendErr error) error {
+	now := q.now()
+
+	msg.Attempts++
+	msg.UpdatedAt = now
+
+	switch {
+	case sendErr == nil:
+		msg.Status = string(StatusSent)
+		msg.SentAt = sql.NullTime{Time: now, Valid: true}
+		msg.LastError = sql.NullString{}
+	case msg.Attempts >= q.maxAttempts:
+		msg.Status = string(StatusFailed)
+		msg.LastError = sql.NullString{String: sendErr.Error(), Valid: true}
+	default:
+		msg.NextAttemptAt = now.Add(backoff.Exponential(q.baseBackoff, q.maxBackoff, msg.Attempts))
+		msg.LastError = sql.NullString{String: sendErr.Error(), Valid: true}
+	}
+
+	if err := q.repo.UpdateMessage(ctx, msg); err != nil {
+		return fmt.Errorf("could not update message: %s", err)
+	}
+
+	if msg.Status == string(StatusFailed) {
+		q.logger.Error("queued email permanently failed",
+			zap.String("message_id", msg.ID), zap.Int("attempts", msg.Attempts), zap.Error(sendErr))
+	}
+	return nil
+}
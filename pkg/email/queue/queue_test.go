@@ -0,0 +1,306 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/pkg/email/queue/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	givenLogger := zap.NewNop()
+	givenRepo := &repositoryMock{}
+	givenSender := &senderMock{}
+
+	actual := New(
+		givenLogger,
+		givenRepo,
+		givenSender,
+		WithWorkers(2),
+		WithRetryPolicy(3, time.Second, time.Minute),
+		WithPollInterval(time.Millisecond),
+	)
+
+	require.NotNil(t, actual)
+	assert.Equal(t, givenLogger, actual.logger)
+	assert.Equal(t, givenRepo, actual.repo)
+	assert.Equal(t, givenSender, actual.sender)
+	assert.Equal(t, 2, actual.workers)
+	assert.Equal(t, 3, actual.maxAttempts)
+	assert.Equal(t, time.Second, actual.baseBackoff)
+	assert.Equal(t, time.Minute, actual.maxBackoff)
+	assert.Equal(t, time.Millisecond, actual.pollInterval)
+}
+
+func TestSend(t *testing.T) {
+	t.Parallel()
+
+	t.Run("message is enqueued", func(t *testing.T) {
+		var inserted *repository.Message
+
+		q := New(zap.NewNop(), &repositoryMock{
+			insertMessageFunc: func(ctx context.Context, m *repository.Message) error {
+				inserted = m
+				return nil
+			},
+		}, &senderMock{})
+
+		err := q.Send("noreply@app.com", "joedoe@mail.com", []byte("hello"))
+		require.NoError(t, err)
+
+		require.NotNil(t, inserted)
+		assert.NotEmpty(t, inserted.ID)
+		assert.Equal(t, "noreply@app.com", inserted.Sender)
+		assert.Equal(t, "joedoe@mail.com", inserted.Recipient)
+		assert.Equal(t, []byte("hello"), inserted.Body)
+		assert.Equal(t, string(StatusPending), inserted.Status)
+		assert.Len(t, q.wake, 1)
+	})
+
+	t.Run("insert error", func(t *testing.T) {
+		q := New(zap.NewNop(), &repositoryMock{
+			insertMessageFunc: func(ctx context.Context, m *repository.Message) error {
+				return errors.New("some error")
+			},
+		}, &senderMock{})
+
+		err := q.Send("noreply@app.com", "joedoe@mail.com", []byte("hello"))
+		assert.Error(t, err)
+	})
+
+	t.Run("context of the caller", func(t *testing.T) {
+		type ctxKey struct{}
+
+		q := New(zap.NewNop(), &repositoryMock{
+			insertMessageFunc: func(ctx context.Context, m *repository.Message) error {
+				assert.Equal(t, "request", ctx.Value(ctxKey{}))
+
+				_, ok := ctx.Deadline()
+				assert.True(t, ok)
+				return ctx.Err()
+			},
+		}, &senderMock{})
+
+		ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+		require.NoError(t, q.SendContext(ctx, "noreply@app.com", "joedoe@mail.com", []byte("hello")))
+
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		assert.Error(t, q.SendContext(ctx, "noreply@app.com", "joedoe@mail.com", []byte("hello")))
+	})
+}
+
+func TestFetchByID(t *testing.T) {
+	t.Parallel()
+
+	givenSentAt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	q := New(zap.NewNop(), &repositoryMock{
+		selectMessageByIDFunc: func(ctx context.Context, id string) (*repository.Message, error) {
+			if id != "123" {
+				return nil, nil
+			}
+			return &repository.Message{
+				ID:       id,
+				Status:   string(StatusSent),
+				Attempts: 2,
+				SentAt:   sql.NullTime{Time: givenSentAt, Valid: true},
+			}, nil
+		},
+	}, &senderMock{})
+
+	actual, err := q.FetchByID(context.Background(), "123")
+	require.NoError(t, err)
+	assert.Equal(t, StatusSent, actual.Status)
+	assert.Equal(t, 2, actual.Attempts)
+	assert.Equal(t, &givenSentAt, actual.SentAt)
+
+	_, err = q.FetchByID(context.Background(), "456")
+	assert.Equal(t, errMessageNotFound, err)
+}
+
+func TestProcessNext(t *testing.T) {
+	t.Parallel()
+
+	givenNow := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	newQueue := func(attempts int, sendErr error, updated **repository.Message) *Queue {
+		q := New(
+			zap.NewNop(),
+			&repositoryMock{
+				claimDueMessagesFunc: func(ctx context.Context, now, leaseUntil time.Time, limit int) ([]repository.Message, error) {
+					assert.Equal(t, 1, limit)
+					assert.True(t, leaseUntil.After(now))
+					return []repository.Message{{ID: "123", Status: string(StatusPending), Attempts: attempts}}, nil
+				},
+				updateMessageFunc: func(ctx context.Context, m *repository.Message) error {
+					*updated = m
+					return nil
+				},
+			},
+			&senderMock{
+				sendFunc: func(from, to string, body []byte) error {
+					return sendErr
+				},
+			},
+			WithRetryPolicy(3, time.Second, time.Minute),
+		)
+		q.now = func() time.Time { return givenNow }
+		return q
+	}
+
+	t.Run("message is sent", func(t *testing.T) {
+		var updated *repository.Message
+
+		processed, err := newQueue(0, nil, &updated).ProcessNext(context.Background())
+		require.NoError(t, err)
+		assert.True(t, processed)
+
+		assert.Equal(t, string(StatusSent), updated.Status)
+		assert.Equal(t, 1, updated.Attempts)
+		assert.Equal(t, givenNow, updated.SentAt.Time)
+	})
+
+	t.Run("failed message is rescheduled", func(t *testing.T) {
+		var updated *repository.Message
+
+		processed, err := newQueue(1, errors.New("smtp unavailable"), &updated).ProcessNext(context.Background())
+		require.NoError(t, err)
+		assert.True(t, processed)
+
+		assert.Equal(t, string(StatusPending), updated.Status)
+		assert.Equal(t, 2, updated.Attempts)
+		assert.Equal(t, givenNow.Add(2*time.Second), updated.NextAttemptAt)
+		assert.Equal(t, "smtp unavailable", updated.LastError.String)
+	})
+
+	t.Run("exhausted message is failed", func(t *testing.T) {
+		var updated *repository.Message
+
+		_, err := newQueue(2, errors.New("mailbox unavailable"), &updated).ProcessNext(context.Background())
+		require.NoError(t, err)
+
+		assert.Equal(t, string(StatusFailed), updated.Status)
+		assert.Equal(t, 3, updated.Attempts)
+		assert.Equal(t, "mailbox unavailable", updated.LastError.String)
+		assert.False(t, updated.SentAt.Valid)
+	})
+
+	t.Run("no due message", func(t *testing.T) {
+		q := New(zap.NewNop(), &repositoryMock{
+			claimDueMessagesFunc: func(ctx context.Context, now, leaseUntil time.Time, limit int) ([]repository.Message, error) {
+				return nil, nil
+			},
+		}, &senderMock{})
+
+		processed, err := q.ProcessNext(context.Background())
+		require.NoError(t, err)
+		assert.False(t, processed)
+	})
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	repo := &memoryRepository{}
+
+	var (
+		mu   sync.Mutex
+		sent []string
+		done = make(chan struct{})
+	)
+
+	q := New(zap.NewNop(), repo, &senderMock{
+		sendFunc: func(from, to string, body []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			sent = append(sent, to)
+			if len(sent) == 3 {
+				close(done)
+			}
+			return nil
+		},
+	}, WithWorkers(2), WithPollInterval(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stopped := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(stopped)
+	}()
+
+	for _, to := range []string{"a@mail.com", "b@mail.com", "c@mail.com"} {
+		require.NoError(t, q.Send("noreply@app.com", to, []byte("hello")))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("messages were not sent")
+	}
+
+	cancel()
+	<-stopped
+
+	assert.ElementsMatch(t, []string{"a@mail.com", "b@mail.com", "c@mail.com"}, sent)
+}
+
+// memoryRepository is a minimal in-memory outbox used to exercise the workers
+type memoryRepository struct {
+	mu       sync.Mutex
+	messages []*repository.Message
+}
+
+func (r *memoryRepository) InsertMessage(ctx context.Context, m *repository.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+func (r *memoryRepository) SelectMessageByID(ctx context.Context, id string) (*repository.Message, error) {
+	return nil, nil
+}
+
+func (r *memoryRepository) ClaimDueMessages(ctx context.Context, now, leaseUntil time.Time, limit int) ([]repository.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var claimed []repository.Message
+	for _, m := range r.messages {
+		if len(claimed) == limit {
+			break
+		}
+		if m.Status == string(StatusPending) && !m.NextAttemptAt.After(now) {
+			m.NextAttemptAt = leaseUntil
+			claimed = append(claimed, *m)
+		}
+	}
+	return claimed, nil
+}
+
+func (r *memoryRepository) UpdateMessage(ctx context.Context, m *repository.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.messages {
+		if r.messages[i].ID == m.ID {
+			updated := *m
+			r.messages[i] = &updated
+			return nil
+		}
+	}
+	return repository.ErrRecordNotFound
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// Enumerate postgresql query strings
+
+	insertMessageQuery string = `INSERT INTO email_outbox (id,sender,recipient,body,status,attempts,
+	next_attempt_at,created_at,updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9);`
+
+	selectMessageByIDQuery string = `SELECT id,sender,recipient,body,status,attempts,next_attempt_at,
+	last_error,sent_at,created_at,updated_at FROM email_outbox WHERE id = $1;`
+
+	// claimDueMessagesQuery pushes the next attempt of due messages forward by a lease
+	// so that concurrent workers do not pick the same messages.
+	claimDueMessagesQuery string = `UPDATE email_outbox SET next_attempt_at = $2
+	WHERE id IN (
+		SELECT id FROM email_outbox WHERE status = 'pending' AND next_attempt_at <= $1
+		ORDER BY next_attempt_at LIMIT $3 FOR UPDATE SKIP LOCKED
+	) RETURNING id,sender,recipient,body,status,attempts,next_attempt_at,
+	last_error,sent_at,created_at,updated_at;`
+
+	updateMessageQuery string = `UPDATE email_outbox SET status = $2, attempts = $3, next_attempt_at = $4,
+	last_error = $5, sent_at = $6, updated_at = $7 WHERE id = $1;`
+)
+
+// Postgres represents an email outbox repository instance with the given database connection
+type Postgres struct{ *sqlx.DB }
+
+// NewPostgres creates a new email outbox repository instance
+func NewPostgres(dbConn *sqlx.DB) *Postgres {
+	return &Postgres{dbConn}
+}
+
+// InsertMessage inserts a message in the outbox
+func (p *Postgres) InsertMessage(ctx context.Context, m *Message) error {
+	if _, err := p.ExecContext(
+		ctx, insertMessageQuery, m.ID, m.Sender, m.Recipient, m.Body, m.Status,
+		m.Attempts, m.NextAttemptAt, m.CreatedAt, m.UpdatedAt,
+	); err != nil {
+		return fmt.Errorf("could not insert message: %s", err)
+	}
+	return nil
+}
+
+// SelectMessageByID selects an outbox message by id
+func (p *Postgres) SelectMessageByID(ctx context.Context, id string) (*Message, error) {
+	row := p.QueryRowContext(ctx, selectMessageByIDQuery, id)
+
+	var m Message
+	if err := row.Scan(
+		&m.ID, &m.Sender, &m.Recipient, &m.Body, &m.Status, &m.Attempts, &m.NextAttemptAt,
+		&m.LastError, &m.SentAt, &m.CreatedAt, &m.UpdatedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not select message: %s", err)
+	}
+	return &m, nil
+}
+
+// ClaimDueMessages selects up to limit pending messages due at now and
+// postpones their next attempt until the given lease expires
+func (p *Postgres) ClaimDueMessages(ctx context.Context, now, leaseUntil time.Time, limit int) ([]Message, error) {
+	rows, err := p.QueryContext(ctx, claimDueMessagesQuery, now, leaseUntil, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not claim messages: %s", err)
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(
+			&m.ID, &m.Sender, &m.Recipient, &m.Body, &m.Status, &m.Attempts, &m.NextAttemptAt,
+			&m.LastError, &m.SentAt, &m.CreatedAt, &m.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("could not scan claimed message: %s", err)
+		}
+		messages = append(messages, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate claimed messages: %s", err)
+	}
+	return messages, nil
+}
+
+// UpdateMessage updates the status and attempt information of a message
+func (p *Postgres) UpdateMessage(ctx context.Context, m *Message) error {
+	res, err := p.ExecContext(
+		ctx, updateMessageQuery, m.ID, m.Status, m.Attempts, m.NextAttemptAt, m.LastError, m.SentAt, m.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("could not update message: %s", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %s", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrRecordNotFound error = errors.New("record not found")
+
+// Message represents a queued email in the database table
+type Message struct {
+	ID            string
+	Sender        string
+	Recipient     string
+	Body          []byte
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     sql.NullString
+	SentAt        sql.NullTime
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
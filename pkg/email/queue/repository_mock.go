@@ -0,0 +1,46 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alesr/stdservices/pkg/email/queue/repository"
+)
+
+var _ repo = (*repositoryMock)(nil)
+
+type repositoryMock struct {
+	insertMessageFunc     func(ctx context.Context, m *repository.Message) error
+	selectMessageByIDFunc func(ctx context.Context, id string) (*repository.Message, error)
+	claimDueMessagesFunc  func(ctx context.Context, now, leaseUntil time.Time, limit int) ([]repository.Message, error)
+	updateMessageFunc     func(ctx context.Context, m *repository.Message) error
+}
+
+func (m *repositoryMock) InsertMessage(ctx context.Context, msg *repository.Message) error {
+	if m.insertMessageFunc == nil {
+		return errors.New("repositoryMock.insertMessageFunc is nil")
+	}
+	return m.insertMessageFunc(ctx, msg)
+}
+
+func (m *repositoryMock) SelectMessageByID(ctx context.Context, id string) (*repository.Message, error) {
+	if m.selectMessageByIDFunc == nil {
+		return nil, errors.New("repositoryMock.selectMessageByIDFunc is nil")
+	}
+	return m.selectMessageByIDFunc(ctx, id)
+}
+
+func (m *repositoryMock) ClaimDueMessages(ctx context.Context, now, leaseUntil time.Time, limit int) ([]repository.Message, error) {
+	if m.claimDueMessagesFunc == nil {
+		return nil, errors.New("repositoryMock.claimDueMessagesFunc is nil")
+	}
+	return m.claimDueMessagesFunc(ctx, now, leaseUntil, limit)
+}
+
+func (m *repositoryMock) UpdateMessage(ctx context.Context, msg *repository.Message) error {
+	if m.updateMessageFunc == nil {
+		return errors.New("repositoryMock.updateMessageFunc is nil")
+	}
+	return m.updateMessageFunc(ctx, msg)
+}
@@ -0,0 +1,16 @@
+package queue
+
+import "errors"
+
+var _ sender = (*senderMock)(nil)
+
+type senderMock struct {
+	sendFunc func(from, to string, body []byte) error
+}
+
+func (m *senderMock) Send(from, to string, body []byte) error {
+	if m.sendFunc == nil {
+		return errors.New("senderMock.sendFunc is nil")
+	}
+	return m.sendFunc(from, to, body)
+}
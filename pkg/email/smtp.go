@@ -0,0 +1,448 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Enumerate TLS modes
+
+	// TLSModeStartTLS upgrades a plain connection with STARTTLS and fails if the server does not support it
+	TLSModeStartTLS TLSMode = "starttls"
+	// TLSModeImplicit connects over TLS from the start, usually on port 465
+	TLSModeImplicit TLSMode = "implicit"
+	// TLSModeNone never uses TLS. Only suitable for local relays and tests.
+	TLSModeNone TLSMode = "none"
+
+	// Enumerate authentication mechanisms
+
+	AuthPlain   AuthMechanism = "PLAIN"
+	AuthLogin   AuthMechanism = "LOGIN"
+	AuthCRAMMD5 AuthMechanism = "CRAM-MD5"
+
+	defaultDialTimeout    = 10 * time.Second
+	defaultCommandTimeout = 30 * time.Second
+	defaultIdleTimeout    = 30 * time.Second
+	defaultMaxIdleConns   = 2
+)
+
+type (
+	// TLSMode defines how the connection to the SMTP server is secured
+	TLSMode string
+
+	// AuthMechanism defines the SMTP authentication mechanism
+	AuthMechanism string
+)
+
+// SMTPConfig configures an SMTP sender
+type SMTPConfig struct {
+	Host string
+	Port int
+
+	// TLSMode defaults to TLSModeStartTLS
+	TLSMode TLSMode
+	// TLSConfig is cloned for every connection. ServerName defaults to Host.
+	TLSConfig *tls.Config
+
+	// Auth defaults to AuthPlain. Authentication is skipped when Username is empty.
+	Auth     AuthMechanism
+	Identity string
+	Username string
+	Password string
+
+	// LocalName is sent with EHLO and defaults to "localhost"
+	LocalName string
+
+	// DialTimeout bounds connecting and the TLS handshake
+	DialTimeout time.Duration
+	// CommandTimeout bounds a whole send when the context has no earlier deadline
+	CommandTimeout time.Duration
+	// IdleTimeout is how long an idle connection is kept for reuse
+	IdleTimeout time.Duration
+	// MaxIdleConns is the number of idle connections kept for reuse
+	MaxIdleConns int
+}
+
+// SMTP sends emails through an SMTP server, reusing connections between messages.
+// It is safe for concurrent use.
+type SMTP struct {
+	cfg  SMTPConfig
+	addr string
+
+	mu   sync.Mutex
+	idle []*smtpConn
+
+	// dial is replaceable in tests
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+type smtpConn struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+// NewSMTP creates a new SMTP sender
+func NewSMTP(cfg SMTPConfig) (*SMTP, error) {
+	if cfg.Host == "" {
+		return nil, errHostRequired
+	}
+
+	if cfg.Port == 0 {
+		return nil, errPortRequired
+	}
+
+	if cfg.TLSMode == "" {
+		cfg.TLSMode = TLSModeStartTLS
+	}
+
+	switch cfg.TLSMode {
+	case TLSModeStartTLS, TLSModeImplicit, TLSModeNone:
+	default:
+		return nil, errTLSModeInvalid
+	}
+
+	if cfg.Auth == "" {
+		cfg.Auth = AuthPlain
+	}
+
+	switch cfg.Auth {
+	case AuthPlain, AuthLogin, AuthCRAMMD5:
+	default:
+		return nil, errAuthMechanismInvalid
+	}
+
+	if cfg.LocalName == "" {
+		cfg.LocalName = "localhost"
+	}
+
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = defaultDialTimeout
+	}
+
+	if cfg.CommandTimeout == 0 {
+		cfg.CommandTimeout = defaultCommandTimeout
+	}
+
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = defaultIdleTimeout
+	}
+
+	if cfg.MaxIdleConns == 0 {
+		cfg.MaxIdleConns = defaultMaxIdleConns
+	}
+
+	dialer := net.Dialer{Timeout: cfg.DialTimeout}
+
+	return &SMTP{
+		cfg:  cfg,
+		addr: net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		dial: dialer.DialContext,
+	}, nil
+}
+
+// Send sends a message bounded by the configured command timeout
+func (s *SMTP) Send(from, to string, body []byte) error {
+	return s.SendContext(context.Background(), from, to, body)
+}
+
+// SendContext sends a message. The send is aborted when the context is done.
+func (s *SMTP) SendContext(ctx context.Context, from, to string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.CommandTimeout)
+	defer cancel()
+
+	c, err := s.conn(ctx)
+	if err != nil {
+		return fmt.Errorf("could not connect to smtp server: %w", contextErr(ctx, err))
+	}
+
+	if err := s.send(ctx, c, from, to, body); err != nil {
+		c.close()
+		return fmt.Errorf("could not send mail: %w", contextErr(ctx, err))
+	}
+
+	s.release(c)
+	return nil
+}
+
+// Close closes idle connections
+func (s *SMTP) Close() error {
+	s.mu.Lock()
+	idle := s.idle
+	s.idle = nil
+	s.mu.Unlock()
+
+	for _, c := range idle {
+		c.quit()
+	}
+	return nil
+}
+
+func (s *SMTP) send(ctx context.Context, c *smtpConn, from, to string, body []byte) error {
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	// Unblock pending I/O as soon as the context is canceled
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		select {
+		case <-ctx.Done():
+			_ = c.conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	err := transmit(c, from, to, body)
+
+	// The watcher must be gone before the connection is released to the pool,
+	// otherwise a late cancellation would break the next send on it
+	close(stop)
+	<-done
+
+	if err != nil {
+		return err
+	}
+
+	// Pooled connections have no deadline while idle
+	return c.conn.SetDeadline(time.Time{})
+}
+
+func transmit(c *smtpConn, from, to string, body []byte) error {
+	if err := c.client.Mail(from); err != nil {
+		return err
+	}
+
+	if err := c.client.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := c.client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+// conn returns a healthy idle connection or dials a new one
+func (s *SMTP) conn(ctx context.Context) (*smtpConn, error) {
+	for {
+		c := s.takeIdle()
+		if c == nil {
+			break
+		}
+
+		if time.Since(c.lastUsed) > s.cfg.IdleTimeout {
+			c.quit()
+			continue
+		}
+
+		// The server may have dropped the connection while idle
+		deadline, _ := ctx.Deadline()
+		_ = c.conn.SetDeadline(deadline)
+
+		if err := c.client.Reset(); err != nil {
+			c.close()
+			continue
+		}
+		return c, nil
+	}
+	return s.dialConn(ctx)
+}
+
+func (s *SMTP) takeIdle() *smtpConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.idle) == 0 {
+		return nil
+	}
+
+	c := s.idle[len(s.idle)-1]
+	s.idle = s.idle[:len(s.idle)-1]
+	return c
+}
+
+func (s *SMTP) release(c *smtpConn) {
+	c.lastUsed = time.Now()
+
+	s.mu.Lock()
+	if len(s.idle) < s.cfg.MaxIdleConns {
+		s.idle = append(s.idle, c)
+		c = nil
+	}
+	s.mu.Unlock()
+
+	if c != nil {
+		c.quit()
+	}
+}
+
+func (s *SMTP) dialConn(ctx context.Context) (*smtpConn, error) {
+	conn, err := s.dial(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if s.cfg.TLSMode == TLSModeImplicit {
+		tlsConn := tls.Client(conn, s.tlsConfig())
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not complete tls handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &smtpConn{conn: conn, client: client}
+
+	if err := s.handshake(c); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake greets the server, upgrades the connection when required and authenticates
+func (s *SMTP) handshake(c *smtpConn) error {
+	if err := c.client.Hello(s.cfg.LocalName); err != nil {
+		return err
+	}
+
+	if s.cfg.TLSMode == TLSModeStartTLS {
+		if ok, _ := c.client.Extension("STARTTLS"); !ok {
+			return errStartTLSUnsupported
+		}
+
+		if err := c.client.StartTLS(s.tlsConfig()); err != nil {
+			return fmt.Errorf("could not start tls: %w", err)
+		}
+	}
+
+	if s.cfg.Username == "" {
+		return nil
+	}
+
+	if ok, _ := c.client.Extension("AUTH"); !ok {
+		return errAuthUnsupported
+	}
+
+	if err := c.client.Auth(s.auth()); err != nil {
+		return fmt.Errorf("could not authenticate: %w", err)
+	}
+	return nil
+}
+
+func (s *SMTP) auth() smtp.Auth {
+	switch s.cfg.Auth {
+	case AuthLogin:
+		return &loginAuth{username: s.cfg.Username, password: s.cfg.Password}
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(s.cfg.Username, s.cfg.Password)
+	default:
+		return smtp.PlainAuth(s.cfg.Identity, s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+}
+
+func (s *SMTP) tlsConfig() *tls.Config {
+	var cfg *tls.Config
+	if s.cfg.TLSConfig != nil {
+		cfg = s.cfg.TLSConfig.Clone()
+	} else {
+		cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if cfg.ServerName == "" {
+		cfg.ServerName = s.cfg.Host
+	}
+	return cfg
+}
+
+func (c *smtpConn) quit() {
+	_ = c.conn.SetDeadline(time.Now().Add(time.Second))
+	if err := c.client.Quit(); err != nil {
+		c.close()
+	}
+}
+
+func (c *smtpConn) close() {
+	c.client.Close()
+}
+
+// contextErr prefers the context error over the I/O error it caused
+func contextErr(ctx context.Context, err error) error {
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return err
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	// The socket deadline is the context deadline, so reads can time out before the context timer fires
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// loginAuth implements the LOGIN authentication mechanism, which net/smtp does not provide
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errUnencryptedConnection
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch string(fromServer) {
+	case "Username:", "User Name\x00":
+		return []byte(a.username), nil
+	case "Password:", "Password\x00":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected login challenge: %q", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,310 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSMTP(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		given         SMTPConfig
+		expectedError error
+	}{
+		{
+			name:          "defaults",
+			given:         SMTPConfig{Host: "smtp.mail.com", Port: 587},
+			expectedError: nil,
+		},
+		{
+			name:          "missing host",
+			given:         SMTPConfig{Port: 587},
+			expectedError: errHostRequired,
+		},
+		{
+			name:          "missing port",
+			given:         SMTPConfig{Host: "smtp.mail.com"},
+			expectedError: errPortRequired,
+		},
+		{
+			name:          "invalid tls mode",
+			given:         SMTPConfig{Host: "smtp.mail.com", Port: 587, TLSMode: "maybe"},
+			expectedError: errTLSModeInvalid,
+		},
+		{
+			name:          "invalid auth mechanism",
+			given:         SMTPConfig{Host: "smtp.mail.com", Port: 587, Auth: "XOAUTH2"},
+			expectedError: errAuthMechanismInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := NewSMTP(tc.given)
+			assert.Equal(t, tc.expectedError, err)
+
+			if err == nil {
+				assert.Equal(t, TLSModeStartTLS, actual.cfg.TLSMode)
+				assert.Equal(t, AuthPlain, actual.cfg.Auth)
+				assert.Equal(t, "smtp.mail.com:587", actual.addr)
+			}
+		})
+	}
+}
+
+func TestSMTP_SendContext(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		configServer func(s *fakeSMTPServer)
+		givenTLSMode TLSMode
+		givenAuth    AuthMechanism
+		expectedTLS  bool
+	}{
+		{
+			name: "starttls with plain auth",
+			configServer: func(s *fakeSMTPServer) {
+				s.startTLS = true
+				s.username, s.password = "user", "secret"
+			},
+			givenTLSMode: TLSModeStartTLS,
+			givenAuth:    AuthPlain,
+			expectedTLS:  true,
+		},
+		{
+			name: "implicit tls with login auth",
+			configServer: func(s *fakeSMTPServer) {
+				s.implicitTLS = true
+				s.username, s.password = "user", "secret"
+			},
+			givenTLSMode: TLSModeImplicit,
+			givenAuth:    AuthLogin,
+			expectedTLS:  true,
+		},
+		{
+			name: "plain connection with cram-md5 auth",
+			configServer: func(s *fakeSMTPServer) {
+				s.username, s.password = "user", "secret"
+			},
+			givenTLSMode: TLSModeNone,
+			givenAuth:    AuthCRAMMD5,
+			expectedTLS:  false,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := newFakeSMTPServer(t, tc.configServer)
+
+			sender, err := NewSMTP(SMTPConfig{
+				Host:      "127.0.0.1",
+				Port:      server.port(),
+				TLSMode:   tc.givenTLSMode,
+				TLSConfig: server.clientTLSConfig(),
+				Auth:      tc.givenAuth,
+				Username:  "user",
+				Password:  "secret",
+			})
+			require.NoError(t, err)
+			defer sender.Close()
+
+			err = sender.SendContext(context.Background(), "noreply@app.com", "joedoe@mail.com", []byte("Subject: hi\r\n\r\nhello\r\n"))
+			require.NoError(t, err)
+
+			_, messages, authed := server.stats()
+			require.Len(t, messages, 1)
+
+			assert.Equal(t, "noreply@app.com", messages[0].from)
+			assert.Equal(t, "joedoe@mail.com", messages[0].to)
+			assert.Equal(t, tc.expectedTLS, messages[0].tls)
+			assert.Contains(t, messages[0].body, "hello")
+			assert.Equal(t, []string{string(tc.givenAuth)}, authed)
+		})
+	}
+}
+
+func TestSMTP_connectionReuse(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeSMTPServer(t, func(s *fakeSMTPServer) {
+		s.startTLS = true
+	})
+
+	sender, err := NewSMTP(SMTPConfig{
+		Host:      "127.0.0.1",
+		Port:      server.port(),
+		TLSConfig: server.clientTLSConfig(),
+	})
+	require.NoError(t, err)
+	defer sender.Close()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, sender.Send("noreply@app.com", "joedoe@mail.com", []byte("hello\r\n")))
+	}
+
+	connections, messages, _ := server.stats()
+	assert.Equal(t, 1, connections)
+	assert.Len(t, messages, 3)
+
+	t.Run("expired idle connection is replaced", func(t *testing.T) {
+		sender.cfg.IdleTimeout = time.Nanosecond
+		time.Sleep(time.Millisecond)
+
+		require.NoError(t, sender.Send("noreply@app.com", "joedoe@mail.com", []byte("hello\r\n")))
+
+		connections, _, _ := server.stats()
+		assert.Equal(t, 2, connections)
+	})
+}
+
+func TestSMTP_canceledAfterSend(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeSMTPServer(t, nil)
+
+	sender, err := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: server.port(), TLSMode: TLSModeNone})
+	require.NoError(t, err)
+	defer sender.Close()
+
+	// Each send cancels its context on return, which must not reach the connection once it is back in the pool
+	for i := 0; i < 200; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		require.NoError(t, sender.SendContext(ctx, "noreply@app.com", "joedoe@mail.com", []byte("hello\r\n")))
+		cancel()
+	}
+
+	connections, messages, _ := server.stats()
+	assert.Equal(t, 1, connections)
+	assert.Len(t, messages, 200)
+}
+
+func TestSMTP_startTLSRequired(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeSMTPServer(t, nil)
+
+	sender, err := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: server.port()})
+	require.NoError(t, err)
+
+	err = sender.Send("noreply@app.com", "joedoe@mail.com", []byte("hello\r\n"))
+	assert.True(t, errors.Is(err, errStartTLSUnsupported))
+
+	_, messages, _ := server.stats()
+	assert.Empty(t, messages)
+}
+
+func TestSMTP_authenticationFailure(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeSMTPServer(t, func(s *fakeSMTPServer) {
+		s.username, s.password = "user", "secret"
+	})
+
+	sender, err := NewSMTP(SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		TLSMode:  TLSModeNone,
+		Username: "user",
+		Password: "wrong",
+	})
+	require.NoError(t, err)
+
+	err = sender.Send("noreply@app.com", "joedoe@mail.com", []byte("hello\r\n"))
+	assert.Error(t, err)
+}
+
+func TestSMTP_contextDeadline(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeSMTPServer(t, func(s *fakeSMTPServer) {
+		s.dataDelay = time.Second
+	})
+
+	sender, err := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: server.port(), TLSMode: TLSModeNone})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	err = sender.SendContext(ctx, "noreply@app.com", "joedoe@mail.com", []byte("hello\r\n"))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	assert.Less(t, time.Since(start), 900*time.Millisecond)
+
+	// A failed connection is not returned to the pool
+	assert.Empty(t, sender.idle)
+}
+
+// expiredContext has a deadline that passed before its timer fired
+type expiredContext struct {
+	context.Context
+}
+
+func (expiredContext) Deadline() (time.Time, bool) {
+	return time.Now().Add(-time.Millisecond), true
+}
+
+func TestContextErr(t *testing.T) {
+	t.Parallel()
+
+	timeoutErr := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	otherErr := errors.New("550 mailbox unavailable")
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testCases := []struct {
+		name          string
+		givenCtx      context.Context
+		givenErr      error
+		expectedError error
+	}{
+		{
+			name:          "timeout of a canceled context",
+			givenCtx:      canceled,
+			givenErr:      timeoutErr,
+			expectedError: context.Canceled,
+		},
+		{
+			name:          "timeout after the deadline, before the context timer fired",
+			givenCtx:      expiredContext{context.Background()},
+			givenErr:      timeoutErr,
+			expectedError: context.DeadlineExceeded,
+		},
+		{
+			name:          "timeout of a live context",
+			givenCtx:      context.Background(),
+			givenErr:      timeoutErr,
+			expectedError: timeoutErr,
+		},
+		{
+			name:          "other error of a canceled context",
+			givenCtx:      canceled,
+			givenErr:      otherErr,
+			expectedError: otherErr,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expectedError, contextErr(tc.givenCtx, tc.givenErr))
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/alesr/stdservices/pkg/locale"
+	"golang.org/x/text/language"
+)
+
+const (
+	// Enumerate message types with default templates
+
+	MessageEmailVerification MessageType = "email_verification"
+)
+
+//go:embed templates/*/*.tmpl
+var defaultTemplatesFS embed.FS
+
+// MessageType identifies the purpose of a message and selects its template
+type MessageType string
+
+// EmailVerificationData is the data passed to MessageEmailVerification templates
+type EmailVerificationData struct {
+	AppName  string
+	Username string
+	Link     string
+	// Code is an optional short code users can type instead of following the link
+	Code      string
+	ExpiresAt time.Time
+}
+
+// Template renders the subject and bodies of a message.
+// The HTML template is optional; messages without it are sent as plain text.
+type Template struct {
+	Subject *texttemplate.Template
+	Text    *texttemplate.Template
+	HTML    *htmltemplate.Template
+}
+
+// ParseTemplate parses the subject, text and HTML sources of a template.
+// An empty html source produces a plain text only template.
+func ParseTemplate(subject, text, html string) (*Template, error) {
+	subjectTmpl, err := texttemplate.New("subject").Parse(subject)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse subject template: %s", err)
+	}
+
+	textTmpl, err := texttemplate.New("text").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse text template: %s", err)
+	}
+
+	tmpl := Template{
+		Subject: subjectTmpl,
+		Text:    textTmpl,
+	}
+
+	if html != "" {
+		htmlTmpl, err := htmltemplate.New("html").Parse(html)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse html template: %s", err)
+		}
+		tmpl.HTML = htmlTmpl
+	}
+	return &tmpl, nil
+}
+
+// Render executes the template with the given data and returns a message
+// carrying the subject and bodies. Callers set the sender and recipients.
+func (t *Template) Render(data interface{}) (*Message, error) {
+	var subject, text, html bytes.Buffer
+
+	if err := t.Subject.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("could not execute subject template: %s", err)
+	}
+
+	if err := t.Text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("could not execute text template: %s", err)
+	}
+
+	if t.HTML != nil {
+		if err := t.HTML.Execute(&html, data); err != nil {
+			return nil, fmt.Errorf("could not execute html template: %s", err)
+		}
+	}
+
+	return &Message{
+		// Header values cannot span lines
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+// Templates holds a template per message type and language. It is safe for concurrent use.
+type Templates struct {
+	mu     sync.RWMutex
+	byType map[MessageType]map[language.Tag]*Template
+}
+
+// NewTemplates returns a template set loaded with the default templates in every supported language
+func NewTemplates() *Templates {
+	t := Templates{byType: make(map[MessageType]map[language.Tag]*Template)}
+
+	for _, msgType := range []MessageType{MessageEmailVerification} {
+		for _, tag := range locale.Supported {
+			tmpl, err := loadDefaultTemplate(msgType, tag)
+			if err != nil {
+				// Default templates are embedded at build time and covered by tests
+				panic(err)
+			}
+			t.set(msgType, tag, tmpl)
+		}
+	}
+	return &t
+}
+
+// Set overrides the template of a message type in the given language
+func (t *Templates) Set(msgType MessageType, tag language.Tag, tmpl *Template) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.set(msgType, tag, tmpl)
+}
+
+func (t *Templates) set(msgType MessageType, tag language.Tag, tmpl *Template) {
+	if t.byType[msgType] == nil {
+		t.byType[msgType] = make(map[language.Tag]*Template)
+	}
+	t.byType[msgType][locale.Base(tag)] = tmpl
+}
+
+// Render renders the template of a message type with the given data.
+// The preferred locale is resolved with locale.Resolve, and the template in
+// the default language is used when none exists in the resolved language.
+func (t *Templates) Render(msgType MessageType, preferredLocale string, data interface{}) (*Message, error) {
+	tag := locale.Resolve(preferredLocale)
+
+	t.mu.RLock()
+	tmpl, ok := t.byType[msgType][tag]
+	if !ok {
+		tmpl, ok = t.byType[msgType][locale.Default]
+	}
+	t.mu.RUnlock()
+
+	if !ok {
+		return nil, errTemplateNotFound
+	}
+
+	msg, err := tmpl.Render(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not render %s template: %s", msgType, err)
+	}
+	return msg, nil
+}
+
+func loadDefaultTemplate(msgType MessageType, tag language.Tag) (*Template, error) {
+	var sources [3]string
+
+	for i, ext := range []string{"subject", "txt", "html"} {
+		b, err := defaultTemplatesFS.ReadFile(fmt.Sprintf("templates/%s/%s.%s.tmpl", tag, msgType, ext))
+		if err != nil {
+			return nil, fmt.Errorf("could not read default %s template: %s", msgType, err)
+		}
+		sources[i] = string(b)
+	}
+	return ParseTemplate(sources[0], sources[1], sources[2])
+}
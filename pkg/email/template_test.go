@@ -0,0 +1,124 @@
+package email
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/language"
+)
+
+func TestTemplates_Render(t *testing.T) {
+	t.Parallel()
+
+	givenData := EmailVerificationData{
+		AppName:   "test-app",
+		Username:  "<jdoe>",
+		Link:      "https://test-app.com/verify-email/abc123",
+		ExpiresAt: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	t.Run("default email verification template", func(t *testing.T) {
+		actual, err := NewTemplates().Render(MessageEmailVerification, "", givenData)
+		require.NoError(t, err)
+
+		assert.Equal(t, "test-app email verification", actual.Subject)
+		assert.Contains(t, actual.Text, "Hi <jdoe>,")
+		assert.Contains(t, actual.Text, givenData.Link)
+		assert.Contains(t, actual.Text, "2022-01-02 00:00 UTC")
+		assert.Contains(t, actual.HTML, "Hi &lt;jdoe&gt;,")
+		assert.Contains(t, actual.HTML, `href="https://test-app.com/verify-email/abc123"`)
+		assert.NotContains(t, actual.Text, "code")
+	})
+
+	t.Run("email verification code", func(t *testing.T) {
+		data := givenData
+		data.Code = "012345"
+
+		actual, err := NewTemplates().Render(MessageEmailVerification, "", data)
+		require.NoError(t, err)
+
+		assert.Contains(t, actual.Text, "Or enter this code: 012345")
+		assert.Contains(t, actual.HTML, "<strong>012345</strong>")
+	})
+
+	t.Run("localized default templates", func(t *testing.T) {
+		templates := NewTemplates()
+
+		pt, err := templates.Render(MessageEmailVerification, "pt-BR,pt;q=0.9", givenData)
+		require.NoError(t, err)
+		assert.Equal(t, "test-app verificação de email", pt.Subject)
+		assert.Contains(t, pt.Text, "Olá <jdoe>,")
+		assert.Contains(t, pt.Text, "02/01/2022 00:00 UTC")
+		assert.Contains(t, pt.HTML, `lang="pt"`)
+
+		de, err := templates.Render(MessageEmailVerification, "de-CH", givenData)
+		require.NoError(t, err)
+		assert.Equal(t, "test-app E-Mail-Bestätigung", de.Subject)
+		assert.Contains(t, de.Text, "Hallo <jdoe>,")
+		assert.Contains(t, de.HTML, givenData.Link)
+	})
+
+	t.Run("unsupported language falls back to english", func(t *testing.T) {
+		actual, err := NewTemplates().Render(MessageEmailVerification, "ja-JP", givenData)
+		require.NoError(t, err)
+		assert.Equal(t, "test-app email verification", actual.Subject)
+	})
+
+	t.Run("missing localized override falls back to default language", func(t *testing.T) {
+		tmpl, err := ParseTemplate("Only english", "text", "")
+		require.NoError(t, err)
+
+		templates := &Templates{byType: make(map[MessageType]map[language.Tag]*Template)}
+		templates.Set("custom", language.English, tmpl)
+
+		actual, err := templates.Render("custom", "de", givenData)
+		require.NoError(t, err)
+		assert.Equal(t, "Only english", actual.Subject)
+	})
+
+	t.Run("overridden template", func(t *testing.T) {
+		tmpl, err := ParseTemplate("Welcome {{.Username}}", "Go to {{.Link}}", "")
+		require.NoError(t, err)
+
+		templates := NewTemplates()
+		templates.Set(MessageEmailVerification, language.English, tmpl)
+
+		actual, err := templates.Render(MessageEmailVerification, "en-US", givenData)
+		require.NoError(t, err)
+
+		assert.Equal(t, "Welcome <jdoe>", actual.Subject)
+		assert.Equal(t, "Go to https://test-app.com/verify-email/abc123", actual.Text)
+		assert.Empty(t, actual.HTML)
+	})
+
+	t.Run("unknown message type", func(t *testing.T) {
+		_, err := NewTemplates().Render("unknown", "en", givenData)
+		assert.Equal(t, errTemplateNotFound, err)
+	})
+
+	t.Run("template execution error", func(t *testing.T) {
+		tmpl, err := ParseTemplate("{{.Missing}}", "", "")
+		require.NoError(t, err)
+
+		templates := NewTemplates()
+		templates.Set(MessageEmailVerification, language.English, tmpl)
+
+		_, err = templates.Render(MessageEmailVerification, "en", givenData)
+		assert.Error(t, err)
+	})
+}
+
+func TestParseTemplate(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseTemplate("{{", "", "")
+	assert.Error(t, err)
+
+	_, err = ParseTemplate("", "{{", "")
+	assert.Error(t, err)
+
+	_, err = ParseTemplate("", "", "{{")
+	assert.Error(t, err)
+}
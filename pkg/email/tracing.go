@@ -0,0 +1,113 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans of the package
+const tracerName = "github.com/alesr/stdservices/pkg/email"
+
+// Span attributes. Addresses are not recorded, only the domain of the recipient.
+const (
+	attrRecipientDomain = attribute.Key("email.recipient_domain")
+	attrMessageSize     = attribute.Key("email.message_size")
+	attrSMTPReplyCode   = attribute.Key("email.smtp_reply_code")
+)
+
+type contextEmailer interface {
+	SendContext(ctx context.Context, from, to string, body []byte) error
+}
+
+// Tracing is an emailer decorator recording an OpenTelemetry span for every message sent
+type Tracing struct {
+	next   emailer
+	tracer trace.Tracer
+}
+
+// NewTracing creates a tracing emailer sending messages through next
+func NewTracing(next emailer, tp trace.TracerProvider) *Tracing {
+	return &Tracing{next: next, tracer: tp.Tracer(tracerName)}
+}
+
+// Send sends the message in a root span
+func (t *Tracing) Send(from, to string, body []byte) error {
+	return t.SendContext(context.Background(), from, to, body)
+}
+
+// SendContext sends the message in a span child of the span in ctx
+func (t *Tracing) SendContext(ctx context.Context, from, to string, body []byte) error {
+	ctx, span := t.tracer.Start(ctx, "email.Send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attrRecipientDomain.String(domain(to)),
+			attrMessageSize.Int(len(body)),
+		),
+	)
+	defer span.End()
+
+	if err := sendContext(ctx, t.next, from, to, body); err != nil {
+		// Errors are not recorded as is, since SMTP replies often quote the addresses of the message
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) {
+			span.SetAttributes(attrSMTPReplyCode.Int(protoErr.Code))
+		}
+		class := ErrorClass(err)
+		if class == "" {
+			class = "send failed"
+		}
+		span.SetStatus(codes.Error, class)
+		return err
+	}
+	return nil
+}
+
+// ErrorClass describes an error without its message, which may quote email addresses, as the SMTP reply code
+// or the kind of failure. It returns an empty string for errors of another kind.
+func ErrorClass(err error) string {
+	var (
+		protoErr *textproto.Error
+		netErr   net.Error
+	)
+
+	switch {
+	case errors.As(err, &protoErr):
+		return "smtp reply " + strconv.Itoa(protoErr.Code)
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline exceeded"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "network timeout"
+	case errors.As(err, &netErr):
+		return "network error"
+	default:
+		return ""
+	}
+}
+
+// sendContext sends through SendContext when the emailer supports it, so that the context
+// reaches the emailers at the end of a chain of decorators
+func sendContext(ctx context.Context, e emailer, from, to string, body []byte) error {
+	if ce, ok := e.(contextEmailer); ok {
+		return ce.SendContext(ctx, from, to, body)
+	}
+	return e.Send(from, to, body)
+}
+
+// domain returns the domain of an address, or an empty string if it has none
+func domain(addr string) string {
+	i := strings.LastIndexByte(addr, '@')
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(addr[i+1:], ">"))
+}
@@ -0,0 +1,135 @@
+package email
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextEmailerMock struct {
+	sendContextFunc func(ctx context.Context, from, to string, body []byte) error
+}
+
+func (m *contextEmailerMock) Send(from, to string, body []byte) error {
+	return errors.New("contextEmailerMock.Send should not be called")
+}
+
+func (m *contextEmailerMock) SendContext(ctx context.Context, from, to string, body []byte) error {
+	if m.sendContextFunc == nil {
+		return errors.New("contextEmailerMock.sendContextFunc is nil")
+	}
+	return m.sendContextFunc(ctx, from, to, body)
+}
+
+func TestTracing_SendContext(t *testing.T) {
+	t.Parallel()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	var sentIn trace.SpanContext
+
+	// The context reaches the emailer behind the DKIM decorator
+	signer, err := NewDKIM(&contextEmailerMock{
+		sendContextFunc: func(ctx context.Context, from, to string, body []byte) error {
+			sentIn = trace.SpanContextFromContext(ctx)
+			return nil
+		},
+	}, DKIMConfig{Domain: "example.com", Selector: "mail", PrivateKey: key})
+	require.NoError(t, err)
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+
+	err = NewTracing(signer, tp).SendContext(ctx, "noreply@example.com", "John Doe <JDoe@Mail.com>", dkimTestMessage)
+	require.NoError(t, err)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+
+	span := spans[0]
+	assert.Equal(t, "email.Send", span.Name)
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+	assert.Equal(t, span.SpanContext.SpanID(), sentIn.SpanID())
+	assert.Equal(t, codes.Unset, span.Status.Code)
+	assert.Contains(t, span.Attributes, attribute.String("email.recipient_domain", "mail.com"))
+	assert.Contains(t, span.Attributes, attribute.Int("email.message_size", len(dkimTestMessage)))
+}
+
+func TestTracing_Send_error(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name               string
+		givenErr           error
+		expectedStatus     string
+		expectedAttributes []attribute.KeyValue
+	}{
+		{
+			name: "smtp reply",
+			givenErr: fmt.Errorf("could not send mail: %w",
+				&textproto.Error{Code: 550, Msg: "5.1.1 <jdoe@mail.com>: Recipient address rejected"}),
+			expectedStatus:     "smtp reply 550",
+			expectedAttributes: []attribute.KeyValue{attribute.Int("email.smtp_reply_code", 550)},
+		},
+		{
+			name:           "deadline exceeded",
+			givenErr:       fmt.Errorf("could not send mail to jdoe@mail.com: %w", context.DeadlineExceeded),
+			expectedStatus: "deadline exceeded",
+		},
+		{
+			name:           "network error",
+			givenErr:       &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			expectedStatus: "network error",
+		},
+		{
+			name:           "unexpected error",
+			givenErr:       errors.New("could not write message for jdoe@mail.com"),
+			expectedStatus: "send failed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter := tracetest.NewInMemoryExporter()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+			err := NewTracing(&emailerMock{
+				sendFunc: func(from, to string, body []byte) error { return tc.givenErr },
+			}, tp).Send("noreply@example.com", "jdoe@mail.com", []byte("body"))
+			assert.Equal(t, tc.givenErr, err)
+
+			spans := exporter.GetSpans()
+			require.Len(t, spans, 1)
+
+			span := spans[0]
+			assert.Equal(t, codes.Error, span.Status.Code)
+			assert.Equal(t, tc.expectedStatus, span.Status.Description)
+			for _, attr := range tc.expectedAttributes {
+				assert.Contains(t, span.Attributes, attr)
+			}
+
+			// The recipient address of the error is not recorded
+			assert.Empty(t, span.Events)
+			for _, attr := range span.Attributes {
+				assert.NotContains(t, attr.Value.Emit(), "jdoe@")
+			}
+		})
+	}
+}
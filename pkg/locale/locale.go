@@ -0,0 +1,43 @@
+package locale
+
+import "golang.org/x/text/language"
+
+// Default is the language used when no supported language matches the user preference
+var Default = language.English
+
+// Supported lists the languages with message catalogs, the first one being the default
+var Supported = []language.Tag{
+	language.English,
+	language.Portuguese,
+	language.German,
+}
+
+var matcher = language.NewMatcher(Supported)
+
+// Resolve returns the supported language that best matches a preference.
+// The preference is either a single BCP 47 tag, such as "pt-BR", or an
+// Accept-Language header value, such as "de-CH,de;q=0.9,en;q=0.8".
+// Unknown or malformed preferences resolve to Default.
+func Resolve(preference string) language.Tag {
+	if preference == "" {
+		return Default
+	}
+
+	tags, _, err := language.ParseAcceptLanguage(preference)
+	if err != nil || len(tags) == 0 {
+		return Default
+	}
+
+	_, index, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return Default
+	}
+	return Supported[index]
+}
+
+// Base returns the language of a tag without region or script subtags.
+// Catalogs are keyed by base language, so "pt-BR" and "pt-PT" share messages.
+func Base(tag language.Tag) language.Tag {
+	base, _ := tag.Base()
+	return language.Make(base.String())
+}
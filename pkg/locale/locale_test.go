@@ -0,0 +1,68 @@
+package locale
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/language"
+)
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		given    string
+		expected language.Tag
+	}{
+		{
+			name:     "empty",
+			given:    "",
+			expected: language.English,
+		},
+		{
+			name:     "exact tag",
+			given:    "de",
+			expected: language.German,
+		},
+		{
+			name:     "regional tag",
+			given:    "pt-BR",
+			expected: language.Portuguese,
+		},
+		{
+			name:     "accept language list",
+			given:    "fr-FR,fr;q=0.9,de;q=0.8,en;q=0.7",
+			expected: language.German,
+		},
+		{
+			name:     "accept language weights",
+			given:    "en;q=0.5,pt;q=0.9",
+			expected: language.Portuguese,
+		},
+		{
+			name:     "unsupported language",
+			given:    "ja",
+			expected: language.English,
+		},
+		{
+			name:     "malformed",
+			given:    "%%%",
+			expected: language.English,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := Resolve(tc.given)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestBase(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, language.Portuguese, Base(language.MustParse("pt-BR")))
+	assert.Equal(t, language.German, Base(language.German))
+}
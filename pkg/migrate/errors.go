@@ -0,0 +1,12 @@
+package migrate
+
+import "errors"
+
+var (
+	// List error messages
+
+	errDirty            = errors.New("database is dirty, a previous migration failed and must be fixed manually")
+	errDuplicateVersion = errors.New("migration version is duplicated")
+	errFileName         = errors.New("migration file name must look like <version>_<name>.up.sql or <version>_<name>.down.sql")
+	errUpMissing        = errors.New("migration has no up file")
+)
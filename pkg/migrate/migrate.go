@@ -0,0 +1,230 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// lockID is the key of the advisory lock held while migrating,
+	// so that replicas starting at the same time don't apply migrations twice
+	lockID int64 = 7301553119
+
+	createVersionTableQuery string = `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT NOT NULL PRIMARY KEY,
+		dirty BOOLEAN NOT NULL
+	);`
+
+	selectVersionQuery string = "SELECT version, dirty FROM schema_migrations LIMIT 1;"
+
+	deleteVersionQuery string = "DELETE FROM schema_migrations;"
+
+	insertVersionQuery string = "INSERT INTO schema_migrations (version, dirty) VALUES ($1, FALSE);"
+)
+
+// Migration is a numbered schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Option configures Up
+type Option func(*config)
+
+type config struct {
+	steps map[int]func(ctx context.Context, tx *sql.Tx) error
+}
+
+// WithSteps runs Go steps, by migration version, for changes SQL cannot express such as backfills computed in Go.
+// A step runs in the transaction of its migration, before its up file.
+func WithSteps(steps map[int]func(ctx context.Context, tx *sql.Tx) error) Option {
+	return func(c *config) {
+		c.steps = steps
+	}
+}
+
+// Load reads the migrations of a directory, named "<version>_<name>.up.sql" and "<version>_<name>.down.sql",
+// sorted by version. Other files are ignored.
+func Load(fsys fs.FS) ([]Migration, error) {
+	paths, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("could not list migrations: %s", err)
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, p := range paths {
+		version, name, direction, err := parseFileName(path.Base(p))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse migration '%s': %s", p, err)
+		}
+
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil, fmt.Errorf("could not read migration '%s': %s", p, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+
+		if m.Name != name {
+			return nil, fmt.Errorf("could not load migration '%s': %s", p, errDuplicateVersion)
+		}
+
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("could not load migration %d: %s", m.Version, errUpMissing)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up applies the migrations of a directory that are newer than the database version, each in its own
+// transaction, and returns how many were applied. The version is kept in the schema_migrations table
+// used by golang-migrate, so databases can be migrated by either tool.
+func Up(ctx context.Context, db *sql.DB, fsys fs.FS, opts ...Option) (int, error) {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		return 0, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not get connection: %s", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1);", lockID); err != nil {
+		return 0, fmt.Errorf("could not acquire migration lock: %s", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1);", lockID)
+
+	if _, err := conn.ExecContext(ctx, createVersionTableQuery); err != nil {
+		return 0, fmt.Errorf("could not create version table: %s", err)
+	}
+
+	current, err := version(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	var applied int
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		if err := apply(ctx, conn, m, cfg.steps[m.Version]); err != nil {
+			return applied, err
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+// version returns the current version of the database, 0 if no migration was applied
+func version(ctx context.Context, conn *sql.Conn) (int, error) {
+	var (
+		v     int
+		dirty bool
+	)
+
+	if err := conn.QueryRowContext(ctx, selectVersionQuery).Scan(&v, &dirty); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("could not select version: %s", err)
+	}
+
+	if dirty {
+		return 0, fmt.Errorf("could not migrate from version %d: %s", v, errDirty)
+	}
+	return v, nil
+}
+
+func apply(ctx context.Context, conn *sql.Conn, m Migration, step func(ctx context.Context, tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	if step != nil {
+		if err := step(ctx, tx); err != nil {
+			return fmt.Errorf("could not run step of migration %d_%s: %s", m.Version, m.Name, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+		return fmt.Errorf("could not apply migration %d_%s: %s", m.Version, m.Name, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, deleteVersionQuery); err != nil {
+		return fmt.Errorf("could not delete version: %s", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, insertVersionQuery, m.Version); err != nil {
+		return fmt.Errorf("could not insert version: %s", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit migration %d_%s: %s", m.Version, m.Name, err)
+	}
+	return nil
+}
+
+// parseFileName splits a migration file name such as "1_create_users_table.up.sql"
+func parseFileName(name string) (version int, title, direction string, err error) {
+	base := strings.TrimSuffix(name, ".sql")
+
+	switch {
+	case strings.HasSuffix(base, ".up"):
+		direction = "up"
+	case strings.HasSuffix(base, ".down"):
+		direction = "down"
+	default:
+		return 0, "", "", errFileName
+	}
+
+	base = strings.TrimSuffix(base, "."+direction)
+
+	prefix, title, found := strings.Cut(base, "_")
+	if !found || title == "" {
+		return 0, "", "", errFileName
+	}
+
+	version, err = strconv.Atoi(prefix)
+	if err != nil || version <= 0 {
+		return 0, "", "", errFileName
+	}
+	return version, title, direction, nil
+}
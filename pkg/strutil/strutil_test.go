@@ -0,0 +1,16 @@
+package strutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncate(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "abc", Truncate("abc", 5))
+	assert.Equal(t, "ab", Truncate("abc", 2))
+	assert.Equal(t, "a", Truncate("aé", 2))
+	assert.Equal(t, "", Truncate("é", 1))
+}
@@ -0,0 +1,74 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// Enumerate alphabets
+
+	// Alphanumeric suits tokens sent in links, carrying about 5.95 bits per character
+	Alphanumeric = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+	// Digits suits short codes typed by users
+	Digits = "0123456789"
+)
+
+var (
+	errAlphabet = errors.New("alphabet must have between 2 and 256 characters")
+	errLength   = errors.New("length must be positive")
+
+	// random is the source of randomness, replaced in tests
+	random io.Reader = rand.Reader
+)
+
+// Generate returns a token of the given length with characters drawn uniformly from the
+// alphabet, using crypto/rand. Alphabets are sequences of bytes, such as Alphanumeric.
+func Generate(length int, alphabet string) (string, error) {
+	if length <= 0 {
+		return "", errLength
+	}
+
+	if len(alphabet) < 2 || len(alphabet) > 256 {
+		return "", errAlphabet
+	}
+
+	// Random bytes at or above limit are rejected, since mapping them
+	// with a modulo would make the first characters more likely
+	limit := 256 - 256%len(alphabet)
+
+	token := make([]byte, 0, length)
+	buf := make([]byte, length)
+
+	for len(token) < length {
+		if _, err := io.ReadFull(random, buf); err != nil {
+			return "", fmt.Errorf("could not read random bytes: %s", err)
+		}
+
+		for _, b := range buf {
+			if int(b) < limit && len(token) < length {
+				token = append(token, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(token), nil
+}
+
+// Hash returns the hex encoded SHA-256 hash of a token. Tokens are stored hashed so
+// that a leaked table cannot be used to verify accounts. Tokens must be random:
+// a fast hash is not suitable for secrets chosen by users.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Equal compares two hashes in constant time, so that comparisons don't leak how many characters match
+func Equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,88 @@
+package token
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenLength   int
+		givenAlphabet string
+		expectedError error
+	}{
+		{
+			name:          "link token",
+			givenLength:   32,
+			givenAlphabet: Alphanumeric,
+		},
+		{
+			name:          "numeric code",
+			givenLength:   6,
+			givenAlphabet: Digits,
+		},
+		{
+			name:          "zero length",
+			givenLength:   0,
+			givenAlphabet: Digits,
+			expectedError: errLength,
+		},
+		{
+			name:          "single character alphabet",
+			givenLength:   6,
+			givenAlphabet: "a",
+			expectedError: errAlphabet,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := Generate(tc.givenLength, tc.givenAlphabet)
+			require.Equal(t, tc.expectedError, err)
+			if err != nil {
+				return
+			}
+
+			assert.Len(t, actual, tc.givenLength)
+			for _, char := range actual {
+				assert.True(t, strings.ContainsRune(tc.givenAlphabet, char))
+			}
+		})
+	}
+}
+
+func TestGenerate_uniform(t *testing.T) {
+	// Not parallel since it replaces the package random source
+	defer func(r io.Reader) { random = r }(random)
+
+	// With 10 digits, bytes from 250 are rejected instead of favoring "0" to "5"
+	random = bytes.NewReader([]byte{250, 255, 9, 251, 19, 0})
+
+	actual, err := Generate(3, Digits)
+	require.NoError(t, err)
+	assert.Equal(t, "990", actual)
+
+	random = bytes.NewReader(nil)
+
+	_, err = Generate(3, Digits)
+	assert.True(t, err != nil && !errors.Is(err, errLength))
+}
+
+func TestHash(t *testing.T) {
+	t.Parallel()
+
+	actual := Hash("abc")
+	assert.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", actual)
+	assert.True(t, Equal(actual, Hash("abc")))
+	assert.False(t, Equal(actual, Hash("abd")))
+	assert.False(t, Equal(actual, actual[:10]))
+}
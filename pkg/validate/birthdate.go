@@ -0,0 +1,74 @@
+package validate
+
+import "time"
+
+const (
+	// BirthdateLayout is the format of birthdates, as in "2006-01-02"
+	BirthdateLayout string = "2006-01-02"
+
+	maxAge = 150
+)
+
+// AgePolicy defines the accepted ages of users, computed from their birthdate.
+// Birthdates in the future are always rejected. The zero value accepts ages from 0 to 150 years.
+type AgePolicy struct {
+	// MinAge is the minimum age in full years, such as 13 to comply with COPPA
+	MinAge int
+
+	// MaxAge is the maximum plausible age in full years. Zero defaults to 150.
+	MaxAge int
+}
+
+// Birthdate validates a birthdate with the zero AgePolicy
+func Birthdate(bDate string) error {
+	return AgePolicy{}.Validate(bDate)
+}
+
+// Validate validates a birthdate against the policy relative to the current time
+func (p AgePolicy) Validate(bDate string) error {
+	return p.ValidateAt(bDate, time.Now())
+}
+
+// ValidateAt validates a birthdate against the policy relative to now,
+// so that callers with their own clock get consistent results
+func (p AgePolicy) ValidateAt(bDate string, now time.Time) error {
+	if bDate == "" {
+		return errBirthdateRequired
+	}
+
+	birthdate, err := time.Parse(BirthdateLayout, bDate)
+	if err != nil {
+		return errBirthdateFormat
+	}
+
+	oldest := p.MaxAge
+	if oldest == 0 {
+		oldest = maxAge
+	}
+
+	age := Age(birthdate, now)
+
+	switch {
+	case age < 0:
+		return errBirthdateFuture
+	case age < p.MinAge:
+		return errBirthdateTooYoung
+	case age > oldest:
+		return errBirthdateTooOld
+	}
+	return nil
+}
+
+// Age returns the age in full years on the calendar date of now, in the location of now.
+// Ages are negative for birthdates in the future. People born on February 29 turn
+// a year older on March 1 in common years.
+func Age(birthdate, now time.Time) int {
+	birthYear, birthMonth, birthDay := birthdate.Date()
+	year, month, day := now.Date()
+
+	age := year - birthYear
+	if month < birthMonth || month == birthMonth && day < birthDay {
+		age--
+	}
+	return age
+}
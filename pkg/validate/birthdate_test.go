@@ -0,0 +1,140 @@
+package validate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgePolicy_ValidateAt(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name        string
+		givenPolicy AgePolicy
+		given       string
+		expected    error
+	}{
+		{
+			name:     "valid",
+			given:    "1990-01-01",
+			expected: nil,
+		},
+		{
+			name:     "born today",
+			given:    "2022-06-15",
+			expected: nil,
+		},
+		{
+			name:     "born tomorrow",
+			given:    "2022-06-16",
+			expected: errBirthdateFuture,
+		},
+		{
+			name:     "default maximum age",
+			given:    "1871-06-16",
+			expected: nil,
+		},
+		{
+			name:     "older than the default maximum age",
+			given:    "1871-06-15",
+			expected: errBirthdateTooOld,
+		},
+		{
+			name:        "minimum age on the birthday",
+			givenPolicy: AgePolicy{MinAge: 13},
+			given:       "2009-06-15",
+			expected:    nil,
+		},
+		{
+			name:        "minimum age the day before the birthday",
+			givenPolicy: AgePolicy{MinAge: 13},
+			given:       "2009-06-16",
+			expected:    errBirthdateTooYoung,
+		},
+		{
+			name:        "custom maximum age",
+			givenPolicy: AgePolicy{MaxAge: 100},
+			given:       "1920-01-01",
+			expected:    errBirthdateTooOld,
+		},
+		{
+			name:        "empty",
+			givenPolicy: AgePolicy{MinAge: 13},
+			given:       "",
+			expected:    errBirthdateRequired,
+		},
+		{
+			name:     "invalid date",
+			given:    "1990-02-30",
+			expected: errBirthdateFormat,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.givenPolicy.ValidateAt(tc.given, now))
+		})
+	}
+}
+
+func TestAge(t *testing.T) {
+	t.Parallel()
+
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	testCases := []struct {
+		name           string
+		givenBirthdate time.Time
+		givenNow       time.Time
+		expected       int
+	}{
+		{
+			name:           "before the birthday",
+			givenBirthdate: date(2000, time.May, 20),
+			givenNow:       date(2020, time.May, 19),
+			expected:       19,
+		},
+		{
+			name:           "on the birthday",
+			givenBirthdate: date(2000, time.May, 20),
+			givenNow:       date(2020, time.May, 20),
+			expected:       20,
+		},
+		{
+			name:           "leap day birthday in a common year",
+			givenBirthdate: date(2004, time.February, 29),
+			givenNow:       date(2021, time.February, 28),
+			expected:       16,
+		},
+		{
+			name:           "leap day birthday the day after in a common year",
+			givenBirthdate: date(2004, time.February, 29),
+			givenNow:       date(2021, time.March, 1),
+			expected:       17,
+		},
+		{
+			name:           "in the future",
+			givenBirthdate: date(2021, time.January, 1),
+			givenNow:       date(2020, time.December, 31),
+			expected:       -1,
+		},
+		{
+			name:           "calendar date in the location of now",
+			givenBirthdate: date(2000, time.May, 20),
+			givenNow:       time.Date(2020, time.May, 19, 23, 0, 0, 0, time.FixedZone("UTC-2", -2*60*60)),
+			expected:       19,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Age(tc.givenBirthdate, tc.givenNow))
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package validate
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// breachedPrefixLen is the length of the SHA-1 hash prefix, in hex characters, naming each range file
+const breachedPrefixLen = 5
+
+// BreachedPasswordFiles checks passwords against an offline copy of the Have I Been Pwned
+// k-anonymity range files: a directory with one file per 5 hex characters SHA-1 prefix,
+// named "5BAA6" or "5BAA6.txt", listing "SUFFIX:COUNT" lines for the remaining 35 characters.
+// Only the file of the password prefix is read.
+type BreachedPasswordFiles struct {
+	dir string
+}
+
+// NewBreachedPasswordFiles creates a breach checker reading range files from a directory
+func NewBreachedPasswordFiles(dir string) (*BreachedPasswordFiles, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not open breached passwords directory: %s", err)
+	}
+
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached passwords path %q is not a directory", dir)
+	}
+	return &BreachedPasswordFiles{dir: dir}, nil
+}
+
+// Breached returns how many times the password appeared in breaches, or 0 if it is not listed
+func (b *BreachedPasswordFiles) Breached(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachedPrefixLen], hash[breachedPrefixLen:]
+
+	f, err := b.open(prefix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("could not open range file: %s", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineSuffix, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(lineSuffix, suffix) {
+			continue
+		}
+
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, fmt.Errorf("could not parse breach count: %s", err)
+		}
+		return n, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("could not read range file: %s", err)
+	}
+	return 0, nil
+}
+
+func (b *BreachedPasswordFiles) open(prefix string) (*os.File, error) {
+	f, err := os.Open(filepath.Join(b.dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.Open(filepath.Join(b.dir, prefix))
+	}
+	return f, err
+}
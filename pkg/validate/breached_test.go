@@ -0,0 +1,42 @@
+package validate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreachedPasswordFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	// SHA-1("password") is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	rangeFile := "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n" +
+		"1E4C9B93F3F0682250B6CF8331B7EE68FD8:9659365\r\n" +
+		"FFFF8E7E0A2B3A7F4D1B9A8B9B5F0D1D1A7:3\r\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte(rangeFile), 0o600))
+
+	checker, err := NewBreachedPasswordFiles(dir)
+	require.NoError(t, err)
+
+	count, err := checker.Breached("password")
+	require.NoError(t, err)
+	assert.Equal(t, 9659365, count)
+
+	count, err = checker.Breached("x7#Kp2$vLq9!")
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	policy := PasswordPolicy{Breached: checker}
+	assert.Equal(t, errPasswordBreached, policy.Validate("password"))
+
+	_, err = NewBreachedPasswordFiles(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+
+	_, err = NewBreachedPasswordFiles(filepath.Join(dir, "5BAA6.txt"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,193 @@
+package validate
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var (
+	//go:embed disposable_domains.txt
+	disposableDomainsFile string
+
+	// providerRules lists the providers whose addresses have aliases delivered to the same mailbox
+	providerRules = map[string]providerRule{
+		"gmail.com":      {domain: "gmail.com", ignoreDots: true},
+		"googlemail.com": {domain: "gmail.com", ignoreDots: true},
+		"outlook.com":    {domain: "outlook.com"},
+		"hotmail.com":    {domain: "hotmail.com"},
+		"live.com":       {domain: "live.com"},
+		"icloud.com":     {domain: "icloud.com"},
+		"fastmail.com":   {domain: "fastmail.com"},
+		"protonmail.com": {domain: "protonmail.com"},
+		"proton.me":      {domain: "proton.me"},
+	}
+)
+
+// providerRule describes how a provider maps addresses to mailboxes.
+// Every provider listed ignores "+tag" suffixes of the local part.
+type providerRule struct {
+	// domain is the main domain of the provider
+	domain string
+
+	// ignoreDots is set for providers ignoring dots in the local part
+	ignoreDots bool
+}
+
+// EmailPolicy defines which emails are accepted and how they are canonicalized to detect duplicates.
+// The zero value accepts any valid address.
+type EmailPolicy struct {
+	// ProviderRules applies provider specific rules when canonicalizing, such as ignoring
+	// dots and "+tag" suffixes in Gmail addresses, so that aliases of a mailbox collide
+	ProviderRules bool
+
+	// Blocked rejects emails whose domain, or a parent domain, is listed, such as DisposableDomains()
+	Blocked *DomainList
+}
+
+// Email validates an email with the zero EmailPolicy
+func Email(email string) error {
+	return EmailPolicy{}.Validate(email)
+}
+
+// Validate validates an email against the policy
+func (p EmailPolicy) Validate(email string) error {
+	if email == "" {
+		return errEmailRequired
+	}
+
+	normalized, err := NormalizeEmail(email)
+	if err != nil {
+		return errEmailFormat
+	}
+
+	if p.Blocked != nil {
+		_, domain := splitEmail(normalized)
+		if p.Blocked.Contains(domain) {
+			return errEmailBlocked
+		}
+	}
+	return nil
+}
+
+// Canonical returns the key identifying the mailbox of an email, which should be stored in a unique
+// column and used for lookups. It is the normalized email in lower case, with provider rules applied
+// if enabled. Invalid emails are only trimmed and lower cased.
+func (p EmailPolicy) Canonical(email string) string {
+	normalized, err := NormalizeEmail(email)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(email))
+	}
+
+	local, domain := splitEmail(normalized)
+	local = strings.ToLower(local)
+
+	if rule, ok := providerRules[domain]; ok && p.ProviderRules {
+		local, _, _ = strings.Cut(local, "+")
+		if rule.ignoreDots {
+			local = strings.ReplaceAll(local, ".", "")
+		}
+		domain = rule.domain
+	}
+	return local + "@" + domain
+}
+
+// NormalizeEmail returns the address of an email, dropping any display name, with its domain
+// lower cased and converted to punycode (IDNA), which is how it should be stored and sent to.
+// The local part is kept as is since mail servers may treat it as case sensitive.
+func NormalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return "", err
+	}
+
+	local, domain := splitEmail(addr.Address)
+
+	domain, err = idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("invalid domain: %s", err)
+	}
+	return local + "@" + domain, nil
+}
+
+// splitEmail splits an address at its last "@", since quoted local parts may contain one
+func splitEmail(addr string) (local, domain string) {
+	i := strings.LastIndex(addr, "@")
+	if i < 0 {
+		return addr, ""
+	}
+	return addr[:i], addr[i+1:]
+}
+
+// DomainList is a set of email domains, also matching their subdomains
+type DomainList struct {
+	domains map[string]struct{}
+}
+
+// NewDomainList creates a list of the given domains
+func NewDomainList(domains ...string) *DomainList {
+	l := DomainList{domains: make(map[string]struct{}, len(domains))}
+	for _, domain := range domains {
+		l.Add(domain)
+	}
+	return &l
+}
+
+// ReadDomainList reads a list with one domain per line. Empty lines and lines starting with "#" are ignored.
+func ReadDomainList(r io.Reader) (*DomainList, error) {
+	l := NewDomainList()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		l.Add(line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read domain list: %s", err)
+	}
+	return l, nil
+}
+
+// DisposableDomains returns a list of well known disposable email providers.
+// The list is not exhaustive; larger lists can be loaded with ReadDomainList.
+func DisposableDomains() *DomainList {
+	l, _ := ReadDomainList(strings.NewReader(disposableDomainsFile))
+	return l
+}
+
+// Add adds a domain to the list
+func (l *DomainList) Add(domain string) {
+	if ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(strings.TrimSpace(domain), ".")); err == nil && ascii != "" {
+		l.domains[ascii] = struct{}{}
+	}
+}
+
+// Contains reports whether a domain or one of its parent domains is listed
+func (l *DomainList) Contains(domain string) bool {
+	domain, err := idna.Lookup.ToASCII(strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return false
+	}
+
+	for domain != "" {
+		if _, ok := l.domains[domain]; ok {
+			return true
+		}
+
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			return false
+		}
+		domain = parent
+	}
+	return false
+}
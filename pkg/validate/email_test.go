@@ -0,0 +1,178 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		given    string
+		expected string
+	}{
+		{
+			name:     "lower cases the domain only",
+			given:    "John.Doe@Mail.COM",
+			expected: "John.Doe@mail.com",
+		},
+		{
+			name:     "drops the display name",
+			given:    "John Doe <jdoe@mail.com>",
+			expected: "jdoe@mail.com",
+		},
+		{
+			name:     "trims spaces",
+			given:    "  jdoe@mail.com  ",
+			expected: "jdoe@mail.com",
+		},
+		{
+			name:     "converts international domains to punycode",
+			given:    "jdoe@Bücher.example",
+			expected: "jdoe@xn--bcher-kva.example",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := NormalizeEmail(tc.given)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+
+	_, err := NormalizeEmail("not an email")
+	assert.Error(t, err)
+}
+
+func TestEmailPolicy_Validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		givenPolicy EmailPolicy
+		given       string
+		expected    error
+	}{
+		{
+			name:     "valid",
+			given:    "jdoe@mail.com",
+			expected: nil,
+		},
+		{
+			name:     "international domain",
+			given:    "jdoe@bücher.example",
+			expected: nil,
+		},
+		{
+			name:     "invalid domain",
+			given:    "jdoe@-mail-.com",
+			expected: errEmailFormat,
+		},
+		{
+			name:     "disposable domain without blocklist",
+			given:    "jdoe@mailinator.com",
+			expected: nil,
+		},
+		{
+			name:        "disposable domain",
+			givenPolicy: EmailPolicy{Blocked: DisposableDomains()},
+			given:       "jdoe@Mailinator.com",
+			expected:    errEmailBlocked,
+		},
+		{
+			name:        "disposable subdomain",
+			givenPolicy: EmailPolicy{Blocked: DisposableDomains()},
+			given:       "jdoe@eu.mailinator.com",
+			expected:    errEmailBlocked,
+		},
+		{
+			name:        "domain ending like a disposable domain",
+			givenPolicy: EmailPolicy{Blocked: DisposableDomains()},
+			given:       "jdoe@notmailinator.com",
+			expected:    nil,
+		},
+		{
+			name:        "empty",
+			givenPolicy: EmailPolicy{Blocked: DisposableDomains()},
+			given:       "",
+			expected:    errEmailRequired,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.givenPolicy.Validate(tc.given))
+		})
+	}
+}
+
+func TestEmailPolicy_Canonical(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		givenPolicy EmailPolicy
+		given       string
+		expected    string
+	}{
+		{
+			name:     "lower cases the address",
+			given:    "John.Doe@Mail.com",
+			expected: "john.doe@mail.com",
+		},
+		{
+			name:     "keeps provider aliases without provider rules",
+			given:    "John.Doe+news@gmail.com",
+			expected: "john.doe+news@gmail.com",
+		},
+		{
+			name:        "gmail ignores dots and tags",
+			givenPolicy: EmailPolicy{ProviderRules: true},
+			given:       "John.Doe+news@GoogleMail.com",
+			expected:    "johndoe@gmail.com",
+		},
+		{
+			name:        "outlook ignores tags only",
+			givenPolicy: EmailPolicy{ProviderRules: true},
+			given:       "john.doe+news@outlook.com",
+			expected:    "john.doe@outlook.com",
+		},
+		{
+			name:        "other providers are left untouched",
+			givenPolicy: EmailPolicy{ProviderRules: true},
+			given:       "john.doe+news@mail.com",
+			expected:    "john.doe+news@mail.com",
+		},
+		{
+			name:     "invalid email",
+			given:    " Not An Email ",
+			expected: "not an email",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.givenPolicy.Canonical(tc.given))
+		})
+	}
+}
+
+func TestReadDomainList(t *testing.T) {
+	t.Parallel()
+
+	actual, err := ReadDomainList(strings.NewReader("# comment\n\nexample.com\nBÜCHER.example\n"))
+	require.NoError(t, err)
+
+	assert.True(t, actual.Contains("example.com"))
+	assert.True(t, actual.Contains("mail.example.com"))
+	assert.True(t, actual.Contains("bücher.example"))
+	assert.True(t, actual.Contains("xn--bcher-kva.example"))
+	assert.False(t, actual.Contains("example.org"))
+	assert.False(t, actual.Contains("com"))
+}
@@ -1,20 +1,61 @@
 package validate
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// List error messages
 
 	errBirthdateFormat   = errors.New("birthdate must be in the format YYYY-MM-DD")
+	errBirthdateFuture   = errors.New("birthdate must not be in the future")
 	errBirthdateRequired = errors.New("birthdate is required")
+	errBirthdateTooOld   = errors.New("birthdate is too far in the past")
+	errBirthdateTooYoung = errors.New("you do not meet the minimum age requirement")
+	errEmailBlocked      = errors.New("email addresses from this domain are not allowed")
 	errEmailFormat       = errors.New("email is invalid")
 	errEmailRequired     = errors.New("email is required")
 	errFullnameFormat    = errors.New("fullname must only contain letters and spaces")
-	errFullnameLength    = errors.New("fullname must be between 3 and 64 characters")
+	errFullnameLength    = errors.New("fullname must be between %d and %d characters")
 	errFullnameRequired  = errors.New("fullname is required")
 	errIDRequired        = errors.New("id is required")
 	errIDFormat          = errors.New("id is invalid")
-	errPasswordFormat    = errors.New("password must contain at least one number, one letter and one special character")
-	errPasswordLength    = errors.New("password must be between 8 and 64 characters")
+	errPasswordBreached  = errors.New("password has appeared in a data breach, please choose another one")
+	errPasswordCommon    = errors.New("password is too common")
+	errPasswordContext   = errors.New("password must not contain your name, username or email")
+	errPasswordDigit     = errors.New("password must contain at least one number")
+	errPasswordLetter    = errors.New("password must contain at least one letter")
+	errPasswordLower     = errors.New("password must contain at least one lowercase letter")
+	errPasswordSymbol    = errors.New("password must contain at least one special character")
+	errPasswordTooLong   = errors.New("password is too long")
+	errPasswordTooShort  = errors.New("password is too short")
+	errPasswordUpper     = errors.New("password must contain at least one uppercase letter")
+	errPasswordWeak      = errors.New("password is too easy to guess")
+	errPasswordMismatch  = errors.New("password confirmation does not match")
 	errPasswordRequired  = errors.New("password is required")
+	errUsernameFormat    = errors.New("username may only contain letters, numbers, dots, underscores and hyphens, and must start and end with a letter or number")
+	errUsernameLength    = errors.New("username must be between %d and %d characters")
+	errUsernameRequired  = errors.New("username is required")
+	errUsernameReserved  = errors.New("username is not available")
 )
+
+// lengthError reports a value outside the length limits of its policy. Its message, and
+// its translations, are formatted with the limits, since policies can change them.
+type lengthError struct {
+	msg      error
+	min, max int
+}
+
+func (e lengthError) Error() string {
+	return e.format(e.msg.Error())
+}
+
+// Is reports whether target is the error whose message e formats
+func (e lengthError) Is(target error) bool {
+	return target == e.msg
+}
+
+func (e lengthError) format(msg string) string {
+	return fmt.Sprintf(msg, e.min, e.max)
+}
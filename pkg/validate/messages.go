@@ -0,0 +1,98 @@
+package validate
+
+import (
+	"errors"
+
+	"github.com/alesr/stdservices/pkg/locale"
+	"golang.org/x/text/language"
+)
+
+// catalog holds the translations of validation errors by base language.
+// English messages are the error strings themselves.
+var catalog = map[language.Tag]map[error]string{
+	language.Portuguese: {
+		errBirthdateFormat:   "a data de nascimento deve estar no formato AAAA-MM-DD",
+		errBirthdateFuture:   "a data de nascimento não pode estar no futuro",
+		errBirthdateRequired: "a data de nascimento é obrigatória",
+		errBirthdateTooOld:   "a data de nascimento está muito distante no passado",
+		errBirthdateTooYoung: "você não atende à idade mínima exigida",
+		errEmailBlocked:      "endereços de email deste domínio não são permitidos",
+		errEmailFormat:       "o email é inválido",
+		errEmailRequired:     "o email é obrigatório",
+		errFullnameFormat:    "o nome completo deve conter apenas letras e espaços",
+		errFullnameLength:    "o nome completo deve ter entre %d e %d caracteres",
+		errFullnameRequired:  "o nome completo é obrigatório",
+		errIDRequired:        "o id é obrigatório",
+		errIDFormat:          "o id é inválido",
+		errPasswordBreached:  "a senha apareceu em um vazamento de dados, escolha outra",
+		errPasswordCommon:    "a senha é muito comum",
+		errPasswordContext:   "a senha não deve conter seu nome, nome de usuário ou email",
+		errPasswordDigit:     "a senha deve conter pelo menos um número",
+		errPasswordLetter:    "a senha deve conter pelo menos uma letra",
+		errPasswordLower:     "a senha deve conter pelo menos uma letra minúscula",
+		errPasswordSymbol:    "a senha deve conter pelo menos um caractere especial",
+		errPasswordTooLong:   "a senha é muito longa",
+		errPasswordTooShort:  "a senha é muito curta",
+		errPasswordUpper:     "a senha deve conter pelo menos uma letra maiúscula",
+		errPasswordWeak:      "a senha é muito fácil de adivinhar",
+		errPasswordMismatch:  "a confirmação da senha não corresponde",
+		errPasswordRequired:  "a senha é obrigatória",
+		errUsernameFormat:    "o nome de usuário só pode conter letras, números, pontos, sublinhados e hífens, e deve começar e terminar com uma letra ou número",
+		errUsernameLength:    "o nome de usuário deve ter entre %d e %d caracteres",
+		errUsernameRequired:  "o nome de usuário é obrigatório",
+		errUsernameReserved:  "o nome de usuário não está disponível",
+	},
+	language.German: {
+		errBirthdateFormat:   "das Geburtsdatum muss das Format JJJJ-MM-TT haben",
+		errBirthdateFuture:   "das Geburtsdatum darf nicht in der Zukunft liegen",
+		errBirthdateRequired: "das Geburtsdatum ist erforderlich",
+		errBirthdateTooOld:   "das Geburtsdatum liegt zu weit in der Vergangenheit",
+		errBirthdateTooYoung: "du erfüllst das Mindestalter nicht",
+		errEmailBlocked:      "E-Mail-Adressen dieser Domain sind nicht erlaubt",
+		errEmailFormat:       "die E-Mail-Adresse ist ungültig",
+		errEmailRequired:     "die E-Mail-Adresse ist erforderlich",
+		errFullnameFormat:    "der vollständige Name darf nur Buchstaben und Leerzeichen enthalten",
+		errFullnameLength:    "der vollständige Name muss zwischen %d und %d Zeichen lang sein",
+		errFullnameRequired:  "der vollständige Name ist erforderlich",
+		errIDRequired:        "die ID ist erforderlich",
+		errIDFormat:          "die ID ist ungültig",
+		errPasswordBreached:  "das Passwort ist in einem Datenleck aufgetaucht, bitte wähle ein anderes",
+		errPasswordCommon:    "das Passwort ist zu häufig",
+		errPasswordContext:   "das Passwort darf weder deinen Namen, Benutzernamen noch deine E-Mail-Adresse enthalten",
+		errPasswordDigit:     "das Passwort muss mindestens eine Zahl enthalten",
+		errPasswordLetter:    "das Passwort muss mindestens einen Buchstaben enthalten",
+		errPasswordLower:     "das Passwort muss mindestens einen Kleinbuchstaben enthalten",
+		errPasswordSymbol:    "das Passwort muss mindestens ein Sonderzeichen enthalten",
+		errPasswordTooLong:   "das Passwort ist zu lang",
+		errPasswordTooShort:  "das Passwort ist zu kurz",
+		errPasswordUpper:     "das Passwort muss mindestens einen Großbuchstaben enthalten",
+		errPasswordWeak:      "das Passwort ist zu leicht zu erraten",
+		errPasswordMismatch:  "die Passwortbestätigung stimmt nicht überein",
+		errPasswordRequired:  "das Passwort ist erforderlich",
+		errUsernameFormat:    "der Benutzername darf nur Buchstaben, Zahlen, Punkte, Unterstriche und Bindestriche enthalten und muss mit einem Buchstaben oder einer Zahl beginnen und enden",
+		errUsernameLength:    "der Benutzername muss zwischen %d und %d Zeichen lang sein",
+		errUsernameRequired:  "der Benutzername ist erforderlich",
+		errUsernameReserved:  "der Benutzername ist nicht verfügbar",
+	},
+}
+
+// Translate returns the message of a validation error in the given language.
+// Errors without a translation are returned in English.
+func Translate(err error, tag language.Tag) string {
+	var le lengthError
+	if errors.As(err, &le) {
+		if msgs, ok := catalog[locale.Base(tag)]; ok {
+			if msg, ok := msgs[le.msg]; ok {
+				return le.format(msg)
+			}
+		}
+		return le.Error()
+	}
+
+	if msgs, ok := catalog[locale.Base(tag)]; ok {
+		if msg, ok := msgs[err]; ok {
+			return msg
+		}
+	}
+	return err.Error()
+}
@@ -0,0 +1,88 @@
+package validate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/language"
+)
+
+func TestTranslate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		givenErr error
+		givenTag language.Tag
+		expected string
+	}{
+		{
+			name:     "english",
+			givenErr: errEmailFormat,
+			givenTag: language.English,
+			expected: "email is invalid",
+		},
+		{
+			name:     "portuguese",
+			givenErr: errEmailFormat,
+			givenTag: language.Portuguese,
+			expected: "o email é inválido",
+		},
+		{
+			name:     "regional german",
+			givenErr: errPasswordMismatch,
+			givenTag: language.MustParse("de-AT"),
+			expected: "die Passwortbestätigung stimmt nicht überein",
+		},
+		{
+			name:     "default username limits",
+			givenErr: Username("jd"),
+			givenTag: language.English,
+			expected: "username must be between 3 and 32 characters",
+		},
+		{
+			name:     "username policy limits",
+			givenErr: UsernamePolicy{MinLength: 5, MaxLength: 8}.Validate("jdoe"),
+			givenTag: language.Portuguese,
+			expected: "o nome de usuário deve ter entre 5 e 8 caracteres",
+		},
+		{
+			name:     "fullname limits",
+			givenErr: Fullname("Jo"),
+			givenTag: language.German,
+			expected: "der vollständige Name muss zwischen 3 und 64 Zeichen lang sein",
+		},
+		{
+			name:     "unsupported language",
+			givenErr: errEmailFormat,
+			givenTag: language.Japanese,
+			expected: "email is invalid",
+		},
+		{
+			name:     "unknown error",
+			givenErr: errors.New("some error"),
+			givenTag: language.German,
+			expected: "some error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := Translate(tc.givenErr, tc.givenTag)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestCatalogCompleteness(t *testing.T) {
+	t.Parallel()
+
+	reference := catalog[language.Portuguese]
+	for tag, msgs := range catalog {
+		assert.Len(t, msgs, len(reference), "catalog %s is incomplete", tag)
+		for err := range reference {
+			assert.Contains(t, msgs, err, "catalog %s misses %q", tag, err)
+		}
+	}
+}
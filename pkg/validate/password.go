@@ -0,0 +1,212 @@
+package validate
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// Enumerate character classes
+
+	ClassLetter CharClass = 1 << iota
+	ClassLower
+	ClassUpper
+	ClassDigit
+	ClassSymbol
+
+	minPasswordLen = 8
+	maxPasswordLen = 64
+
+	// maxLoginPasswordBytes bounds the passwords checked at login whatever the policy, so that oversized inputs
+	// are rejected before hashing. bcrypt only reads the first 72 bytes, but longer passwords set under earlier
+	// policies, such as 64 multi-byte characters, must still be accepted.
+	maxLoginPasswordBytes = 1024
+
+	// minContextLen is the length from which user inputs are not allowed in passwords
+	minContextLen = 4
+)
+
+// CharClass is a set of character classes
+type CharClass int
+
+// DefaultPasswordPolicy is the policy applied by Password
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:       minPasswordLen,
+	MaxLength:       maxPasswordLen,
+	RequiredClasses: ClassLetter | ClassDigit | ClassSymbol,
+	RejectCommon:    true,
+}
+
+// PasswordPolicy defines which passwords are accepted.
+// Zero lengths default to 8 and 64 characters.
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+
+	// RequiredClasses lists the character classes every password must contain
+	RequiredClasses CharClass
+
+	// MinScore is the minimum strength score, from 0 (too guessable) to 4 (very unguessable),
+	// see EstimatePasswordStrength
+	MinScore int
+
+	// RejectCommon rejects passwords found in the list of common passwords or in Dictionary
+	RejectCommon bool
+
+	// Dictionary lists additional words, such as the application name,
+	// that are rejected as passwords and make passwords containing them weaker
+	Dictionary []string
+
+	// Breached rejects passwords that appeared in known data breaches. Breach lookups failing
+	// are ignored so that validation doesn't depend on the availability of the list.
+	Breached BreachChecker
+}
+
+// BreachChecker reports how many times a password appeared in known data breaches
+type BreachChecker interface {
+	Breached(password string) (int, error)
+}
+
+// Password validates a password with DefaultPasswordPolicy
+func Password(password string) error {
+	return DefaultPasswordPolicy.Validate(password)
+}
+
+// LoginPassword validates a password given to log in. Only its presence and a sanity bound on its length
+// are checked, so that passwords set under any previous policy, such as a lower minimum length, still work.
+func LoginPassword(password string) error {
+	if password == "" {
+		return errPasswordRequired
+	}
+
+	if len(password) > maxLoginPasswordBytes {
+		return errPasswordTooLong
+	}
+	return nil
+}
+
+// Validate validates a password against the policy. User inputs, such as the
+// username, email and full name, must not appear in the password.
+func (p PasswordPolicy) Validate(password string, userInputs ...string) error {
+	if password == "" {
+		return errPasswordRequired
+	}
+
+	minLen, maxLen := p.MinLength, p.MaxLength
+	if minLen == 0 {
+		minLen = minPasswordLen
+	}
+	if maxLen == 0 {
+		maxLen = maxPasswordLen
+	}
+
+	n := utf8.RuneCountInString(password)
+	if n < minLen {
+		return errPasswordTooShort
+	}
+	if n > maxLen {
+		return errPasswordTooLong
+	}
+
+	if err := p.validateClasses(password); err != nil {
+		return err
+	}
+
+	lower := strings.ToLower(password)
+	for _, token := range contextTokens(userInputs) {
+		if utf8.RuneCountInString(token) >= minContextLen && strings.Contains(lower, token) {
+			return errPasswordContext
+		}
+	}
+
+	if p.RejectCommon {
+		if _, ok := commonPasswords[lower]; ok {
+			return errPasswordCommon
+		}
+
+		for _, word := range p.Dictionary {
+			if strings.EqualFold(word, password) {
+				return errPasswordCommon
+			}
+		}
+	}
+
+	if p.Breached != nil {
+		if count, err := p.Breached.Breached(password); err == nil && count > 0 {
+			return errPasswordBreached
+		}
+	}
+
+	if p.MinScore > 0 {
+		dictionary := append(contextTokens(userInputs), p.Dictionary...)
+		if EstimatePasswordStrength(password, dictionary...).Score < p.MinScore {
+			return errPasswordWeak
+		}
+	}
+	return nil
+}
+
+func (p PasswordPolicy) validateClasses(password string) error {
+	var found CharClass
+
+	for _, char := range password {
+		found |= classOf(char)
+	}
+
+	switch {
+	case p.RequiredClasses&ClassLetter != 0 && found&ClassLetter == 0:
+		return errPasswordLetter
+	case p.RequiredClasses&ClassLower != 0 && found&ClassLower == 0:
+		return errPasswordLower
+	case p.RequiredClasses&ClassUpper != 0 && found&ClassUpper == 0:
+		return errPasswordUpper
+	case p.RequiredClasses&ClassDigit != 0 && found&ClassDigit == 0:
+		return errPasswordDigit
+	case p.RequiredClasses&ClassSymbol != 0 && found&ClassSymbol == 0:
+		return errPasswordSymbol
+	}
+	return nil
+}
+
+func classOf(r rune) CharClass {
+	switch {
+	case unicode.IsLower(r):
+		return ClassLetter | ClassLower
+	case unicode.IsUpper(r):
+		return ClassLetter | ClassUpper
+	case unicode.IsLetter(r):
+		return ClassLetter
+	case unicode.IsNumber(r):
+		return ClassDigit
+	default:
+		return ClassSymbol
+	}
+}
+
+// contextTokens splits user inputs into lower case words, so that both "John Doe" and its
+// words are matched. Only the local part of email addresses is used.
+func contextTokens(userInputs []string) []string {
+	var tokens []string
+
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if local, _, ok := strings.Cut(input, "@"); ok {
+			input = local
+		}
+
+		if input == "" {
+			continue
+		}
+
+		tokens = append(tokens, input)
+
+		words := strings.FieldsFunc(input, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		if len(words) > 1 {
+			tokens = append(tokens, words...)
+		}
+	}
+	return tokens
+}
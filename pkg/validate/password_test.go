@@ -0,0 +1,190 @@
+package validate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type breachCheckerMock struct {
+	breachedFunc func(password string) (int, error)
+}
+
+func (m *breachCheckerMock) Breached(password string) (int, error) {
+	if m.breachedFunc == nil {
+		return 0, errors.New("breachCheckerMock.breachedFunc is nil")
+	}
+	return m.breachedFunc(password)
+}
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	t.Parallel()
+
+	breached := breachCheckerMock{
+		breachedFunc: func(password string) (int, error) {
+			if password == "Summer2022!" {
+				return 42, nil
+			}
+			return 0, nil
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		givenPolicy     PasswordPolicy
+		givenPassword   string
+		givenUserInputs []string
+		expected        error
+	}{
+		{
+			name:          "zero policy only checks length",
+			givenPolicy:   PasswordPolicy{},
+			givenPassword: "abcdefgh",
+			expected:      nil,
+		},
+		{
+			name:          "custom length",
+			givenPolicy:   PasswordPolicy{MinLength: 12},
+			givenPassword: "abcdefghijk",
+			expected:      errPasswordTooShort,
+		},
+		{
+			name:          "length counts characters",
+			givenPolicy:   PasswordPolicy{MaxLength: 8},
+			givenPassword: "äöüäöüäö",
+			expected:      nil,
+		},
+		{
+			name:          "missing uppercase",
+			givenPolicy:   PasswordPolicy{RequiredClasses: ClassLower | ClassUpper},
+			givenPassword: "abcdefgh",
+			expected:      errPasswordUpper,
+		},
+		{
+			name:          "missing lowercase",
+			givenPolicy:   PasswordPolicy{RequiredClasses: ClassLower | ClassUpper},
+			givenPassword: "ABCDEFGH",
+			expected:      errPasswordLower,
+		},
+		{
+			name:            "contains username",
+			givenPolicy:     PasswordPolicy{},
+			givenPassword:   "JDoe2000!",
+			givenUserInputs: []string{"jdoe", "john@mail.com", "John Doe"},
+			expected:        errPasswordContext,
+		},
+		{
+			name:            "contains email local part",
+			givenPolicy:     PasswordPolicy{},
+			givenPassword:   "johnny-b-goode",
+			givenUserInputs: []string{"jdoe", "johnny@mail.com"},
+			expected:        errPasswordContext,
+		},
+		{
+			name:            "contains a word of the full name",
+			givenPolicy:     PasswordPolicy{},
+			givenPassword:   "ilovemaria!",
+			givenUserInputs: []string{"Maria Silva"},
+			expected:        errPasswordContext,
+		},
+		{
+			name:            "short user inputs are ignored",
+			givenPolicy:     PasswordPolicy{},
+			givenPassword:   "joe-is-here",
+			givenUserInputs: []string{"Joe"},
+			expected:        nil,
+		},
+		{
+			name:          "common password",
+			givenPolicy:   PasswordPolicy{RejectCommon: true},
+			givenPassword: "Baseball",
+			expected:      errPasswordCommon,
+		},
+		{
+			name:          "dictionary word",
+			givenPolicy:   PasswordPolicy{RejectCommon: true, Dictionary: []string{"stdservices"}},
+			givenPassword: "StdServices",
+			expected:      errPasswordCommon,
+		},
+		{
+			name:          "breached",
+			givenPolicy:   PasswordPolicy{Breached: &breached},
+			givenPassword: "Summer2022!",
+			expected:      errPasswordBreached,
+		},
+		{
+			name: "breach lookup failure is ignored",
+			givenPolicy: PasswordPolicy{Breached: &breachCheckerMock{
+				breachedFunc: func(password string) (int, error) { return 0, errors.New("some error") },
+			}},
+			givenPassword: "Summer2022!",
+			expected:      nil,
+		},
+		{
+			name:          "weak",
+			givenPolicy:   PasswordPolicy{MinScore: 3},
+			givenPassword: "password#123",
+			expected:      errPasswordWeak,
+		},
+		{
+			name:            "weak with user input",
+			givenPolicy:     PasswordPolicy{MinScore: 2},
+			givenPassword:   "Sil-123456",
+			givenUserInputs: []string{"sil"},
+			expected:        errPasswordWeak,
+		},
+		{
+			name:          "strong",
+			givenPolicy:   PasswordPolicy{MinScore: 3},
+			givenPassword: "x7#Kp2$vLq9!",
+			expected:      nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := tc.givenPolicy.Validate(tc.givenPassword, tc.givenUserInputs...)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestLoginPassword(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenPassword string
+		expectedError error
+	}{
+		{
+			name:          "shorter than the policy",
+			givenPassword: "abc",
+		},
+		{
+			name:          "64 multi-byte characters",
+			givenPassword: strings.Repeat("密", 64),
+		},
+		{
+			name:          "empty",
+			givenPassword: "",
+			expectedError: errPasswordRequired,
+		},
+		{
+			name:          "too long",
+			givenPassword: strings.Repeat("a", maxLoginPasswordBytes+1),
+			expectedError: errPasswordTooLong,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expectedError, LoginPassword(tc.givenPassword))
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package validate
+
+import (
+	_ "embed"
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	// minMatchLen is the minimum length of dictionary, repeat and sequence matches
+	minMatchLen = 3
+
+	// Cardinality of the character classes used to estimate brute force guesses
+	digitCardinality  = 10
+	letterCardinality = 26
+	symbolCardinality = 33
+	otherCardinality  = 100
+)
+
+var (
+	//go:embed common_passwords.txt
+	commonPasswordsFile string
+
+	// commonPasswords maps common passwords to their rank, starting at 1 for the most common
+	commonPasswords = parseRankedList(commonPasswordsFile)
+
+	// scoreThresholds are the base 10 logarithms of the guesses needed for scores 1 to 4, as in zxcvbn
+	scoreThresholds = []float64{3, 6, 8, 10}
+
+	// leetVariants undo common character substitutions, once reading "1" as "i" and once as "l"
+	leetVariants = []*strings.Replacer{
+		strings.NewReplacer("4", "a", "@", "a", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t", "+", "t"),
+		strings.NewReplacer("4", "a", "@", "a", "3", "e", "1", "l", "|", "l", "0", "o", "$", "s", "5", "s", "7", "t", "+", "t"),
+	}
+)
+
+// PasswordStrength is an estimation of how hard a password is to guess
+type PasswordStrength struct {
+	// GuessesLog10 is the base 10 logarithm of the estimated number of guesses needed to find the password
+	GuessesLog10 float64
+
+	// Score ranges from 0 (too guessable) to 4 (very unguessable)
+	Score int
+}
+
+// EstimatePasswordStrength estimates the number of guesses an attacker needs to find a password,
+// in the spirit of zxcvbn. The password is split into the cheapest sequence of common passwords
+// and dictionary words (including reversed and leet spelled ones), repeated characters, sequences
+// such as "abc" or "321", and brute forced characters.
+func EstimatePasswordStrength(password string, dictionary ...string) PasswordStrength {
+	original := []rune(password)
+	lower := []rune(strings.ToLower(password))
+
+	words := make(map[string]int, len(dictionary))
+	for _, word := range dictionary {
+		if word != "" {
+			words[strings.ToLower(word)] = 1
+		}
+	}
+
+	// best[i] is the minimum log10 guesses for the first i characters
+	best := make([]float64, len(lower)+1)
+
+	for i := 1; i <= len(lower); i++ {
+		best[i] = best[i-1] + math.Log10(cardinality(original[i-1]))
+
+		for j := i - minMatchLen; j >= 0; j-- {
+			guesses := matchGuesses(original[j:i], lower[j:i], words)
+			if guesses > 0 && best[j]+math.Log10(guesses) < best[i] {
+				best[i] = best[j] + math.Log10(guesses)
+			}
+		}
+	}
+
+	strength := PasswordStrength{GuessesLog10: best[len(lower)]}
+	for _, threshold := range scoreThresholds {
+		if strength.GuessesLog10 >= threshold {
+			strength.Score++
+		}
+	}
+	return strength
+}
+
+// matchGuesses returns the guesses needed for a segment matched as a dictionary word,
+// a repeat or a sequence, or 0 if the segment matches none of them
+func matchGuesses(original, lower []rune, words map[string]int) float64 {
+	var guesses float64
+
+	consider := func(g float64) {
+		if g > 0 && (guesses == 0 || g < guesses) {
+			guesses = g
+		}
+	}
+
+	segment := string(lower)
+
+	if rank := wordRank(segment, words); rank > 0 {
+		consider(float64(rank) * uppercaseVariations(original))
+	}
+
+	if rank := wordRank(reverse(segment), words); rank > 0 {
+		consider(float64(rank) * uppercaseVariations(original) * 2)
+	}
+
+	for _, r := range leetVariants {
+		if unleet := r.Replace(segment); unleet != segment {
+			if rank := wordRank(unleet, words); rank > 0 {
+				consider(float64(rank) * uppercaseVariations(original) * 2)
+			}
+		}
+	}
+
+	if isRepeat(lower) {
+		consider(cardinality(original[0]) * float64(len(lower)))
+	}
+
+	if isSequence(lower) {
+		base := cardinality(lower[0])
+		// Sequences starting from the obvious characters are tried first
+		switch lower[0] {
+		case 'a', 'z', '0', '1', '9':
+			base = 4
+		}
+		consider(base * float64(len(lower)))
+	}
+	return guesses
+}
+
+func wordRank(word string, words map[string]int) int {
+	if rank, ok := words[word]; ok {
+		return rank
+	}
+	return commonPasswords[word]
+}
+
+// uppercaseVariations is the factor applied to dictionary words for their capitalization.
+// Capitalizing the first or last letter, or every letter, is cheap to guess.
+func uppercaseVariations(word []rune) float64 {
+	var upper int
+	for _, r := range word {
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+
+	switch {
+	case upper == 0:
+		return 1
+	case upper == len(word), upper == 1 && (unicode.IsUpper(word[0]) || unicode.IsUpper(word[len(word)-1])):
+		return 2
+	default:
+		return math.Pow(2, float64(upper))
+	}
+}
+
+func isRepeat(s []rune) bool {
+	for _, r := range s[1:] {
+		if r != s[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func isSequence(s []rune) bool {
+	delta := s[1] - s[0]
+	if delta != 1 && delta != -1 {
+		return false
+	}
+
+	for i := 2; i < len(s); i++ {
+		if s[i]-s[i-1] != delta {
+			return false
+		}
+	}
+	return true
+}
+
+func cardinality(r rune) float64 {
+	switch {
+	case r >= '0' && r <= '9':
+		return digitCardinality
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		return letterCardinality
+	case r < unicode.MaxASCII:
+		return symbolCardinality
+	default:
+		return otherCardinality
+	}
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+func parseRankedList(list string) map[string]int {
+	ranks := map[string]int{}
+	for _, line := range strings.Split(list, "\n") {
+		if word := strings.TrimSpace(line); word != "" {
+			if _, ok := ranks[word]; !ok {
+				ranks[word] = len(ranks) + 1
+			}
+		}
+	}
+	return ranks
+}
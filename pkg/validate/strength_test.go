@@ -0,0 +1,74 @@
+package validate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimatePasswordStrength(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		givenPassword   string
+		givenDictionary []string
+		expectedScore   int
+	}{
+		{
+			name:          "common password",
+			givenPassword: "password",
+			expectedScore: 0,
+		},
+		{
+			name:          "capitalized leet common password",
+			givenPassword: "P@ssw0rd",
+			expectedScore: 0,
+		},
+		{
+			name:          "reversed common password",
+			givenPassword: "drowssap",
+			expectedScore: 0,
+		},
+		{
+			name:          "common password with a sequence",
+			givenPassword: "password#123",
+			expectedScore: 0,
+		},
+		{
+			name:          "repeat",
+			givenPassword: "aaaaaaaaaaaa",
+			expectedScore: 0,
+		},
+		{
+			name:            "dictionary word",
+			givenPassword:   "stdservices!",
+			givenDictionary: []string{"stdservices"},
+			expectedScore:   0,
+		},
+		{
+			name:          "dictionary word unknown without dictionary",
+			givenPassword: "stdservices!",
+			expectedScore: 4,
+		},
+		{
+			name:          "random",
+			givenPassword: "x7#Kp2$vLq9!",
+			expectedScore: 4,
+		},
+		{
+			name:          "long passphrase",
+			givenPassword: "correcthorsebatterystaple",
+			expectedScore: 4,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := EstimatePasswordStrength(tc.givenPassword, tc.givenDictionary...)
+			assert.Equal(t, tc.expectedScore, actual.Score)
+		})
+	}
+
+	assert.Less(t, EstimatePasswordStrength("jdoe2000").GuessesLog10, EstimatePasswordStrength("jdoe2000!x").GuessesLog10)
+}
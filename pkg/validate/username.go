@@ -0,0 +1,118 @@
+package validate
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mtibben/confusables"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	minUsernameLen = 3
+	maxUsernameLen = 32
+)
+
+// DefaultReservedUsernames lists the names reserved by the zero UsernamePolicy
+var DefaultReservedUsernames = []string{
+	"abuse", "admin", "administrator", "api", "help", "hostmaster", "login", "logout", "me", "moderator",
+	"noreply", "null", "official", "postmaster", "register", "root", "security", "settings", "signup",
+	"staff", "support", "system", "undefined", "webmaster", "www",
+}
+
+// UsernamePolicy defines which usernames are accepted.
+// The zero value accepts 3 to 32 letters, digits, dots, underscores and hyphens,
+// starting and ending with a letter or digit, and rejects DefaultReservedUsernames.
+type UsernamePolicy struct {
+	// MinLength and MaxLength bound the number of characters after normalization
+	MinLength int
+	MaxLength int
+
+	// AllowedRune reports whether a character may appear in a username
+	AllowedRune func(r rune) bool
+
+	// Reserved lists names that cannot be registered, nor any name confusable with them.
+	// Nil means DefaultReservedUsernames; use an empty slice to reserve nothing.
+	Reserved []string
+}
+
+// Username validates a username with the zero UsernamePolicy
+func Username(username string) error {
+	return UsernamePolicy{}.Validate(username)
+}
+
+// Validate validates a username against the policy
+func (p UsernamePolicy) Validate(username string) error {
+	if username == "" {
+		return errUsernameRequired
+	}
+
+	minLen, maxLen := p.MinLength, p.MaxLength
+	if minLen == 0 {
+		minLen = minUsernameLen
+	}
+	if maxLen == 0 {
+		maxLen = maxUsernameLen
+	}
+
+	normalized := NormalizeUsername(username)
+
+	if n := utf8.RuneCountInString(normalized); n < minLen || n > maxLen {
+		return lengthError{msg: errUsernameLength, min: minLen, max: maxLen}
+	}
+
+	allowed := p.AllowedRune
+	if allowed == nil {
+		allowed = defaultUsernameRune
+	}
+
+	for _, char := range normalized {
+		if !allowed(char) {
+			return errUsernameFormat
+		}
+	}
+
+	first, _ := utf8.DecodeRuneInString(normalized)
+	last, _ := utf8.DecodeLastRuneInString(normalized)
+	if !isLetterOrDigit(first) || !isLetterOrDigit(last) {
+		return errUsernameFormat
+	}
+
+	reserved := p.Reserved
+	if reserved == nil {
+		reserved = DefaultReservedUsernames
+	}
+
+	skeleton := UsernameSkeleton(normalized)
+	for _, name := range reserved {
+		if skeleton == UsernameSkeleton(name) {
+			return errUsernameReserved
+		}
+	}
+	return nil
+}
+
+// NormalizeUsername returns the NFKC normalized form of a username, which is how it should be stored and displayed.
+// Compatibility characters such as fullwidth letters are folded into their canonical form.
+func NormalizeUsername(username string) string {
+	return norm.NFKC.String(username)
+}
+
+// UsernameSkeleton returns the case folded confusable skeleton of a username (UTS #39).
+// Usernames that look alike, such as "admin" and "аdmin" with a Cyrillic a, share the same skeleton,
+// which should be stored in a unique column to prevent impersonation.
+func UsernameSkeleton(username string) string {
+	fold := cases.Fold()
+
+	// Fold again after mapping since some prototypes are upper case, such as "O" for "0"
+	return fold.String(confusables.Skeleton(fold.String(NormalizeUsername(username))))
+}
+
+func defaultUsernameRune(r rune) bool {
+	return isLetterOrDigit(r) || r == '.' || r == '_' || r == '-'
+}
+
+func isLetterOrDigit(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,200 @@
+package validate
+
+import (
+	"testing"
+	"unicode"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsername(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		given    string
+		expected error
+	}{
+		{
+			name:     "valid",
+			given:    "john.doe_92",
+			expected: nil,
+		},
+		{
+			name:     "non latin letters",
+			given:    "josé-müller",
+			expected: nil,
+		},
+		{
+			name:     "empty",
+			given:    "",
+			expected: errUsernameRequired,
+		},
+		{
+			name:     "too short",
+			given:    "jd",
+			expected: lengthError{msg: errUsernameLength, min: 3, max: 32},
+		},
+		{
+			name:     "too long",
+			given:    "abcdefghijklmnopqrstuvwxyz0123456",
+			expected: lengthError{msg: errUsernameLength, min: 3, max: 32},
+		},
+		{
+			name:     "space",
+			given:    "john doe",
+			expected: errUsernameFormat,
+		},
+		{
+			name:     "leading separator",
+			given:    "_johndoe",
+			expected: errUsernameFormat,
+		},
+		{
+			name:     "trailing separator",
+			given:    "johndoe.",
+			expected: errUsernameFormat,
+		},
+		{
+			name:     "reserved",
+			given:    "Admin",
+			expected: errUsernameReserved,
+		},
+		{
+			name:     "reserved with cyrillic homoglyph",
+			given:    "аdmin",
+			expected: errUsernameReserved,
+		},
+		{
+			name:     "reserved with fullwidth letters",
+			given:    "ｒｏｏｔ",
+			expected: errUsernameReserved,
+		},
+		{
+			name:     "reserved with digit homoglyph",
+			given:    "r00t",
+			expected: errUsernameReserved,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := Username(tc.given)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestUsernamePolicy_Validate(t *testing.T) {
+	t.Parallel()
+
+	policy := UsernamePolicy{
+		MinLength:   5,
+		MaxLength:   8,
+		AllowedRune: func(r rune) bool { return r < unicode.MaxASCII && (unicode.IsLower(r) || unicode.IsDigit(r)) },
+		Reserved:    []string{"acme1"},
+	}
+
+	testCases := []struct {
+		name     string
+		given    string
+		expected error
+	}{
+		{
+			name:     "valid",
+			given:    "jdoe92",
+			expected: nil,
+		},
+		{
+			name:     "default reserved names are replaced",
+			given:    "admin",
+			expected: nil,
+		},
+		{
+			name:     "too short",
+			given:    "jdoe",
+			expected: lengthError{msg: errUsernameLength, min: 5, max: 8},
+		},
+		{
+			name:     "too long",
+			given:    "johndoe92",
+			expected: lengthError{msg: errUsernameLength, min: 5, max: 8},
+		},
+		{
+			name:     "not allowed characters",
+			given:    "jöhndoe",
+			expected: errUsernameFormat,
+		},
+		{
+			name:     "reserved",
+			given:    "acme1",
+			expected: errUsernameReserved,
+		},
+		{
+			name:     "confusable with reserved",
+			given:    "acmel",
+			expected: errUsernameReserved,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := policy.Validate(tc.given)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+
+	assert.Equal(t, errUsernameReserved, UsernamePolicy{MinLength: 4, Reserved: []string{"acme"}}.Validate("ACME"))
+	assert.NoError(t, UsernamePolicy{Reserved: []string{}}.Validate("admin"))
+}
+
+func TestUsernameSkeleton(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		givenA    string
+		givenB    string
+		confusing bool
+	}{
+		{
+			name:      "case",
+			givenA:    "JohnDoe",
+			givenB:    "johndoe",
+			confusing: true,
+		},
+		{
+			name:      "cyrillic letters",
+			givenA:    "pаypаl",
+			givenB:    "paypal",
+			confusing: true,
+		},
+		{
+			name:      "fullwidth letters",
+			givenA:    "ｊｄｏｅ",
+			givenB:    "jdoe",
+			confusing: true,
+		},
+		{
+			name:      "digit zero",
+			givenA:    "g00gle",
+			givenB:    "google",
+			confusing: true,
+		},
+		{
+			name:      "different names",
+			givenA:    "jdoe",
+			givenB:    "jane",
+			confusing: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := UsernameSkeleton(tc.givenA) == UsernameSkeleton(tc.givenB)
+			assert.Equal(t, tc.confusing, actual)
+		})
+	}
+
+	assert.Equal(t, "jdoe", NormalizeUsername("ｊｄｏｅ"))
+}
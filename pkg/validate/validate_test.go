@@ -28,12 +28,12 @@ func TestFullname(t *testing.T) {
 		{
 			name:     "too short",
 			given:    "a",
-			expected: errFullnameLength,
+			expected: lengthError{msg: errFullnameLength, min: 3, max: 64},
 		},
 		{
 			name:     "too long",
 			given:    "mckrbdwenwfrbvkgivwqivchjvvijvuycprqdnddjqdnnfwiczwhrfxznnzxpnmjl",
-			expected: errFullnameLength,
+			expected: lengthError{msg: errFullnameLength, min: 3, max: 64},
 		},
 		{
 			name:     "invalid characters",
@@ -73,6 +73,16 @@ func TestBirthdate(t *testing.T) {
 			given:    "2019/01/01",
 			expected: errBirthdateFormat,
 		},
+		{
+			name:     "in the future",
+			given:    "9999-01-01",
+			expected: errBirthdateFuture,
+		},
+		{
+			name:     "too old",
+			given:    "1700-01-01",
+			expected: errBirthdateTooOld,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -137,42 +147,47 @@ func TestPassword(t *testing.T) {
 		{
 			name:     "too short",
 			given:    "a",
-			expected: errPasswordLength,
+			expected: errPasswordTooShort,
 		},
 		{
 			name:     "too long",
 			given:    "mckrbdwenwfrbvkgivwqivchjvvijvuycprqdnddjqdnnfwiczwhrfxznnzxpnmjl",
-			expected: errPasswordLength,
+			expected: errPasswordTooLong,
+		},
+		{
+			name:     "letters and numbers",
+			given:    "password1",
+			expected: errPasswordSymbol,
 		},
 		{
 			name:     "only letters",
 			given:    "abcdefghijklmnopqrstuvwxyz",
-			expected: errPasswordFormat,
+			expected: errPasswordDigit,
 		},
 		{
 			name:     "only numbers",
 			given:    "0123456789",
-			expected: errPasswordFormat,
+			expected: errPasswordLetter,
 		},
 		{
 			name:     "only special characters",
 			given:    "!@#$%^&*()_+-=",
-			expected: errPasswordFormat,
+			expected: errPasswordLetter,
 		},
 		{
 			name:     "only letters and numbers",
 			given:    "abcdefghijklmnopqrstuvwxyz0123456789",
-			expected: errPasswordFormat,
+			expected: errPasswordSymbol,
 		},
 		{
 			name:     "only letters and special characters",
 			given:    "abcdefghijklmnopqrstuvwxyz!@#$%^&*()_+-=",
-			expected: errPasswordFormat,
+			expected: errPasswordDigit,
 		},
 		{
 			name:     "only numbers and special characters",
 			given:    "0123456789!@#$%^&*()_+-=",
-			expected: errPasswordFormat,
+			expected: errPasswordLetter,
 		},
 	}
 
@@ -216,3 +231,10 @@ func TestID(t *testing.T) {
 		})
 	}
 }
+
+func TestPasswordConfirmation(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, PasswordConfirmation("password#123", "password#123"))
+	assert.Equal(t, errPasswordMismatch, PasswordConfirmation("password#123", "password#124"))
+}
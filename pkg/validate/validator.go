@@ -0,0 +1,92 @@
+package validate
+
+import "strings"
+
+// Rule validates a single value
+type Rule func(value string) error
+
+// FieldError is the validation error of a single field
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+// ValidationErrors collects the field errors of an input, in the order the fields were validated
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Field+": "+fe.Err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Get returns the error of a field or nil if the field is valid
+func (e ValidationErrors) Get(field string) error {
+	for _, fe := range e {
+		if fe.Field == field {
+			return fe.Err
+		}
+	}
+	return nil
+}
+
+// Validator applies rules to the fields of an input and collects every failure,
+// so all mistakes can be reported at once:
+//
+//	err := validate.New().
+//		Field("email", in.Email, validate.Email).
+//		Field("password", in.Password, validate.Password).
+//		Check("confirm_password", validate.PasswordConfirmation(in.Password, in.ConfirmPassword)).
+//		Err()
+type Validator struct {
+	errs ValidationErrors
+}
+
+// New creates an empty validator
+func New() *Validator {
+	return &Validator{}
+}
+
+// Field applies rules to a field value in order and records the first failure
+func (v *Validator) Field(field, value string, rules ...Rule) *Validator {
+	for _, rule := range rules {
+		if err := rule(value); err != nil {
+			return v.Check(field, err)
+		}
+	}
+	return v
+}
+
+// Check records the result of a check that doesn't fit a Rule, such as one comparing fields.
+// Only the first error of a field is kept.
+func (v *Validator) Check(field string, err error) *Validator {
+	if err != nil && v.errs.Get(field) == nil {
+		v.errs = append(v.errs, FieldError{Field: field, Err: err})
+	}
+	return v
+}
+
+// Errors returns the collected field errors
+func (v *Validator) Errors() ValidationErrors {
+	return append(ValidationErrors(nil), v.errs...)
+}
+
+// Err returns the collected ValidationErrors or nil if every field is valid
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.Errors()
+}
+
+// Optional skips the rule for empty values, for inputs where fields may be omitted such as partial updates
+func Optional(rule Rule) Rule {
+	return func(value string) error {
+		if value == "" {
+			return nil
+		}
+		return rule(value)
+	}
+}
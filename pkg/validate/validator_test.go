@@ -0,0 +1,94 @@
+package validate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidator(t *testing.T) {
+	t.Parallel()
+
+	errShort := errors.New("too short")
+
+	minLen := func(value string) error {
+		if len(value) < 3 {
+			return errShort
+		}
+		return nil
+	}
+
+	testCases := []struct {
+		name     string
+		given    *Validator
+		expected error
+	}{
+		{
+			name: "valid",
+			given: New().
+				Field("fullname", "John Doe", Fullname).
+				Field("email", "jdoe@mail.com", Email).
+				Check("confirm_password", PasswordConfirmation("a", "a")),
+			expected: nil,
+		},
+		{
+			name: "collects every field",
+			given: New().
+				Field("fullname", "", Fullname).
+				Field("email", "not an email", Email).
+				Field("birthdate", "1990-01-01", Birthdate).
+				Check("confirm_password", PasswordConfirmation("a", "b")),
+			expected: ValidationErrors{
+				{Field: "fullname", Err: errFullnameRequired},
+				{Field: "email", Err: errEmailFormat},
+				{Field: "confirm_password", Err: errPasswordMismatch},
+			},
+		},
+		{
+			name:     "stops at the first failing rule of a field",
+			given:    New().Field("username", "", minLen, Fullname),
+			expected: ValidationErrors{{Field: "username", Err: errShort}},
+		},
+		{
+			name: "keeps the first error of a field",
+			given: New().
+				Field("password", "", Password).
+				Check("password", errShort),
+			expected: ValidationErrors{{Field: "password", Err: errPasswordRequired}},
+		},
+		{
+			name:     "optional empty value",
+			given:    New().Field("email", "", Optional(Email)),
+			expected: nil,
+		},
+		{
+			name:     "optional invalid value",
+			given:    New().Field("email", "nope", Optional(Email)),
+			expected: ValidationErrors{{Field: "email", Err: errEmailFormat}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.given.Err())
+		})
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	t.Parallel()
+
+	err := New().
+		Field("fullname", "", Fullname).
+		Field("email", "nope", Email).
+		Err()
+
+	var errs ValidationErrors
+	require.True(t, errors.As(err, &errs))
+
+	assert.Equal(t, "fullname: fullname is required; email: email is invalid", errs.Error())
+	assert.Equal(t, errEmailFormat, errs.Get("email"))
+	assert.Nil(t, errs.Get("password"))
+}
@@ -0,0 +1,206 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/alesr/stdservices/pkg/validate"
+	"github.com/alesr/stdservices/users/repository"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 1000
+)
+
+var _ AdminService = (*DefaultService)(nil)
+
+// AdminService defines the operations reserved to operators, such as the usersctl CLI.
+// They must not be exposed to users: for instance, SetRole can grant the admin role.
+type AdminService interface {
+	// List returns the users matching the input, oldest first
+	List(ctx context.Context, in ListUsersInput) ([]User, error)
+
+	// SetRole sets the role of a user, RoleUser or RoleAdmin, and revokes the tokens
+	// issued with the previous role
+	SetRole(ctx context.Context, id, r string) error
+
+	// Restore restores a deleted user
+	Restore(ctx context.Context, id string) error
+
+	// ForceVerifyEmail marks the email of a user as verified without a verification email
+	ForceVerifyEmail(ctx context.Context, id string) error
+
+	// ResetPassword sets the password of a user, which must satisfy the password policy,
+	// and revokes the tokens issued before
+	ResetPassword(ctx context.Context, id, password string) error
+
+	// RevokeTokens revokes every token of a user issued so far
+	RevokeTokens(ctx context.Context, id string) error
+}
+
+// List returns the users matching the input, oldest first
+func (s *DefaultService) List(ctx context.Context, in ListUsersInput) (_ []User, err error) {
+	ctx, span := s.startSpan(ctx, "users.List")
+	defer func() { endSpan(span, err) }()
+
+	if in.Role != "" {
+		if _, err := parseRole(in.Role); err != nil {
+			return nil, err
+		}
+	}
+
+	limit := in.Limit
+	switch {
+	case limit <= 0:
+		limit = defaultListLimit
+	case limit > maxListLimit:
+		limit = maxListLimit
+	}
+
+	offset := in.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	storageUsers, err := s.repo.SelectUsers(ctx, repository.UserFilter{
+		Query:          in.Query,
+		Role:           in.Role,
+		IncludeDeleted: in.IncludeDeleted,
+		Limit:          limit,
+		Offset:         offset,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not select users: %w", err)
+	}
+
+	res := make([]User, 0, len(storageUsers))
+	for i := range storageUsers {
+		user, err := newUserFromRepository(&storageUsers[i])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse storage user to domain model: %w", err)
+		}
+		res = append(res, *user)
+	}
+	return res, nil
+}
+
+// SetRole sets the role of a user and revokes the tokens issued with the previous role
+func (s *DefaultService) SetRole(ctx context.Context, id, r string) (err error) {
+	ctx, span := s.startSpan(ctx, "users.SetRole", attrUserID.String(id))
+	defer func() { endSpan(span, err) }()
+	defer func() {
+		s.audit(ctx, AuditEntry{Action: AuditRoleChanged, SubjectID: id, Metadata: map[string]string{"role": r}}, err)
+	}()
+
+	if err := validate.ID(id); err != nil {
+		return fmt.Errorf("could not validate id: %w", newValidationE("id", err.Error()))
+	}
+
+	parsed, err := parseRole(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.UpdateRole(ctx, id, string(parsed), s.now()); err != nil {
+		return notFoundOr(fmt.Errorf("could not update role: %w", err))
+	}
+	return nil
+}
+
+// Restore restores a deleted user
+func (s *DefaultService) Restore(ctx context.Context, id string) (err error) {
+	ctx, span := s.startSpan(ctx, "users.Restore", attrUserID.String(id))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, AuditEntry{Action: AuditUserRestored, SubjectID: id}, err) }()
+
+	if err := validate.ID(id); err != nil {
+		return fmt.Errorf("could not validate id: %w", newValidationE("id", err.Error()))
+	}
+
+	if err := s.repo.RestoreByID(ctx, id, s.now()); err != nil {
+		return notFoundOr(fmt.Errorf("could not restore user: %w", err))
+	}
+	return nil
+}
+
+// ForceVerifyEmail marks the email of a user as verified without a verification email
+func (s *DefaultService) ForceVerifyEmail(ctx context.Context, id string) (err error) {
+	ctx, span := s.startSpan(ctx, "users.ForceVerifyEmail", attrUserID.String(id))
+	defer func() { endSpan(span, err) }()
+	defer func() {
+		s.audit(ctx, AuditEntry{Action: AuditEmailVerified, SubjectID: id, Metadata: map[string]string{"method": "admin"}}, err)
+	}()
+
+	if err := validate.ID(id); err != nil {
+		return fmt.Errorf("could not validate id: %w", newValidationE("id", err.Error()))
+	}
+
+	if err := s.repo.UpdateEmailVerified(ctx, id, s.now()); err != nil {
+		return notFoundOr(fmt.Errorf("could not update email verified: %w", err))
+	}
+
+	s.publish(ctx, EventEmailVerified, id)
+	return nil
+}
+
+// ResetPassword sets the password of a user and revokes the tokens issued before
+func (s *DefaultService) ResetPassword(ctx context.Context, id, password string) (err error) {
+	ctx, span := s.startSpan(ctx, "users.ResetPassword", attrUserID.String(id))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, AuditEntry{Action: AuditPasswordReset, SubjectID: id}, err) }()
+
+	if err := validate.ID(id); err != nil {
+		return fmt.Errorf("could not validate id: %w", newValidationE("id", err.Error()))
+	}
+
+	storageUser, err := s.repo.SelectByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("could not select user by id: %w", err)
+	}
+
+	if storageUser == nil {
+		return ErrNotFound
+	}
+
+	if err := s.policies.password.Validate(
+		password, storageUser.Username, storageUser.Email, storageUser.Fullname,
+	); err != nil {
+		return fmt.Errorf("could not validate password: %w", newValidationE("password", err.Error()))
+	}
+
+	hash, err := s.hashPassword(ctx, password)
+	if err != nil {
+		return fmt.Errorf("could not hash password: %w", err)
+	}
+
+	if err := s.repo.UpdatePasswordHash(ctx, id, hash, s.now()); err != nil {
+		return notFoundOr(fmt.Errorf("could not update password hash: %w", err))
+	}
+	return nil
+}
+
+// RevokeTokens revokes every token of a user issued so far
+func (s *DefaultService) RevokeTokens(ctx context.Context, id string) (err error) {
+	ctx, span := s.startSpan(ctx, "users.RevokeTokens", attrUserID.String(id))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, AuditEntry{Action: AuditTokensRevoked, SubjectID: id}, err) }()
+
+	if err := validate.ID(id); err != nil {
+		return fmt.Errorf("could not validate id: %w", newValidationE("id", err.Error()))
+	}
+
+	if err := s.repo.RevokeTokens(ctx, id, s.now()); err != nil {
+		return notFoundOr(fmt.Errorf("could not revoke tokens: %w", err))
+	}
+	return nil
+}
+
+// notFoundOr returns ErrNotFound if err wraps repository.ErrRecordNotFound, err otherwise
+func notFoundOr(err error) error {
+	if errors.Is(err, repository.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package users
+
+import (
+	"context"
+	"errors"
+)
+
+var _ AdminService = (*MockAdminService)(nil)
+
+type MockAdminService struct {
+	ListFunc             func(ctx context.Context, in ListUsersInput) ([]User, error)
+	SetRoleFunc          func(ctx context.Context, id, r string) error
+	RestoreFunc          func(ctx context.Context, id string) error
+	ForceVerifyEmailFunc func(ctx context.Context, id string) error
+	ResetPasswordFunc    func(ctx context.Context, id, password string) error
+	RevokeTokensFunc     func(ctx context.Context, id string) error
+}
+
+func (m *MockAdminService) List(ctx context.Context, in ListUsersInput) ([]User, error) {
+	if m.ListFunc == nil {
+		return nil, errors.New("MockAdminService.ListFunc is nil")
+	}
+	return m.ListFunc(ctx, in)
+}
+
+func (m *MockAdminService) SetRole(ctx context.Context, id, r string) error {
+	if m.SetRoleFunc == nil {
+		return errors.New("MockAdminService.SetRoleFunc is nil")
+	}
+	return m.SetRoleFunc(ctx, id, r)
+}
+
+func (m *MockAdminService) Restore(ctx context.Context, id string) error {
+	if m.RestoreFunc == nil {
+		return errors.New("MockAdminService.RestoreFunc is nil")
+	}
+	return m.RestoreFunc(ctx, id)
+}
+
+func (m *MockAdminService) ForceVerifyEmail(ctx context.Context, id string) error {
+	if m.ForceVerifyEmailFunc == nil {
+		return errors.New("MockAdminService.ForceVerifyEmailFunc is nil")
+	}
+	return m.ForceVerifyEmailFunc(ctx, id)
+}
+
+func (m *MockAdminService) ResetPassword(ctx context.Context, id, password string) error {
+	if m.ResetPasswordFunc == nil {
+		return errors.New("MockAdminService.ResetPasswordFunc is nil")
+	}
+	return m.ResetPasswordFunc(ctx, id, password)
+}
+
+func (m *MockAdminService) RevokeTokens(ctx context.Context, id string) error {
+	if m.RevokeTokensFunc == nil {
+		return errors.New("MockAdminService.RevokeTokensFunc is nil")
+	}
+	return m.RevokeTokensFunc(ctx, id)
+}
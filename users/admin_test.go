@@ -0,0 +1,288 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	birthdate := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	deletedAt := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		givenInput     ListUsersInput
+		expectedFilter repository.UserFilter
+		expectedError  error
+	}{
+		{
+			name:           "default limit",
+			givenInput:     ListUsersInput{Query: "doe"},
+			expectedFilter: repository.UserFilter{Query: "doe", Limit: defaultListLimit},
+		},
+		{
+			name:           "capped limit",
+			givenInput:     ListUsersInput{Role: "admin", IncludeDeleted: true, Limit: 5000, Offset: 10},
+			expectedFilter: repository.UserFilter{Role: "admin", IncludeDeleted: true, Limit: maxListLimit, Offset: 10},
+		},
+		{
+			name:           "negative offset",
+			givenInput:     ListUsersInput{Limit: 10, Offset: -1},
+			expectedFilter: repository.UserFilter{Limit: 10},
+		},
+		{
+			name:          "invalid role",
+			givenInput:    ListUsersInput{Role: "root"},
+			expectedError: ErrRoleInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := DefaultService{
+				repo: &repositoryMock{
+					selectUsersFunc: func(ctx context.Context, filter repository.UserFilter) ([]repository.User, error) {
+						assert.Equal(t, tc.expectedFilter, filter)
+						return []repository.User{
+							{ID: "1", Role: "user", Birthdate: birthdate},
+							{ID: "2", Role: "admin", Birthdate: birthdate, DeletedAt: sql.NullTime{Time: deletedAt, Valid: true}},
+						}, nil
+					},
+				},
+			}
+
+			actual, err := svc.List(context.Background(), tc.givenInput)
+			assert.Equal(t, tc.expectedError, err)
+
+			if tc.expectedError == nil {
+				assert.Equal(t, []User{
+					{ID: "1", Role: RoleUser, Birthdate: newBirthdate(birthdate)},
+					{ID: "2", Role: RoleAdmin, Birthdate: newBirthdate(birthdate), DeletedAt: deletedAt},
+				}, actual)
+			}
+		})
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		givenID       string
+		givenRole     string
+		givenErr      error
+		expectedError error
+	}{
+		{
+			name:      "promoted",
+			givenID:   uuid.NewString(),
+			givenRole: string(RoleAdmin),
+		},
+		{
+			name:          "invalid role",
+			givenID:       uuid.NewString(),
+			givenRole:     "root",
+			expectedError: ErrRoleInvalid,
+		},
+		{
+			name:          "not found",
+			givenID:       uuid.NewString(),
+			givenRole:     string(RoleUser),
+			givenErr:      fmt.Errorf("could not update role: %w", repository.ErrRecordNotFound),
+			expectedError: ErrNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := DefaultService{
+				clock: func() time.Time { return now },
+				repo: &repositoryMock{
+					updateRoleFunc: func(ctx context.Context, id, role string, updatedAt time.Time) error {
+						assert.Equal(t, tc.givenID, id)
+						assert.Equal(t, tc.givenRole, role)
+						assert.Equal(t, now, updatedAt)
+						return tc.givenErr
+					},
+				},
+			}
+
+			err := svc.SetRole(context.Background(), tc.givenID, tc.givenRole)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+
+	t.Run("invalid id", func(t *testing.T) {
+		err := (&DefaultService{}).SetRole(context.Background(), "foo", string(RoleAdmin))
+		assert.True(t, errors.Is(err, ErrValidation))
+	})
+}
+
+func TestRestore(t *testing.T) {
+	t.Parallel()
+
+	svc := DefaultService{
+		repo: &repositoryMock{
+			restoreByIDFunc: func(ctx context.Context, id string, updatedAt time.Time) error {
+				return fmt.Errorf("could not restore user: %w", repository.ErrRecordNotFound)
+			},
+		},
+	}
+
+	err := svc.Restore(context.Background(), uuid.NewString())
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestForceVerifyEmail(t *testing.T) {
+	t.Parallel()
+
+	userID := uuid.NewString()
+
+	var published []Event
+
+	svc := DefaultService{
+		repo: &repositoryMock{
+			updateEmailVerifiedFunc: func(ctx context.Context, id string, updatedAt time.Time) error {
+				assert.Equal(t, userID, id)
+				return nil
+			},
+		},
+		eventPublisher: &eventPublisherMock{
+			publishFunc: func(ctx context.Context, event Event) error {
+				published = append(published, event)
+				return nil
+			},
+		},
+	}
+
+	require.NoError(t, svc.ForceVerifyEmail(context.Background(), userID))
+
+	require.Len(t, published, 1)
+	assert.Equal(t, EventEmailVerified, published[0].Type)
+	assert.Equal(t, userID, published[0].UserID)
+}
+
+func TestResetPassword(t *testing.T) {
+	t.Parallel()
+
+	userID := uuid.NewString()
+
+	testCases := []struct {
+		name          string
+		givenPassword string
+		expectedError error
+	}{
+		{
+			name:          "reset",
+			givenPassword: "new-password#123",
+		},
+		{
+			name:          "weak password",
+			givenPassword: "password",
+			expectedError: ErrValidation,
+		},
+		{
+			name:          "password contains the username",
+			givenPassword: "johndoe#12345",
+			expectedError: ErrValidation,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var storedHash string
+
+			svc := New(nil, "jwt-secret", &repositoryMock{
+				selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+					return &repository.User{ID: id, Username: "johndoe", Email: "jdoe@mail.com", Fullname: "John Doe"}, nil
+				},
+				updatePasswordHashFunc: func(ctx context.Context, id, passwordHash string, updatedAt time.Time) error {
+					storedHash = passwordHash
+					return nil
+				},
+			})
+
+			err := svc.ResetPassword(context.Background(), userID, tc.givenPassword)
+			if tc.expectedError != nil {
+				assert.True(t, errors.Is(err, tc.expectedError))
+				assert.Empty(t, storedHash)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(tc.givenPassword)))
+		})
+	}
+
+	t.Run("user not found", func(t *testing.T) {
+		svc := DefaultService{
+			repo: &repositoryMock{
+				selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+					return nil, nil
+				},
+			},
+		}
+
+		err := svc.ResetPassword(context.Background(), userID, "new-password#123")
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
+func TestRevokeTokens(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	userID := uuid.NewString()
+
+	var revokedAt sql.NullTime
+
+	svc := DefaultService{
+		clock:         func() time.Time { return now },
+		jwtSigningKey: "secret",
+		repo: &repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				return &repository.User{ID: id, Username: "jdoe", Role: "user", TokensRevokedAt: revokedAt}, nil
+			},
+			revokeTokensFunc: func(ctx context.Context, id string, at time.Time) error {
+				revokedAt = sql.NullTime{Time: at, Valid: true}
+				return nil
+			},
+		},
+	}
+
+	token, err := svc.generateJWT(&repository.User{ID: userID, Role: "user"}, "", now)
+	require.NoError(t, err)
+
+	_, err = svc.VerifyToken(context.Background(), token)
+	require.NoError(t, err)
+
+	now = now.Add(time.Minute)
+	require.NoError(t, svc.RevokeTokens(context.Background(), userID))
+
+	_, err = svc.VerifyToken(context.Background(), token)
+	assert.Equal(t, ErrTokenRevoked, err)
+
+	// Tokens issued after the revocation are accepted
+	now = now.Add(time.Second)
+
+	token, err = svc.generateJWT(&repository.User{ID: userID, Role: "user"}, "", now)
+	require.NoError(t, err)
+
+	_, err = svc.VerifyToken(context.Background(), token)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,153 @@
+package users
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// Enumerate audit actions
+
+	AuditUserCreated           AuditAction = "user.created"
+	AuditUserDeleted           AuditAction = "user.deleted"
+	AuditUserRestored          AuditAction = "user.restored"
+	AuditLogin                 AuditAction = "user.login"
+	AuditEmailVerificationSent AuditAction = "user.email_verification_sent"
+	AuditEmailVerified         AuditAction = "user.email_verified"
+	AuditRoleChanged           AuditAction = "user.role_changed"
+	AuditPasswordReset         AuditAction = "user.password_reset"
+	AuditTokensRevoked         AuditAction = "user.tokens_revoked"
+	AuditSessionsRevoked       AuditAction = "user.sessions_revoked"
+
+	// Enumerate audit outcomes
+
+	AuditOutcomeSuccess AuditOutcome = "success"
+	AuditOutcomeFailure AuditOutcome = "failure"
+
+	// auditTimeout bounds recording an entry, which outlives the request it audits
+	auditTimeout = 5 * time.Second
+)
+
+// AuditActions lists every action recorded by the service
+var AuditActions = []AuditAction{
+	AuditUserCreated,
+	AuditUserDeleted,
+	AuditUserRestored,
+	AuditLogin,
+	AuditEmailVerificationSent,
+	AuditEmailVerified,
+	AuditRoleChanged,
+	AuditPasswordReset,
+	AuditTokensRevoked,
+	AuditSessionsRevoked,
+}
+
+type (
+	// AuditAction identifies the kind of operation an audit entry records
+	AuditAction string
+
+	// AuditOutcome tells whether an audited operation succeeded
+	AuditOutcome string
+
+	// AuditEntry records who attempted an operation on a user account, from where, and how it ended
+	AuditEntry struct {
+		Action AuditAction
+
+		// ActorID identifies who issued the request, see RequestInfo. It is empty for anonymous requests.
+		ActorID string
+
+		// SubjectID is the id of the user the operation applies to. It is empty when
+		// the user is unknown, such as a login attempt with an unregistered email.
+		SubjectID string
+
+		IP        string
+		UserAgent string
+		Outcome   AuditOutcome
+
+		// Reason is the error code of a failed operation
+		Reason string
+
+		// Metadata holds action specific details, such as the role granted by a role change
+		Metadata   map[string]string
+		OccurredAt time.Time
+	}
+
+	// RequestInfo describes the origin of a request, recorded by the audit log.
+	// Transports attach it to the context of service calls with WithRequestInfo.
+	RequestInfo struct {
+		ActorID   string
+		IP        string
+		UserAgent string
+	}
+
+	auditor interface {
+		Record(ctx context.Context, entry AuditEntry) error
+	}
+
+	requestInfoKey struct{}
+
+	// detachedContext carries the values of its parent, such as the trace span, without its cancellation
+	detachedContext struct {
+		parent context.Context
+	}
+)
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+func (a AuditAction) String() string {
+	return string(a)
+}
+
+// WithAuditor sets an auditor recording every state-changing operation and every login attempt
+func WithAuditor(a auditor) ServiceOption {
+	return func(s *DefaultService) {
+		s.auditor = a
+	}
+}
+
+// WithRequestInfo returns a copy of ctx carrying the origin of a request
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFromContext returns the origin of a request set by WithRequestInfo, if any
+func RequestInfoFromContext(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info
+}
+
+// audit records the outcome of an operation with the auditor, if any.
+// Like publishing, auditing is best effort: failures are logged and never fail the caller.
+// Entries are recorded even when the client disconnected, which would otherwise cancel the request context.
+func (s *DefaultService) audit(ctx context.Context, entry AuditEntry, err error) {
+	if s.auditor == nil {
+		return
+	}
+
+	info := RequestInfoFromContext(ctx)
+
+	entry.ActorID = info.ActorID
+	entry.IP = info.IP
+	entry.UserAgent = info.UserAgent
+	entry.OccurredAt = s.now().UTC()
+	entry.Outcome = AuditOutcomeSuccess
+
+	if err != nil {
+		entry.Outcome = AuditOutcomeFailure
+		entry.Reason = errorCode(err)
+	}
+
+	ctx, cancel := context.WithTimeout(detachedContext{ctx}, auditTimeout)
+	defer cancel()
+
+	if err := s.auditor.Record(ctx, entry); err != nil {
+		s.logger.Error("could not record audit entry",
+			zap.String("action", entry.Action.String()), zap.String("subject_id", entry.SubjectID), zap.Error(err))
+	}
+}
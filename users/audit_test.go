@@ -0,0 +1,225 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestWithAuditor(t *testing.T) {
+	t.Parallel()
+
+	givenAuditor := &auditorMock{}
+
+	actual := New(zap.NewNop(), "jwt-secret", &repositoryMock{}, WithAuditor(givenAuditor))
+
+	assert.Equal(t, givenAuditor, actual.auditor)
+}
+
+func TestAudit(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+	givenInfo := RequestInfo{ActorID: uuid.New().String(), IP: "192.0.2.1", UserAgent: "curl/7.79.1"}
+
+	t.Run("entry is recorded", func(t *testing.T) {
+		var recorded []AuditEntry
+
+		svc := DefaultService{
+			logger: zap.NewNop(),
+			auditor: &auditorMock{
+				recordFunc: func(ctx context.Context, entry AuditEntry) error {
+					recorded = append(recorded, entry)
+					return nil
+				},
+			},
+		}
+
+		ctx := WithRequestInfo(context.Background(), givenInfo)
+
+		svc.audit(ctx, AuditEntry{Action: AuditUserDeleted, SubjectID: givenUserID}, nil)
+		svc.audit(ctx, AuditEntry{Action: AuditUserDeleted, SubjectID: givenUserID}, ErrNotFound)
+
+		require.Len(t, recorded, 2)
+
+		assert.Equal(t, AuditUserDeleted, recorded[0].Action)
+		assert.Equal(t, givenUserID, recorded[0].SubjectID)
+		assert.Equal(t, givenInfo.ActorID, recorded[0].ActorID)
+		assert.Equal(t, givenInfo.IP, recorded[0].IP)
+		assert.Equal(t, givenInfo.UserAgent, recorded[0].UserAgent)
+		assert.Equal(t, AuditOutcomeSuccess, recorded[0].Outcome)
+		assert.Empty(t, recorded[0].Reason)
+		assert.False(t, recorded[0].OccurredAt.IsZero())
+
+		assert.Equal(t, AuditOutcomeFailure, recorded[1].Outcome)
+		assert.Equal(t, string(CodeNotFound), recorded[1].Reason)
+	})
+
+	t.Run("canceled request", func(t *testing.T) {
+		var recorded []AuditEntry
+
+		svc := DefaultService{
+			logger: zap.NewNop(),
+			auditor: &auditorMock{
+				recordFunc: func(ctx context.Context, entry AuditEntry) error {
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+
+					_, ok := ctx.Deadline()
+					assert.True(t, ok)
+
+					recorded = append(recorded, entry)
+					return nil
+				},
+			},
+		}
+
+		ctx, cancel := context.WithCancel(WithRequestInfo(context.Background(), givenInfo))
+		cancel()
+
+		svc.audit(ctx, AuditEntry{Action: AuditLogin, SubjectID: givenUserID}, nil)
+
+		require.Len(t, recorded, 1)
+		assert.Equal(t, givenInfo.ActorID, recorded[0].ActorID)
+	})
+
+	t.Run("record error is not propagated", func(t *testing.T) {
+		svc := DefaultService{
+			logger: zap.NewNop(),
+			auditor: &auditorMock{
+				recordFunc: func(ctx context.Context, entry AuditEntry) error {
+					return errors.New("some error")
+				},
+			},
+		}
+
+		assert.NotPanics(t, func() {
+			svc.audit(context.Background(), AuditEntry{Action: AuditUserDeleted, SubjectID: givenUserID}, nil)
+		})
+	})
+
+	t.Run("no auditor", func(t *testing.T) {
+		svc := DefaultService{}
+
+		assert.NotPanics(t, func() {
+			svc.audit(context.Background(), AuditEntry{Action: AuditUserDeleted, SubjectID: givenUserID}, nil)
+		})
+	})
+}
+
+func TestGenerateToken_audit(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password%&123"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name            string
+		givenEmail      string
+		givenPassword   string
+		expectedSubject string
+		expectedOutcome AuditOutcome
+		expectedReason  string
+	}{
+		{
+			name:            "successful login",
+			givenEmail:      "jdoe@mail.com",
+			givenPassword:   "password%&123",
+			expectedSubject: givenUserID,
+			expectedOutcome: AuditOutcomeSuccess,
+		},
+		{
+			name:            "wrong password",
+			givenEmail:      "jdoe@mail.com",
+			givenPassword:   "password%&456",
+			expectedSubject: givenUserID,
+			expectedOutcome: AuditOutcomeFailure,
+			expectedReason:  string(CodePasswordInvalid),
+		},
+		{
+			name:            "unknown email",
+			givenEmail:      "unknown@mail.com",
+			givenPassword:   "password%&123",
+			expectedOutcome: AuditOutcomeFailure,
+			expectedReason:  string(CodeNotFound),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var recorded []AuditEntry
+
+			svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					if email != "jdoe@mail.com" {
+						return nil, nil
+					}
+					return &repository.User{ID: givenUserID, Email: email, PasswordHash: string(hash), Role: "user"}, nil
+				},
+				insertSessionFunc: func(ctx context.Context, in repository.Session) error {
+					return nil
+				},
+			}, WithAuditor(&auditorMock{
+				recordFunc: func(ctx context.Context, entry AuditEntry) error {
+					recorded = append(recorded, entry)
+					return nil
+				},
+			}))
+
+			_, _ = svc.GenerateToken(context.Background(), tc.givenEmail, tc.givenPassword)
+
+			require.Len(t, recorded, 1)
+			assert.Equal(t, AuditLogin, recorded[0].Action)
+			assert.Equal(t, tc.expectedSubject, recorded[0].SubjectID)
+			assert.Equal(t, tc.expectedOutcome, recorded[0].Outcome)
+			assert.Equal(t, tc.expectedReason, recorded[0].Reason)
+			assert.Equal(t, tc.expectedOutcome == AuditOutcomeSuccess, recorded[0].Metadata["session_id"] != "")
+		})
+	}
+}
+
+func TestSetRole_audit(t *testing.T) {
+	t.Parallel()
+
+	givenID := uuid.New().String()
+
+	var recorded []AuditEntry
+
+	svc := DefaultService{
+		logger: zap.NewNop(),
+		repo: &repositoryMock{
+			updateRoleFunc: func(ctx context.Context, id, role string, updatedAt time.Time) error {
+				return nil
+			},
+		},
+		auditor: &auditorMock{
+			recordFunc: func(ctx context.Context, entry AuditEntry) error {
+				recorded = append(recorded, entry)
+				return nil
+			},
+		},
+	}
+
+	ctx := WithRequestInfo(context.Background(), RequestInfo{ActorID: "usersctl"})
+	require.NoError(t, svc.SetRole(ctx, givenID, "admin"))
+
+	require.Len(t, recorded, 1)
+	assert.Equal(t, AuditRoleChanged, recorded[0].Action)
+	assert.Equal(t, givenID, recorded[0].SubjectID)
+	assert.Equal(t, "usersctl", recorded[0].ActorID)
+	assert.Equal(t, map[string]string{"role": "admin"}, recorded[0].Metadata)
+}
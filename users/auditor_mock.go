@@ -0,0 +1,19 @@
+package users
+
+import (
+	"context"
+	"errors"
+)
+
+var _ auditor = (*auditorMock)(nil)
+
+type auditorMock struct {
+	recordFunc func(ctx context.Context, entry AuditEntry) error
+}
+
+func (m *auditorMock) Record(ctx context.Context, entry AuditEntry) error {
+	if m.recordFunc == nil {
+		return errors.New("auditorMock.recordFunc is nil")
+	}
+	return m.recordFunc(ctx, entry)
+}
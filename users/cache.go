@@ -0,0 +1,275 @@
+package users
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+)
+
+const (
+	// Enumerate the results of user cache lookups
+
+	cacheResultHit  = "hit"
+	cacheResultMiss = "miss"
+)
+
+var _ repo = (*cachingRepo)(nil)
+
+// UserCacheStats describes the activity of the user cache enabled with WithUserCache
+type UserCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Size is the number of users currently cached
+	Size int
+}
+
+// WithUserCache caches up to size users selected by id, such as by VerifyToken, for at most ttl.
+// Changes made through the service invalidate the cached user, but changes made by other instances
+// of the service, such as a role change or a token revocation, are only seen once ttl elapses.
+// A size or ttl of zero disables the cache, which is the default.
+func WithUserCache(size int, ttl time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.userCacheSize = size
+		s.userCacheTTL = ttl
+	}
+}
+
+// UserCacheStats returns the activity of the user cache, zero if it is disabled
+func (s *DefaultService) UserCacheStats() UserCacheStats {
+	if s.userCache == nil {
+		return UserCacheStats{}
+	}
+	return s.userCache.stats()
+}
+
+type (
+	// cachingRepo caches the users returned by SelectByID in a bounded LRU with a TTL.
+	// Concurrent misses of the same user share a single query, and writes to a user invalidate it.
+	cachingRepo struct {
+		repo
+		size    int
+		ttl     time.Duration
+		now     func() time.Time
+		metrics *Metrics
+
+		mu       sync.Mutex
+		entries  map[string]*list.Element
+		lru      *list.List
+		inflight map[string]*cacheCall
+
+		// generation is incremented by every invalidation, so that queries started
+		// before an invalidation do not cache the user they selected
+		generation uint64
+
+		hits, misses, evictions uint64
+	}
+
+	cacheEntry struct {
+		id        string
+		user      repository.User
+		expiresAt time.Time
+	}
+
+	// cacheCall is a query shared by the concurrent misses of a user
+	cacheCall struct {
+		done chan struct{}
+		user *repository.User
+		err  error
+	}
+)
+
+func newCachingRepo(next repo, size int, ttl time.Duration, now func() time.Time, m *Metrics) *cachingRepo {
+	return &cachingRepo{
+		repo:     next,
+		size:     size,
+		ttl:      ttl,
+		now:      now,
+		metrics:  m,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		inflight: make(map[string]*cacheCall),
+	}
+}
+
+// SelectByID returns the cached user with the given id, or selects it from the repository.
+// Users that are not found are not cached. Ids are keyed in their canonical form, so that
+// a user selected with an upper case id is invalidated by a write with the lower case one.
+func (r *cachingRepo) SelectByID(ctx context.Context, id string) (*repository.User, error) {
+	id = canonicalID(id)
+
+	for {
+		user, shared, err := r.selectByID(ctx, id)
+
+		// A shared query runs with the context of the caller that started it: when that caller gives up,
+		// the others select the user again with their own context
+		if shared && isContextErr(err) && ctx.Err() == nil {
+			continue
+		}
+		return user, err
+	}
+}
+
+// selectByID returns the cached user with the given id, or selects it from the repository,
+// reporting whether the query was started by another caller
+func (r *cachingRepo) selectByID(ctx context.Context, id string) (*repository.User, bool, error) {
+	r.mu.Lock()
+
+	if el, ok := r.entries[id]; ok {
+		entry := el.Value.(*cacheEntry)
+		if r.now().Before(entry.expiresAt) {
+			r.lru.MoveToFront(el)
+			r.hits++
+			r.mu.Unlock()
+
+			r.metrics.observeCache(cacheResultHit)
+			user := entry.user
+			return &user, false, nil
+		}
+		r.remove(el)
+	}
+
+	r.misses++
+
+	if call, ok := r.inflight[id]; ok {
+		r.mu.Unlock()
+		r.metrics.observeCache(cacheResultMiss)
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		return copyUser(call.user), true, call.err
+	}
+
+	call := cacheCall{done: make(chan struct{})}
+	r.inflight[id] = &call
+	generation := r.generation
+	r.mu.Unlock()
+
+	r.metrics.observeCache(cacheResultMiss)
+
+	call.user, call.err = r.repo.SelectByID(ctx, id)
+
+	// The call is done only once it is no longer in flight, so that the callers retrying it start a new one
+	r.mu.Lock()
+	if r.inflight[id] == &call {
+		delete(r.inflight, id)
+	}
+	if call.err == nil && call.user != nil && generation == r.generation {
+		r.add(id, *call.user)
+	}
+	r.mu.Unlock()
+
+	close(call.done)
+
+	return copyUser(call.user), false, call.err
+}
+
+func (r *cachingRepo) DeleteByID(ctx context.Context, id string) error {
+	defer r.invalidate(id)
+	return r.repo.DeleteByID(ctx, id)
+}
+
+func (r *cachingRepo) RestoreByID(ctx context.Context, id string, updatedAt time.Time) error {
+	defer r.invalidate(id)
+	return r.repo.RestoreByID(ctx, id, updatedAt)
+}
+
+func (r *cachingRepo) UpdateRole(ctx context.Context, id, role string, updatedAt time.Time) error {
+	defer r.invalidate(id)
+	return r.repo.UpdateRole(ctx, id, role, updatedAt)
+}
+
+func (r *cachingRepo) UpdatePasswordHash(ctx context.Context, id, passwordHash string, updatedAt time.Time) error {
+	defer r.invalidate(id)
+	return r.repo.UpdatePasswordHash(ctx, id, passwordHash, updatedAt)
+}
+
+func (r *cachingRepo) UpdateEmailVerified(ctx context.Context, id string, updatedAt time.Time) error {
+	defer r.invalidate(id)
+	return r.repo.UpdateEmailVerified(ctx, id, updatedAt)
+}
+
+func (r *cachingRepo) RevokeTokens(ctx context.Context, id string, revokedAt time.Time) error {
+	defer r.invalidate(id)
+	return r.repo.RevokeTokens(ctx, id, revokedAt)
+}
+
+// ConfirmEmailVerification invalidates every cached user, since the verified user is only known
+// by the repository. Verifications are rare enough for the cache to be refilled quickly.
+func (r *cachingRepo) ConfirmEmailVerification(ctx context.Context, tokenHash string, verifiedAt time.Time) error {
+	defer r.invalidateAll()
+	return r.repo.ConfirmEmailVerification(ctx, tokenHash, verifiedAt)
+}
+
+// invalidate removes a user from the cache. It runs after writes whether they fail or not,
+// since a failed write may still have been applied.
+func (r *cachingRepo) invalidate(id string) {
+	id = canonicalID(id)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if el, ok := r.entries[id]; ok {
+		r.remove(el)
+	}
+	delete(r.inflight, id)
+	r.generation++
+}
+
+func (r *cachingRepo) invalidateAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = make(map[string]*list.Element)
+	r.lru.Init()
+	r.inflight = make(map[string]*cacheCall)
+	r.generation++
+}
+
+// add caches a user, evicting the least recently used one if the cache is full. r.mu must be held.
+func (r *cachingRepo) add(id string, user repository.User) {
+	if el, ok := r.entries[id]; ok {
+		r.remove(el)
+	}
+
+	for r.lru.Len() >= r.size {
+		r.remove(r.lru.Back())
+		r.evictions++
+	}
+
+	r.entries[id] = r.lru.PushFront(&cacheEntry{id: id, user: user, expiresAt: r.now().Add(r.ttl)})
+}
+
+// remove removes an entry from the cache. r.mu must be held.
+func (r *cachingRepo) remove(el *list.Element) {
+	r.lru.Remove(el)
+	delete(r.entries, el.Value.(*cacheEntry).id)
+}
+
+func (r *cachingRepo) stats() UserCacheStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return UserCacheStats{Hits: r.hits, Misses: r.misses, Evictions: r.evictions, Size: r.lru.Len()}
+}
+
+// isContextErr reports whether err is due to a canceled or expired context
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// copyUser returns a copy of user, so that callers sharing a query cannot modify each other's user
+func copyUser(user *repository.User) *repository.User {
+	if user == nil {
+		return nil
+	}
+	u := *user
+	return &u
+}
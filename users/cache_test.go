@@ -0,0 +1,346 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachingRepo_SelectByID(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	var selected []string
+
+	r := newCachingRepo(&repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			selected = append(selected, id)
+			if id == "unknown" {
+				return nil, nil
+			}
+			return &repository.User{ID: id, Username: "user-" + id}, nil
+		},
+	}, 2, time.Minute, func() time.Time { return now }, nil)
+
+	get := func(id string) *repository.User {
+		user, err := r.SelectByID(context.Background(), id)
+		require.NoError(t, err)
+		return user
+	}
+
+	assert.Equal(t, "user-1", get("1").Username)
+	assert.Equal(t, "user-1", get("1").Username)
+	assert.Equal(t, []string{"1"}, selected)
+
+	// Callers get copies of the cached user
+	get("1").Username = "changed"
+	assert.Equal(t, "user-1", get("1").Username)
+
+	// Users that are not found are not cached
+	assert.Nil(t, get("unknown"))
+	assert.Nil(t, get("unknown"))
+	assert.Equal(t, []string{"1", "unknown", "unknown"}, selected)
+
+	// The least recently used user is evicted
+	get("2")
+	get("1")
+	get("3")
+	get("1")
+	get("2")
+	assert.Equal(t, []string{"1", "unknown", "unknown", "2", "3", "2"}, selected)
+
+	// Users expire after the ttl
+	now = now.Add(time.Minute)
+	get("2")
+	assert.Equal(t, []string{"1", "unknown", "unknown", "2", "3", "2", "2"}, selected)
+
+	assert.Equal(t, UserCacheStats{Hits: 5, Misses: 7, Evictions: 2, Size: 2}, r.stats())
+}
+
+func TestCachingRepo_invalidation(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name  string
+		write func(r *cachingRepo) error
+	}{
+		{
+			name:  "delete",
+			write: func(r *cachingRepo) error { return r.DeleteByID(context.Background(), "1") },
+		},
+		{
+			name:  "restore",
+			write: func(r *cachingRepo) error { return r.RestoreByID(context.Background(), "1", now) },
+		},
+		{
+			name:  "role change",
+			write: func(r *cachingRepo) error { return r.UpdateRole(context.Background(), "1", "admin", now) },
+		},
+		{
+			name:  "password change",
+			write: func(r *cachingRepo) error { return r.UpdatePasswordHash(context.Background(), "1", "hash", now) },
+		},
+		{
+			name:  "email verified",
+			write: func(r *cachingRepo) error { return r.UpdateEmailVerified(context.Background(), "1", now) },
+		},
+		{
+			name:  "tokens revoked",
+			write: func(r *cachingRepo) error { return r.RevokeTokens(context.Background(), "1", now) },
+		},
+		{
+			name:  "email verification confirmed",
+			write: func(r *cachingRepo) error { return r.ConfirmEmailVerification(context.Background(), "hash", now) },
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var selects int
+
+			// Failed writes invalidate the user too
+			writeErr := errors.New("connection reset")
+
+			r := newCachingRepo(&repositoryMock{
+				selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+					selects++
+					return &repository.User{ID: id}, nil
+				},
+				deleteByIDFunc: func(ctx context.Context, id string) error { return writeErr },
+				restoreByIDFunc: func(ctx context.Context, id string, updatedAt time.Time) error {
+					return writeErr
+				},
+				updateRoleFunc: func(ctx context.Context, id, role string, updatedAt time.Time) error {
+					return writeErr
+				},
+				updatePasswordHashFunc: func(ctx context.Context, id, passwordHash string, updatedAt time.Time) error {
+					return writeErr
+				},
+				updateEmailVerifiedFunc: func(ctx context.Context, id string, updatedAt time.Time) error {
+					return writeErr
+				},
+				revokeTokensFunc: func(ctx context.Context, id string, revokedAt time.Time) error {
+					return writeErr
+				},
+				confirmEmailVerificationFunc: func(ctx context.Context, tokenHash string, verifiedAt time.Time) error {
+					return writeErr
+				},
+			}, 10, time.Minute, func() time.Time { return now }, nil)
+
+			_, err := r.SelectByID(context.Background(), "1")
+			require.NoError(t, err)
+
+			assert.Equal(t, writeErr, tc.write(r))
+
+			_, err = r.SelectByID(context.Background(), "1")
+			require.NoError(t, err)
+			assert.Equal(t, 2, selects)
+		})
+	}
+}
+
+func TestCachingRepo_idForms(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	givenID := uuid.New()
+
+	var selected []string
+
+	r := newCachingRepo(&repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			selected = append(selected, id)
+			return &repository.User{ID: id}, nil
+		},
+		updateRoleFunc: func(ctx context.Context, id, role string, updatedAt time.Time) error {
+			return nil
+		},
+	}, 10, time.Minute, func() time.Time { return now }, nil)
+
+	for _, id := range []string{strings.ToUpper(givenID.String()), givenID.URN(), givenID.String()} {
+		_, err := r.SelectByID(context.Background(), id)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{givenID.String()}, selected)
+
+	require.NoError(t, r.UpdateRole(context.Background(), strings.ToUpper(givenID.String()), "admin", now))
+
+	_, err := r.SelectByID(context.Background(), givenID.String())
+	require.NoError(t, err)
+	assert.Len(t, selected, 2)
+}
+
+func TestCachingRepo_concurrentMisses(t *testing.T) {
+	t.Parallel()
+
+	const callers = 10
+
+	var selects int32
+	release := make(chan struct{})
+
+	r := newCachingRepo(&repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			atomic.AddInt32(&selects, 1)
+			<-release
+			return &repository.User{ID: id}, nil
+		},
+	}, 10, time.Minute, time.Now, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			user, err := r.SelectByID(context.Background(), "1")
+			assert.NoError(t, err)
+			assert.Equal(t, "1", user.ID)
+		}()
+	}
+
+	require.Eventually(t, func() bool { return r.stats().Misses == callers }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&selects))
+	assert.Equal(t, 1, r.stats().Size)
+}
+
+func TestCachingRepo_leaderCanceled(t *testing.T) {
+	t.Parallel()
+
+	var selects int32
+	selecting := make(chan struct{})
+
+	r := newCachingRepo(&repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			if atomic.AddInt32(&selects, 1) == 1 {
+				close(selecting)
+				<-ctx.Done()
+				return nil, fmt.Errorf("could not select user: %w", ctx.Err())
+			}
+			return &repository.User{ID: id}, nil
+		},
+	}, 10, time.Minute, time.Now, nil)
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+
+		_, err := r.SelectByID(leaderCtx, "1")
+		assert.True(t, errors.Is(err, context.Canceled), err)
+	}()
+	<-selecting
+
+	waiterDone := make(chan struct{})
+	go func() {
+		defer close(waiterDone)
+
+		user, err := r.SelectByID(context.Background(), "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "1", user.ID)
+	}()
+
+	// The waiter joins the query of the leader before it is canceled
+	require.Eventually(t, func() bool { return r.stats().Misses == 2 }, time.Second, time.Millisecond)
+	cancel()
+
+	<-leaderDone
+	<-waiterDone
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&selects))
+	assert.Equal(t, 1, r.stats().Size)
+}
+
+func TestCachingRepo_invalidationDuringQuery(t *testing.T) {
+	t.Parallel()
+
+	selecting := make(chan struct{})
+	release := make(chan struct{})
+
+	var selects int32
+
+	r := newCachingRepo(&repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			if atomic.AddInt32(&selects, 1) == 1 {
+				close(selecting)
+				<-release
+				return &repository.User{ID: id, Role: "user"}, nil
+			}
+			return &repository.User{ID: id, Role: "admin"}, nil
+		},
+		updateRoleFunc: func(ctx context.Context, id, role string, updatedAt time.Time) error {
+			return nil
+		},
+	}, 10, time.Minute, time.Now, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		user, err := r.SelectByID(context.Background(), "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "user", user.Role)
+	}()
+
+	<-selecting
+	require.NoError(t, r.UpdateRole(context.Background(), "1", "admin", time.Now()))
+	close(release)
+	<-done
+
+	// The user selected before the role change is not cached
+	user, err := r.SelectByID(context.Background(), "1")
+	require.NoError(t, err)
+	assert.Equal(t, "admin", user.Role)
+}
+
+func TestWithUserCache(t *testing.T) {
+	t.Parallel()
+
+	m, err := NewMetrics(prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	userID := uuid.NewString()
+
+	svc := New(nil, "secret", &repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return &repository.User{ID: id, Username: "jdoe", Role: "user"}, nil
+		},
+	}, WithClock(func() time.Time { return now }), WithMetrics(m), WithUserCache(100, time.Minute))
+
+	token, err := svc.generateJWT(&repository.User{ID: userID, Role: "user"}, "", now)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err := svc.VerifyToken(context.Background(), token)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, UserCacheStats{Hits: 2, Misses: 1, Size: 1}, svc.UserCacheStats())
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.queries.WithLabelValues("SelectByID", "ok")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.cacheLookups.WithLabelValues(cacheResultHit)))
+
+	// The cache is disabled by default
+	assert.Equal(t, UserCacheStats{}, New(nil, "secret", &repositoryMock{}).UserCacheStats())
+}
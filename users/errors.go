@@ -1,31 +1,138 @@
 package users
 
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/alesr/stdservices/pkg/validate"
+	"golang.org/x/text/language"
+)
+
+const (
+	// Enumerate error codes
+
+	CodeAlreadyExists    Code = "user.already_exists"
+	CodeBusy             Code = "service.busy"
+	CodeNotFound         Code = "user.not_found"
+	CodePasswordInvalid  Code = "user.password_invalid"
+	CodeRoleForbidden    Code = "user.role_forbidden"
+	CodeRoleInvalid      Code = "user.role_invalid"
+	CodeSessionNotFound  Code = "session.not_found"
+	CodeTokenEmpty       Code = "user.token_empty"
+	CodeTokenExpired     Code = "user.token_expired"
+	CodeTokenInvalid     Code = "user.token_invalid"
+	CodeTokenRevoked     Code = "user.token_revoked"
+	CodeValidationFailed Code = "validation.failed"
+
+	CodeVerificationAttemptsExceeded Code = "verification.attempts_exceeded"
+	CodeVerificationExpired          Code = "verification.expired"
+	CodeVerificationInvalid          Code = "verification.invalid"
+	CodeVerificationRateLimited      Code = "verification.rate_limited"
+)
+
 type ParsableError interface {
 	Error() string
 }
 
+// Code is a stable, machine-readable error code
+type Code string
+
+// FieldError describes why a single input field is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// E is the error returned by the service for expected failures.
+// Callers match it with errors.Is against the exported error variables,
+// or extract it with errors.As to read its code and details.
 type E struct {
-	msg string
+	code    Code
+	msg     string
+	details []FieldError
 }
 
-func newE(msg string) E {
-	return E{msg: msg}
+func newE(code Code, msg string) E {
+	return E{code: code, msg: msg}
+}
+
+// newValidationE returns a validation error for a single field
+func newValidationE(field, msg string) E {
+	return E{
+		code:    CodeValidationFailed,
+		msg:     msg,
+		details: []FieldError{{Field: field, Message: msg}},
+	}
+}
+
+// newValidationErrorsE returns a validation error listing every invalid field,
+// with messages translated to the given language
+func newValidationErrorsE(errs validate.ValidationErrors, tag language.Tag) E {
+	e := E{code: CodeValidationFailed}
+
+	msgs := make([]string, 0, len(errs))
+	for _, fe := range errs {
+		msg := validate.Translate(fe.Err, tag)
+		msgs = append(msgs, msg)
+		e.details = append(e.details, FieldError{Field: fe.Field, Message: msg})
+	}
+
+	e.msg = strings.Join(msgs, "; ")
+	return e
 }
 
 func (e E) Error() string {
 	return e.msg
 }
 
+// Code returns the error code
+func (e E) Code() Code {
+	return e.code
+}
+
+// Details returns the invalid fields of a validation error
+func (e E) Details() []FieldError {
+	return e.details
+}
+
+// Is reports whether target is an E with the same code, so that every
+// validation error matches ErrValidation regardless of its message and details
+func (e E) Is(target error) bool {
+	t, ok := target.(E)
+	return ok && t.code == e.code
+}
+
+// MarshalJSON encodes the error as {"code": ..., "message": ..., "details": [...]}
+func (e E) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Code    Code         `json:"code"`
+		Message string       `json:"message"`
+		Details []FieldError `json:"details,omitempty"`
+	}{
+		Code:    e.code,
+		Message: e.msg,
+		Details: e.details,
+	})
+}
+
 var (
 	// Enumerate service errors
 
-	errAlreadyExists    = newE("user already exists")
-	errForbidenRole     = newE("user role is forbiden")
-	errNotFound         = newE("user not found")
-	errPasswordInvalid  = newE("user password is invalid")
-	errPasswordMismatch = newE("user password mismatch")
-	errRoleInvalid      = newE("user role is invalid")
-	errTokenEmpty       = newE("user token is empty")
-	errTokenExpired     = newE("user token is expired")
-	errTokenInvalid     = newE("user token is invalid")
+	ErrAlreadyExists   = newE(CodeAlreadyExists, "user already exists")
+	ErrBusy            = newE(CodeBusy, "service is busy, try again later")
+	ErrForbiddenRole   = newE(CodeRoleForbidden, "user role is forbiden")
+	ErrNotFound        = newE(CodeNotFound, "user not found")
+	ErrPasswordInvalid = newE(CodePasswordInvalid, "user password is invalid")
+	ErrRoleInvalid     = newE(CodeRoleInvalid, "user role is invalid")
+	ErrSessionNotFound = newE(CodeSessionNotFound, "session not found")
+	ErrTokenEmpty      = newE(CodeTokenEmpty, "user token is empty")
+	ErrTokenExpired    = newE(CodeTokenExpired, "user token is expired")
+	ErrTokenInvalid    = newE(CodeTokenInvalid, "user token is invalid")
+	ErrTokenRevoked    = newE(CodeTokenRevoked, "user token is revoked")
+	ErrValidation      = newE(CodeValidationFailed, "user input is invalid")
+
+	ErrVerificationAttemptsExceeded = newE(CodeVerificationAttemptsExceeded, "too many verification attempts, request a new code")
+	ErrVerificationExpired          = newE(CodeVerificationExpired, "verification is expired, request a new one")
+	ErrVerificationInvalid          = newE(CodeVerificationInvalid, "verification is invalid")
+	ErrVerificationRateLimited      = newE(CodeVerificationRateLimited, "too many verification emails, try again later")
 )
@@ -0,0 +1,58 @@
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestE_wrapping(t *testing.T) {
+	t.Parallel()
+
+	given := fmt.Errorf("could not validate create user input: %w", newValidationE("email", "email is invalid"))
+
+	assert.True(t, errors.Is(given, ErrValidation))
+	assert.False(t, errors.Is(given, ErrNotFound))
+
+	var e E
+	require.True(t, errors.As(given, &e))
+
+	assert.Equal(t, CodeValidationFailed, e.Code())
+	assert.Equal(t, "email is invalid", e.Error())
+	assert.Equal(t, []FieldError{{Field: "email", Message: "email is invalid"}}, e.Details())
+
+	assert.True(t, errors.Is(fmt.Errorf("could not select user: %w", ErrNotFound), ErrNotFound))
+}
+
+func TestE_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		given    E
+		expected string
+	}{
+		{
+			name:     "without details",
+			given:    ErrNotFound,
+			expected: `{"code":"user.not_found","message":"user not found"}`,
+		},
+		{
+			name:     "with details",
+			given:    newValidationE("email", "email is invalid"),
+			expected: `{"code":"validation.failed","message":"email is invalid","details":[{"field":"email","message":"email is invalid"}]}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := json.Marshal(tc.given)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(actual))
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package users
+
+import (
+	"context"
+	"errors"
+)
+
+var _ eventPublisher = (*eventPublisherMock)(nil)
+
+type eventPublisherMock struct {
+	publishFunc func(ctx context.Context, event Event) error
+}
+
+func (m *eventPublisherMock) Publish(ctx context.Context, event Event) error {
+	if m.publishFunc == nil {
+		return errors.New("eventPublisherMock.publishFunc is nil")
+	}
+	return m.publishFunc(ctx, event)
+}
@@ -0,0 +1,69 @@
+package users
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// Enumerate user event types
+
+	EventUserCreated   EventType = "user.created"
+	EventUserDeleted   EventType = "user.deleted"
+	EventEmailVerified EventType = "user.email_verified"
+)
+
+// EventTypes lists every event type published by the service
+var EventTypes = []EventType{
+	EventUserCreated,
+	EventUserDeleted,
+	EventEmailVerified,
+}
+
+type (
+	// EventType identifies the kind of change an event describes
+	EventType string
+
+	// Event describes a change to a user account
+	Event struct {
+		Type       EventType
+		UserID     string
+		OccurredAt time.Time
+	}
+
+	eventPublisher interface {
+		Publish(ctx context.Context, event Event) error
+	}
+)
+
+func (t EventType) String() string {
+	return string(t)
+}
+
+// WithEventPublisher sets a publisher notified whenever a user account changes
+func WithEventPublisher(publisher eventPublisher) ServiceOption {
+	return func(s *DefaultService) {
+		s.eventPublisher = publisher
+	}
+}
+
+// publish notifies the event publisher, if any, about a user account change.
+// Publishing is best effort: failures are logged and never fail the caller.
+func (s *DefaultService) publish(ctx context.Context, eventType EventType, userID string) {
+	if s.eventPublisher == nil {
+		return
+	}
+
+	event := Event{
+		Type:       eventType,
+		UserID:     userID,
+		OccurredAt: s.now().UTC(),
+	}
+
+	if err := s.eventPublisher.Publish(ctx, event); err != nil {
+		s.logger.Error("could not publish user event",
+			zap.String("event_type", eventType.String()), zap.String("user_id", userID), zap.Error(err))
+	}
+}
@@ -0,0 +1,102 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWithEventPublisher(t *testing.T) {
+	t.Parallel()
+
+	givenPublisher := &eventPublisherMock{}
+
+	actual := New(zap.NewNop(), "jwt-secret", &repositoryMock{}, WithEventPublisher(givenPublisher))
+
+	assert.Equal(t, givenPublisher, actual.eventPublisher)
+}
+
+func TestPublish(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+
+	t.Run("event is published", func(t *testing.T) {
+		var published []Event
+
+		svc := DefaultService{
+			logger: zap.NewNop(),
+			eventPublisher: &eventPublisherMock{
+				publishFunc: func(ctx context.Context, event Event) error {
+					published = append(published, event)
+					return nil
+				},
+			},
+		}
+
+		svc.publish(context.Background(), EventUserCreated, givenUserID)
+
+		require.Len(t, published, 1)
+		assert.Equal(t, EventUserCreated, published[0].Type)
+		assert.Equal(t, givenUserID, published[0].UserID)
+		assert.False(t, published[0].OccurredAt.IsZero())
+	})
+
+	t.Run("publish error is not propagated", func(t *testing.T) {
+		svc := DefaultService{
+			logger: zap.NewNop(),
+			eventPublisher: &eventPublisherMock{
+				publishFunc: func(ctx context.Context, event Event) error {
+					return errors.New("some error")
+				},
+			},
+		}
+
+		assert.NotPanics(t, func() {
+			svc.publish(context.Background(), EventUserCreated, givenUserID)
+		})
+	})
+
+	t.Run("no publisher", func(t *testing.T) {
+		svc := DefaultService{}
+
+		assert.NotPanics(t, func() {
+			svc.publish(context.Background(), EventUserDeleted, givenUserID)
+		})
+	})
+}
+
+func TestDelete_publishesEvent(t *testing.T) {
+	t.Parallel()
+
+	givenID := uuid.New().String()
+
+	var published []Event
+
+	svc := DefaultService{
+		logger: zap.NewNop(),
+		repo: &repositoryMock{
+			deleteByIDFunc: func(ctx context.Context, id string) error {
+				return nil
+			},
+		},
+		eventPublisher: &eventPublisherMock{
+			publishFunc: func(ctx context.Context, event Event) error {
+				published = append(published, event)
+				return nil
+			},
+		},
+	}
+
+	err := svc.Delete(context.Background(), givenID)
+	require.NoError(t, err)
+
+	require.Len(t, published, 1)
+	assert.Equal(t, EventUserDeleted, published[0].Type)
+	assert.Equal(t, givenID, published[0].UserID)
+}
@@ -0,0 +1,99 @@
+package users
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultHashingQueue bounds the password hashes waiting for a worker by default
+	defaultHashingQueue = 256
+
+	// Enumerate the reasons bcrypt operations are rejected
+
+	hashingRejectedQueueFull = "queue_full"
+	hashingRejectedCanceled  = "canceled"
+)
+
+// hashingPool bounds the number of concurrent bcrypt operations, so that a burst of logins cannot take every core.
+// Operations wait for a worker in a bounded queue, and are rejected with ErrBusy once it is full.
+type hashingPool struct {
+	workers chan struct{}
+	queue   int64
+	waiting int64
+	metrics *Metrics
+}
+
+// WithHashingLimit runs at most concurrency bcrypt operations at a time, with at most queue operations
+// waiting for one to finish. By default, concurrency is half the available CPUs and queue is 256.
+// A concurrency of zero keeps the default, and a negative one runs bcrypt on the calling goroutine without limit.
+func WithHashingLimit(concurrency, queue int) ServiceOption {
+	return func(s *DefaultService) {
+		s.hashingConcurrency = concurrency
+		s.hashingQueue = queue
+	}
+}
+
+// defaultHashingConcurrency leaves half the CPUs to other requests
+func defaultHashingConcurrency() int {
+	if n := runtime.GOMAXPROCS(0) / 2; n > 1 {
+		return n
+	}
+	return 1
+}
+
+func newHashingPool(concurrency, queue int, m *Metrics) *hashingPool {
+	if concurrency < 0 {
+		return nil
+	}
+
+	if concurrency == 0 {
+		concurrency = defaultHashingConcurrency()
+	}
+
+	if queue < 0 {
+		queue = 0
+	}
+	return &hashingPool{workers: make(chan struct{}, concurrency), queue: int64(queue), metrics: m}
+}
+
+// do runs fn once a worker is available. It returns ErrBusy if the queue is full,
+// or the error of ctx if it is done before fn starts. A nil pool runs fn right away.
+func (p *hashingPool) do(ctx context.Context, operation string, fn func()) error {
+	if p == nil {
+		fn()
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	select {
+	case p.workers <- struct{}{}:
+	default:
+		if atomic.AddInt64(&p.waiting, 1) > p.queue {
+			atomic.AddInt64(&p.waiting, -1)
+			p.metrics.observeHashingRejected(operation, hashingRejectedQueueFull)
+			return ErrBusy
+		}
+
+		select {
+		case p.workers <- struct{}{}:
+			atomic.AddInt64(&p.waiting, -1)
+		case <-ctx.Done():
+			atomic.AddInt64(&p.waiting, -1)
+			p.metrics.observeHashingRejected(operation, hashingRejectedCanceled)
+			return ctx.Err()
+		}
+	}
+	defer func() { <-p.workers }()
+
+	p.metrics.observeHashingWait(operation, start)
+	fn()
+	return nil
+}
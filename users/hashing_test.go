@@ -0,0 +1,147 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashingPool_concurrency(t *testing.T) {
+	t.Parallel()
+
+	const (
+		concurrency = 2
+		callers     = 8
+	)
+
+	pool := newHashingPool(concurrency, callers, nil)
+
+	var running, maxRunning int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := pool.do(context.Background(), "hash", func() {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					peak := atomic.LoadInt32(&maxRunning)
+					if n <= peak || atomic.CompareAndSwapInt32(&maxRunning, peak, n) {
+						break
+					}
+				}
+
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(concurrency), atomic.LoadInt32(&maxRunning))
+}
+
+func TestHashingPool_rejections(t *testing.T) {
+	t.Parallel()
+
+	m, err := NewMetrics(prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	pool := newHashingPool(1, 1, m)
+
+	// Occupy the only worker
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	go func() {
+		_ = pool.do(context.Background(), "hash", func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+
+	// Fill the queue with a caller that gives up
+	ctx, cancel := context.WithCancel(context.Background())
+
+	queued := make(chan error)
+	go func() {
+		queued <- pool.do(ctx, "compare", func() { t.Error("canceled operation ran") })
+	}()
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&pool.waiting) == 1 }, time.Second, time.Millisecond)
+
+	err = pool.do(context.Background(), "compare", func() { t.Error("rejected operation ran") })
+	assert.Equal(t, ErrBusy, err)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-queued)
+
+	// Callers that already gave up are not queued
+	err = pool.do(ctx, "compare", func() { t.Error("canceled operation ran") })
+	assert.Equal(t, context.Canceled, err)
+
+	close(release)
+
+	require.Eventually(t, func() bool {
+		return pool.do(context.Background(), "hash", func() {}) == nil
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.bcryptRejected.WithLabelValues("compare", hashingRejectedQueueFull)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.bcryptRejected.WithLabelValues("compare", hashingRejectedCanceled)))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.bcryptWait))
+}
+
+func TestHashingPool_disabled(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, newHashingPool(-1, 10, nil))
+	assert.Equal(t, defaultHashingConcurrency(), cap(newHashingPool(0, 10, nil).workers))
+
+	var pool *hashingPool
+
+	var ran bool
+	require.NoError(t, pool.do(context.Background(), "hash", func() { ran = true }))
+	assert.True(t, ran)
+}
+
+func TestGenerateToken_busy(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password%&123"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	svc := New(nil, "secret", &repositoryMock{
+		selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+			return &repository.User{ID: "8b3a2f7e-1c2d-4e5f-8a9b-0c1d2e3f4a5b", PasswordHash: string(hash), Role: "user"}, nil
+		},
+	}, WithHashingLimit(1, 0))
+
+	// Occupy the only worker, with no room in the queue
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	go func() {
+		_ = svc.hashingPool.do(context.Background(), "hash", func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+	defer close(release)
+
+	_, err = svc.GenerateToken(context.Background(), "jdoe@mail.com", "password%&123")
+	assert.True(t, errors.Is(err, ErrBusy), err)
+}
@@ -0,0 +1,145 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alesr/stdservices/pkg/validate"
+)
+
+// defaultLoaderWait is how long a Loader waits for more ids before fetching a batch
+const defaultLoaderWait = time.Millisecond
+
+type (
+	// Loader coalesces the users fetched concurrently during a request into FetchByIDs calls,
+	// and remembers them until the request ends. A loader must not outlive its request:
+	// changes made to users after they are loaded are not seen.
+	Loader struct {
+		ctx      context.Context
+		svc      Service
+		wait     time.Duration
+		maxBatch int
+
+		mu      sync.Mutex
+		results map[string]*loaderResult
+		batch   *loaderBatch
+	}
+
+	loaderResult struct {
+		done chan struct{}
+		user *User
+		err  error
+	}
+
+	loaderBatch struct {
+		ids        []string
+		results    []*loaderResult
+		dispatched bool
+	}
+)
+
+// NewLoader returns a loader fetching users from svc with ctx, the context of the request
+func NewLoader(ctx context.Context, svc Service) *Loader {
+	return &Loader{
+		ctx:      ctx,
+		svc:      svc,
+		wait:     defaultLoaderWait,
+		maxBatch: maxFetchByIDs,
+		results:  make(map[string]*loaderResult),
+	}
+}
+
+// Load returns the user with the given id, or ErrNotFound if it does not exist or is deleted.
+// Loads made within a millisecond of each other share a single FetchByIDs call.
+func (l *Loader) Load(ctx context.Context, id string) (*User, error) {
+	// Invalid ids are rejected here, so that they do not fail the batch of other callers
+	if err := validate.ID(id); err != nil {
+		return nil, fmt.Errorf("could not validate id: %w", newValidationE("id", err.Error()))
+	}
+
+	// Loads are keyed by the canonical id, so that every form of an id shares its result
+	id = canonicalID(id)
+
+	l.mu.Lock()
+	res, ok := l.results[id]
+	if !ok {
+		res = &loaderResult{done: make(chan struct{})}
+		l.results[id] = res
+		l.enqueue(id, res)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-res.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	// Callers get copies, since the user is shared by every load of its id
+	user := *res.user
+	return &user, nil
+}
+
+// enqueue adds an id to the pending batch, starting a new batch if there is none.
+// It must be called with l.mu held.
+func (l *Loader) enqueue(id string, res *loaderResult) {
+	if l.batch == nil {
+		batch := &loaderBatch{}
+		l.batch = batch
+		time.AfterFunc(l.wait, func() { l.dispatch(batch) })
+	}
+
+	l.batch.ids = append(l.batch.ids, id)
+	l.batch.results = append(l.batch.results, res)
+
+	if len(l.batch.ids) >= l.maxBatch {
+		go l.dispatch(l.batch)
+		l.batch = nil
+	}
+}
+
+// dispatch fetches the users of a batch once, either when it is full or when its wait elapses
+func (l *Loader) dispatch(batch *loaderBatch) {
+	l.mu.Lock()
+	if batch.dispatched {
+		l.mu.Unlock()
+		return
+	}
+	batch.dispatched = true
+
+	if l.batch == batch {
+		l.batch = nil
+	}
+	l.mu.Unlock()
+
+	users, err := l.svc.FetchByIDs(l.ctx, batch.ids)
+
+	if err != nil {
+		// Failed ids are forgotten, so that later loads retry them
+		l.mu.Lock()
+		for _, id := range batch.ids {
+			delete(l.results, id)
+		}
+		l.mu.Unlock()
+	}
+
+	for i, id := range batch.ids {
+		res := batch.results[i]
+
+		switch user, ok := users[id]; {
+		case err != nil:
+			res.err = fmt.Errorf("could not fetch users by ids: %w", err)
+		case !ok:
+			res.err = ErrNotFound
+		default:
+			res.user = user
+		}
+		close(res.done)
+	}
+}
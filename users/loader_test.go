@@ -0,0 +1,210 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoader_Load(t *testing.T) {
+	t.Parallel()
+
+	const callers = 10
+
+	ids := make([]string, callers)
+	for i := range ids {
+		ids[i] = uuid.NewString()
+	}
+	unknownID := uuid.NewString()
+
+	var (
+		mu      sync.Mutex
+		batches [][]string
+	)
+
+	svc := &MockService{
+		FetchByIDsFunc: func(ctx context.Context, ids []string) (map[string]*User, error) {
+			mu.Lock()
+			batches = append(batches, ids)
+			mu.Unlock()
+
+			res := make(map[string]*User, len(ids))
+			for _, id := range ids {
+				if id != unknownID {
+					res[id] = &User{ID: id}
+				}
+			}
+			return res, nil
+		},
+	}
+
+	l := NewLoader(context.Background(), svc)
+	l.wait = 50 * time.Millisecond
+
+	var wg sync.WaitGroup
+	for _, id := range append(ids, ids[0], unknownID) {
+		id := id
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			user, err := l.Load(context.Background(), id)
+			if id == unknownID {
+				assert.Equal(t, ErrNotFound, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, id, user.ID)
+		}()
+	}
+	wg.Wait()
+
+	// Concurrent loads share a single call, with each id once
+	require.Len(t, batches, 1)
+	assert.ElementsMatch(t, append(ids, unknownID), batches[0])
+
+	// Loaded users are remembered, and callers get copies
+	user, err := l.Load(context.Background(), ids[0])
+	require.NoError(t, err)
+	user.Username = "changed"
+
+	user, err = l.Load(context.Background(), ids[0])
+	require.NoError(t, err)
+	assert.Empty(t, user.Username)
+	assert.Len(t, batches, 1)
+}
+
+func TestLoader_idForms(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewString()
+
+	var calls int32
+
+	// The real service, so that ids are canonicalized like in production
+	svc := &DefaultService{repo: &repositoryMock{
+		selectByIDsFunc: func(ctx context.Context, ids []string) ([]repository.User, error) {
+			atomic.AddInt32(&calls, 1)
+			assert.Equal(t, []string{id}, ids)
+			return []repository.User{{ID: id, Role: "user"}}, nil
+		},
+	}}
+
+	l := NewLoader(context.Background(), svc)
+	l.wait = 50 * time.Millisecond
+
+	var wg sync.WaitGroup
+	for _, given := range []string{id, strings.ToUpper(id), "{" + id + "}", "urn:uuid:" + id} {
+		given := given
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			user, err := l.Load(context.Background(), given)
+			require.NoError(t, err)
+			assert.Equal(t, id, user.ID)
+		}()
+	}
+	wg.Wait()
+
+	// Every form of the id shares a single load
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestLoader_maxBatch(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu      sync.Mutex
+		batches [][]string
+	)
+
+	svc := &MockService{
+		FetchByIDsFunc: func(ctx context.Context, ids []string) (map[string]*User, error) {
+			mu.Lock()
+			batches = append(batches, ids)
+			mu.Unlock()
+
+			res := make(map[string]*User, len(ids))
+			for _, id := range ids {
+				res[id] = &User{ID: id}
+			}
+			return res, nil
+		},
+	}
+
+	l := NewLoader(context.Background(), svc)
+	l.wait = time.Hour
+	l.maxBatch = 2
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := l.Load(context.Background(), uuid.NewString())
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// Full batches are fetched without waiting
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[1], 2)
+}
+
+func TestLoader_errors(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewString()
+	fetchErr := errors.New("some error")
+
+	var calls int
+
+	svc := &MockService{
+		FetchByIDsFunc: func(ctx context.Context, ids []string) (map[string]*User, error) {
+			calls++
+			if calls == 1 {
+				return nil, fetchErr
+			}
+			return map[string]*User{id: {ID: id}}, nil
+		},
+	}
+
+	l := NewLoader(context.Background(), svc)
+
+	_, err := l.Load(context.Background(), "%invalid-id%")
+	assert.Equal(t, string(CodeValidationFailed), errorCode(err))
+	assert.Zero(t, calls)
+
+	_, err = l.Load(context.Background(), id)
+	assert.True(t, errors.Is(err, fetchErr), err)
+
+	// Failed ids are fetched again
+	user, err := l.Load(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, id, user.ID)
+	assert.Equal(t, 2, calls)
+
+	// Callers that give up do not wait for the batch
+	l.wait = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = l.Load(ctx, uuid.NewString())
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,495 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Enumerate the outcomes of verification emails
+
+	emailOutcomeSent        = "sent"
+	emailOutcomeFailed      = "failed"
+	emailOutcomeRateLimited = "rate_limited"
+)
+
+var (
+	_ Service = (*metricsService)(nil)
+	_ repo    = (*metricsRepo)(nil)
+)
+
+// Metrics holds the Prometheus collectors of the users service.
+// Pass it to WithMetrics to instrument the repository, password hashing and verification emails,
+// and to NewMetricsService to instrument the service methods.
+type Metrics struct {
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	queries         *prometheus.CounterVec
+	queryDuration   *prometheus.HistogramVec
+	bcryptDuration  *prometheus.HistogramVec
+	bcryptWait      *prometheus.HistogramVec
+	bcryptRejected  *prometheus.CounterVec
+	emails          *prometheus.CounterVec
+	cacheLookups    *prometheus.CounterVec
+}
+
+// NewMetrics creates the collectors of the users service and registers them to reg
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	m := Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "users",
+			Subsystem: "service",
+			Name:      "requests_total",
+			Help:      "Number of service calls by method and error code, ok on success.",
+		}, []string{"method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "users",
+			Subsystem: "service",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of service calls by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "users",
+			Subsystem: "repository",
+			Name:      "queries_total",
+			Help:      "Number of repository calls by method and error code, ok on success.",
+		}, []string{"method", "code"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "users",
+			Subsystem: "repository",
+			Name:      "query_duration_seconds",
+			Help:      "Duration of repository calls by method.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"method"}),
+		bcryptDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "users",
+			Name:      "bcrypt_duration_seconds",
+			Help:      "Duration of password hashing and comparison by operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 10),
+		}, []string{"operation"}),
+		bcryptWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "users",
+			Name:      "bcrypt_queue_wait_seconds",
+			Help:      "Time bcrypt operations waited for a worker, by operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"operation"}),
+		bcryptRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "users",
+			Name:      "bcrypt_rejected_total",
+			Help:      "Number of bcrypt operations rejected by operation and reason: queue_full or canceled.",
+		}, []string{"operation", "reason"}),
+		emails: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "users",
+			Name:      "verification_emails_total",
+			Help:      "Number of verification emails by outcome: sent, failed or rate_limited.",
+		}, []string{"outcome"}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "users",
+			Subsystem: "cache",
+			Name:      "lookups_total",
+			Help:      "Number of user cache lookups by result: hit or miss.",
+		}, []string{"result"}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		m.requests, m.requestDuration, m.queries, m.queryDuration,
+		m.bcryptDuration, m.bcryptWait, m.bcryptRejected, m.emails, m.cacheLookups,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("could not register users metrics: %s", err)
+		}
+	}
+	return &m, nil
+}
+
+// WithMetrics records repository calls, password hashing durations and queueing, verification email outcomes
+// and user cache lookups
+func WithMetrics(m *Metrics) ServiceOption {
+	return func(s *DefaultService) {
+		s.metrics = m
+	}
+}
+
+// observeRequest records a service call started at start
+func (m *Metrics) observeRequest(method string, start time.Time, err error) {
+	m.requests.WithLabelValues(method, errorCode(err)).Inc()
+	m.requestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// observeQuery records a repository call started at start
+func (m *Metrics) observeQuery(method string, start time.Time, err error) {
+	code := "ok"
+	switch {
+	case errors.Is(err, repository.ErrRecordNotFound):
+		code = "not_found"
+	case errors.Is(err, repository.ErrDuplicateRecord):
+		code = "duplicate"
+	case err != nil:
+		code = "error"
+	}
+
+	m.queries.WithLabelValues(method, code).Inc()
+	m.queryDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// observeBcrypt records a bcrypt operation started at start. It is a no-op on nil metrics.
+func (m *Metrics) observeBcrypt(operation string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.bcryptDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// observeHashingWait records the time a bcrypt operation started at start waited for a worker.
+// It is a no-op on nil metrics.
+func (m *Metrics) observeHashingWait(operation string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.bcryptWait.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// observeHashingRejected records a bcrypt operation that never ran. It is a no-op on nil metrics.
+func (m *Metrics) observeHashingRejected(operation, reason string) {
+	if m == nil {
+		return
+	}
+	m.bcryptRejected.WithLabelValues(operation, reason).Inc()
+}
+
+// observeEmail records the outcome of a verification email. It is a no-op on nil metrics.
+func (m *Metrics) observeEmail(outcome string) {
+	if m == nil {
+		return
+	}
+	m.emails.WithLabelValues(outcome).Inc()
+}
+
+// observeCache records the result of a user cache lookup. It is a no-op on nil metrics.
+func (m *Metrics) observeCache(result string) {
+	if m == nil {
+		return
+	}
+	m.cacheLookups.WithLabelValues(result).Inc()
+}
+
+// errorCode returns the code of a service error, ok for nil and internal for unexpected errors
+func errorCode(err error) string {
+	if err == nil {
+		return "ok"
+	}
+
+	var e E
+	if errors.As(err, &e) {
+		return string(e.code)
+	}
+	return "internal"
+}
+
+type metricsService struct {
+	next    Service
+	metrics *Metrics
+}
+
+// NewMetricsService returns a Service recording the calls to next, by method and error code
+func NewMetricsService(next Service, m *Metrics) Service {
+	return &metricsService{next: next, metrics: m}
+}
+
+func (s *metricsService) Create(ctx context.Context, in CreateUserInput) (*User, error) {
+	start := time.Now()
+	user, err := s.next.Create(ctx, in)
+	s.metrics.observeRequest("Create", start, err)
+	return user, err
+}
+
+func (s *metricsService) Delete(ctx context.Context, id string) error {
+	start := time.Now()
+	err := s.next.Delete(ctx, id)
+	s.metrics.observeRequest("Delete", start, err)
+	return err
+}
+
+func (s *metricsService) FetchByID(ctx context.Context, id string) (*User, error) {
+	start := time.Now()
+	user, err := s.next.FetchByID(ctx, id)
+	s.metrics.observeRequest("FetchByID", start, err)
+	return user, err
+}
+
+func (s *metricsService) FetchByIDs(ctx context.Context, ids []string) (map[string]*User, error) {
+	start := time.Now()
+	users, err := s.next.FetchByIDs(ctx, ids)
+	s.metrics.observeRequest("FetchByIDs", start, err)
+	return users, err
+}
+
+func (s *metricsService) GenerateToken(ctx context.Context, email, password string) (string, error) {
+	start := time.Now()
+	token, err := s.next.GenerateToken(ctx, email, password)
+	s.metrics.observeRequest("GenerateToken", start, err)
+	return token, err
+}
+
+func (s *metricsService) VerifyToken(ctx context.Context, token string) (*VerifyTokenResponse, error) {
+	start := time.Now()
+	res, err := s.next.VerifyToken(ctx, token)
+	s.metrics.observeRequest("VerifyToken", start, err)
+	return res, err
+}
+
+func (s *metricsService) SendEmailVerification(ctx context.Context, userID, username, to string) error {
+	start := time.Now()
+	err := s.next.SendEmailVerification(ctx, userID, username, to)
+	s.metrics.observeRequest("SendEmailVerification", start, err)
+	return err
+}
+
+func (s *metricsService) VerifyEmail(ctx context.Context, token string) error {
+	start := time.Now()
+	err := s.next.VerifyEmail(ctx, token)
+	s.metrics.observeRequest("VerifyEmail", start, err)
+	return err
+}
+
+func (s *metricsService) VerifyEmailCode(ctx context.Context, userID, code string) error {
+	start := time.Now()
+	err := s.next.VerifyEmailCode(ctx, userID, code)
+	s.metrics.observeRequest("VerifyEmailCode", start, err)
+	return err
+}
+
+func (s *metricsService) GetVerificationStatus(ctx context.Context, userID string) (*VerificationStatus, error) {
+	start := time.Now()
+	status, err := s.next.GetVerificationStatus(ctx, userID)
+	s.metrics.observeRequest("GetVerificationStatus", start, err)
+	return status, err
+}
+
+func (s *metricsService) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	start := time.Now()
+	sessions, err := s.next.ListSessions(ctx, userID)
+	s.metrics.observeRequest("ListSessions", start, err)
+	return sessions, err
+}
+
+func (s *metricsService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	start := time.Now()
+	err := s.next.RevokeSession(ctx, userID, sessionID)
+	s.metrics.observeRequest("RevokeSession", start, err)
+	return err
+}
+
+func (s *metricsService) RevokeOtherSessions(ctx context.Context, userID, currentSessionID string) error {
+	start := time.Now()
+	err := s.next.RevokeOtherSessions(ctx, userID, currentSessionID)
+	s.metrics.observeRequest("RevokeOtherSessions", start, err)
+	return err
+}
+
+// metricsRepo records the calls to the repository of a service built with WithMetrics
+type metricsRepo struct {
+	next    repo
+	metrics *Metrics
+}
+
+func (r *metricsRepo) Insert(ctx context.Context, user *repository.User) (*repository.User, error) {
+	start := time.Now()
+	res, err := r.next.Insert(ctx, user)
+	r.metrics.observeQuery("Insert", start, err)
+	return res, err
+}
+
+func (r *metricsRepo) SelectByID(ctx context.Context, id string) (*repository.User, error) {
+	start := time.Now()
+	res, err := r.next.SelectByID(ctx, id)
+	r.metrics.observeQuery("SelectByID", start, err)
+	return res, err
+}
+
+func (r *metricsRepo) SelectByIDs(ctx context.Context, ids []string) ([]repository.User, error) {
+	start := time.Now()
+	res, err := r.next.SelectByIDs(ctx, ids)
+	r.metrics.observeQuery("SelectByIDs", start, err)
+	return res, err
+}
+
+func (r *metricsRepo) SelectByEmail(ctx context.Context, email string) (*repository.User, error) {
+	start := time.Now()
+	res, err := r.next.SelectByEmail(ctx, email)
+	r.metrics.observeQuery("SelectByEmail", start, err)
+	return res, err
+}
+
+func (r *metricsRepo) DeleteByID(ctx context.Context, id string) error {
+	start := time.Now()
+	err := r.next.DeleteByID(ctx, id)
+	r.metrics.observeQuery("DeleteByID", start, err)
+	return err
+}
+
+func (r *metricsRepo) SelectUsers(ctx context.Context, filter repository.UserFilter) ([]repository.User, error) {
+	start := time.Now()
+	res, err := r.next.SelectUsers(ctx, filter)
+	r.metrics.observeQuery("SelectUsers", start, err)
+	return res, err
+}
+
+func (r *metricsRepo) RestoreByID(ctx context.Context, id string, updatedAt time.Time) error {
+	start := time.Now()
+	err := r.next.RestoreByID(ctx, id, updatedAt)
+	r.metrics.observeQuery("RestoreByID", start, err)
+	return err
+}
+
+func (r *metricsRepo) UpdateRole(ctx context.Context, id, role string, updatedAt time.Time) error {
+	start := time.Now()
+	err := r.next.UpdateRole(ctx, id, role, updatedAt)
+	r.metrics.observeQuery("UpdateRole", start, err)
+	return err
+}
+
+func (r *metricsRepo) UpdatePasswordHash(ctx context.Context, id, passwordHash string, updatedAt time.Time) error {
+	start := time.Now()
+	err := r.next.UpdatePasswordHash(ctx, id, passwordHash, updatedAt)
+	r.metrics.observeQuery("UpdatePasswordHash", start, err)
+	return err
+}
+
+func (r *metricsRepo) UpdateEmailVerified(ctx context.Context, id string, updatedAt time.Time) error {
+	start := time.Now()
+	err := r.next.UpdateEmailVerified(ctx, id, updatedAt)
+	r.metrics.observeQuery("UpdateEmailVerified", start, err)
+	return err
+}
+
+func (r *metricsRepo) RevokeTokens(ctx context.Context, id string, revokedAt time.Time) error {
+	start := time.Now()
+	err := r.next.RevokeTokens(ctx, id, revokedAt)
+	r.metrics.observeQuery("RevokeTokens", start, err)
+	return err
+}
+
+func (r *metricsRepo) InsertEmailVerification(
+	ctx context.Context, in repository.EmailVerification, since time.Time,
+	check func(a *repository.EmailVerificationActivity) error,
+) error {
+	// Inserts denied by check are not failed queries
+	var checkErr error
+	if check != nil {
+		next := check
+		check = func(a *repository.EmailVerificationActivity) error {
+			checkErr = next(a)
+			return checkErr
+		}
+	}
+
+	start := time.Now()
+	err := r.next.InsertEmailVerification(ctx, in, since, check)
+
+	queryErr := err
+	if checkErr != nil && err == checkErr {
+		queryErr = nil
+	}
+	r.metrics.observeQuery("InsertEmailVerification", start, queryErr)
+	return err
+}
+
+func (r *metricsRepo) SelectEmailVerification(ctx context.Context, tokenHash string) (*repository.EmailVerification, error) {
+	start := time.Now()
+	res, err := r.next.SelectEmailVerification(ctx, tokenHash)
+	r.metrics.observeQuery("SelectEmailVerification", start, err)
+	return res, err
+}
+
+func (r *metricsRepo) SelectLatestEmailVerification(ctx context.Context, userID string) (*repository.EmailVerification, error) {
+	start := time.Now()
+	res, err := r.next.SelectLatestEmailVerification(ctx, userID)
+	r.metrics.observeQuery("SelectLatestEmailVerification", start, err)
+	return res, err
+}
+
+func (r *metricsRepo) IncrementEmailVerificationAttempts(ctx context.Context, tokenHash string) (int, error) {
+	start := time.Now()
+	res, err := r.next.IncrementEmailVerificationAttempts(ctx, tokenHash)
+	r.metrics.observeQuery("IncrementEmailVerificationAttempts", start, err)
+	return res, err
+}
+
+func (r *metricsRepo) ConfirmEmailVerification(ctx context.Context, tokenHash string, verifiedAt time.Time) error {
+	start := time.Now()
+	err := r.next.ConfirmEmailVerification(ctx, tokenHash, verifiedAt)
+	r.metrics.observeQuery("ConfirmEmailVerification", start, err)
+	return err
+}
+
+func (r *metricsRepo) SelectEmailVerificationActivity(
+	ctx context.Context, userID, email string, since time.Time,
+) (*repository.EmailVerificationActivity, error) {
+	start := time.Now()
+	res, err := r.next.SelectEmailVerificationActivity(ctx, userID, email, since)
+	r.metrics.observeQuery("SelectEmailVerificationActivity", start, err)
+	return res, err
+}
+
+func (r *metricsRepo) DeleteExpiredEmailVerifications(ctx context.Context, expiredBefore, createdBefore time.Time) (int, error) {
+	start := time.Now()
+	res, err := r.next.DeleteExpiredEmailVerifications(ctx, expiredBefore, createdBefore)
+	r.metrics.observeQuery("DeleteExpiredEmailVerifications", start, err)
+	return res, err
+}
+
+func (r *metricsRepo) InsertSession(ctx context.Context, in repository.Session) error {
+	start := time.Now()
+	err := r.next.InsertSession(ctx, in)
+	r.metrics.observeQuery("InsertSession", start, err)
+	return err
+}
+
+func (r *metricsRepo) SelectSession(ctx context.Context, id string) (*repository.Session, error) {
+	start := time.Now()
+	res, err := r.next.SelectSession(ctx, id)
+	r.metrics.observeQuery("SelectSession", start, err)
+	return res, err
+}
+
+func (r *metricsRepo) SelectActiveSessions(ctx context.Context, userID string, now time.Time) ([]repository.Session, error) {
+	start := time.Now()
+	res, err := r.next.SelectActiveSessions(ctx, userID, now)
+	r.metrics.observeQuery("SelectActiveSessions", start, err)
+	return res, err
+}
+
+func (r *metricsRepo) TouchSession(ctx context.Context, id string, lastSeenAt time.Time) error {
+	start := time.Now()
+	err := r.next.TouchSession(ctx, id, lastSeenAt)
+	r.metrics.observeQuery("TouchSession", start, err)
+	return err
+}
+
+func (r *metricsRepo) RevokeSession(ctx context.Context, id, userID string, revokedAt time.Time) error {
+	start := time.Now()
+	err := r.next.RevokeSession(ctx, id, userID, revokedAt)
+	r.metrics.observeQuery("RevokeSession", start, err)
+	return err
+}
+
+func (r *metricsRepo) RevokeOtherSessions(ctx context.Context, userID, keepID string, revokedAt time.Time) (int, error) {
+	start := time.Now()
+	res, err := r.next.RevokeOtherSessions(ctx, userID, keepID, revokedAt)
+	r.metrics.observeQuery("RevokeOtherSessions", start, err)
+	return res, err
+}
+
+func (r *metricsRepo) DeleteExpiredSessions(ctx context.Context, expiredBefore time.Time) (int, error) {
+	start := time.Now()
+	res, err := r.next.DeleteExpiredSessions(ctx, expiredBefore)
+	r.metrics.observeQuery("DeleteExpiredSessions", start, err)
+	return res, err
+}
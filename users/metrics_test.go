@@ -0,0 +1,114 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestNewMetrics(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+
+	_, err := NewMetrics(reg)
+	require.NoError(t, err)
+
+	_, err = NewMetrics(reg)
+	assert.ErrorContains(t, err, "could not register users metrics")
+}
+
+func TestNewMetricsService(t *testing.T) {
+	t.Parallel()
+
+	m, err := NewMetrics(prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	var fetchErr error
+
+	svc := NewMetricsService(&MockService{
+		FetchByIDFunc: func(ctx context.Context, id string) (*User, error) {
+			if fetchErr != nil {
+				return nil, fetchErr
+			}
+			return &User{ID: id}, nil
+		},
+	}, m)
+
+	for _, givenErr := range []error{nil, nil, ErrNotFound, fmt.Errorf("could not select user: %w", ErrNotFound), errors.New("boom")} {
+		fetchErr = givenErr
+
+		_, err := svc.FetchByID(context.Background(), "1")
+		assert.Equal(t, givenErr, err)
+	}
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.requests.WithLabelValues("FetchByID", "ok")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.requests.WithLabelValues("FetchByID", string(CodeNotFound))))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.requests.WithLabelValues("FetchByID", "internal")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.requestDuration))
+}
+
+func TestWithMetrics(t *testing.T) {
+	t.Parallel()
+
+	m, err := NewMetrics(prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password%&123"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	userID := "8b3a2f7e-1c2d-4e5f-8a9b-0c1d2e3f4a5b"
+
+	var sendErr error
+
+	svc := New(nil, "secret", &repositoryMock{
+		selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+			return &repository.User{ID: userID, Email: email, PasswordHash: string(hash), Role: "user"}, nil
+		},
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return nil, fmt.Errorf("could not select user: %w", repository.ErrRecordNotFound)
+		},
+		selectEmailVerificationActivityFunc: func(ctx context.Context, userID, email string, since time.Time) (*repository.EmailVerificationActivity, error) {
+			return &repository.EmailVerificationActivity{}, nil
+		},
+		insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
+			return nil
+		},
+		insertSessionFunc: func(ctx context.Context, in repository.Session) error {
+			return nil
+		},
+	},
+		WithMetrics(m),
+		WithEmailVerificationRateLimit(0, 0, 0),
+		WithEmailVerification("test-app", "noreply@test-app.com", "https://test-app.com/verify", &emailerMock{
+			sendFunc: func(from, to string, body []byte) error { return sendErr },
+		}),
+	)
+
+	_, err = svc.GenerateToken(context.Background(), "jdoe@mail.com", "password%&123")
+	require.NoError(t, err)
+
+	_, err = svc.FetchByID(context.Background(), userID)
+	require.Error(t, err)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.queries.WithLabelValues("SelectByEmail", "ok")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.queries.WithLabelValues("SelectByID", "not_found")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.bcryptDuration))
+
+	require.NoError(t, svc.sendEmailVerification(context.Background(), userID, "jdoe", "jdoe@mail.com", "en"))
+
+	sendErr = errors.New("connection refused")
+	require.Error(t, svc.sendEmailVerification(context.Background(), userID, "jdoe", "jdoe@mail.com", "en"))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.emails.WithLabelValues(emailOutcomeSent)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.emails.WithLabelValues(emailOutcomeFailed)))
+}
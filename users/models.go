@@ -3,6 +3,7 @@ package users
 import (
 	"time"
 
+	"github.com/alesr/stdservices/pkg/locale"
 	"github.com/alesr/stdservices/pkg/validate"
 )
 
@@ -15,6 +16,9 @@ const (
 
 type VerifyTokenResponse struct {
 	ID, Username, Role string
+	EmailVerified      bool
+	// SessionID is the session the token was issued with, empty for tokens issued before sessions
+	SessionID string
 }
 
 type role string
@@ -23,28 +27,140 @@ func (r role) String() string {
 	return string(r)
 }
 
+// parseRole returns the role named s
+func parseRole(s string) (role, error) {
+	switch r := role(s); r {
+	case RoleUser, RoleAdmin:
+		return r, nil
+	default:
+		return "", ErrRoleInvalid
+	}
+}
+
 func (r role) validate() error {
 	switch r {
 	case RoleUser:
 		return nil
 	case RoleAdmin:
-		return errForbidenRole
+		return ErrForbiddenRole
 	default:
-		return errRoleInvalid
+		return ErrRoleInvalid
 	}
 }
 
+// inputPolicies groups the configurable rules applied to user inputs
+type inputPolicies struct {
+	username validate.UsernamePolicy
+	password validate.PasswordPolicy
+	email    validate.EmailPolicy
+	age      validate.AgePolicy
+}
+
+// Birthdate is a calendar date of birth, without time of day nor time zone
+type Birthdate struct {
+	year  int
+	month time.Month
+	day   int
+}
+
+// ParseBirthdate parses a birthdate in the format YYYY-MM-DD
+func ParseBirthdate(s string) (Birthdate, error) {
+	t, err := time.Parse(validate.BirthdateLayout, s)
+	if err != nil {
+		return Birthdate{}, err
+	}
+	return newBirthdate(t), nil
+}
+
+// newBirthdate returns the calendar date of t in its location
+func newBirthdate(t time.Time) Birthdate {
+	year, month, day := t.Date()
+	return Birthdate{year: year, month: month, day: day}
+}
+
+// IsZero reports whether the birthdate is unset
+func (b Birthdate) IsZero() bool {
+	return b == Birthdate{}
+}
+
+// Time returns the birthdate at midnight UTC
+func (b Birthdate) Time() time.Time {
+	return time.Date(b.year, b.month, b.day, 0, 0, 0, 0, time.UTC)
+}
+
+// AgeAt returns the age in full years on the calendar date of now, see validate.Age
+func (b Birthdate) AgeAt(now time.Time) int {
+	return validate.Age(b.Time(), now)
+}
+
+func (b Birthdate) String() string {
+	if b.IsZero() {
+		return ""
+	}
+	return b.Time().Format(validate.BirthdateLayout)
+}
+
+// MarshalText encodes the birthdate in the format YYYY-MM-DD
+func (b Birthdate) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// UnmarshalText decodes a birthdate in the format YYYY-MM-DD
+func (b *Birthdate) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*b = Birthdate{}
+		return nil
+	}
+
+	parsed, err := ParseBirthdate(string(text))
+	if err != nil {
+		return err
+	}
+	*b = parsed
+	return nil
+}
+
+// VerificationStatus describes the email verification state of a user
+type VerificationStatus struct {
+	EmailVerified bool
+
+	// Pending is set when a verification that can still be used was sent, until ExpiresAt
+	Pending   bool
+	ExpiresAt time.Time
+
+	// LastSentAt is when the latest outstanding verification was sent, zero if there is none
+	LastSentAt time.Time
+
+	// NextSendAt is the earliest time a new verification can be sent, zero if it can be sent now
+	NextSendAt time.Time
+}
+
 // User represents a user domain model
 type User struct {
 	ID            string
 	Fullname      string
 	Username      string
-	Birthdate     string
+	Birthdate     Birthdate
 	Email         string
 	EmailVerified bool
 	Role          role
+	Locale        string
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
+	// DeletedAt is zero unless the user is deleted
+	DeletedAt time.Time
+}
+
+// ListUsersInput selects the users returned by AdminService.List
+type ListUsersInput struct {
+	// Query matches a substring of the username, email or full name, case insensitively, or the id
+	Query string
+	// Role matches the role of users, any role if empty
+	Role           string
+	IncludeDeleted bool
+	// Limit defaults to 50 and is capped at 1000
+	Limit  int
+	Offset int
 }
 
 // CreateUserInput represents the input data for creating a user
@@ -55,31 +171,25 @@ type CreateUserInput struct {
 	Email           string
 	Password        string
 	ConfirmPassword string
+	// Locale is the preferred language of the user, either a BCP 47 tag or an
+	// Accept-Language header value. It is resolved to a supported language.
+	Locale string
 }
 
-func (in *CreateUserInput) validate() error {
-	if err := validate.Fullname(in.Fullname); err != nil {
-		return newE(err.Error())
-	}
-
-	if err := validate.Fullname(in.Username); err != nil {
-		return newE(err.Error())
-	}
-
-	if err := validate.Birthdate(in.Birthdate); err != nil {
-		return newE(err.Error())
-	}
-
-	if err := validate.Email(in.Email); err != nil {
-		return newE(err.Error())
-	}
-
-	if err := validate.Password(in.Password); err != nil {
-		return newE(err.Error())
-	}
-
-	if in.Password != in.ConfirmPassword {
-		return errPasswordMismatch
+// validate validates every field of the input and reports all failures at once,
+// in the language of the input locale. Ages are computed relative to now.
+func (in *CreateUserInput) validate(policies inputPolicies, now time.Time) error {
+	errs := validate.New().
+		Field("fullname", in.Fullname, validate.Fullname).
+		Field("username", in.Username, policies.username.Validate).
+		Check("birthdate", policies.age.ValidateAt(in.Birthdate, now)).
+		Field("email", in.Email, policies.email.Validate).
+		Check("password", policies.password.Validate(in.Password, in.Username, in.Email, in.Fullname)).
+		Check("confirm_password", validate.PasswordConfirmation(in.Password, in.ConfirmPassword)).
+		Errors()
+
+	if len(errs) > 0 {
+		return newValidationErrorsE(errs, locale.Resolve(in.Locale))
 	}
 	return nil
 }
@@ -1,9 +1,14 @@
 package users
 
 import (
+	"encoding/json"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/alesr/stdservices/pkg/validate"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCreateUserInput_validate(t *testing.T) {
@@ -50,6 +55,30 @@ func TestCreateUserInput_validate(t *testing.T) {
 			},
 			expectedError: true,
 		},
+		{
+			name: "username with spaces",
+			given: CreateUserInput{
+				Fullname:        "John Doe",
+				Username:        "john doe",
+				Birthdate:       "1990-01-01",
+				Email:           "joedoe@mail.com",
+				Password:        "1234%6abc",
+				ConfirmPassword: "1234%6abc",
+			},
+			expectedError: true,
+		},
+		{
+			name: "reserved username",
+			given: CreateUserInput{
+				Fullname:        "John Doe",
+				Username:        "аdmin",
+				Birthdate:       "1990-01-01",
+				Email:           "joedoe@mail.com",
+				Password:        "1234%6abc",
+				ConfirmPassword: "1234%6abc",
+			},
+			expectedError: true,
+		},
 		{
 			name: "missing birthdate",
 			given: CreateUserInput{
@@ -98,6 +127,18 @@ func TestCreateUserInput_validate(t *testing.T) {
 			},
 			expectedError: true,
 		},
+		{
+			name: "password contains username",
+			given: CreateUserInput{
+				Fullname:        "John Doe",
+				Username:        "johndoe",
+				Birthdate:       "1990-01-01",
+				Email:           "joedoe@mail.com",
+				Password:        "johndoe#1990",
+				ConfirmPassword: "johndoe#1990",
+			},
+			expectedError: true,
+		},
 		{
 			name: "password mismatch",
 			given: CreateUserInput{
@@ -114,7 +155,7 @@ func TestCreateUserInput_validate(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			actual := tc.given.validate()
+			actual := tc.given.validate(inputPolicies{password: validate.DefaultPasswordPolicy}, time.Now())
 
 			if tc.expectedError {
 				assert.Error(t, actual)
@@ -124,3 +165,148 @@ func TestCreateUserInput_validate(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateUserInput_validate_localized(t *testing.T) {
+	t.Parallel()
+
+	given := CreateUserInput{
+		Fullname:        "John Doe",
+		Username:        "johndoe",
+		Birthdate:       "1990-01-01",
+		Email:           "joedoe@mail.com",
+		Password:        "1234%6abc",
+		ConfirmPassword: "1234%6zzzz",
+	}
+
+	testCases := []struct {
+		name          string
+		givenLocale   string
+		expectedError error
+	}{
+		{
+			name:          "default language",
+			givenLocale:   "",
+			expectedError: newValidationE("confirm_password", "password confirmation does not match"),
+		},
+		{
+			name:          "portuguese",
+			givenLocale:   "pt-BR,pt;q=0.9,en;q=0.8",
+			expectedError: newValidationE("confirm_password", "a confirmação da senha não corresponde"),
+		},
+		{
+			name:          "german",
+			givenLocale:   "de",
+			expectedError: newValidationE("confirm_password", "die Passwortbestätigung stimmt nicht überein"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			in := given
+			in.Locale = tc.givenLocale
+
+			assert.Equal(t, tc.expectedError, in.validate(inputPolicies{password: validate.DefaultPasswordPolicy}, time.Now()))
+		})
+	}
+}
+
+func TestCreateUserInput_validate_aggregates(t *testing.T) {
+	t.Parallel()
+
+	given := CreateUserInput{
+		Fullname:        "",
+		Username:        "johndoe",
+		Birthdate:       "01/01/1990",
+		Email:           "not an email",
+		Password:        "short",
+		ConfirmPassword: "",
+		Locale:          "pt",
+	}
+
+	err := given.validate(inputPolicies{password: validate.DefaultPasswordPolicy}, time.Now())
+
+	var e E
+	require.True(t, errors.As(err, &e))
+
+	assert.True(t, errors.Is(err, ErrValidation))
+	assert.Equal(t, []FieldError{
+		{Field: "fullname", Message: "o nome completo é obrigatório"},
+		{Field: "birthdate", Message: "a data de nascimento deve estar no formato AAAA-MM-DD"},
+		{Field: "email", Message: "o email é inválido"},
+		{Field: "password", Message: "a senha é muito curta"},
+		{Field: "confirm_password", Message: "a confirmação da senha não corresponde"},
+	}, e.Details())
+}
+
+func TestCreateUserInput_validate_age(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		givenBirthdate string
+		expectedError  error
+	}{
+		{
+			name:           "old enough",
+			givenBirthdate: "2009-06-15",
+			expectedError:  nil,
+		},
+		{
+			name:           "too young",
+			givenBirthdate: "2009-06-16",
+			expectedError:  newValidationE("birthdate", "you do not meet the minimum age requirement"),
+		},
+		{
+			name:           "in the future",
+			givenBirthdate: "2022-06-16",
+			expectedError:  newValidationE("birthdate", "birthdate must not be in the future"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			in := CreateUserInput{
+				Fullname:        "John Doe",
+				Username:        "johndoe",
+				Birthdate:       tc.givenBirthdate,
+				Email:           "joedoe@mail.com",
+				Password:        "1234%6abc",
+				ConfirmPassword: "1234%6abc",
+			}
+
+			policies := inputPolicies{password: validate.DefaultPasswordPolicy, age: validate.AgePolicy{MinAge: 13}}
+			assert.Equal(t, tc.expectedError, in.validate(policies, now))
+		})
+	}
+}
+
+func TestBirthdate(t *testing.T) {
+	t.Parallel()
+
+	actual, err := ParseBirthdate("2004-02-29")
+	require.NoError(t, err)
+
+	assert.Equal(t, "2004-02-29", actual.String())
+	assert.Equal(t, time.Date(2004, time.February, 29, 0, 0, 0, 0, time.UTC), actual.Time())
+	assert.Equal(t, 16, actual.AgeAt(time.Date(2021, time.February, 28, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 17, actual.AgeAt(time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)))
+
+	// Dates read from the database keep their calendar date whatever their location
+	assert.Equal(t, actual, newBirthdate(time.Date(2004, time.February, 29, 0, 0, 0, 0, time.FixedZone("", 3*60*60))))
+
+	text, err := json.Marshal(struct{ Birthdate Birthdate }{actual})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"Birthdate":"2004-02-29"}`, string(text))
+
+	var decoded struct{ Birthdate Birthdate }
+	require.NoError(t, json.Unmarshal(text, &decoded))
+	assert.Equal(t, actual, decoded.Birthdate)
+
+	_, err = ParseBirthdate("2021-02-29")
+	assert.Error(t, err)
+
+	assert.True(t, Birthdate{}.IsZero())
+	assert.Equal(t, "", Birthdate{}.String())
+}
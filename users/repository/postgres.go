@@ -5,50 +5,156 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/pgtype"
 	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	// Enumerate postgresql query strings
 
-	insertQuery string = `INSERT INTO users (id,fullname,username,birthdate,email,email_verified,password_hash,
-	role,created_at,updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING 
-	id,fullname,username,birthdate,email,email_verified,password_hash,role,created_at,updated_at;`
+	// userColumns lists the columns scanned by scanUser
+	userColumns string = `id,fullname,username,username_normalized,birthdate,email,email_normalized,email_verified,
+	password_hash,role,locale,created_at,updated_at,deleted_at,tokens_revoked_at`
 
-	selectByIDQuery string = `SELECT id,fullname,username,birthdate,email,email_verified,
-	password_hash,role,created_at,updated_at FROM users WHERE id = $1 AND deleted_at IS NULL;`
+	insertQuery string = `INSERT INTO users (id,fullname,username,username_normalized,birthdate,email,email_normalized,
+	email_verified,password_hash,role,locale,created_at,updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+	RETURNING ` + userColumns + ";"
 
-	selectByEmailQuery string = `SELECT id,fullname,username,birthdate,email,email_verified,
-	password_hash,role,created_at,updated_at FROM users WHERE email = $1 AND deleted_at IS NULL;`
+	selectByIDQuery string = "SELECT " + userColumns + " FROM users WHERE id = $1 AND deleted_at IS NULL;"
+
+	// selectByIDsQuery casts $1, a text array, so that a single query selects every id
+	selectByIDsQuery string = "SELECT " + userColumns + " FROM users WHERE id = ANY($1::UUID[]) AND deleted_at IS NULL;"
+
+	selectByEmailQuery string = "SELECT " + userColumns + " FROM users WHERE email_normalized = $1 AND deleted_at IS NULL;"
+
+	// selectUsersQuery matches $1 as a substring of the username, email or full name, or as the id
+	selectUsersQuery string = "SELECT " + userColumns + ` FROM users
+	WHERE ($1 = '' OR username ILIKE '%' || $1 || '%' ESCAPE '\' OR email ILIKE '%' || $1 || '%' ESCAPE '\'
+		OR fullname ILIKE '%' || $1 || '%' ESCAPE '\' OR id::TEXT = $1)
+	AND ($2 = '' OR role::TEXT = $2)
+	AND ($3 OR deleted_at IS NULL)
+	ORDER BY created_at, id LIMIT $4 OFFSET $5;`
 
 	deleteByIDQuery string = "UPDATE users SET deleted_at = NOW() WHERE id = $1;"
 
-	insertEmailVerificationQuery string = `INSERT INTO email_verifications 
-	(code,user_id,created_at,expires_at) VALUES ($1,$2,$3,$4);`
+	restoreByIDQuery string = "UPDATE users SET deleted_at = NULL, updated_at = $2 WHERE id = $1 AND deleted_at IS NOT NULL;"
+
+	// updateRoleQuery also revokes the tokens issued with the previous role, which they carry
+	updateRoleQuery string = `UPDATE users SET role = $2, tokens_revoked_at = $3, updated_at = $3
+	WHERE id = $1 AND deleted_at IS NULL;`
+
+	// updatePasswordHashQuery also revokes the tokens issued with the previous password
+	updatePasswordHashQuery string = `UPDATE users SET password_hash = $2, tokens_revoked_at = $3, updated_at = $3
+	WHERE id = $1 AND deleted_at IS NULL;`
+
+	updateEmailVerifiedQuery string = `UPDATE users SET email_verified = TRUE, updated_at = $2
+	WHERE id = $1 AND deleted_at IS NULL;`
+
+	revokeTokensQuery string = "UPDATE users SET tokens_revoked_at = $2 WHERE id = $1 AND deleted_at IS NULL;"
+
+	// insertEmailVerificationQuery invalidates the outstanding verifications of the user
+	// in the same statement, so that only the latest verification can be used
+	insertEmailVerificationQuery string = `WITH invalidated AS (
+		UPDATE email_verifications SET invalidated_at = $6
+		WHERE user_id = $4 AND verified_at IS NULL AND invalidated_at IS NULL
+	)
+	INSERT INTO email_verifications (token_hash,code_hash,email,user_id,attempts,created_at,expires_at)
+	VALUES ($1,$2,$3,$4,$5,$6,$7);`
+
+	// lockEmailVerificationsQuery locks the verifications of a user and of an address until the end of
+	// the transaction. Every insert locks the user first, so that waiting transactions cannot deadlock.
+	lockEmailVerificationsQuery string = `SELECT pg_advisory_xact_lock(hashtext('email_verifications.user_id'), hashtext($1)),
+	pg_advisory_xact_lock(hashtext('email_verifications.email'), hashtext($2));`
+
+	selectEmailVerificationQuery string = `SELECT token_hash,code_hash,email,user_id,attempts,created_at,expires_at,
+	verified_at,invalidated_at FROM email_verifications WHERE token_hash = $1;`
+
+	selectLatestEmailVerificationQuery string = `SELECT token_hash,code_hash,email,user_id,attempts,created_at,expires_at,
+	verified_at,invalidated_at FROM email_verifications WHERE user_id = $1 AND verified_at IS NULL AND invalidated_at IS NULL
+	ORDER BY created_at DESC LIMIT 1;`
+
+	selectEmailVerificationActivityQuery string = `SELECT
+	COUNT(*) FILTER (WHERE user_id = $1 AND created_at >= $3),
+	MIN(created_at) FILTER (WHERE user_id = $1 AND created_at >= $3),
+	COUNT(*) FILTER (WHERE email = $2 AND created_at >= $3),
+	MIN(created_at) FILTER (WHERE email = $2 AND created_at >= $3),
+	MAX(created_at)
+	FROM email_verifications WHERE user_id = $1 OR email = $2;`
+
+	deleteExpiredEmailVerificationsQuery string = `DELETE FROM email_verifications
+	WHERE expires_at < $1 AND created_at < $2;`
+
+	incrementEmailVerificationAttemptsQuery string = `UPDATE email_verifications SET attempts = attempts + 1
+	WHERE token_hash = $1 RETURNING attempts;`
+
+	// confirmEmailVerificationQuery marks the verification as used and the email of its user
+	// as verified in a single statement, so that a verification can only be used once
+	confirmEmailVerificationQuery string = `WITH verification AS (
+		UPDATE email_verifications SET verified_at = $2
+		WHERE token_hash = $1 AND verified_at IS NULL AND invalidated_at IS NULL RETURNING user_id
+	)
+	UPDATE users SET email_verified = TRUE, updated_at = $2 FROM verification
+	WHERE users.id = verification.user_id AND users.deleted_at IS NULL;`
+
+	// sessionColumns lists the columns scanned by scanSession
+	sessionColumns string = "id,user_id,user_agent,ip,created_at,last_seen_at,expires_at,revoked_at"
+
+	insertSessionQuery string = `INSERT INTO sessions (id,user_id,user_agent,ip,created_at,last_seen_at,expires_at)
+	VALUES ($1,$2,$3,$4,$5,$6,$7);`
+
+	selectSessionQuery string = "SELECT " + sessionColumns + " FROM sessions WHERE id = $1;"
+
+	// selectActiveSessionsQuery skips the sessions created before the tokens of the user were revoked.
+	// Token issue times have a precision of a second, so sessions are compared at the second too.
+	selectActiveSessionsQuery string = `SELECT s.id,s.user_id,s.user_agent,s.ip,s.created_at,s.last_seen_at,
+	s.expires_at,s.revoked_at FROM sessions s JOIN users u ON u.id = s.user_id
+	WHERE s.user_id = $1 AND s.revoked_at IS NULL AND s.expires_at > $2
+	AND (u.tokens_revoked_at IS NULL OR date_trunc('second', s.created_at) >= u.tokens_revoked_at)
+	ORDER BY s.last_seen_at DESC, s.id;`
+
+	touchSessionQuery string = "UPDATE sessions SET last_seen_at = $2 WHERE id = $1 AND last_seen_at < $2;"
+
+	revokeSessionQuery string = `UPDATE sessions SET revoked_at = $3
+	WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;`
+
+	revokeOtherSessionsQuery string = `UPDATE sessions SET revoked_at = $3
+	WHERE user_id = $1 AND id::TEXT <> $2 AND revoked_at IS NULL AND expires_at > $3;`
+
+	deleteExpiredSessionsQuery string = "DELETE FROM sessions WHERE expires_at < $1;"
 )
 
 // Postgres represents a user repository instance with the given database connection
-type Postgres struct{ *sqlx.DB }
+type Postgres struct {
+	*sqlx.DB
+	tracer trace.Tracer
+}
 
 // New creates a new user repository instance
-func NewPostgres(dbConn *sqlx.DB) *Postgres {
-	return &Postgres{dbConn}
+func NewPostgres(dbConn *sqlx.DB, opts ...PostgresOption) *Postgres {
+	p := Postgres{DB: dbConn}
+	for _, opt := range opts {
+		opt(&p)
+	}
+	return &p
 }
 
-func (p *Postgres) Insert(ctx context.Context, u *User) (*User, error) {
+func (p *Postgres) Insert(ctx context.Context, u *User) (_ *User, err error) {
+	ctx, span := p.startSpan(ctx, "Insert", attrUserID.String(u.ID))
+	defer func() { endSpan(span, err) }()
+
 	var res User
 
 	if err := p.QueryRowContext(
-		ctx, insertQuery, u.ID, u.Fullname, u.Username,
-		u.Birthdate, u.Email, u.EmailVerified, u.PasswordHash,
-		u.Role, u.CreatedAt, u.UpdatedAt,
-	).Scan(
-		&res.ID, &res.Fullname, &res.Username, &res.Birthdate, &res.Email,
-		&res.EmailVerified, &res.PasswordHash, &res.Role, &res.CreatedAt, &res.UpdatedAt,
-	); err != nil {
+		ctx, insertQuery, u.ID, u.Fullname, u.Username, u.UsernameNormalized,
+		u.Birthdate, u.Email, u.EmailNormalized, u.EmailVerified, u.PasswordHash,
+		u.Role, u.Locale, u.CreatedAt, u.UpdatedAt,
+	).Scan(scanUser(&res)...); err != nil {
 		var e *pgconn.PgError
 		if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
 			return nil, ErrDuplicateRecord
@@ -59,7 +165,10 @@ func (p *Postgres) Insert(ctx context.Context, u *User) (*User, error) {
 }
 
 // SelectByID selects a user by id and returns the user
-func (p *Postgres) SelectByID(ctx context.Context, id string) (*User, error) {
+func (p *Postgres) SelectByID(ctx context.Context, id string) (_ *User, err error) {
+	ctx, span := p.startSpan(ctx, "SelectByID", attrUserID.String(id))
+	defer func() { endSpan(span, err) }()
+
 	user, err := p.selectUser(ctx, selectByIDQuery, id)
 	if err != nil {
 		return nil, fmt.Errorf("could not select user by id: %s", err)
@@ -67,7 +176,11 @@ func (p *Postgres) SelectByID(ctx context.Context, id string) (*User, error) {
 	return user, nil
 }
 
-func (p *Postgres) SelectByEmail(ctx context.Context, email string) (*User, error) {
+// SelectByEmail selects a user by the canonical form of its email and returns the user
+func (p *Postgres) SelectByEmail(ctx context.Context, email string) (_ *User, err error) {
+	ctx, span := p.startSpan(ctx, "SelectByEmail")
+	defer func() { endSpan(span, err) }()
+
 	user, err := p.selectUser(ctx, selectByEmailQuery, email)
 	if err != nil {
 		return nil, fmt.Errorf("could not select user by email: %s", err)
@@ -75,13 +188,66 @@ func (p *Postgres) SelectByEmail(ctx context.Context, email string) (*User, erro
 	return user, nil
 }
 
+// SelectUsers selects the users matching the filter, oldest first
+func (p *Postgres) SelectUsers(ctx context.Context, filter UserFilter) (_ []User, err error) {
+	ctx, span := p.startSpan(ctx, "SelectUsers")
+	defer func() { endSpan(span, err) }()
+
+	users, err := p.selectUsers(
+		ctx, selectUsersQuery,
+		escapeLike(filter.Query), filter.Role, filter.IncludeDeleted, filter.Limit, filter.Offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not select users: %s", err)
+	}
+	return users, nil
+}
+
+// SelectByIDs selects the non-deleted users with the given ids in a single query.
+// Users that do not exist are left out, in no particular order.
+func (p *Postgres) SelectByIDs(ctx context.Context, ids []string) (_ []User, err error) {
+	ctx, span := p.startSpan(ctx, "SelectByIDs")
+	defer func() { endSpan(span, err) }()
+
+	var arg pgtype.TextArray
+	if err := arg.Set(ids); err != nil {
+		return nil, fmt.Errorf("could not encode ids: %s", err)
+	}
+
+	users, err := p.selectUsers(ctx, selectByIDsQuery, &arg)
+	if err != nil {
+		return nil, fmt.Errorf("could not select users by ids: %s", err)
+	}
+	return users, nil
+}
+
+// selectUsers executes the given query and returns the users of every row
+func (p *Postgres) selectUsers(ctx context.Context, query string, args ...interface{}) ([]User, error) {
+	rows, err := p.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(scanUser(&u)...); err != nil {
+			return nil, fmt.Errorf("could not scan user: %s", err)
+		}
+		res = append(res, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate users: %s", err)
+	}
+	return res, nil
+}
+
 // selectUser executes the given query and returns the user
 func (p *Postgres) selectUser(ctx context.Context, query, arg string) (*User, error) {
 	var u User
-	if err := p.QueryRowContext(ctx, query, arg).Scan(
-		&u.ID, &u.Fullname, &u.Username, &u.Birthdate, &u.Email,
-		&u.EmailVerified, &u.PasswordHash, &u.Role, &u.CreatedAt, &u.UpdatedAt,
-	); err != nil {
+	if err := p.QueryRowContext(ctx, query, arg).Scan(scanUser(&u)...); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -90,10 +256,76 @@ func (p *Postgres) selectUser(ctx context.Context, query, arg string) (*User, er
 	return &u, nil
 }
 
-func (p *Postgres) DeleteByID(ctx context.Context, id string) error {
-	res, err := p.ExecContext(ctx, deleteByIDQuery, id)
+func (p *Postgres) DeleteByID(ctx context.Context, id string) (err error) {
+	ctx, span := p.startSpan(ctx, "DeleteByID", attrUserID.String(id))
+	defer func() { endSpan(span, err) }()
+
+	if err := p.updateUser(ctx, deleteByIDQuery, id); err != nil {
+		return fmt.Errorf("could not delete user: %w", err)
+	}
+	return nil
+}
+
+// RestoreByID restores a deleted user. It returns ErrRecordNotFound if the user is not deleted.
+func (p *Postgres) RestoreByID(ctx context.Context, id string, updatedAt time.Time) (err error) {
+	ctx, span := p.startSpan(ctx, "RestoreByID", attrUserID.String(id))
+	defer func() { endSpan(span, err) }()
+
+	if err := p.updateUser(ctx, restoreByIDQuery, id, updatedAt); err != nil {
+		return fmt.Errorf("could not restore user: %w", err)
+	}
+	return nil
+}
+
+// UpdateRole sets the role of a user and revokes the tokens issued before updatedAt
+func (p *Postgres) UpdateRole(ctx context.Context, id, role string, updatedAt time.Time) (err error) {
+	ctx, span := p.startSpan(ctx, "UpdateRole", attrUserID.String(id))
+	defer func() { endSpan(span, err) }()
+
+	if err := p.updateUser(ctx, updateRoleQuery, id, role, updatedAt); err != nil {
+		return fmt.Errorf("could not update role: %w", err)
+	}
+	return nil
+}
+
+// UpdatePasswordHash sets the password hash of a user and revokes the tokens issued before updatedAt
+func (p *Postgres) UpdatePasswordHash(ctx context.Context, id, passwordHash string, updatedAt time.Time) (err error) {
+	ctx, span := p.startSpan(ctx, "UpdatePasswordHash", attrUserID.String(id))
+	defer func() { endSpan(span, err) }()
+
+	if err := p.updateUser(ctx, updatePasswordHashQuery, id, passwordHash, updatedAt); err != nil {
+		return fmt.Errorf("could not update password hash: %w", err)
+	}
+	return nil
+}
+
+// UpdateEmailVerified marks the email of a user as verified
+func (p *Postgres) UpdateEmailVerified(ctx context.Context, id string, updatedAt time.Time) (err error) {
+	ctx, span := p.startSpan(ctx, "UpdateEmailVerified", attrUserID.String(id))
+	defer func() { endSpan(span, err) }()
+
+	if err := p.updateUser(ctx, updateEmailVerifiedQuery, id, updatedAt); err != nil {
+		return fmt.Errorf("could not update email verified: %w", err)
+	}
+	return nil
+}
+
+// RevokeTokens revokes the tokens of a user issued before revokedAt
+func (p *Postgres) RevokeTokens(ctx context.Context, id string, revokedAt time.Time) (err error) {
+	ctx, span := p.startSpan(ctx, "RevokeTokens", attrUserID.String(id))
+	defer func() { endSpan(span, err) }()
+
+	if err := p.updateUser(ctx, revokeTokensQuery, id, revokedAt); err != nil {
+		return fmt.Errorf("could not revoke tokens: %w", err)
+	}
+	return nil
+}
+
+// updateUser executes an update of a single user and returns ErrRecordNotFound if no user was updated
+func (p *Postgres) updateUser(ctx context.Context, query string, args ...interface{}) error {
+	res, err := p.ExecContext(ctx, query, args...)
 	if err != nil {
-		return fmt.Errorf("could not delete user: %s", err)
+		return err
 	}
 
 	rowsAffected, err := res.RowsAffected()
@@ -107,10 +339,302 @@ func (p *Postgres) DeleteByID(ctx context.Context, id string) error {
 	return nil
 }
 
-func (p *Postgres) InsertEmailVerification(ctx context.Context, in EmailVerification) error {
-	_, err := p.ExecContext(ctx, insertEmailVerificationQuery, in.Code, in.UserID, in.CreatedAt, in.ExpiresAt)
+// scanUser returns the destinations of the columns listed in userColumns
+func scanUser(u *User) []interface{} {
+	return []interface{}{
+		&u.ID, &u.Fullname, &u.Username, &u.UsernameNormalized, &u.Birthdate, &u.Email, &u.EmailNormalized,
+		&u.EmailVerified, &u.PasswordHash, &u.Role, &u.Locale, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt, &u.TokensRevokedAt,
+	}
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, so that they are matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// InsertEmailVerification inserts an email verification and invalidates the outstanding ones of its user.
+// If check is not nil, it is given the activity of the user and of the address since the given time, and the
+// verification is only inserted if check returns nil; its error is returned as is. Inserts of a user or of an
+// address hold transaction level advisory locks, so that concurrent inserts are checked one after the other.
+func (p *Postgres) InsertEmailVerification(
+	ctx context.Context, in EmailVerification, since time.Time, check func(a *EmailVerificationActivity) error,
+) (err error) {
+	ctx, span := p.startSpan(ctx, "InsertEmailVerification", attrUserID.String(in.UserID))
+	defer func() { endSpan(span, err) }()
+
+	tx, err := p.BeginTxx(ctx, nil)
 	if err != nil {
+		return fmt.Errorf("could not begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	if check != nil {
+		if _, err := tx.ExecContext(ctx, lockEmailVerificationsQuery, in.UserID, in.Email); err != nil {
+			return fmt.Errorf("could not lock email verifications: %s", err)
+		}
+
+		a, err := scanEmailVerificationActivity(
+			tx.QueryRowContext(ctx, selectEmailVerificationActivityQuery, in.UserID, in.Email, since),
+		)
+		if err != nil {
+			return err
+		}
+
+		if err := check(a); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(
+		ctx, insertEmailVerificationQuery,
+		in.TokenHash, in.CodeHash, in.Email, in.UserID, in.Attempts, in.CreatedAt, in.ExpiresAt,
+	); err != nil {
 		return fmt.Errorf("could not insert email verification: %s", err)
 	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %s", err)
+	}
 	return nil
 }
+
+// SelectEmailVerification selects an email verification by the hash of its link token
+func (p *Postgres) SelectEmailVerification(ctx context.Context, tokenHash string) (_ *EmailVerification, err error) {
+	ctx, span := p.startSpan(ctx, "SelectEmailVerification")
+	defer func() { endSpan(span, err) }()
+
+	v, err := p.selectEmailVerification(ctx, selectEmailVerificationQuery, tokenHash)
+	if err != nil {
+		return nil, fmt.Errorf("could not select email verification by token hash: %s", err)
+	}
+	return v, nil
+}
+
+// SelectLatestEmailVerification selects the email verification of a user that was neither used nor invalidated
+func (p *Postgres) SelectLatestEmailVerification(ctx context.Context, userID string) (_ *EmailVerification, err error) {
+	ctx, span := p.startSpan(ctx, "SelectLatestEmailVerification", attrUserID.String(userID))
+	defer func() { endSpan(span, err) }()
+
+	v, err := p.selectEmailVerification(ctx, selectLatestEmailVerificationQuery, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select latest email verification: %s", err)
+	}
+	return v, nil
+}
+
+func (p *Postgres) selectEmailVerification(ctx context.Context, query, arg string) (*EmailVerification, error) {
+	var v EmailVerification
+	if err := p.QueryRowContext(ctx, query, arg).Scan(
+		&v.TokenHash, &v.CodeHash, &v.Email, &v.UserID, &v.Attempts,
+		&v.CreatedAt, &v.ExpiresAt, &v.VerifiedAt, &v.InvalidatedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not select email verification: %s", err)
+	}
+	return &v, nil
+}
+
+// IncrementEmailVerificationAttempts records a verification attempt and returns the number of attempts so far.
+// Concurrent attempts each get a different count, so limits cannot be bypassed with parallel requests.
+func (p *Postgres) IncrementEmailVerificationAttempts(ctx context.Context, tokenHash string) (_ int, err error) {
+	ctx, span := p.startSpan(ctx, "IncrementEmailVerificationAttempts")
+	defer func() { endSpan(span, err) }()
+
+	var attempts int
+	if err := p.QueryRowContext(ctx, incrementEmailVerificationAttemptsQuery, tokenHash).Scan(&attempts); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrRecordNotFound
+		}
+		return 0, fmt.Errorf("could not increment email verification attempts: %s", err)
+	}
+	return attempts, nil
+}
+
+// ConfirmEmailVerification marks an unused email verification as used and the email of its user as verified.
+// It returns ErrRecordNotFound if the verification was already used or its user deleted.
+func (p *Postgres) ConfirmEmailVerification(ctx context.Context, tokenHash string, verifiedAt time.Time) (err error) {
+	ctx, span := p.startSpan(ctx, "ConfirmEmailVerification")
+	defer func() { endSpan(span, err) }()
+
+	res, err := p.ExecContext(ctx, confirmEmailVerificationQuery, tokenHash, verifiedAt)
+	if err != nil {
+		return fmt.Errorf("could not confirm email verification: %s", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %s", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// SelectEmailVerificationActivity summarizes the email verifications sent to a user or to an address.
+// Counts only include verifications created since the given time.
+func (p *Postgres) SelectEmailVerificationActivity(
+	ctx context.Context, userID, email string, since time.Time,
+) (_ *EmailVerificationActivity, err error) {
+	ctx, span := p.startSpan(ctx, "SelectEmailVerificationActivity", attrUserID.String(userID))
+	defer func() { endSpan(span, err) }()
+
+	return scanEmailVerificationActivity(p.QueryRowContext(ctx, selectEmailVerificationActivityQuery, userID, email, since))
+}
+
+func scanEmailVerificationActivity(row *sql.Row) (*EmailVerificationActivity, error) {
+	var a EmailVerificationActivity
+	if err := row.Scan(&a.UserCount, &a.UserOldest, &a.EmailCount, &a.EmailOldest, &a.LastCreatedAt); err != nil {
+		return nil, fmt.Errorf("could not select email verification activity: %s", err)
+	}
+	return &a, nil
+}
+
+// DeleteExpiredEmailVerifications deletes the email verifications that expired before the first given time
+// and were created before the second one, and returns how many were deleted
+func (p *Postgres) DeleteExpiredEmailVerifications(ctx context.Context, expiredBefore, createdBefore time.Time) (_ int, err error) {
+	ctx, span := p.startSpan(ctx, "DeleteExpiredEmailVerifications")
+	defer func() { endSpan(span, err) }()
+
+	res, err := p.ExecContext(ctx, deleteExpiredEmailVerificationsQuery, expiredBefore, createdBefore)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete expired email verifications: %s", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not get rows affected: %s", err)
+	}
+	return int(rowsAffected), nil
+}
+
+// InsertSession inserts a session
+func (p *Postgres) InsertSession(ctx context.Context, in Session) (err error) {
+	ctx, span := p.startSpan(ctx, "InsertSession", attrUserID.String(in.UserID))
+	defer func() { endSpan(span, err) }()
+
+	if _, err := p.ExecContext(
+		ctx, insertSessionQuery, in.ID, in.UserID, in.UserAgent, in.IP, in.CreatedAt, in.LastSeenAt, in.ExpiresAt,
+	); err != nil {
+		return fmt.Errorf("could not insert session: %s", err)
+	}
+	return nil
+}
+
+// SelectSession selects a session by id, revoked or not
+func (p *Postgres) SelectSession(ctx context.Context, id string) (_ *Session, err error) {
+	ctx, span := p.startSpan(ctx, "SelectSession")
+	defer func() { endSpan(span, err) }()
+
+	var s Session
+	if err := p.QueryRowContext(ctx, selectSessionQuery, id).Scan(scanSession(&s)...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not select session: %s", err)
+	}
+	return &s, nil
+}
+
+// SelectActiveSessions selects the sessions of a user that are neither revoked nor expired at now,
+// most recently seen first
+func (p *Postgres) SelectActiveSessions(ctx context.Context, userID string, now time.Time) (_ []Session, err error) {
+	ctx, span := p.startSpan(ctx, "SelectActiveSessions", attrUserID.String(userID))
+	defer func() { endSpan(span, err) }()
+
+	rows, err := p.QueryContext(ctx, selectActiveSessionsQuery, userID, now)
+	if err != nil {
+		return nil, fmt.Errorf("could not select sessions: %s", err)
+	}
+	defer rows.Close()
+
+	var res []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(scanSession(&s)...); err != nil {
+			return nil, fmt.Errorf("could not scan session: %s", err)
+		}
+		res = append(res, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate sessions: %s", err)
+	}
+	return res, nil
+}
+
+// TouchSession moves the last activity of a session forward to lastSeenAt
+func (p *Postgres) TouchSession(ctx context.Context, id string, lastSeenAt time.Time) (err error) {
+	ctx, span := p.startSpan(ctx, "TouchSession")
+	defer func() { endSpan(span, err) }()
+
+	if _, err := p.ExecContext(ctx, touchSessionQuery, id, lastSeenAt); err != nil {
+		return fmt.Errorf("could not touch session: %s", err)
+	}
+	return nil
+}
+
+// RevokeSession revokes a session of a user.
+// It returns ErrRecordNotFound if the user has no such session or it is already revoked.
+func (p *Postgres) RevokeSession(ctx context.Context, id, userID string, revokedAt time.Time) (err error) {
+	ctx, span := p.startSpan(ctx, "RevokeSession", attrUserID.String(userID))
+	defer func() { endSpan(span, err) }()
+
+	res, err := p.ExecContext(ctx, revokeSessionQuery, id, userID, revokedAt)
+	if err != nil {
+		return fmt.Errorf("could not revoke session: %s", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %s", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// RevokeOtherSessions revokes the unexpired sessions of a user but the one with the given id,
+// and returns how many were revoked
+func (p *Postgres) RevokeOtherSessions(ctx context.Context, userID, keepID string, revokedAt time.Time) (_ int, err error) {
+	ctx, span := p.startSpan(ctx, "RevokeOtherSessions", attrUserID.String(userID))
+	defer func() { endSpan(span, err) }()
+
+	res, err := p.ExecContext(ctx, revokeOtherSessionsQuery, userID, keepID, revokedAt)
+	if err != nil {
+		return 0, fmt.Errorf("could not revoke sessions: %s", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not get rows affected: %s", err)
+	}
+	return int(rowsAffected), nil
+}
+
+// DeleteExpiredSessions deletes the sessions that expired before the given time and returns how many were deleted
+func (p *Postgres) DeleteExpiredSessions(ctx context.Context, expiredBefore time.Time) (_ int, err error) {
+	ctx, span := p.startSpan(ctx, "DeleteExpiredSessions")
+	defer func() { endSpan(span, err) }()
+
+	res, err := p.ExecContext(ctx, deleteExpiredSessionsQuery, expiredBefore)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete expired sessions: %s", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not get rows affected: %s", err)
+	}
+	return int(rowsAffected), nil
+}
+
+// scanSession returns the destinations of the columns listed in sessionColumns
+func scanSession(s *Session) []interface{} {
+	return []interface{}{&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.RevokedAt}
+}
@@ -2,12 +2,22 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
 	_ "github.com/jackc/pgx/stdlib"
 	"github.com/jmoiron/sqlx"
@@ -27,16 +37,19 @@ func TestIntegrationInsert(t *testing.T) {
 		repo := NewPostgres(dbConn)
 
 		user := &User{
-			ID:            uuid.New().String(),
-			Fullname:      "John Doe",
-			Username:      "jdoe",
-			Birthdate:     "2000-01-01",
-			Email:         "joedoe@mail.com",
-			EmailVerified: false,
-			PasswordHash:  "123456",
-			Role:          "user",
-			CreatedAt:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
-			UpdatedAt:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			ID:                 uuid.New().String(),
+			Fullname:           "John Doe",
+			Username:           "jdoe",
+			UsernameNormalized: "jdoe",
+			Birthdate:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Email:              "joedoe@mail.com",
+			EmailNormalized:    "joedoe@mail.com",
+			EmailVerified:      false,
+			PasswordHash:       "123456",
+			Role:               "user",
+			Locale:             "en",
+			CreatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			UpdatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
 		}
 
 		actual, err := repo.Insert(context.TODO(), user)
@@ -52,16 +65,19 @@ func TestIntegrationInsert(t *testing.T) {
 		repo := NewPostgres(dbConn)
 
 		user := &User{
-			ID:            uuid.New().String(),
-			Fullname:      "John Doe",
-			Username:      "jdoe",
-			Birthdate:     "2000-01-01",
-			Email:         "joedoe@mail.com",
-			EmailVerified: false,
-			PasswordHash:  "123456",
-			Role:          "user",
-			CreatedAt:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
-			UpdatedAt:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			ID:                 uuid.New().String(),
+			Fullname:           "John Doe",
+			Username:           "jdoe",
+			UsernameNormalized: "jdoe",
+			Birthdate:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Email:              "joedoe@mail.com",
+			EmailNormalized:    "joedoe@mail.com",
+			EmailVerified:      false,
+			PasswordHash:       "123456",
+			Role:               "user",
+			Locale:             "en",
+			CreatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			UpdatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
 		}
 
 		_, err := repo.Insert(context.TODO(), user)
@@ -70,6 +86,40 @@ func TestIntegrationInsert(t *testing.T) {
 		_, err = repo.Insert(context.TODO(), user)
 		assert.Error(t, err)
 	})
+
+	t.Run("cannot insert another spelling of the same email", func(t *testing.T) {
+		dbConn := setupDB(t)
+		defer teardownDB(t, dbConn)
+
+		repo := NewPostgres(dbConn)
+
+		user := &User{
+			ID:                 uuid.New().String(),
+			Fullname:           "John Doe",
+			Username:           "jdoe",
+			UsernameNormalized: "jdoe",
+			Birthdate:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Email:              "joedoe@mail.com",
+			EmailNormalized:    "joedoe@mail.com",
+			PasswordHash:       "123456",
+			Role:               "user",
+			Locale:             "en",
+			CreatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			UpdatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+
+		_, err := repo.Insert(context.TODO(), user)
+		require.NoError(t, err)
+
+		other := *user
+		other.ID = uuid.New().String()
+		other.Username = "jdoe2"
+		other.UsernameNormalized = "jdoe2"
+		other.Email = "JoeDoe@mail.com"
+
+		_, err = repo.Insert(context.TODO(), &other)
+		assert.Equal(t, ErrDuplicateRecord, err)
+	})
 }
 
 func TestIntegrationSelectByID(t *testing.T) {
@@ -83,16 +133,19 @@ func TestIntegrationSelectByID(t *testing.T) {
 	repo := NewPostgres(dbConn)
 
 	user := &User{
-		ID:            uuid.New().String(),
-		Fullname:      "John Doe",
-		Username:      "jdoe",
-		Birthdate:     "2000-01-01",
-		Email:         "joedoe@mail.com",
-		EmailVerified: false,
-		PasswordHash:  "123456",
-		Role:          "user",
-		CreatedAt:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
-		UpdatedAt:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		ID:                 uuid.New().String(),
+		Fullname:           "John Doe",
+		Username:           "jdoe",
+		UsernameNormalized: "jdoe",
+		Birthdate:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Email:              "joedoe@mail.com",
+		EmailNormalized:    "joedoe@mail.com",
+		EmailVerified:      false,
+		PasswordHash:       "123456",
+		Role:               "user",
+		Locale:             "en",
+		CreatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		UpdatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
 	}
 
 	_, err := repo.Insert(context.TODO(), user)
@@ -123,6 +176,62 @@ func TestIntegrationSelectByID(t *testing.T) {
 	})
 }
 
+func TestIntegrationSelectByIDs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	dbConn := setupDB(t)
+	defer teardownDB(t, dbConn)
+
+	repo := NewPostgres(dbConn)
+
+	newUser := func(username string) *User {
+		return &User{
+			ID:                 uuid.New().String(),
+			Fullname:           "John Doe",
+			Username:           username,
+			UsernameNormalized: username,
+			Birthdate:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Email:              username + "@mail.com",
+			EmailNormalized:    username + "@mail.com",
+			PasswordHash:       "123456",
+			Role:               "user",
+			Locale:             "en",
+			CreatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			UpdatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+	}
+
+	jdoe, jane, bob := newUser("jdoe"), newUser("jane"), newUser("bob")
+	for _, u := range []*User{jdoe, jane, bob} {
+		_, err := repo.Insert(context.TODO(), u)
+		require.NoError(t, err)
+	}
+	require.NoError(t, repo.DeleteByID(context.TODO(), bob.ID))
+
+	t.Run("existing users", func(t *testing.T) {
+		actual, err := repo.SelectByIDs(context.TODO(), []string{jdoe.ID, jane.ID, uuid.New().String()})
+		require.NoError(t, err)
+
+		assert.ElementsMatch(t, []User{*jdoe, *jane}, actual)
+	})
+
+	t.Run("deleted users", func(t *testing.T) {
+		actual, err := repo.SelectByIDs(context.TODO(), []string{bob.ID})
+		require.NoError(t, err)
+
+		assert.Empty(t, actual)
+	})
+
+	t.Run("no ids", func(t *testing.T) {
+		actual, err := repo.SelectByIDs(context.TODO(), nil)
+		require.NoError(t, err)
+
+		assert.Empty(t, actual)
+	})
+}
+
 func TestIntegrationSelectByEmail(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
@@ -134,23 +243,26 @@ func TestIntegrationSelectByEmail(t *testing.T) {
 	repo := NewPostgres(dbConn)
 
 	user := &User{
-		ID:            uuid.New().String(),
-		Fullname:      "John Doe",
-		Username:      "jdoe",
-		Birthdate:     "2000-01-01",
-		Email:         "joedoe@mail.com",
-		EmailVerified: false,
-		PasswordHash:  "123456",
-		Role:          "user",
-		CreatedAt:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
-		UpdatedAt:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		ID:                 uuid.New().String(),
+		Fullname:           "John Doe",
+		Username:           "jdoe",
+		UsernameNormalized: "jdoe",
+		Birthdate:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Email:              "joedoe@mail.com",
+		EmailNormalized:    "joedoe@mail.com",
+		EmailVerified:      false,
+		PasswordHash:       "123456",
+		Role:               "user",
+		Locale:             "en",
+		CreatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		UpdatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
 	}
 
 	_, err := repo.Insert(context.TODO(), user)
 	require.NoError(t, err)
 
 	t.Run("user exists", func(t *testing.T) {
-		actual, err := repo.SelectByEmail(context.TODO(), user.Email)
+		actual, err := repo.SelectByEmail(context.TODO(), user.EmailNormalized)
 		require.NoError(t, err)
 
 		require.Equal(t, user, actual)
@@ -185,16 +297,19 @@ func TestIntegrationDeleteByID(t *testing.T) {
 	repo := NewPostgres(dbConn)
 
 	user := &User{
-		ID:            uuid.New().String(),
-		Fullname:      "John Doe",
-		Username:      "jdoe",
-		Birthdate:     "2000-01-01",
-		Email:         "joedoe@mail.com",
-		EmailVerified: false,
-		PasswordHash:  "123456",
-		Role:          "user",
-		CreatedAt:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
-		UpdatedAt:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		ID:                 uuid.New().String(),
+		Fullname:           "John Doe",
+		Username:           "jdoe",
+		UsernameNormalized: "jdoe",
+		Birthdate:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Email:              "joedoe@mail.com",
+		EmailNormalized:    "joedoe@mail.com",
+		EmailVerified:      false,
+		PasswordHash:       "123456",
+		Role:               "user",
+		Locale:             "en",
+		CreatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		UpdatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
 	}
 
 	_, err := repo.Insert(context.TODO(), user)
@@ -228,30 +343,541 @@ func TestIntegrationInsertEmailVerification(t *testing.T) {
 
 	userID := uuid.New().String()
 	user := &User{
-		ID:            userID,
-		Fullname:      "John Doe",
-		Username:      "jdoe",
-		Birthdate:     "2000-01-01",
-		Email:         "joedoe@mail.com",
-		EmailVerified: false,
-		PasswordHash:  "123456",
-		Role:          "user",
-		CreatedAt:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
-		UpdatedAt:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		ID:                 userID,
+		Fullname:           "John Doe",
+		Username:           "jdoe",
+		UsernameNormalized: "jdoe",
+		Birthdate:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Email:              "joedoe@mail.com",
+		EmailNormalized:    "joedoe@mail.com",
+		EmailVerified:      false,
+		PasswordHash:       "123456",
+		Role:               "user",
+		Locale:             "en",
+		CreatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		UpdatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
 	}
 
 	_, err := repo.Insert(context.TODO(), user)
 	require.NoError(t, err)
 
 	emailVerification := EmailVerification{
-		Code:      "123456",
+		TokenHash: strings.Repeat("a", 64),
+		CodeHash:  sql.NullString{String: strings.Repeat("b", 64), Valid: true},
+		Email:     "joedoe@mail.com",
 		UserID:    userID,
-		CreatedAt: time.Time{},
-		ExpiresAt: time.Time{},
+		CreatedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		ExpiresAt: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	err = repo.InsertEmailVerification(context.TODO(), emailVerification, time.Time{}, nil)
+	require.NoError(t, err)
+
+	t.Run("select by token hash", func(t *testing.T) {
+		actual, err := repo.SelectEmailVerification(context.TODO(), emailVerification.TokenHash)
+		require.NoError(t, err)
+		require.Equal(t, &emailVerification, actual)
+
+		actual, err = repo.SelectEmailVerification(context.TODO(), strings.Repeat("c", 64))
+		require.NoError(t, err)
+		require.Nil(t, actual)
+	})
+
+	t.Run("select latest", func(t *testing.T) {
+		actual, err := repo.SelectLatestEmailVerification(context.TODO(), userID)
+		require.NoError(t, err)
+		require.Equal(t, &emailVerification, actual)
+	})
+
+	t.Run("increment attempts", func(t *testing.T) {
+		attempts, err := repo.IncrementEmailVerificationAttempts(context.TODO(), emailVerification.TokenHash)
+		require.NoError(t, err)
+		assert.Equal(t, 1, attempts)
+
+		attempts, err = repo.IncrementEmailVerificationAttempts(context.TODO(), emailVerification.TokenHash)
+		require.NoError(t, err)
+		assert.Equal(t, 2, attempts)
+
+		_, err = repo.IncrementEmailVerificationAttempts(context.TODO(), strings.Repeat("c", 64))
+		assert.Equal(t, ErrRecordNotFound, err)
+	})
+
+	t.Run("confirm", func(t *testing.T) {
+		verifiedAt := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		err := repo.ConfirmEmailVerification(context.TODO(), emailVerification.TokenHash, verifiedAt)
+		require.NoError(t, err)
+
+		actual, err := repo.SelectEmailVerification(context.TODO(), emailVerification.TokenHash)
+		require.NoError(t, err)
+		assert.Equal(t, sql.NullTime{Time: verifiedAt, Valid: true}, actual.VerifiedAt)
+
+		storageUser, err := repo.SelectByID(context.TODO(), userID)
+		require.NoError(t, err)
+		assert.True(t, storageUser.EmailVerified)
+
+		latest, err := repo.SelectLatestEmailVerification(context.TODO(), userID)
+		require.NoError(t, err)
+		assert.Nil(t, latest)
+
+		err = repo.ConfirmEmailVerification(context.TODO(), emailVerification.TokenHash, verifiedAt)
+		assert.Equal(t, ErrRecordNotFound, err)
+	})
+
+	resent := []EmailVerification{
+		{
+			TokenHash: strings.Repeat("d", 64),
+			Email:     "joedoe@mail.com",
+			UserID:    userID,
+			CreatedAt: time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC),
+			ExpiresAt: time.Date(2020, 1, 4, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			TokenHash: strings.Repeat("e", 64),
+			Email:     "joedoe@mail.com",
+			UserID:    userID,
+			CreatedAt: time.Date(2020, 1, 3, 0, 5, 0, 0, time.UTC),
+			ExpiresAt: time.Date(2020, 1, 4, 0, 5, 0, 0, time.UTC),
+		},
+	}
+
+	t.Run("invalidate outstanding on insert", func(t *testing.T) {
+		for _, in := range resent {
+			require.NoError(t, repo.InsertEmailVerification(context.TODO(), in, time.Time{}, nil))
+		}
+
+		first, err := repo.SelectEmailVerification(context.TODO(), resent[0].TokenHash)
+		require.NoError(t, err)
+		assert.Equal(t, sql.NullTime{Time: resent[1].CreatedAt, Valid: true}, first.InvalidatedAt)
+
+		// The verified row is left untouched
+		confirmed, err := repo.SelectEmailVerification(context.TODO(), emailVerification.TokenHash)
+		require.NoError(t, err)
+		assert.False(t, confirmed.InvalidatedAt.Valid)
+
+		latest, err := repo.SelectLatestEmailVerification(context.TODO(), userID)
+		require.NoError(t, err)
+		require.NotNil(t, latest)
+		assert.Equal(t, resent[1].TokenHash, latest.TokenHash)
+
+		err = repo.ConfirmEmailVerification(context.TODO(), resent[0].TokenHash, resent[1].CreatedAt)
+		assert.Equal(t, ErrRecordNotFound, err)
+	})
+
+	t.Run("activity", func(t *testing.T) {
+		actual, err := repo.SelectEmailVerificationActivity(
+			context.TODO(), userID, "joedoe@mail.com", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+		)
+		require.NoError(t, err)
+
+		assert.Equal(t, &EmailVerificationActivity{
+			UserCount:     2,
+			UserOldest:    sql.NullTime{Time: resent[0].CreatedAt, Valid: true},
+			EmailCount:    2,
+			EmailOldest:   sql.NullTime{Time: resent[0].CreatedAt, Valid: true},
+			LastCreatedAt: sql.NullTime{Time: resent[1].CreatedAt, Valid: true},
+		}, actual)
+
+		actual, err = repo.SelectEmailVerificationActivity(
+			context.TODO(), uuid.New().String(), "other@mail.com", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, &EmailVerificationActivity{}, actual)
+	})
+
+	t.Run("delete expired", func(t *testing.T) {
+		deleted, err := repo.DeleteExpiredEmailVerifications(
+			context.TODO(), time.Date(2020, 1, 4, 0, 1, 0, 0, time.UTC), time.Date(2020, 1, 3, 0, 1, 0, 0, time.UTC),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, 2, deleted)
+
+		actual, err := repo.SelectEmailVerification(context.TODO(), resent[1].TokenHash)
+		require.NoError(t, err)
+		assert.NotNil(t, actual)
+	})
+}
+
+func TestIntegrationInsertEmailVerification_concurrentLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	dbConn := setupDB(t)
+	defer teardownDB(t, dbConn)
+
+	repo := NewPostgres(dbConn)
+
+	userID := uuid.New().String()
+	_, err := repo.Insert(context.TODO(), &User{
+		ID:                 userID,
+		Fullname:           "John Doe",
+		Username:           "jdoe",
+		UsernameNormalized: "jdoe",
+		Birthdate:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Email:              "joedoe@mail.com",
+		EmailNormalized:    "joedoe@mail.com",
+		PasswordHash:       "123456",
+		Role:               "user",
+		Locale:             "en",
+		CreatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		UpdatedAt:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+
+	const (
+		callers  = 10
+		maxSends = 2
+	)
+
+	errLimited := errors.New("limited")
+	check := func(a *EmailVerificationActivity) error {
+		if a.UserCount >= maxSends {
+			return errLimited
+		}
+		return nil
 	}
 
-	err = repo.InsertEmailVerification(context.TODO(), emailVerification)
+	var (
+		wg               sync.WaitGroup
+		inserted, denied int32
+	)
+
+	for i := 0; i < callers; i++ {
+		i := i
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			createdAt := time.Date(2020, 1, 3, 0, 0, i, 0, time.UTC)
+			err := repo.InsertEmailVerification(context.TODO(), EmailVerification{
+				TokenHash: fmt.Sprintf("%064d", i),
+				Email:     "joedoe@mail.com",
+				UserID:    userID,
+				CreatedAt: createdAt,
+				ExpiresAt: createdAt.Add(24 * time.Hour),
+			}, time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), check)
+
+			switch err {
+			case nil:
+				atomic.AddInt32(&inserted, 1)
+			case errLimited:
+				atomic.AddInt32(&denied, 1)
+			default:
+				t.Errorf("unexpected error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Concurrent inserts are checked one after the other, so that they cannot all pass the limit
+	assert.Equal(t, int32(maxSends), inserted)
+	assert.Equal(t, int32(callers-maxSends), denied)
+
+	activity, err := repo.SelectEmailVerificationActivity(
+		context.TODO(), userID, "joedoe@mail.com", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+	)
 	require.NoError(t, err)
+	assert.Equal(t, maxSends, activity.UserCount)
+}
+
+func TestIntegrationAdmin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	dbConn := setupDB(t)
+	defer teardownDB(t, dbConn)
+
+	repo := NewPostgres(dbConn)
+
+	newUser := func(username, fullname string, createdAt time.Time) *User {
+		return &User{
+			ID:                 uuid.New().String(),
+			Fullname:           fullname,
+			Username:           username,
+			UsernameNormalized: username,
+			Birthdate:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Email:              username + "@mail.com",
+			EmailNormalized:    username + "@mail.com",
+			PasswordHash:       "123456",
+			Role:               "user",
+			Locale:             "en",
+			CreatedAt:          createdAt,
+			UpdatedAt:          createdAt,
+		}
+	}
+
+	jdoe := newUser("jdoe", "John Doe", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	jane := newUser("jane_doe", "Jane Doe", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))
+	bob := newUser("bob", "Bob 100%", time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC))
+
+	for _, u := range []*User{jdoe, jane, bob} {
+		_, err := repo.Insert(context.TODO(), u)
+		require.NoError(t, err)
+	}
+
+	updatedAt := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("update role", func(t *testing.T) {
+		require.NoError(t, repo.UpdateRole(context.TODO(), jdoe.ID, "admin", updatedAt))
+
+		actual, err := repo.SelectByID(context.TODO(), jdoe.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "admin", actual.Role)
+		assert.Equal(t, sql.NullTime{Time: updatedAt, Valid: true}, actual.TokensRevokedAt)
+
+		err = repo.UpdateRole(context.TODO(), uuid.New().String(), "admin", updatedAt)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+	})
+
+	t.Run("update password hash", func(t *testing.T) {
+		require.NoError(t, repo.UpdatePasswordHash(context.TODO(), jane.ID, "654321", updatedAt))
+
+		actual, err := repo.SelectByID(context.TODO(), jane.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "654321", actual.PasswordHash)
+		assert.Equal(t, sql.NullTime{Time: updatedAt, Valid: true}, actual.TokensRevokedAt)
+	})
+
+	t.Run("update email verified", func(t *testing.T) {
+		require.NoError(t, repo.UpdateEmailVerified(context.TODO(), bob.ID, updatedAt))
+
+		actual, err := repo.SelectByID(context.TODO(), bob.ID)
+		require.NoError(t, err)
+		assert.True(t, actual.EmailVerified)
+	})
+
+	t.Run("revoke tokens", func(t *testing.T) {
+		revokedAt := updatedAt.Add(time.Hour)
+		require.NoError(t, repo.RevokeTokens(context.TODO(), bob.ID, revokedAt))
+
+		actual, err := repo.SelectByID(context.TODO(), bob.ID)
+		require.NoError(t, err)
+		assert.Equal(t, sql.NullTime{Time: revokedAt, Valid: true}, actual.TokensRevokedAt)
+	})
+
+	t.Run("delete and restore", func(t *testing.T) {
+		err := repo.RestoreByID(context.TODO(), bob.ID, updatedAt)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+
+		require.NoError(t, repo.DeleteByID(context.TODO(), bob.ID))
+
+		err = repo.UpdateEmailVerified(context.TODO(), bob.ID, updatedAt)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+
+		deleted, err := repo.SelectUsers(context.TODO(), UserFilter{Query: "bob", IncludeDeleted: true, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, deleted, 1)
+		assert.True(t, deleted[0].DeletedAt.Valid)
+
+		require.NoError(t, repo.RestoreByID(context.TODO(), bob.ID, updatedAt))
+
+		actual, err := repo.SelectByID(context.TODO(), bob.ID)
+		require.NoError(t, err)
+		assert.NotNil(t, actual)
+	})
+
+	t.Run("select users", func(t *testing.T) {
+		require.NoError(t, repo.DeleteByID(context.TODO(), jane.ID))
+
+		testCases := []struct {
+			name        string
+			givenFilter UserFilter
+			expectedIDs []string
+		}{
+			{
+				name:        "all",
+				givenFilter: UserFilter{Limit: 10},
+				expectedIDs: []string{jdoe.ID, bob.ID},
+			},
+			{
+				name:        "including deleted",
+				givenFilter: UserFilter{IncludeDeleted: true, Limit: 10},
+				expectedIDs: []string{jdoe.ID, jane.ID, bob.ID},
+			},
+			{
+				name:        "query matches the full name case insensitively",
+				givenFilter: UserFilter{Query: "DOE", IncludeDeleted: true, Limit: 10},
+				expectedIDs: []string{jdoe.ID, jane.ID},
+			},
+			{
+				name:        "wildcards are matched literally",
+				givenFilter: UserFilter{Query: "_", IncludeDeleted: true, Limit: 10},
+				expectedIDs: []string{jane.ID},
+			},
+			{
+				name:        "percent sign",
+				givenFilter: UserFilter{Query: "%", Limit: 10},
+				expectedIDs: []string{bob.ID},
+			},
+			{
+				name:        "id",
+				givenFilter: UserFilter{Query: bob.ID, Limit: 10},
+				expectedIDs: []string{bob.ID},
+			},
+			{
+				name:        "role",
+				givenFilter: UserFilter{Role: "admin", Limit: 10},
+				expectedIDs: []string{jdoe.ID},
+			},
+			{
+				name:        "page",
+				givenFilter: UserFilter{IncludeDeleted: true, Limit: 1, Offset: 1},
+				expectedIDs: []string{jane.ID},
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				actual, err := repo.SelectUsers(context.TODO(), tc.givenFilter)
+				require.NoError(t, err)
+
+				ids := make([]string, 0, len(actual))
+				for _, u := range actual {
+					ids = append(ids, u.ID)
+				}
+				assert.Equal(t, tc.expectedIDs, ids)
+			})
+		}
+	})
+}
+
+func TestIntegrationSessions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	dbConn := setupDB(t)
+	defer teardownDB(t, dbConn)
+
+	repo := NewPostgres(dbConn)
+
+	createdAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	user, err := repo.Insert(context.TODO(), &User{
+		ID:                 uuid.New().String(),
+		Fullname:           "John Doe",
+		Username:           "jdoe",
+		UsernameNormalized: "jdoe",
+		Birthdate:          time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Email:              "jdoe@mail.com",
+		EmailNormalized:    "jdoe@mail.com",
+		PasswordHash:       "123456",
+		Role:               "user",
+		Locale:             "en",
+		CreatedAt:          createdAt,
+		UpdatedAt:          createdAt,
+	})
+	require.NoError(t, err)
+
+	newSession := func(minutes int) Session {
+		at := createdAt.Add(time.Duration(minutes) * time.Minute)
+		return Session{
+			ID:         uuid.New().String(),
+			UserID:     user.ID,
+			UserAgent:  "curl/7.79.1",
+			IP:         "192.0.2.1",
+			CreatedAt:  at,
+			LastSeenAt: at,
+			ExpiresAt:  at.Add(24 * time.Hour),
+		}
+	}
+
+	laptop, phone, tablet := newSession(0), newSession(1), newSession(2)
+	for _, s := range []Session{laptop, phone, tablet} {
+		require.NoError(t, repo.InsertSession(context.TODO(), s))
+	}
+
+	now := createdAt.Add(time.Hour)
+
+	t.Run("select session", func(t *testing.T) {
+		actual, err := repo.SelectSession(context.TODO(), laptop.ID)
+		require.NoError(t, err)
+		assert.Equal(t, &laptop, actual)
+
+		actual, err = repo.SelectSession(context.TODO(), uuid.New().String())
+		require.NoError(t, err)
+		assert.Nil(t, actual)
+	})
+
+	t.Run("touch session", func(t *testing.T) {
+		require.NoError(t, repo.TouchSession(context.TODO(), laptop.ID, now))
+
+		actual, err := repo.SelectActiveSessions(context.TODO(), user.ID, now)
+		require.NoError(t, err)
+		require.Len(t, actual, 3)
+		assert.Equal(t, []string{laptop.ID, tablet.ID, phone.ID}, []string{actual[0].ID, actual[1].ID, actual[2].ID})
+		assert.Equal(t, now, actual[0].LastSeenAt)
+	})
+
+	t.Run("revoke session", func(t *testing.T) {
+		require.NoError(t, repo.RevokeSession(context.TODO(), phone.ID, user.ID, now))
+
+		err := repo.RevokeSession(context.TODO(), phone.ID, user.ID, now)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+
+		actual, err := repo.SelectSession(context.TODO(), phone.ID)
+		require.NoError(t, err)
+		assert.Equal(t, sql.NullTime{Time: now, Valid: true}, actual.RevokedAt)
+	})
+
+	t.Run("revoke other sessions", func(t *testing.T) {
+		revoked, err := repo.RevokeOtherSessions(context.TODO(), user.ID, laptop.ID, now)
+		require.NoError(t, err)
+		assert.Equal(t, 1, revoked)
+
+		actual, err := repo.SelectActiveSessions(context.TODO(), user.ID, now)
+		require.NoError(t, err)
+		require.Len(t, actual, 1)
+		assert.Equal(t, laptop.ID, actual[0].ID)
+	})
+
+	t.Run("revoked tokens", func(t *testing.T) {
+		require.NoError(t, repo.RevokeTokens(context.TODO(), user.ID, createdAt.Add(time.Second)))
+
+		actual, err := repo.SelectActiveSessions(context.TODO(), user.ID, now)
+		require.NoError(t, err)
+		assert.Empty(t, actual)
+	})
+
+	t.Run("delete expired sessions", func(t *testing.T) {
+		deleted, err := repo.DeleteExpiredSessions(context.TODO(), createdAt.Add(48*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, 3, deleted)
+	})
+}
+
+func TestIntegrationTracing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	dbConn := setupDB(t)
+	defer teardownDB(t, dbConn)
+
+	exporter := tracetest.NewInMemoryExporter()
+	repo := NewPostgres(dbConn, WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))))
+
+	id := uuid.New().String()
+
+	_, err := repo.SelectByID(context.TODO(), id)
+	require.NoError(t, err)
+
+	err = repo.RevokeTokens(context.TODO(), id, time.Now())
+	require.ErrorIs(t, err, ErrRecordNotFound)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+
+	for i, name := range []string{"SelectByID", "RevokeTokens"} {
+		assert.Equal(t, "users.repository."+name, spans[i].Name)
+		assert.Equal(t, codes.Unset, spans[i].Status.Code)
+		assert.Contains(t, spans[i].Attributes, attribute.String("db.system", "postgresql"))
+		assert.Contains(t, spans[i].Attributes, attribute.String("db.operation", name))
+		assert.Contains(t, spans[i].Attributes, attribute.String("user.id", id))
+	}
 }
 
 func setupDB(t *testing.T) *sqlx.DB {
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"database/sql"
 	"errors"
 	"time"
 )
@@ -12,21 +13,78 @@ var (
 
 // User represents a user in the database table
 type User struct {
-	ID            string
-	Fullname      string
-	Username      string
-	Birthdate     string
-	Email         string
-	PasswordHash  string
-	Role          string
-	EmailVerified bool
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
+	ID       string
+	Fullname string
+	Username string
+	// UsernameNormalized is the confusable skeleton of the username, unique among users
+	UsernameNormalized string
+	// Birthdate is stored in a DATE column and read at midnight UTC
+	Birthdate time.Time
+	Email     string
+	// EmailNormalized is the canonical form of the email, unique among users
+	EmailNormalized string
+	PasswordHash    string
+	Role            string
+	Locale          string
+	EmailVerified   bool
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	DeletedAt       sql.NullTime
+	// TokensRevokedAt invalidates the tokens of the user issued before it
+	TokensRevokedAt sql.NullTime
 }
 
+// UserFilter selects users
+type UserFilter struct {
+	// Query matches a substring of the username, email or full name, case insensitively, or the id
+	Query string
+	// Role matches the role of users, any role if empty
+	Role           string
+	IncludeDeleted bool
+	Limit          int
+	Offset         int
+}
+
+// EmailVerification represents an email verification in the database table.
+// Only hashes of the link token and of the short code are stored.
 type EmailVerification struct {
-	Code      string
-	UserID    string
-	CreatedAt time.Time
+	TokenHash string
+	// CodeHash is null for verifications sent without a short code
+	CodeHash sql.NullString
+	// Email is the canonical address the verification was sent to
+	Email      string
+	UserID     string
+	Attempts   int
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	VerifiedAt sql.NullTime
+	// InvalidatedAt is set when a newer verification was sent to the user
+	InvalidatedAt sql.NullTime
+}
+
+// EmailVerificationActivity summarizes the email verifications sent to a user and to an address
+type EmailVerificationActivity struct {
+	// UserCount and UserOldest describe the verifications of the user in the counted period
+	UserCount  int
+	UserOldest sql.NullTime
+
+	// EmailCount and EmailOldest describe the verifications sent to the address in the counted period
+	EmailCount  int
+	EmailOldest sql.NullTime
+
+	// LastCreatedAt is the creation time of the latest verification of the user or the address
+	LastCreatedAt sql.NullTime
+}
+
+// Session represents a login of a user in the database table
+type Session struct {
+	ID         string
+	UserID     string
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	// ExpiresAt is the expiration of the token issued with the session
 	ExpiresAt time.Time
+	RevokedAt sql.NullTime
 }
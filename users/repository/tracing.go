@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans of the repository
+const tracerName = "github.com/alesr/stdservices/users/repository"
+
+// attrUserID is the only user data recorded on spans; query arguments are not
+const attrUserID = attribute.Key("user.id")
+
+// PostgresOption configures a Postgres repository
+type PostgresOption func(*Postgres)
+
+// WithTracerProvider sets the provider of the OpenTelemetry spans recorded for every query.
+// It defaults to the global provider, see otel.SetTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) PostgresOption {
+	return func(p *Postgres) {
+		p.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// startSpan starts a span for the repository method named operation
+func (p *Postgres) startSpan(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := p.tracer
+	if tracer == nil {
+		tracer = otel.Tracer(tracerName)
+	}
+
+	return tracer.Start(ctx, "users.repository."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationKey.String(operation)),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// endSpan records unexpected errors on the span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrRecordNotFound) && !errors.Is(err, ErrDuplicateRecord) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
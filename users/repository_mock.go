@@ -3,6 +3,7 @@ package users
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/alesr/stdservices/users/repository"
 )
@@ -10,11 +11,31 @@ import (
 var _ repo = (*repositoryMock)(nil)
 
 type repositoryMock struct {
-	insertFunc                  func(ctx context.Context, user *repository.User) (*repository.User, error)
-	selectByIDFunc              func(ctx context.Context, id string) (*repository.User, error)
-	selectByEmailFunc           func(ctx context.Context, email string) (*repository.User, error)
-	deleteByIDFunc              func(ctx context.Context, id string) error
-	insertEmailVerificationFunc func(ctx context.Context, in repository.EmailVerification) error
+	insertFunc                             func(ctx context.Context, user *repository.User) (*repository.User, error)
+	selectByIDFunc                         func(ctx context.Context, id string) (*repository.User, error)
+	selectByIDsFunc                        func(ctx context.Context, ids []string) ([]repository.User, error)
+	selectByEmailFunc                      func(ctx context.Context, email string) (*repository.User, error)
+	deleteByIDFunc                         func(ctx context.Context, id string) error
+	insertEmailVerificationFunc            func(ctx context.Context, in repository.EmailVerification) error
+	selectEmailVerificationFunc            func(ctx context.Context, tokenHash string) (*repository.EmailVerification, error)
+	selectLatestEmailVerificationFunc      func(ctx context.Context, userID string) (*repository.EmailVerification, error)
+	incrementEmailVerificationAttemptsFunc func(ctx context.Context, tokenHash string) (int, error)
+	confirmEmailVerificationFunc           func(ctx context.Context, tokenHash string, verifiedAt time.Time) error
+	selectEmailVerificationActivityFunc    func(ctx context.Context, userID, email string, since time.Time) (*repository.EmailVerificationActivity, error)
+	deleteExpiredEmailVerificationsFunc    func(ctx context.Context, expiredBefore, createdBefore time.Time) (int, error)
+	selectUsersFunc                        func(ctx context.Context, filter repository.UserFilter) ([]repository.User, error)
+	restoreByIDFunc                        func(ctx context.Context, id string, updatedAt time.Time) error
+	updateRoleFunc                         func(ctx context.Context, id, role string, updatedAt time.Time) error
+	updatePasswordHashFunc                 func(ctx context.Context, id, passwordHash string, updatedAt time.Time) error
+	updateEmailVerifiedFunc                func(ctx context.Context, id string, updatedAt time.Time) error
+	revokeTokensFunc                       func(ctx context.Context, id string, revokedAt time.Time) error
+	insertSessionFunc                      func(ctx context.Context, in repository.Session) error
+	selectSessionFunc                      func(ctx context.Context, id string) (*repository.Session, error)
+	selectActiveSessionsFunc               func(ctx context.Context, userID string, now time.Time) ([]repository.Session, error)
+	touchSessionFunc                       func(ctx context.Context, id string, lastSeenAt time.Time) error
+	revokeSessionFunc                      func(ctx context.Context, id, userID string, revokedAt time.Time) error
+	revokeOtherSessionsFunc                func(ctx context.Context, userID, keepID string, revokedAt time.Time) (int, error)
+	deleteExpiredSessionsFunc              func(ctx context.Context, expiredBefore time.Time) (int, error)
 }
 
 func (m *repositoryMock) Insert(ctx context.Context, user *repository.User) (*repository.User, error) {
@@ -31,6 +52,13 @@ func (m *repositoryMock) SelectByID(ctx context.Context, id string) (*repository
 	return m.selectByIDFunc(ctx, id)
 }
 
+func (m *repositoryMock) SelectByIDs(ctx context.Context, ids []string) ([]repository.User, error) {
+	if m.selectByIDsFunc == nil {
+		return nil, errors.New("repositoryMock.selectByIDsFunc is nil")
+	}
+	return m.selectByIDsFunc(ctx, ids)
+}
+
 func (m *repositoryMock) SelectByEmail(ctx context.Context, email string) (*repository.User, error) {
 	if m.selectByEmailFunc == nil {
 		return nil, errors.New("repositoryMock.selectByEmailFunc is nil")
@@ -45,9 +73,158 @@ func (m *repositoryMock) DeleteByID(ctx context.Context, id string) error {
 	return m.deleteByIDFunc(ctx, id)
 }
 
-func (m *repositoryMock) InsertEmailVerification(ctx context.Context, in repository.EmailVerification) error {
+// InsertEmailVerification checks the activity returned by selectEmailVerificationActivityFunc
+// before calling insertEmailVerificationFunc, like the repository does in a transaction
+func (m *repositoryMock) InsertEmailVerification(
+	ctx context.Context, in repository.EmailVerification, since time.Time,
+	check func(a *repository.EmailVerificationActivity) error,
+) error {
+	if check != nil {
+		activity, err := m.SelectEmailVerificationActivity(ctx, in.UserID, in.Email, since)
+		if err != nil {
+			return err
+		}
+
+		if err := check(activity); err != nil {
+			return err
+		}
+	}
+
 	if m.insertEmailVerificationFunc == nil {
 		return errors.New("repositoryMock.insertEmailVerificationFunc is nil")
 	}
 	return m.insertEmailVerificationFunc(ctx, in)
 }
+
+func (m *repositoryMock) SelectEmailVerification(ctx context.Context, tokenHash string) (*repository.EmailVerification, error) {
+	if m.selectEmailVerificationFunc == nil {
+		return nil, errors.New("repositoryMock.selectEmailVerificationFunc is nil")
+	}
+	return m.selectEmailVerificationFunc(ctx, tokenHash)
+}
+
+func (m *repositoryMock) SelectLatestEmailVerification(ctx context.Context, userID string) (*repository.EmailVerification, error) {
+	if m.selectLatestEmailVerificationFunc == nil {
+		return nil, errors.New("repositoryMock.selectLatestEmailVerificationFunc is nil")
+	}
+	return m.selectLatestEmailVerificationFunc(ctx, userID)
+}
+
+func (m *repositoryMock) IncrementEmailVerificationAttempts(ctx context.Context, tokenHash string) (int, error) {
+	if m.incrementEmailVerificationAttemptsFunc == nil {
+		return 0, errors.New("repositoryMock.incrementEmailVerificationAttemptsFunc is nil")
+	}
+	return m.incrementEmailVerificationAttemptsFunc(ctx, tokenHash)
+}
+
+func (m *repositoryMock) ConfirmEmailVerification(ctx context.Context, tokenHash string, verifiedAt time.Time) error {
+	if m.confirmEmailVerificationFunc == nil {
+		return errors.New("repositoryMock.confirmEmailVerificationFunc is nil")
+	}
+	return m.confirmEmailVerificationFunc(ctx, tokenHash, verifiedAt)
+}
+
+func (m *repositoryMock) SelectEmailVerificationActivity(ctx context.Context, userID, email string, since time.Time) (*repository.EmailVerificationActivity, error) {
+	if m.selectEmailVerificationActivityFunc == nil {
+		return nil, errors.New("repositoryMock.selectEmailVerificationActivityFunc is nil")
+	}
+	return m.selectEmailVerificationActivityFunc(ctx, userID, email, since)
+}
+
+func (m *repositoryMock) DeleteExpiredEmailVerifications(ctx context.Context, expiredBefore, createdBefore time.Time) (int, error) {
+	if m.deleteExpiredEmailVerificationsFunc == nil {
+		return 0, errors.New("repositoryMock.deleteExpiredEmailVerificationsFunc is nil")
+	}
+	return m.deleteExpiredEmailVerificationsFunc(ctx, expiredBefore, createdBefore)
+}
+
+func (m *repositoryMock) SelectUsers(ctx context.Context, filter repository.UserFilter) ([]repository.User, error) {
+	if m.selectUsersFunc == nil {
+		return nil, errors.New("repositoryMock.selectUsersFunc is nil")
+	}
+	return m.selectUsersFunc(ctx, filter)
+}
+
+func (m *repositoryMock) RestoreByID(ctx context.Context, id string, updatedAt time.Time) error {
+	if m.restoreByIDFunc == nil {
+		return errors.New("repositoryMock.restoreByIDFunc is nil")
+	}
+	return m.restoreByIDFunc(ctx, id, updatedAt)
+}
+
+func (m *repositoryMock) UpdateRole(ctx context.Context, id, role string, updatedAt time.Time) error {
+	if m.updateRoleFunc == nil {
+		return errors.New("repositoryMock.updateRoleFunc is nil")
+	}
+	return m.updateRoleFunc(ctx, id, role, updatedAt)
+}
+
+func (m *repositoryMock) UpdatePasswordHash(ctx context.Context, id, passwordHash string, updatedAt time.Time) error {
+	if m.updatePasswordHashFunc == nil {
+		return errors.New("repositoryMock.updatePasswordHashFunc is nil")
+	}
+	return m.updatePasswordHashFunc(ctx, id, passwordHash, updatedAt)
+}
+
+func (m *repositoryMock) UpdateEmailVerified(ctx context.Context, id string, updatedAt time.Time) error {
+	if m.updateEmailVerifiedFunc == nil {
+		return errors.New("repositoryMock.updateEmailVerifiedFunc is nil")
+	}
+	return m.updateEmailVerifiedFunc(ctx, id, updatedAt)
+}
+
+func (m *repositoryMock) RevokeTokens(ctx context.Context, id string, revokedAt time.Time) error {
+	if m.revokeTokensFunc == nil {
+		return errors.New("repositoryMock.revokeTokensFunc is nil")
+	}
+	return m.revokeTokensFunc(ctx, id, revokedAt)
+}
+
+func (m *repositoryMock) InsertSession(ctx context.Context, in repository.Session) error {
+	if m.insertSessionFunc == nil {
+		return errors.New("repositoryMock.insertSessionFunc is nil")
+	}
+	return m.insertSessionFunc(ctx, in)
+}
+
+func (m *repositoryMock) SelectSession(ctx context.Context, id string) (*repository.Session, error) {
+	if m.selectSessionFunc == nil {
+		return nil, errors.New("repositoryMock.selectSessionFunc is nil")
+	}
+	return m.selectSessionFunc(ctx, id)
+}
+
+func (m *repositoryMock) SelectActiveSessions(ctx context.Context, userID string, now time.Time) ([]repository.Session, error) {
+	if m.selectActiveSessionsFunc == nil {
+		return nil, errors.New("repositoryMock.selectActiveSessionsFunc is nil")
+	}
+	return m.selectActiveSessionsFunc(ctx, userID, now)
+}
+
+func (m *repositoryMock) TouchSession(ctx context.Context, id string, lastSeenAt time.Time) error {
+	if m.touchSessionFunc == nil {
+		return errors.New("repositoryMock.touchSessionFunc is nil")
+	}
+	return m.touchSessionFunc(ctx, id, lastSeenAt)
+}
+
+func (m *repositoryMock) RevokeSession(ctx context.Context, id, userID string, revokedAt time.Time) error {
+	if m.revokeSessionFunc == nil {
+		return errors.New("repositoryMock.revokeSessionFunc is nil")
+	}
+	return m.revokeSessionFunc(ctx, id, userID, revokedAt)
+}
+
+func (m *repositoryMock) RevokeOtherSessions(ctx context.Context, userID, keepID string, revokedAt time.Time) (int, error) {
+	if m.revokeOtherSessionsFunc == nil {
+		return 0, errors.New("repositoryMock.revokeOtherSessionsFunc is nil")
+	}
+	return m.revokeOtherSessionsFunc(ctx, userID, keepID, revokedAt)
+}
+
+func (m *repositoryMock) DeleteExpiredSessions(ctx context.Context, expiredBefore time.Time) (int, error) {
+	if m.deleteExpiredSessionsFunc == nil {
+		return 0, errors.New("repositoryMock.deleteExpiredSessionsFunc is nil")
+	}
+	return m.deleteExpiredSessionsFunc(ctx, expiredBefore)
+}
@@ -0,0 +1,155 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alesr/stdservices/pkg/validate"
+	"github.com/alesr/stdservices/users/repository"
+	"go.uber.org/zap"
+)
+
+// Session is a login of a user, created with each token by GenerateToken
+type Session struct {
+	ID        string
+	UserAgent string
+	IP        string
+	CreatedAt time.Time
+	// LastSeenAt is updated by VerifyToken at most once a minute
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+// ListSessions returns the active sessions of a user, most recently seen first
+func (s *DefaultService) ListSessions(ctx context.Context, userID string) (_ []Session, err error) {
+	ctx, span := s.startSpan(ctx, "users.ListSessions", attrUserID.String(userID))
+	defer func() { endSpan(span, err) }()
+
+	if err := validate.ID(userID); err != nil {
+		return nil, fmt.Errorf("could not validate id: %w", newValidationE("id", err.Error()))
+	}
+
+	storageSessions, err := s.repo.SelectActiveSessions(ctx, userID, s.now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("could not select sessions: %w", err)
+	}
+
+	sessions := make([]Session, 0, len(storageSessions))
+	for i := range storageSessions {
+		sessions = append(sessions, newSessionFromRepository(&storageSessions[i]))
+	}
+	return sessions, nil
+}
+
+// RevokeSession revokes a session of a user. The token issued with the session is rejected from then on.
+func (s *DefaultService) RevokeSession(ctx context.Context, userID, sessionID string) (err error) {
+	ctx, span := s.startSpan(ctx, "users.RevokeSession", attrUserID.String(userID))
+	defer func() { endSpan(span, err) }()
+	defer func() {
+		s.audit(ctx, AuditEntry{
+			Action:    AuditSessionsRevoked,
+			SubjectID: userID,
+			Metadata:  map[string]string{"session_id": sessionID},
+		}, err)
+	}()
+
+	if err := validate.ID(userID); err != nil {
+		return fmt.Errorf("could not validate id: %w", newValidationE("id", err.Error()))
+	}
+
+	if err := validate.ID(sessionID); err != nil {
+		return fmt.Errorf("could not validate session id: %w", newValidationE("session_id", err.Error()))
+	}
+
+	if err := s.repo.RevokeSession(ctx, sessionID, userID, s.now().UTC()); err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return fmt.Errorf("could not revoke session: %w", err)
+	}
+	return nil
+}
+
+// RevokeOtherSessions revokes every session of a user but the current one, such as after a
+// suspicious login. An empty current session id revokes every session.
+func (s *DefaultService) RevokeOtherSessions(ctx context.Context, userID, currentSessionID string) (err error) {
+	ctx, span := s.startSpan(ctx, "users.RevokeOtherSessions", attrUserID.String(userID))
+	defer func() { endSpan(span, err) }()
+
+	var revoked int
+	defer func() {
+		s.audit(ctx, AuditEntry{
+			Action:    AuditSessionsRevoked,
+			SubjectID: userID,
+			Metadata:  map[string]string{"kept_session_id": currentSessionID, "revoked": fmt.Sprint(revoked)},
+		}, err)
+	}()
+
+	if err := validate.ID(userID); err != nil {
+		return fmt.Errorf("could not validate id: %w", newValidationE("id", err.Error()))
+	}
+
+	if currentSessionID != "" {
+		if err := validate.ID(currentSessionID); err != nil {
+			return fmt.Errorf("could not validate session id: %w", newValidationE("session_id", err.Error()))
+		}
+	}
+
+	revoked, err = s.repo.RevokeOtherSessions(ctx, userID, currentSessionID, s.now().UTC())
+	if err != nil {
+		return fmt.Errorf("could not revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// CleanupSessions deletes expired sessions and returns how many were deleted
+func (s *DefaultService) CleanupSessions(ctx context.Context) (_ int, err error) {
+	ctx, span := s.startSpan(ctx, "users.CleanupSessions")
+	defer func() { endSpan(span, err) }()
+
+	deleted, err := s.repo.DeleteExpiredSessions(ctx, s.now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("could not delete expired sessions: %w", err)
+	}
+	return deleted, nil
+}
+
+// verifySession checks that the session a token was issued with belongs to the user and is not revoked,
+// and records the activity of the session
+func (s *DefaultService) verifySession(ctx context.Context, sessionID, userID string) error {
+	session, err := s.repo.SelectSession(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("could not select session: %w", err)
+	}
+
+	if session == nil || session.UserID != userID {
+		return fmt.Errorf("could not find session: %w", ErrTokenInvalid)
+	}
+
+	if session.RevokedAt.Valid {
+		return ErrTokenRevoked
+	}
+
+	// Writes are throttled, so that verifying every request of a client does not write every time.
+	// Like auditing, recording the activity is best effort.
+	now := s.now().UTC()
+	if session.LastSeenAt.Before(now.Add(-sessionTouchInterval)) {
+		if err := s.repo.TouchSession(ctx, sessionID, now); err != nil {
+			s.logger.Error("could not touch session", zap.String("session_id", sessionID), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func newSessionFromRepository(session *repository.Session) Session {
+	return Session{
+		ID:         session.ID,
+		UserAgent:  session.UserAgent,
+		IP:         session.IP,
+		CreatedAt:  session.CreatedAt,
+		LastSeenAt: session.LastSeenAt,
+		ExpiresAt:  session.ExpiresAt,
+	}
+}
@@ -0,0 +1,330 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestGenerateToken_session(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	userID := uuid.NewString()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password%&123"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	var (
+		session repository.Session
+		touched []time.Time
+	)
+
+	svc := DefaultService{
+		logger:        zap.NewNop(),
+		clock:         func() time.Time { return now },
+		jwtSigningKey: "secret",
+		repo: &repositoryMock{
+			selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+				return &repository.User{ID: userID, Email: email, PasswordHash: string(hash), Role: "user"}, nil
+			},
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				return &repository.User{ID: id, Username: "jdoe", Role: "user"}, nil
+			},
+			insertSessionFunc: func(ctx context.Context, in repository.Session) error {
+				session = in
+				return nil
+			},
+			selectSessionFunc: func(ctx context.Context, id string) (*repository.Session, error) {
+				if id != session.ID {
+					return nil, nil
+				}
+				s := session
+				return &s, nil
+			},
+			touchSessionFunc: func(ctx context.Context, id string, lastSeenAt time.Time) error {
+				touched = append(touched, lastSeenAt)
+				session.LastSeenAt = lastSeenAt
+				return nil
+			},
+		},
+	}
+
+	ctx := WithRequestInfo(context.Background(), RequestInfo{IP: "203.0.113.7", UserAgent: strings.Repeat("a", 600)})
+
+	token, err := svc.GenerateToken(ctx, "jdoe@mail.com", "password%&123")
+	require.NoError(t, err)
+
+	_, err = uuid.Parse(session.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, userID, session.UserID)
+	assert.Equal(t, "203.0.113.7", session.IP)
+	assert.Len(t, session.UserAgent, maxSessionUserAgentLen)
+	assert.Equal(t, now, session.CreatedAt)
+	assert.Equal(t, now, session.LastSeenAt)
+	assert.Equal(t, now.Add(defaultTokenTTL), session.ExpiresAt)
+
+	actual, err := svc.VerifyToken(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, actual.SessionID)
+
+	// The last activity is written at most once per touch interval
+	now = now.Add(30 * time.Second)
+	_, err = svc.VerifyToken(context.Background(), token)
+	require.NoError(t, err)
+	assert.Empty(t, touched)
+
+	now = now.Add(time.Minute)
+	_, err = svc.VerifyToken(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{now}, touched)
+
+	session.RevokedAt = sql.NullTime{Time: now, Valid: true}
+	_, err = svc.VerifyToken(context.Background(), token)
+	assert.Equal(t, ErrTokenRevoked, err)
+}
+
+func TestVerifyToken_session(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	userID := uuid.NewString()
+	sessionID := uuid.NewString()
+
+	testCases := []struct {
+		name              string
+		givenSession      *repository.Session
+		givenTouchErr     error
+		expectedSessionID string
+		expectedErr       error
+	}{
+		{
+			name:              "active session",
+			givenSession:      &repository.Session{ID: sessionID, UserID: userID, LastSeenAt: now.Add(-time.Hour)},
+			expectedSessionID: sessionID,
+		},
+		{
+			name:              "touch error is ignored",
+			givenSession:      &repository.Session{ID: sessionID, UserID: userID, LastSeenAt: now.Add(-time.Hour)},
+			givenTouchErr:     errors.New("connection refused"),
+			expectedSessionID: sessionID,
+		},
+		{
+			name: "revoked session",
+			givenSession: &repository.Session{
+				ID: sessionID, UserID: userID, LastSeenAt: now, RevokedAt: sql.NullTime{Time: now, Valid: true},
+			},
+			expectedErr: ErrTokenRevoked,
+		},
+		{
+			name:        "unknown session",
+			expectedErr: ErrTokenInvalid,
+		},
+		{
+			name:         "session of another user",
+			givenSession: &repository.Session{ID: sessionID, UserID: uuid.NewString(), LastSeenAt: now},
+			expectedErr:  ErrTokenInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := DefaultService{
+				logger:        zap.NewNop(),
+				clock:         func() time.Time { return now },
+				jwtSigningKey: "secret",
+				repo: &repositoryMock{
+					selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+						return &repository.User{ID: id, Username: "jdoe", Role: "user"}, nil
+					},
+					selectSessionFunc: func(ctx context.Context, id string) (*repository.Session, error) {
+						assert.Equal(t, sessionID, id)
+						return tc.givenSession, nil
+					},
+					touchSessionFunc: func(ctx context.Context, id string, lastSeenAt time.Time) error {
+						return tc.givenTouchErr
+					},
+				},
+			}
+
+			token, err := svc.generateJWT(&repository.User{ID: userID, Role: "user"}, sessionID, now)
+			require.NoError(t, err)
+
+			actual, err := svc.VerifyToken(context.Background(), token)
+			if tc.expectedErr != nil {
+				assert.True(t, errors.Is(err, tc.expectedErr), err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedSessionID, actual.SessionID)
+		})
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	userID := uuid.NewString()
+
+	svc := DefaultService{
+		clock: func() time.Time { return now },
+		repo: &repositoryMock{
+			selectActiveSessionsFunc: func(ctx context.Context, id string, at time.Time) ([]repository.Session, error) {
+				assert.Equal(t, userID, id)
+				assert.Equal(t, now, at)
+				return []repository.Session{
+					{ID: "1", UserID: id, UserAgent: "curl/7.79.1", IP: "203.0.113.7", CreatedAt: now, LastSeenAt: now, ExpiresAt: now},
+				}, nil
+			},
+		},
+	}
+
+	actual, err := svc.ListSessions(context.Background(), userID)
+	require.NoError(t, err)
+	assert.Equal(t, []Session{
+		{ID: "1", UserAgent: "curl/7.79.1", IP: "203.0.113.7", CreatedAt: now, LastSeenAt: now, ExpiresAt: now},
+	}, actual)
+
+	_, err = svc.ListSessions(context.Background(), "invalid")
+	assert.True(t, errors.Is(err, ErrValidation))
+}
+
+func TestRevokeSession(t *testing.T) {
+	t.Parallel()
+
+	userID := uuid.NewString()
+	sessionID := uuid.NewString()
+
+	testCases := []struct {
+		name           string
+		givenUserID    string
+		givenSessionID string
+		givenRepoErr   error
+		expectedErr    error
+	}{
+		{
+			name:           "session revoked",
+			givenUserID:    userID,
+			givenSessionID: sessionID,
+		},
+		{
+			name:           "session not found",
+			givenUserID:    userID,
+			givenSessionID: sessionID,
+			givenRepoErr:   repository.ErrRecordNotFound,
+			expectedErr:    ErrSessionNotFound,
+		},
+		{
+			name:           "invalid session id",
+			givenUserID:    userID,
+			givenSessionID: "invalid",
+			expectedErr:    ErrValidation,
+		},
+		{
+			name:           "invalid user id",
+			givenUserID:    "invalid",
+			givenSessionID: sessionID,
+			expectedErr:    ErrValidation,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var recorded []AuditEntry
+
+			svc := DefaultService{
+				logger: zap.NewNop(),
+				repo: &repositoryMock{
+					revokeSessionFunc: func(ctx context.Context, id, uid string, revokedAt time.Time) error {
+						assert.Equal(t, tc.givenSessionID, id)
+						assert.Equal(t, tc.givenUserID, uid)
+						return tc.givenRepoErr
+					},
+				},
+				auditor: &auditorMock{
+					recordFunc: func(ctx context.Context, entry AuditEntry) error {
+						recorded = append(recorded, entry)
+						return nil
+					},
+				},
+			}
+
+			err := svc.RevokeSession(context.Background(), tc.givenUserID, tc.givenSessionID)
+			if tc.expectedErr != nil {
+				assert.True(t, errors.Is(err, tc.expectedErr), err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			require.Len(t, recorded, 1)
+			assert.Equal(t, AuditSessionsRevoked, recorded[0].Action)
+			assert.Equal(t, tc.givenSessionID, recorded[0].Metadata["session_id"])
+		})
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	t.Parallel()
+
+	userID := uuid.NewString()
+	currentID := uuid.NewString()
+
+	var keptID string
+
+	svc := DefaultService{
+		repo: &repositoryMock{
+			revokeOtherSessionsFunc: func(ctx context.Context, uid, keepID string, revokedAt time.Time) (int, error) {
+				assert.Equal(t, userID, uid)
+				keptID = keepID
+				return 2, nil
+			},
+		},
+	}
+
+	require.NoError(t, svc.RevokeOtherSessions(context.Background(), userID, currentID))
+	assert.Equal(t, currentID, keptID)
+
+	// Without a current session, every session is revoked
+	require.NoError(t, svc.RevokeOtherSessions(context.Background(), userID, ""))
+	assert.Empty(t, keptID)
+
+	err := svc.RevokeOtherSessions(context.Background(), userID, "invalid")
+	assert.True(t, errors.Is(err, ErrValidation))
+}
+
+func TestCleanupSessions(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	svc := DefaultService{
+		clock: func() time.Time { return now },
+		repo: &repositoryMock{
+			deleteExpiredSessionsFunc: func(ctx context.Context, expiredBefore time.Time) (int, error) {
+				assert.Equal(t, now, expiredBefore)
+				return 3, nil
+			},
+		},
+	}
+
+	deleted, err := svc.CleanupSessions(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+}
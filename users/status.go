@@ -0,0 +1,119 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	httpStatuses = map[Code]int{
+		CodeAlreadyExists:    http.StatusConflict,
+		CodeBusy:             http.StatusServiceUnavailable,
+		CodeNotFound:         http.StatusNotFound,
+		CodePasswordInvalid:  http.StatusUnauthorized,
+		CodeRoleForbidden:    http.StatusForbidden,
+		CodeRoleInvalid:      http.StatusBadRequest,
+		CodeSessionNotFound:  http.StatusNotFound,
+		CodeTokenEmpty:       http.StatusUnauthorized,
+		CodeTokenExpired:     http.StatusUnauthorized,
+		CodeTokenInvalid:     http.StatusUnauthorized,
+		CodeTokenRevoked:     http.StatusUnauthorized,
+		CodeValidationFailed: http.StatusBadRequest,
+
+		CodeVerificationAttemptsExceeded: http.StatusTooManyRequests,
+		CodeVerificationExpired:          http.StatusGone,
+		CodeVerificationInvalid:          http.StatusBadRequest,
+		CodeVerificationRateLimited:      http.StatusTooManyRequests,
+	}
+
+	grpcCodes = map[Code]codes.Code{
+		CodeAlreadyExists:    codes.AlreadyExists,
+		CodeBusy:             codes.Unavailable,
+		CodeNotFound:         codes.NotFound,
+		CodePasswordInvalid:  codes.Unauthenticated,
+		CodeRoleForbidden:    codes.PermissionDenied,
+		CodeRoleInvalid:      codes.InvalidArgument,
+		CodeSessionNotFound:  codes.NotFound,
+		CodeTokenEmpty:       codes.Unauthenticated,
+		CodeTokenExpired:     codes.Unauthenticated,
+		CodeTokenInvalid:     codes.Unauthenticated,
+		CodeTokenRevoked:     codes.Unauthenticated,
+		CodeValidationFailed: codes.InvalidArgument,
+
+		CodeVerificationAttemptsExceeded: codes.ResourceExhausted,
+		CodeVerificationExpired:          codes.FailedPrecondition,
+		CodeVerificationInvalid:          codes.InvalidArgument,
+		CodeVerificationRateLimited:      codes.ResourceExhausted,
+	}
+)
+
+// HTTPStatus returns the HTTP status code for an error returned by the service.
+// Unexpected errors map to 500 Internal Server Error.
+func HTTPStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+
+	var e E
+	if errors.As(err, &e) {
+		if code, ok := httpStatuses[e.code]; ok {
+			return code
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// GRPCStatus returns the gRPC status for an error returned by the service.
+// Validation errors carry their field details as a BadRequest detail.
+// Unexpected errors map to codes.Internal without leaking their message.
+func GRPCStatus(err error) *status.Status {
+	if err == nil {
+		return status.New(codes.OK, "")
+	}
+
+	var e E
+	if errors.As(err, &e) {
+		code, ok := grpcCodes[e.code]
+		if !ok {
+			code = codes.Unknown
+		}
+
+		st := status.New(code, e.msg)
+		if len(e.details) == 0 {
+			return st
+		}
+
+		violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(e.details))
+		for _, d := range e.details {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: d.Field, Description: d.Message})
+		}
+
+		if withDetails, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); err == nil {
+			return withDetails
+		}
+		return st
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.New(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.New(codes.Canceled, err.Error())
+	default:
+		return status.New(codes.Internal, "internal error")
+	}
+}
+
+// GRPCStatus allows gRPC servers to convert unwrapped service errors returned by handlers
+func (e E) GRPCStatus() *status.Status {
+	return GRPCStatus(e)
+}
@@ -0,0 +1,109 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatus(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		given        error
+		expectedHTTP int
+		expectedGRPC codes.Code
+	}{
+		{
+			name:         "nil",
+			given:        nil,
+			expectedHTTP: http.StatusOK,
+			expectedGRPC: codes.OK,
+		},
+		{
+			name:         "wrapped not found",
+			given:        fmt.Errorf("could not fetch user: %w", ErrNotFound),
+			expectedHTTP: http.StatusNotFound,
+			expectedGRPC: codes.NotFound,
+		},
+		{
+			name:         "already exists",
+			given:        ErrAlreadyExists,
+			expectedHTTP: http.StatusConflict,
+			expectedGRPC: codes.AlreadyExists,
+		},
+		{
+			name:         "validation",
+			given:        newValidationE("email", "email is invalid"),
+			expectedHTTP: http.StatusBadRequest,
+			expectedGRPC: codes.InvalidArgument,
+		},
+		{
+			name:         "expired token",
+			given:        ErrTokenExpired,
+			expectedHTTP: http.StatusUnauthorized,
+			expectedGRPC: codes.Unauthenticated,
+		},
+		{
+			name:         "forbidden role",
+			given:        ErrForbiddenRole,
+			expectedHTTP: http.StatusForbidden,
+			expectedGRPC: codes.PermissionDenied,
+		},
+		{
+			name:         "too many verification attempts",
+			given:        ErrVerificationAttemptsExceeded,
+			expectedHTTP: http.StatusTooManyRequests,
+			expectedGRPC: codes.ResourceExhausted,
+		},
+		{
+			name:         "deadline exceeded",
+			given:        fmt.Errorf("could not select user: %w", context.DeadlineExceeded),
+			expectedHTTP: http.StatusGatewayTimeout,
+			expectedGRPC: codes.DeadlineExceeded,
+		},
+		{
+			name:         "unexpected",
+			given:        errors.New("connection refused"),
+			expectedHTTP: http.StatusInternalServerError,
+			expectedGRPC: codes.Internal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedHTTP, HTTPStatus(tc.given))
+			assert.Equal(t, tc.expectedGRPC, GRPCStatus(tc.given).Code())
+		})
+	}
+}
+
+func TestGRPCStatus_details(t *testing.T) {
+	t.Parallel()
+
+	st, ok := status.FromError(newValidationE("email", "email is invalid"))
+	require.True(t, ok)
+
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	assert.Equal(t, "email is invalid", st.Message())
+
+	details := st.Details()
+	require.Len(t, details, 1)
+
+	badRequest, ok := details[0].(*errdetails.BadRequest)
+	require.True(t, ok)
+	require.Len(t, badRequest.FieldViolations, 1)
+	assert.Equal(t, "email", badRequest.FieldViolations[0].Field)
+	assert.Equal(t, "email is invalid", badRequest.FieldViolations[0].Description)
+
+	assert.Equal(t, "internal error", GRPCStatus(errors.New("password=hunter2")).Message())
+}
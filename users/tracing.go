@@ -0,0 +1,61 @@
+package users
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alesr/stdservices/pkg/email"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans of the service
+const tracerName = "github.com/alesr/stdservices/users"
+
+// Span attributes. Passwords, tokens and email addresses are never recorded.
+const (
+	attrUserID    = attribute.Key("user.id")
+	attrErrorCode = attribute.Key("error.code")
+)
+
+// WithTracerProvider sets the provider of the OpenTelemetry spans recorded by the service.
+// It defaults to the global provider, see otel.SetTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) ServiceOption {
+	return func(s *DefaultService) {
+		s.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// startSpan starts a span named name as a child of the span in ctx
+func (s *DefaultService) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := s.tracer
+	if tracer == nil {
+		tracer = otel.Tracer(tracerName)
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the code of err on the span and ends it. Unexpected errors also set the span status,
+// while expected errors such as validation failures are part of the normal operation of the service.
+// Messages of unexpected errors are not recorded, since SMTP replies and database errors may quote emails.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.SetAttributes(attrErrorCode.String(errorCode(err)))
+
+		var e E
+		if !errors.As(err, &e) {
+			span.SetStatus(codes.Error, errorClass(err))
+		}
+	}
+	span.End()
+}
+
+// errorClass describes an unexpected error without its message
+func errorClass(err error) string {
+	if class := email.ErrorClass(err); class != "" {
+		return class
+	}
+	return "internal error"
+}
@@ -0,0 +1,165 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	const (
+		userID   = "8b3a2f7e-1c2d-4e5f-8a9b-0c1d2e3f4a5b"
+		email    = "jdoe@mail.com"
+		password = "password%&123"
+	)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name               string
+		givenPassword      string
+		givenRepoErr       error
+		expectedSpans      []string
+		expectedUserID     string
+		expectedErrorCode  string
+		expectedStatusCode codes.Code
+	}{
+		{
+			name:           "token generated",
+			givenPassword:  password,
+			expectedSpans:  []string{"users.bcrypt.compare", "users.GenerateToken"},
+			expectedUserID: userID,
+		},
+		{
+			name:              "wrong password",
+			givenPassword:     "wrong-password#1",
+			expectedSpans:     []string{"users.bcrypt.compare", "users.GenerateToken"},
+			expectedUserID:    userID,
+			expectedErrorCode: string(CodePasswordInvalid),
+		},
+		{
+			name:               "unexpected error",
+			givenPassword:      password,
+			givenRepoErr:       errors.New("connection refused"),
+			expectedSpans:      []string{"users.GenerateToken"},
+			expectedErrorCode:  "internal",
+			expectedStatusCode: codes.Error,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter := tracetest.NewInMemoryExporter()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+			svc := New(nil, "secret", &repositoryMock{
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					if tc.givenRepoErr != nil {
+						return nil, tc.givenRepoErr
+					}
+					return &repository.User{ID: userID, Email: email, PasswordHash: string(hash), Role: "user"}, nil
+				},
+				insertSessionFunc: func(ctx context.Context, in repository.Session) error {
+					return nil
+				},
+			}, WithTracerProvider(tp))
+
+			_, _ = svc.GenerateToken(context.Background(), email, tc.givenPassword)
+
+			spans := exporter.GetSpans()
+
+			var names []string
+			for _, span := range spans {
+				names = append(names, span.Name)
+
+				for _, attr := range span.Attributes {
+					value := attr.Value.Emit()
+					assert.False(t, strings.Contains(value, email) || strings.Contains(value, tc.givenPassword),
+						"span %s records %s", span.Name, attr.Key)
+				}
+			}
+			require.Equal(t, tc.expectedSpans, names)
+
+			root := spans[len(spans)-1]
+			assert.Equal(t, tc.expectedStatusCode, root.Status.Code)
+
+			attrs := map[string]string{}
+			for _, attr := range root.Attributes {
+				attrs[string(attr.Key)] = attr.Value.Emit()
+			}
+			assert.Equal(t, tc.expectedUserID, attrs["user.id"])
+			assert.Equal(t, tc.expectedErrorCode, attrs["error.code"])
+
+			if len(spans) > 1 {
+				assert.Equal(t, root.SpanContext.SpanID(), spans[0].Parent.SpanID())
+			}
+		})
+	}
+}
+
+func TestTracing_emailErrors(t *testing.T) {
+	t.Parallel()
+
+	const email = "jdoe@mail.com"
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	// SMTP servers often quote the recipient in their replies
+	sendErr := fmt.Errorf("could not send mail: %w",
+		&textproto.Error{Code: 550, Msg: "5.1.1 <" + email + ">: Recipient address rejected"})
+
+	svc := New(nil, "secret", &repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return &repository.User{ID: id, Email: email, Locale: "en"}, nil
+		},
+		insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
+			return nil
+		},
+	},
+		WithTracerProvider(tp),
+		WithEmailVerificationRateLimit(0, 0, 0),
+		WithEmailVerification("test-app", "noreply@test-app.com", "https://test-app.com/verify-email", &emailerMock{
+			sendFunc: func(from, to string, body []byte) error { return sendErr },
+		}),
+	)
+
+	err := svc.SendEmailVerification(context.Background(), "8b3a2f7e-1c2d-4e5f-8a9b-0c1d2e3f4a5b", "jdoe", email)
+	require.True(t, errors.Is(err, sendErr), err)
+
+	spans := exporter.GetSpans()
+	require.NotEmpty(t, spans)
+
+	root := spans[len(spans)-1]
+	assert.Equal(t, "users.SendEmailVerification", root.Name)
+	assert.Equal(t, codes.Error, root.Status.Code)
+	assert.Equal(t, "smtp reply 550", root.Status.Description)
+
+	for _, span := range spans {
+		assert.NotContains(t, span.Status.Description, email, "span %s records the email in its status", span.Name)
+
+		for _, attr := range span.Attributes {
+			assert.NotContains(t, attr.Value.Emit(), email, "span %s records %s", span.Name, attr.Key)
+		}
+
+		for _, event := range span.Events {
+			for _, attr := range event.Attributes {
+				assert.NotContains(t, attr.Value.Emit(), email, "span %s records %s", span.Name, attr.Key)
+			}
+		}
+	}
+}
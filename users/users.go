@@ -2,14 +2,23 @@ package users
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"database/sql"
 	"errors"
 	"fmt"
-	"math/rand"
-	"path"
+	"net/mail"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/alesr/stdservices/pkg/email"
+	"github.com/alesr/stdservices/pkg/locale"
+	"github.com/alesr/stdservices/pkg/strutil"
+	"github.com/alesr/stdservices/pkg/token"
 	"github.com/alesr/stdservices/pkg/validate"
 	"github.com/alesr/stdservices/users/repository"
+	"github.com/alesr/stdservices/users/verifier"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
 	"github.com/golang-jwt/jwt"
@@ -17,9 +26,42 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	defaultTokenTTL             = 24 * time.Hour
+	defaultEmailVerificationTTL = 24 * time.Hour
+
+	// emailVerificationTokenLen is the length of the alphanumeric token sent in verification links, about 190 bits
+	emailVerificationTokenLen = 32
+
+	// emailVerificationCodeLen is the length of the numeric code users can type instead of following the link
+	emailVerificationCodeLen = 6
+
+	// maxEmailVerificationAttempts bounds the guesses of a verification code, which has only a million values
+	maxEmailVerificationAttempts = 5
+
+	// Default limits of verification emails sent to a user or an address
+	defaultEmailVerificationCooldown = time.Minute
+	defaultEmailVerificationMaxSends = 5
+	defaultEmailVerificationWindow   = time.Hour
+
+	defaultCleanupInterval = time.Hour
+
+	// maxFetchByIDs bounds the ids of a single FetchByIDs call
+	maxFetchByIDs = 1000
+
+	// sessionTouchInterval bounds how often verifying tokens writes the last activity of their session
+	sessionTouchInterval = time.Minute
+
+	// maxSessionUserAgentLen is the length of the user agent stored with a session
+	maxSessionUserAgentLen = 512
+)
+
 var (
 	_                Service                = (*DefaultService)(nil)
 	jwtSigningMethod *jwt.SigningMethodHMAC = jwt.SigningMethodHS512
+
+	// defaultEmailTemplates is used by services built without New
+	defaultEmailTemplates = email.NewTemplates()
 )
 
 type (
@@ -34,6 +76,10 @@ type (
 		// FetchByID fetches a non-deleted user by id and returns the user
 		FetchByID(ctx context.Context, id string) (*User, error)
 
+		// FetchByIDs fetches the non-deleted users with the given ids in a single query and returns them by id,
+		// spelled as given. Users that do not exist or are deleted are left out of the map.
+		FetchByIDs(ctx context.Context, ids []string) (map[string]*User, error)
+
 		// GenerateToken generates a JWT token for the user
 		GenerateToken(ctx context.Context, email, password string) (string, error)
 
@@ -43,23 +89,76 @@ type (
 		// SendEmailVerification sends an email verification to the user.
 		// The user must be created before calling this method.
 		SendEmailVerification(ctx context.Context, userID, username, to string) error
+
+		// VerifyEmail marks the email of a user as verified with the token of a verification link
+		VerifyEmail(ctx context.Context, token string) error
+
+		// VerifyEmailCode marks the email of a user as verified with the short code of their latest verification email
+		VerifyEmailCode(ctx context.Context, userID, code string) error
+
+		// GetVerificationStatus returns whether the email of a user is verified,
+		// whether a verification is pending and when a new one can be sent
+		GetVerificationStatus(ctx context.Context, userID string) (*VerificationStatus, error)
+
+		// ListSessions returns the active sessions of a user, most recently seen first
+		ListSessions(ctx context.Context, userID string) ([]Session, error)
+
+		// RevokeSession revokes a session of a user, rejecting the token issued with it
+		RevokeSession(ctx context.Context, userID, sessionID string) error
+
+		// RevokeOtherSessions revokes every session of a user but the current one
+		RevokeOtherSessions(ctx context.Context, userID, currentSessionID string) error
 	}
 
 	repo interface {
 		Insert(ctx context.Context, user *repository.User) (*repository.User, error)
 		SelectByID(ctx context.Context, id string) (*repository.User, error)
+		SelectByIDs(ctx context.Context, ids []string) ([]repository.User, error)
 		SelectByEmail(ctx context.Context, email string) (*repository.User, error)
 		DeleteByID(ctx context.Context, id string) error
-		InsertEmailVerification(ctx context.Context, in repository.EmailVerification) error
+		SelectUsers(ctx context.Context, filter repository.UserFilter) ([]repository.User, error)
+		RestoreByID(ctx context.Context, id string, updatedAt time.Time) error
+		UpdateRole(ctx context.Context, id, role string, updatedAt time.Time) error
+		UpdatePasswordHash(ctx context.Context, id, passwordHash string, updatedAt time.Time) error
+		UpdateEmailVerified(ctx context.Context, id string, updatedAt time.Time) error
+		RevokeTokens(ctx context.Context, id string, revokedAt time.Time) error
+		InsertEmailVerification(
+			ctx context.Context, in repository.EmailVerification, since time.Time,
+			check func(a *repository.EmailVerificationActivity) error,
+		) error
+		SelectEmailVerification(ctx context.Context, tokenHash string) (*repository.EmailVerification, error)
+		SelectLatestEmailVerification(ctx context.Context, userID string) (*repository.EmailVerification, error)
+		IncrementEmailVerificationAttempts(ctx context.Context, tokenHash string) (int, error)
+		ConfirmEmailVerification(ctx context.Context, tokenHash string, verifiedAt time.Time) error
+		SelectEmailVerificationActivity(
+			ctx context.Context, userID, email string, since time.Time,
+		) (*repository.EmailVerificationActivity, error)
+		DeleteExpiredEmailVerifications(ctx context.Context, expiredBefore, createdBefore time.Time) (int, error)
+		InsertSession(ctx context.Context, in repository.Session) error
+		SelectSession(ctx context.Context, id string) (*repository.Session, error)
+		SelectActiveSessions(ctx context.Context, userID string, now time.Time) ([]repository.Session, error)
+		TouchSession(ctx context.Context, id string, lastSeenAt time.Time) error
+		RevokeSession(ctx context.Context, id, userID string, revokedAt time.Time) error
+		RevokeOtherSessions(ctx context.Context, userID, keepID string, revokedAt time.Time) (int, error)
+		DeleteExpiredSessions(ctx context.Context, expiredBefore time.Time) (int, error)
 	}
 
 	emailer interface {
 		Send(from, to string, body []byte) error
 	}
 
+	// contextEmailer is implemented by emailers that can be canceled and traced, such as email.SMTP
+	contextEmailer interface {
+		SendContext(ctx context.Context, from, to string, body []byte) error
+	}
+
+	// jwtClaim lists the claims of tokens, read by verifier.ParseClaims
 	jwtClaim struct {
-		id   string
-		role string
+		UserID        string `json:"user_id"`
+		Username      string `json:"username"`
+		Role          string `json:"role"`
+		EmailVerified bool   `json:"email_verified"`
+		SessionID     string `json:"sid"`
 		jwt.StandardClaims
 	}
 )
@@ -75,254 +174,960 @@ func WithEmailVerification(fromName, fromAddr, endpoint string, emailer emailer)
 	}
 }
 
+// WithEmailTemplates overrides the templates used to render emails, see email.Templates.Set
+func WithEmailTemplates(templates *email.Templates) ServiceOption {
+	return func(s *DefaultService) {
+		s.emailTemplates = templates
+	}
+}
+
+// WithUsernamePolicy sets the rules usernames must follow, see validate.UsernamePolicy
+func WithUsernamePolicy(policy validate.UsernamePolicy) ServiceOption {
+	return func(s *DefaultService) {
+		s.policies.username = policy
+	}
+}
+
+// WithPasswordPolicy sets the rules passwords must follow, see validate.PasswordPolicy.
+// It defaults to validate.DefaultPasswordPolicy.
+func WithPasswordPolicy(policy validate.PasswordPolicy) ServiceOption {
+	return func(s *DefaultService) {
+		s.policies.password = policy
+	}
+}
+
+// WithEmailPolicy sets the rules emails must follow and how they are canonicalized to detect
+// duplicate accounts, see validate.EmailPolicy. Changing the canonicalization rules of a service
+// with existing users requires updating their email_normalized column.
+func WithEmailPolicy(policy validate.EmailPolicy) ServiceOption {
+	return func(s *DefaultService) {
+		s.policies.email = policy
+	}
+}
+
+// WithEmailVerificationRateLimit bounds the verification emails sent to a user or to an address:
+// consecutive emails are at least cooldown apart and at most maxSends are sent per window.
+// Zero values disable the corresponding limit.
+func WithEmailVerificationRateLimit(cooldown time.Duration, maxSends int, window time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.emailVerificationCooldown = cooldown
+		s.emailVerificationMaxSends = maxSends
+		s.emailVerificationWindow = window
+	}
+}
+
+// WithTokenTTL sets how long the JWT tokens generated by the service are valid
+func WithTokenTTL(ttl time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.tokenTTL = ttl
+	}
+}
+
+// WithECDSASigningKey signs tokens with key, using ES256 instead of HS512 and the signing key passed to New.
+// Services that cannot reach the database verify them with the public key only, see the verifier package.
+// The key id is set in the kid header of tokens, so that verifiers can tell keys apart during a rotation.
+func WithECDSASigningKey(keyID string, key *ecdsa.PrivateKey) ServiceOption {
+	return func(s *DefaultService) {
+		s.jwtKeyID = keyID
+		s.jwtPrivateKey = key
+	}
+}
+
+// WithVerificationKeys makes VerifyToken also accept the tokens signed with keys, such as the previous key of the
+// service while WithECDSASigningKey is rotated, so that the tokens issued before the rotation remain valid.
+func WithVerificationKeys(keys ...verifier.Key) ServiceOption {
+	return func(s *DefaultService) {
+		if s.jwtVerificationKeys == nil {
+			s.jwtVerificationKeys = make(map[string]*ecdsa.PublicKey, len(keys))
+		}
+
+		for _, key := range keys {
+			s.jwtVerificationKeys[key.ID] = key.PublicKey
+		}
+	}
+}
+
+// WithStatelessVerification makes VerifyToken trust the signed claims of tokens without reading the database.
+// Revoked tokens and sessions are then accepted until they expire, and the username and email verification
+// status are those of the time the token was issued, so short token TTLs are recommended.
+func WithStatelessVerification() ServiceOption {
+	return func(s *DefaultService) {
+		s.statelessVerification = true
+	}
+}
+
+// WithEmailVerificationTTL sets how long the link and code of a verification email can be used
+func WithEmailVerificationTTL(ttl time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.emailVerificationTTL = ttl
+	}
+}
+
+// WithCleanupInterval sets how often Run deletes expired email verifications
+func WithCleanupInterval(interval time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.cleanupInterval = interval
+	}
+}
+
+// WithAgePolicy sets the minimum and maximum age of users, see validate.AgePolicy
+func WithAgePolicy(policy validate.AgePolicy) ServiceOption {
+	return func(s *DefaultService) {
+		s.policies.age = policy
+	}
+}
+
+// WithClock sets the function returning the current time, used for ages, timestamps and expirations.
+// It defaults to time.Now.
+func WithClock(now func() time.Time) ServiceOption {
+	return func(s *DefaultService) {
+		s.clock = now
+	}
+}
+
 type DefaultService struct {
+	clock                       func() time.Time
 	logger                      *zap.Logger
 	jwtSigningKey               string
+	jwtPrivateKey               *ecdsa.PrivateKey
+	jwtKeyID                    string
+	jwtVerificationKeys         map[string]*ecdsa.PublicKey
+	statelessVerification       bool
+	tokenTTL                    time.Duration
 	emailVerificationSenderName string
 	emailVerificationSenderAddr string
 	emailVerificationEndpoint   string
+	emailVerificationTTL        time.Duration
+	emailVerificationCooldown   time.Duration
+	emailVerificationMaxSends   int
+	emailVerificationWindow     time.Duration
+	cleanupInterval             time.Duration
 	emailer                     emailer
+	emailTemplates              *email.Templates
+	eventPublisher              eventPublisher
+	auditor                     auditor
+	metrics                     *Metrics
+	userCacheSize               int
+	userCacheTTL                time.Duration
+	userCache                   *cachingRepo
+	hashingConcurrency          int
+	hashingQueue                int
+	hashingPool                 *hashingPool
+	policies                    inputPolicies
 	repo                        repo
+	tracer                      trace.Tracer
 }
 
 // New instantiates a new users service
 func New(logger *zap.Logger, jwtSigningKey string, repo repo, opts ...ServiceOption) *DefaultService {
 	service := DefaultService{
-		logger:        logger,
-		jwtSigningKey: jwtSigningKey,
-		repo:          repo,
+		logger:         logger,
+		jwtSigningKey:  jwtSigningKey,
+		emailTemplates: email.NewTemplates(),
+		policies:       inputPolicies{password: validate.DefaultPasswordPolicy},
+		repo:           repo,
+		tokenTTL:       defaultTokenTTL,
+
+		emailVerificationTTL:      defaultEmailVerificationTTL,
+		emailVerificationCooldown: defaultEmailVerificationCooldown,
+		emailVerificationMaxSends: defaultEmailVerificationMaxSends,
+		emailVerificationWindow:   defaultEmailVerificationWindow,
+		cleanupInterval:           defaultCleanupInterval,
+		hashingQueue:              defaultHashingQueue,
 	}
 
 	for _, opt := range opts {
 		opt(&service)
 	}
+
+	if service.metrics != nil {
+		service.repo = &metricsRepo{next: service.repo, metrics: service.metrics}
+	}
+
+	service.hashingPool = newHashingPool(service.hashingConcurrency, service.hashingQueue, service.metrics)
+
+	// The cache wraps the metrics, so that only the queries reaching the database are recorded
+	if service.userCacheSize > 0 && service.userCacheTTL > 0 {
+		service.userCache = newCachingRepo(service.repo, service.userCacheSize, service.userCacheTTL, service.now, service.metrics)
+		service.repo = service.userCache
+	}
 	return &service
 }
 
 // Create creates a new user and returns the created user
-func (s *DefaultService) Create(ctx context.Context, in CreateUserInput) (*User, error) {
-	if err := in.validate(); err != nil {
+func (s *DefaultService) Create(ctx context.Context, in CreateUserInput) (_ *User, err error) {
+	ctx, span := s.startSpan(ctx, "users.Create")
+	defer func() { endSpan(span, err) }()
+
+	var userID string
+	defer func() { s.audit(ctx, AuditEntry{Action: AuditUserCreated, SubjectID: userID}, err) }()
+
+	now := s.now()
+
+	if err := in.validate(s.policies, now); err != nil {
 		return nil, fmt.Errorf("could not validate create user input: %w", err)
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(in.Password), bcrypt.DefaultCost)
+	birthdate, err := ParseBirthdate(in.Birthdate)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse birthdate: %w", err)
+	}
+
+	emailAddr, err := validate.NormalizeEmail(in.Email)
 	if err != nil {
-		return nil, fmt.Errorf("could not hash password: %s", err)
+		return nil, fmt.Errorf("could not normalize email: %w", err)
+	}
+
+	hash, err := s.hashPassword(ctx, in.Password)
+	if err != nil {
+		return nil, fmt.Errorf("could not hash password: %w", err)
 	}
 
 	insertedUser, err := s.repo.Insert(ctx, &repository.User{
-		ID:            uuid.NewString(),
-		Fullname:      in.Fullname,
-		Username:      in.Username,
-		Birthdate:     in.Birthdate,
-		Email:         in.Email,
-		EmailVerified: false,
-		PasswordHash:  string(hash),
-		Role:          string(RoleUser),
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
+		ID:                 uuid.NewString(),
+		Fullname:           in.Fullname,
+		Username:           validate.NormalizeUsername(in.Username),
+		UsernameNormalized: validate.UsernameSkeleton(in.Username),
+		Birthdate:          birthdate.Time(),
+		Email:              emailAddr,
+		EmailNormalized:    s.policies.email.Canonical(in.Email),
+		EmailVerified:      false,
+		PasswordHash:       hash,
+		Role:               string(RoleUser),
+		Locale:             locale.Resolve(in.Locale).String(),
+		CreatedAt:          now,
+		UpdatedAt:          now,
 	})
 	if err != nil {
 		if errors.Is(err, repository.ErrDuplicateRecord) {
-			return nil, errAlreadyExists
+			return nil, ErrAlreadyExists
 		}
-		return nil, fmt.Errorf("could not insert user: %s", err)
+		return nil, fmt.Errorf("could not insert user: %w", err)
 	}
 
 	user, err := newUserFromRepository(insertedUser)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse storage user to domain model: %s", err)
+		return nil, fmt.Errorf("could not parse storage user to domain model: %w", err)
 	}
+	span.SetAttributes(attrUserID.String(user.ID))
+	userID = user.ID
 
 	if s.emailer != nil {
-		if err := s.SendEmailVerification(ctx, user.ID, user.Username, user.Email); err != nil {
+		if err := s.sendEmailVerification(ctx, user.ID, user.Username, user.Email, user.Locale); err != nil {
 			// It doesn't matter if the email verification fails.
 			// The next time an API call is made, a new verification will can be requested
 			s.logger.Error("could not send email verification", zap.String("user_id", user.ID), zap.Error(err))
 		}
 	}
+
+	s.publish(ctx, EventUserCreated, user.ID)
 	return user, nil
 }
 
 // FetchByID fetches a user by id and returns the user
-func (s *DefaultService) FetchByID(ctx context.Context, id string) (*User, error) {
+func (s *DefaultService) FetchByID(ctx context.Context, id string) (_ *User, err error) {
+	ctx, span := s.startSpan(ctx, "users.FetchByID", attrUserID.String(id))
+	defer func() { endSpan(span, err) }()
+
 	if err := validate.ID(id); err != nil {
-		return nil, fmt.Errorf("could not validate id: %w", err)
+		return nil, fmt.Errorf("could not validate id: %w", newValidationE("id", err.Error()))
 	}
 
 	storageUser, err := s.repo.SelectByID(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("could not select user by id: %s", err)
+		return nil, fmt.Errorf("could not select user by id: %w", err)
 	}
 
 	if storageUser == nil {
-		return nil, errNotFound
+		return nil, ErrNotFound
 	}
 
 	user, err := newUserFromRepository(storageUser)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse storage user to domain model: %s", err)
+		return nil, fmt.Errorf("could not parse storage user to domain model: %w", err)
 	}
 	return user, nil
 }
 
-func (s *DefaultService) Delete(ctx context.Context, id string) error {
+// FetchByIDs fetches the users with the given ids and returns them by id
+func (s *DefaultService) FetchByIDs(ctx context.Context, ids []string) (_ map[string]*User, err error) {
+	ctx, span := s.startSpan(ctx, "users.FetchByIDs")
+	defer func() { endSpan(span, err) }()
+
+	if len(ids) > maxFetchByIDs {
+		return nil, newValidationE("ids", fmt.Sprintf("at most %d ids can be fetched at once", maxFetchByIDs))
+	}
+
+	// Ids are deduplicated in the canonical form the repository returns, since validate.ID
+	// also accepts upper case, braced and URN forms, and users are returned under every given form
+	unique := make([]string, 0, len(ids))
+	spellings := make(map[string][]string, len(ids))
+
+	for _, id := range ids {
+		if err := validate.ID(id); err != nil {
+			return nil, fmt.Errorf("could not validate id %q: %w", id, newValidationE("ids", err.Error()))
+		}
+
+		canonical := canonicalID(id)
+		if _, ok := spellings[canonical]; !ok {
+			unique = append(unique, canonical)
+		}
+		spellings[canonical] = append(spellings[canonical], id)
+	}
+
+	res := make(map[string]*User, len(unique))
+	if len(unique) == 0 {
+		return res, nil
+	}
+
+	storageUsers, err := s.repo.SelectByIDs(ctx, unique)
+	if err != nil {
+		return nil, fmt.Errorf("could not select users by ids: %w", err)
+	}
+
+	for i := range storageUsers {
+		user, err := newUserFromRepository(&storageUsers[i])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse storage user to domain model: %w", err)
+		}
+
+		for _, id := range spellings[canonicalID(user.ID)] {
+			u := *user
+			res[id] = &u
+		}
+	}
+	return res, nil
+}
+
+func (s *DefaultService) Delete(ctx context.Context, id string) (err error) {
+	ctx, span := s.startSpan(ctx, "users.Delete", attrUserID.String(id))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, AuditEntry{Action: AuditUserDeleted, SubjectID: id}, err) }()
+
 	if err := validate.ID(id); err != nil {
-		return fmt.Errorf("could not validate id: %w", err)
+		return fmt.Errorf("could not validate id: %w", newValidationE("id", err.Error()))
 	}
 
 	if err := s.repo.DeleteByID(ctx, id); err != nil {
-		return fmt.Errorf("could not delete user by id: %s", err)
+		return fmt.Errorf("could not delete user by id: %w", err)
 	}
+
+	s.publish(ctx, EventUserDeleted, id)
 	return nil
 }
 
 // GenerateToken generates a JWT token for the user
-func (s *DefaultService) GenerateToken(ctx context.Context, email, password string) (string, error) {
+func (s *DefaultService) GenerateToken(ctx context.Context, email, password string) (_ string, err error) {
+	ctx, span := s.startSpan(ctx, "users.GenerateToken")
+	defer func() { endSpan(span, err) }()
+
+	// Failed attempts are audited too, with the user they targeted when the email is registered
+	var userID, sessionID string
+	defer func() {
+		entry := AuditEntry{Action: AuditLogin, SubjectID: userID}
+		if sessionID != "" {
+			entry.Metadata = map[string]string{"session_id": sessionID}
+		}
+		s.audit(ctx, entry, err)
+	}()
+
 	if err := validate.Email(email); err != nil {
-		return "", fmt.Errorf("could not validate email: %s", err)
+		return "", fmt.Errorf("could not validate email: %w", newValidationE("email", err.Error()))
 	}
 
-	if err := validate.Password(password); err != nil {
-		return "", fmt.Errorf("could not validate password: %s", err)
+	// The password policy is not applied, so that passwords set under a previous policy are still accepted
+	if err := validate.LoginPassword(password); err != nil {
+		return "", fmt.Errorf("could not validate password: %w", newValidationE("password", err.Error()))
 	}
 
-	// Fetch user by username
-	storageUser, err := s.repo.SelectByEmail(ctx, email)
+	// Fetch user by the canonical form of the email, so that any spelling of it matches
+	storageUser, err := s.repo.SelectByEmail(ctx, s.policies.email.Canonical(email))
 	if err != nil {
-		return "", fmt.Errorf("could not select user by email: %s", err)
+		return "", fmt.Errorf("could not select user by email: %w", err)
 	}
 
 	// Check if user exists
 	if storageUser == nil {
-		return "", errNotFound
+		return "", ErrNotFound
 	}
+	span.SetAttributes(attrUserID.String(storageUser.ID))
+	userID = storageUser.ID
 
 	// Check if password is correct
-	if err := bcrypt.CompareHashAndPassword([]byte(storageUser.PasswordHash), []byte(password)); err != nil {
-		return "", errPasswordInvalid
+	if err := s.comparePassword(ctx, storageUser.PasswordHash, password); err != nil {
+		return "", err
+	}
+
+	// Every token is bound to a new session, so that it can be revoked alone
+	now := s.now().UTC()
+	info := RequestInfoFromContext(ctx)
+
+	session := repository.Session{
+		ID:         uuid.NewString(),
+		UserID:     storageUser.ID,
+		UserAgent:  strutil.Truncate(info.UserAgent, maxSessionUserAgentLen),
+		IP:         info.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(durationOr(s.tokenTTL, defaultTokenTTL)),
+	}
+
+	if err := s.repo.InsertSession(ctx, session); err != nil {
+		return "", fmt.Errorf("could not insert session: %w", err)
 	}
 
 	// Generate JWT
-	token, err := s.generateJWT(storageUser.ID, role(storageUser.Role))
+	token, err := s.generateJWT(storageUser, session.ID, now)
 	if err != nil {
-		return "", fmt.Errorf("could not generate jwt: %s", err)
+		return "", fmt.Errorf("could not generate jwt: %w", err)
 	}
+	sessionID = session.ID
 	return token, nil
 }
 
 // VerifyToken verifies a JWT token and returns the authentication data
-func (s *DefaultService) VerifyToken(ctx context.Context, token string) (*VerifyTokenResponse, error) {
+func (s *DefaultService) VerifyToken(ctx context.Context, token string) (_ *VerifyTokenResponse, err error) {
+	ctx, span := s.startSpan(ctx, "users.VerifyToken")
+	defer func() { endSpan(span, err) }()
+
 	if token == "" {
-		return nil, errTokenEmpty
+		return nil, ErrTokenEmpty
 	}
 
-	jwtToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		method, ok := token.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
+	// Expiration is checked below against the service clock instead of the parser's
+	parser := jwt.Parser{SkipClaimsValidation: true}
 
-		if method.Alg() != jwtSigningMethod.Alg() {
-			return nil, errors.New("invalid token signing method")
-		}
-		return []byte(s.jwtSigningKey), nil
-	})
+	jwtToken, err := parser.Parse(token, s.verificationKey)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse token: %s", err)
+		return nil, fmt.Errorf("could not parse token: %s: %w", err, ErrTokenInvalid)
 	}
 
-	claims, ok := jwtToken.Claims.(jwt.MapClaims)
+	mapClaims, ok := jwtToken.Claims.(jwt.MapClaims)
 	if !ok || !jwtToken.Valid {
-		return nil, errTokenInvalid
+		return nil, ErrTokenInvalid
 	}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return nil, fmt.Errorf("could not find user id in token")
+	claims, err := verifier.ParseClaims(mapClaims)
+	if err != nil {
+		return nil, fmt.Errorf("could not read claims: %s: %w", err, ErrTokenInvalid)
 	}
+	span.SetAttributes(attrUserID.String(claims.UserID))
 
-	role, ok := claims["role"].(string)
-	if !ok {
-		return nil, fmt.Errorf("could not find role in token")
+	if claims.ExpiresAt.Before(s.now()) {
+		return nil, ErrTokenExpired
 	}
 
-	expiration, ok := claims["exp"].(float64)
-	if !ok {
-		return nil, fmt.Errorf("could not find expiration in token")
-	}
+	if s.statelessVerification {
+		// Tokens issued before the username was signed cannot be trusted for it
+		if claims.Username == "" {
+			return nil, fmt.Errorf("could not find username in token: %w", ErrTokenInvalid)
+		}
 
-	if time.Unix(int64(expiration), 0).Before(time.Now()) {
-		return nil, errTokenExpired
+		return &VerifyTokenResponse{
+			ID:            claims.UserID,
+			Username:      claims.Username,
+			Role:          claims.Role,
+			EmailVerified: claims.EmailVerified,
+			SessionID:     claims.SessionID,
+		}, nil
 	}
 
-	storageUser, err := s.repo.SelectByID(ctx, userID)
+	storageUser, err := s.repo.SelectByID(ctx, claims.UserID)
 	if err != nil {
-		return nil, fmt.Errorf("could not select user by id: %s", err)
+		return nil, fmt.Errorf("could not select user by id: %w", err)
 	}
 
 	if storageUser == nil {
-		return nil, errNotFound
+		return nil, ErrNotFound
+	}
+
+	// Issue times have a precision of a second, so tokens issued in the second of a revocation are revoked too
+	if storageUser.TokensRevokedAt.Valid && claims.IssuedAt.Before(storageUser.TokensRevokedAt.Time) {
+		return nil, ErrTokenRevoked
+	}
+
+	// Tokens issued before sessions were introduced have no session and stay valid until they expire
+	if claims.SessionID != "" {
+		if err := s.verifySession(ctx, claims.SessionID, storageUser.ID); err != nil {
+			return nil, err
+		}
 	}
 
 	return &VerifyTokenResponse{
-		ID:       storageUser.ID,
-		Username: storageUser.Username,
-		Role:     role,
+		ID:            storageUser.ID,
+		Username:      storageUser.Username,
+		Role:          claims.Role,
+		EmailVerified: storageUser.EmailVerified,
+		SessionID:     claims.SessionID,
 	}, nil
 }
 
-func (s *DefaultService) SendEmailVerification(ctx context.Context, userID, username, to string) error {
-	code := randString(6)
+// SendEmailVerification sends an email verification to the user in the user's language
+func (s *DefaultService) SendEmailVerification(ctx context.Context, userID, username, to string) (err error) {
+	ctx, span := s.startSpan(ctx, "users.SendEmailVerification", attrUserID.String(userID))
+	defer func() { endSpan(span, err) }()
+	defer func() { s.audit(ctx, AuditEntry{Action: AuditEmailVerificationSent, SubjectID: userID}, err) }()
+
+	storageUser, err := s.repo.SelectByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("could not select user by id: %w", err)
+	}
+
+	if storageUser == nil {
+		return ErrNotFound
+	}
+	return s.sendEmailVerification(ctx, userID, username, to, storageUser.Locale)
+}
+
+func (s *DefaultService) sendEmailVerification(ctx context.Context, userID, username, to, userLocale string) error {
+	now := s.now().UTC()
+	addr := s.policies.email.Canonical(to)
+
+	linkToken, err := token.Generate(emailVerificationTokenLen, token.Alphanumeric)
+	if err != nil {
+		return fmt.Errorf("could not generate verification token: %w", err)
+	}
+
+	code, err := token.Generate(emailVerificationCodeLen, token.Digits)
+	if err != nil {
+		return fmt.Errorf("could not generate verification code: %w", err)
+	}
+
+	link, err := verificationLink(s.emailVerificationEndpoint, linkToken)
+	if err != nil {
+		return fmt.Errorf("could not build verification link: %w", err)
+	}
 
 	in := repository.EmailVerification{
-		Code:      code,
+		TokenHash: token.Hash(linkToken),
+		CodeHash:  sql.NullString{String: token.Hash(code), Valid: true},
+		Email:     addr,
 		UserID:    userID,
-		CreatedAt: time.Now().UTC(),
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24),
+		CreatedAt: now,
+		ExpiresAt: now.Add(durationOr(s.emailVerificationTTL, defaultEmailVerificationTTL)),
+	}
+
+	// The rate limit is checked by the insert, so that concurrent requests cannot all pass it
+	var check func(a *repository.EmailVerificationActivity) error
+	if s.limitsEmailVerifications() {
+		check = func(a *repository.EmailVerificationActivity) error {
+			if now.Before(s.nextEmailVerificationAfter(a)) {
+				return ErrVerificationRateLimited
+			}
+			return nil
+		}
+	}
+
+	// Outstanding verifications of the user are invalidated, so only the latest link and code work
+	if err := s.repo.InsertEmailVerification(ctx, in, now.Add(-s.emailVerificationWindow), check); err != nil {
+		if errors.Is(err, ErrVerificationRateLimited) {
+			s.metrics.observeEmail(emailOutcomeRateLimited)
+			return ErrVerificationRateLimited
+		}
+		return fmt.Errorf("could not insert email verification: %w", err)
 	}
 
-	if err := s.repo.InsertEmailVerification(ctx, in); err != nil {
-		return fmt.Errorf("could not insert email verification: %s", err)
+	templates := s.emailTemplates
+	if templates == nil {
+		templates = defaultEmailTemplates
 	}
 
-	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s Email Verification\r\n\r\nPlease click the following link to verify your email address: %s\r\n",
-		s.emailVerificationSenderAddr, to, s.emailVerificationSenderName, path.Join(s.emailVerificationEndpoint, code))
+	msg, err := templates.Render(email.MessageEmailVerification, userLocale, email.EmailVerificationData{
+		AppName:   s.emailVerificationSenderName,
+		Username:  username,
+		Link:      link,
+		Code:      code,
+		ExpiresAt: in.ExpiresAt,
+	})
+	if err != nil {
+		return fmt.Errorf("could not render email verification: %w", err)
+	}
 
-	if err := s.emailer.Send(s.emailVerificationSenderName, to, []byte(body)); err != nil {
-		return fmt.Errorf("could not send email verification: %s", err)
+	msg.From = mail.Address{Name: s.emailVerificationSenderName, Address: s.emailVerificationSenderAddr}
+	msg.To = []mail.Address{{Name: username, Address: to}}
+
+	body, err := msg.Bytes()
+	if err != nil {
+		return fmt.Errorf("could not build email verification message: %w", err)
 	}
+
+	if err := s.sendEmail(ctx, to, body); err != nil {
+		s.metrics.observeEmail(emailOutcomeFailed)
+		return fmt.Errorf("could not send email verification: %w", err)
+	}
+
+	s.metrics.observeEmail(emailOutcomeSent)
 	return nil
 }
 
-func (s *DefaultService) generateJWT(userID string, role role) (string, error) {
+// limitsEmailVerifications reports whether verification emails are rate limited
+func (s *DefaultService) limitsEmailVerifications() bool {
+	return s.emailVerificationCooldown > 0 || s.emailVerificationMaxSends > 0
+}
+
+// nextEmailVerificationAt returns the earliest time a verification email can be sent to the user at the address
+func (s *DefaultService) nextEmailVerificationAt(ctx context.Context, userID, addr string, now time.Time) (time.Time, error) {
+	if !s.limitsEmailVerifications() {
+		return time.Time{}, nil
+	}
+
+	activity, err := s.repo.SelectEmailVerificationActivity(ctx, userID, addr, now.Add(-s.emailVerificationWindow))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not select email verification activity: %w", err)
+	}
+	return s.nextEmailVerificationAfter(activity), nil
+}
+
+// nextEmailVerificationAfter returns the earliest time a verification email can be sent given the activity
+// of the user and of the address during the rate limit window
+func (s *DefaultService) nextEmailVerificationAfter(activity *repository.EmailVerificationActivity) time.Time {
+	var next time.Time
+
+	later := func(t time.Time) {
+		if t.After(next) {
+			next = t
+		}
+	}
+
+	if s.emailVerificationCooldown > 0 && activity.LastCreatedAt.Valid {
+		later(activity.LastCreatedAt.Time.Add(s.emailVerificationCooldown))
+	}
+
+	if s.emailVerificationMaxSends > 0 {
+		// Once the limit is reached, a slot frees up when the oldest counted email leaves the window
+		if activity.UserCount >= s.emailVerificationMaxSends && activity.UserOldest.Valid {
+			later(activity.UserOldest.Time.Add(s.emailVerificationWindow))
+		}
+
+		if activity.EmailCount >= s.emailVerificationMaxSends && activity.EmailOldest.Valid {
+			later(activity.EmailOldest.Time.Add(s.emailVerificationWindow))
+		}
+	}
+	return next
+}
+
+// GetVerificationStatus returns whether the email of a user is verified,
+// whether a verification is pending and when a new one can be sent
+func (s *DefaultService) GetVerificationStatus(ctx context.Context, userID string) (_ *VerificationStatus, err error) {
+	ctx, span := s.startSpan(ctx, "users.GetVerificationStatus", attrUserID.String(userID))
+	defer func() { endSpan(span, err) }()
+
 	if err := validate.ID(userID); err != nil {
-		return "", fmt.Errorf("could not validate id: %w", err)
+		return nil, fmt.Errorf("could not validate id: %w", newValidationE("id", err.Error()))
+	}
+
+	storageUser, err := s.repo.SelectByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select user by id: %w", err)
+	}
+
+	if storageUser == nil {
+		return nil, ErrNotFound
+	}
+
+	status := VerificationStatus{EmailVerified: storageUser.EmailVerified}
+	if status.EmailVerified {
+		return &status, nil
+	}
+
+	now := s.now().UTC()
+
+	latest, err := s.repo.SelectLatestEmailVerification(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select latest email verification: %w", err)
 	}
 
-	if err := role.validate(); err != nil {
-		return "", errRoleInvalid
+	if latest != nil {
+		status.LastSentAt = latest.CreatedAt
+		if now.Before(latest.ExpiresAt) {
+			status.Pending = true
+			status.ExpiresAt = latest.ExpiresAt
+		}
 	}
 
-	now := time.Now().UTC()
+	next, err := s.nextEmailVerificationAt(ctx, userID, storageUser.EmailNormalized, now)
+	if err != nil {
+		return nil, fmt.Errorf("could not check email verification rate limit: %w", err)
+	}
+
+	if now.Before(next) {
+		status.NextSendAt = next
+	}
+	return &status, nil
+}
 
-	token := jwt.NewWithClaims(jwtSigningMethod, jwtClaim{
-		userID,
-		string(role),
+// Run deletes expired email verifications and sessions every cleanup interval until the context is canceled
+func (s *DefaultService) Run(ctx context.Context) {
+	interval := s.cleanupInterval
+	if interval <= 0 {
+		interval = defaultCleanupInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.CleanupEmailVerifications(ctx); err != nil {
+			s.logger.Error("could not clean up email verifications", zap.Error(err))
+		}
+
+		if _, err := s.CleanupSessions(ctx); err != nil {
+			s.logger.Error("could not clean up sessions", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CleanupEmailVerifications deletes expired email verifications and returns how many were deleted.
+// Verifications still counted by the rate limit are kept.
+func (s *DefaultService) CleanupEmailVerifications(ctx context.Context) (_ int, err error) {
+	ctx, span := s.startSpan(ctx, "users.CleanupEmailVerifications")
+	defer func() { endSpan(span, err) }()
+
+	now := s.now().UTC()
+
+	retention := s.emailVerificationWindow
+	if s.emailVerificationCooldown > retention {
+		retention = s.emailVerificationCooldown
+	}
+
+	deleted, err := s.repo.DeleteExpiredEmailVerifications(ctx, now, now.Add(-retention))
+	if err != nil {
+		return 0, fmt.Errorf("could not delete expired email verifications: %w", err)
+	}
+	return deleted, nil
+}
+
+// VerifyEmail marks the email of a user as verified with the token of a verification link.
+// Each verification can only be used once.
+func (s *DefaultService) VerifyEmail(ctx context.Context, verificationToken string) (err error) {
+	ctx, span := s.startSpan(ctx, "users.VerifyEmail")
+	defer func() { endSpan(span, err) }()
+
+	var userID string
+	defer func() {
+		s.audit(ctx, AuditEntry{Action: AuditEmailVerified, SubjectID: userID, Metadata: map[string]string{"method": "link"}}, err)
+	}()
+
+	if verificationToken == "" {
+		return ErrVerificationInvalid
+	}
+
+	// Tokens are looked up by hash, which doesn't leak the token through timing
+	verification, err := s.repo.SelectEmailVerification(ctx, token.Hash(verificationToken))
+	if err != nil {
+		return fmt.Errorf("could not select email verification: %w", err)
+	}
+
+	if verification == nil || verification.VerifiedAt.Valid {
+		return ErrVerificationInvalid
+	}
+	span.SetAttributes(attrUserID.String(verification.UserID))
+	userID = verification.UserID
+
+	if !s.now().Before(verification.ExpiresAt) {
+		return ErrVerificationExpired
+	}
+	return s.confirmEmailVerification(ctx, verification)
+}
+
+// VerifyEmailCode marks the email of a user as verified with the short code of their latest verification email.
+// Codes are compared in constant time and locked after a few wrong attempts; the link keeps working.
+func (s *DefaultService) VerifyEmailCode(ctx context.Context, userID, code string) (err error) {
+	ctx, span := s.startSpan(ctx, "users.VerifyEmailCode", attrUserID.String(userID))
+	defer func() { endSpan(span, err) }()
+	defer func() {
+		s.audit(ctx, AuditEntry{Action: AuditEmailVerified, SubjectID: userID, Metadata: map[string]string{"method": "code"}}, err)
+	}()
+
+	if err := validate.ID(userID); err != nil {
+		return fmt.Errorf("could not validate id: %w", newValidationE("id", err.Error()))
+	}
+
+	if code == "" {
+		return ErrVerificationInvalid
+	}
+
+	verification, err := s.repo.SelectLatestEmailVerification(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("could not select latest email verification: %w", err)
+	}
+
+	if verification == nil || !verification.CodeHash.Valid {
+		return ErrVerificationInvalid
+	}
+
+	if !s.now().Before(verification.ExpiresAt) {
+		return ErrVerificationExpired
+	}
+
+	// The attempt is recorded before comparing, so that concurrent guesses count too
+	attempts, err := s.repo.IncrementEmailVerificationAttempts(ctx, verification.TokenHash)
+	if err != nil {
+		return fmt.Errorf("could not increment email verification attempts: %w", err)
+	}
+
+	if attempts > maxEmailVerificationAttempts {
+		return ErrVerificationAttemptsExceeded
+	}
+
+	if !token.Equal(token.Hash(code), verification.CodeHash.String) {
+		return ErrVerificationInvalid
+	}
+	return s.confirmEmailVerification(ctx, verification)
+}
+
+func (s *DefaultService) confirmEmailVerification(ctx context.Context, verification *repository.EmailVerification) error {
+	if err := s.repo.ConfirmEmailVerification(ctx, verification.TokenHash, s.now().UTC()); err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			// Used concurrently or the user was deleted
+			return ErrVerificationInvalid
+		}
+		return fmt.Errorf("could not confirm email verification: %w", err)
+	}
+
+	s.publish(ctx, EventEmailVerified, verification.UserID)
+	return nil
+}
+
+func (s *DefaultService) generateJWT(user *repository.User, sessionID string, now time.Time) (string, error) {
+	if err := validate.ID(user.ID); err != nil {
+		return "", fmt.Errorf("could not validate id: %w", err)
+	}
+
+	if err := role(user.Role).validate(); err != nil {
+		return "", ErrRoleInvalid
+	}
+
+	claims := jwtClaim{
+		user.ID,
+		user.Username,
+		user.Role,
+		user.EmailVerified,
+		sessionID,
 		jwt.StandardClaims{
 			IssuedAt:  now.Unix(),
-			ExpiresAt: now.Add(time.Hour * 24).Unix(),
+			ExpiresAt: now.Add(durationOr(s.tokenTTL, defaultTokenTTL)).Unix(),
 		},
-	})
+	}
+
+	var (
+		token *jwt.Token
+		key   interface{} = []byte(s.jwtSigningKey)
+	)
 
-	signedString, err := token.SignedString([]byte(s.jwtSigningKey))
+	if s.jwtPrivateKey != nil {
+		token = jwt.NewWithClaims(verifier.SigningMethod, claims)
+		token.Header["kid"] = s.jwtKeyID
+		key = s.jwtPrivateKey
+	} else {
+		token = jwt.NewWithClaims(jwtSigningMethod, claims)
+	}
+
+	signedString, err := token.SignedString(key)
 	if err != nil {
-		return "", fmt.Errorf("could not sign token: %s", err)
+		return "", fmt.Errorf("could not sign token: %w", err)
 	}
 
 	return signedString, nil
 }
 
+// verificationKey returns the key verifying the signature of a token. Tokens signed with another method than
+// the one of the service are rejected, so that the public key of the service is never used as an HMAC secret.
+func (s *DefaultService) verificationKey(token *jwt.Token) (interface{}, error) {
+	if s.jwtPrivateKey != nil {
+		if token.Method != verifier.SigningMethod {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		keyID, _ := token.Header["kid"].(string)
+		if keyID == s.jwtKeyID {
+			return &s.jwtPrivateKey.PublicKey, nil
+		}
+
+		key, ok := s.jwtVerificationKeys[keyID]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id: %q", keyID)
+		}
+		return key, nil
+	}
+
+	method, ok := token.Method.(*jwt.SigningMethodHMAC)
+	if !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	if method.Alg() != jwtSigningMethod.Alg() {
+		return nil, errors.New("invalid token signing method")
+	}
+	return []byte(s.jwtSigningKey), nil
+}
+
+// sendEmail sends a message with the context if the emailer supports it
+func (s *DefaultService) sendEmail(ctx context.Context, to string, body []byte) error {
+	if e, ok := s.emailer.(contextEmailer); ok {
+		return e.SendContext(ctx, s.emailVerificationSenderAddr, to, body)
+	}
+	return s.emailer.Send(s.emailVerificationSenderAddr, to, body)
+}
+
+// hashPassword hashes a password with bcrypt once a hashing worker is available
+func (s *DefaultService) hashPassword(ctx context.Context, password string) (string, error) {
+	ctx, span := s.startSpan(ctx, "users.bcrypt.hash")
+	defer span.End()
+
+	var (
+		hash    []byte
+		hashErr error
+	)
+
+	if err := s.hashingPool.do(ctx, "hash", func() {
+		defer s.metrics.observeBcrypt("hash", time.Now())
+		hash, hashErr = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	}); err != nil {
+		return "", fmt.Errorf("could not wait for a hashing worker: %w", err)
+	}
+
+	if hashErr != nil {
+		return "", hashErr
+	}
+	return string(hash), nil
+}
+
+// comparePassword returns ErrPasswordInvalid if the password does not match the bcrypt hash.
+// It runs once a hashing worker is available.
+func (s *DefaultService) comparePassword(ctx context.Context, hash, password string) error {
+	ctx, span := s.startSpan(ctx, "users.bcrypt.compare")
+	defer span.End()
+
+	var compareErr error
+
+	if err := s.hashingPool.do(ctx, "compare", func() {
+		defer s.metrics.observeBcrypt("compare", time.Now())
+		compareErr = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	}); err != nil {
+		return fmt.Errorf("could not wait for a hashing worker: %w", err)
+	}
+
+	if compareErr != nil {
+		return ErrPasswordInvalid
+	}
+	return nil
+}
+
+// now returns the current time of the service clock
+func (s *DefaultService) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock()
+}
+
 func newUserFromRepository(user *repository.User) (*User, error) {
 	var role role
 	switch user.Role {
@@ -338,23 +1143,44 @@ func newUserFromRepository(user *repository.User) (*User, error) {
 		ID:            user.ID,
 		Fullname:      user.Fullname,
 		Username:      user.Username,
-		Birthdate:     user.Birthdate,
+		Birthdate:     newBirthdate(user.Birthdate),
 		Email:         user.Email,
 		EmailVerified: user.EmailVerified,
 		Role:          role,
+		Locale:        user.Locale,
 		CreatedAt:     user.CreatedAt,
 		UpdatedAt:     user.UpdatedAt,
+		DeletedAt:     user.DeletedAt.Time,
 	}, nil
 }
 
-const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
+// canonicalID returns the lower case hyphenated form of an id, in which the repository returns ids,
+// so that ids given in the other forms validate.ID accepts can be compared. Invalid ids are returned as is.
+func canonicalID(id string) string {
+	u, err := uuid.Parse(id)
+	if err != nil {
+		return id
+	}
+	return u.String()
+}
 
-var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+// durationOr returns d, or fallback if d is not positive, for services built without New
+func durationOr(d, fallback time.Duration) time.Duration {
+	if d <= 0 {
+		return fallback
+	}
+	return d
+}
 
-func randString(length int) string {
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = chars[seededRand.Intn(len(chars))]
+// verificationLink appends a path segment to the verification endpoint,
+// keeping its scheme, host and query string intact
+func verificationLink(endpoint, code string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
 	}
-	return string(b)
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + code
+	u.RawPath = ""
+	return u.String(), nil
 }
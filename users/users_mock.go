@@ -11,9 +11,16 @@ type MockService struct {
 	CreateFunc                func(ctx context.Context, in CreateUserInput) (*User, error)
 	DeleteFunc                func(ctx context.Context, id string) error
 	FetchByIDFunc             func(ctx context.Context, id string) (*User, error)
+	FetchByIDsFunc            func(ctx context.Context, ids []string) (map[string]*User, error)
 	GenerateTokenFunc         func(ctx context.Context, email, password string) (string, error)
 	VerifyTokenFunc           func(ctx context.Context, token string) (*VerifyTokenResponse, error)
 	SendEmailVerificationFunc func(ctx context.Context, userID, username, to string) error
+	VerifyEmailFunc           func(ctx context.Context, token string) error
+	VerifyEmailCodeFunc       func(ctx context.Context, userID, code string) error
+	GetVerificationStatusFunc func(ctx context.Context, userID string) (*VerificationStatus, error)
+	ListSessionsFunc          func(ctx context.Context, userID string) ([]Session, error)
+	RevokeSessionFunc         func(ctx context.Context, userID, sessionID string) error
+	RevokeOtherSessionsFunc   func(ctx context.Context, userID, currentSessionID string) error
 }
 
 func (m *MockService) Create(ctx context.Context, in CreateUserInput) (*User, error) {
@@ -37,6 +44,13 @@ func (m *MockService) FetchByID(ctx context.Context, id string) (*User, error) {
 	return m.FetchByIDFunc(ctx, id)
 }
 
+func (m *MockService) FetchByIDs(ctx context.Context, ids []string) (map[string]*User, error) {
+	if m.FetchByIDsFunc == nil {
+		return nil, errors.New("MockService.FetchByIDsFunc is nil")
+	}
+	return m.FetchByIDsFunc(ctx, ids)
+}
+
 func (m *MockService) GenerateToken(ctx context.Context, email, password string) (string, error) {
 	if m.GenerateTokenFunc == nil {
 		return "", errors.New("MockService.GenerateTokenFunc is nil")
@@ -57,3 +71,45 @@ func (m *MockService) SendEmailVerification(ctx context.Context, userID, usernam
 	}
 	return m.SendEmailVerificationFunc(ctx, userID, username, to)
 }
+
+func (m *MockService) VerifyEmail(ctx context.Context, token string) error {
+	if m.VerifyEmailFunc == nil {
+		return errors.New("MockService.VerifyEmailFunc is nil")
+	}
+	return m.VerifyEmailFunc(ctx, token)
+}
+
+func (m *MockService) VerifyEmailCode(ctx context.Context, userID, code string) error {
+	if m.VerifyEmailCodeFunc == nil {
+		return errors.New("MockService.VerifyEmailCodeFunc is nil")
+	}
+	return m.VerifyEmailCodeFunc(ctx, userID, code)
+}
+
+func (m *MockService) GetVerificationStatus(ctx context.Context, userID string) (*VerificationStatus, error) {
+	if m.GetVerificationStatusFunc == nil {
+		return nil, errors.New("MockService.GetVerificationStatusFunc is nil")
+	}
+	return m.GetVerificationStatusFunc(ctx, userID)
+}
+
+func (m *MockService) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	if m.ListSessionsFunc == nil {
+		return nil, errors.New("MockService.ListSessionsFunc is nil")
+	}
+	return m.ListSessionsFunc(ctx, userID)
+}
+
+func (m *MockService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	if m.RevokeSessionFunc == nil {
+		return errors.New("MockService.RevokeSessionFunc is nil")
+	}
+	return m.RevokeSessionFunc(ctx, userID, sessionID)
+}
+
+func (m *MockService) RevokeOtherSessions(ctx context.Context, userID, currentSessionID string) error {
+	if m.RevokeOtherSessionsFunc == nil {
+		return errors.New("MockService.RevokeOtherSessionsFunc is nil")
+	}
+	return m.RevokeOtherSessionsFunc(ctx, userID, currentSessionID)
+}
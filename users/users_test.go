@@ -1,13 +1,33 @@
 package users
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"database/sql"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/alesr/stdservices/pkg/email"
+	"github.com/alesr/stdservices/pkg/token"
+	"github.com/alesr/stdservices/pkg/validate"
 	"github.com/alesr/stdservices/users/repository"
+	"github.com/alesr/stdservices/users/verifier"
+	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -49,6 +69,190 @@ func TestNew(t *testing.T) {
 	assert.Equal(t, givenEmailVerificationEndpoint, actual.emailVerificationEndpoint)
 	assert.Equal(t, givenEmailer, actual.emailer)
 	assert.Equal(t, givenRepo, actual.repo)
+	assert.Equal(t, validate.DefaultPasswordPolicy, actual.policies.password)
+
+	givenPasswordPolicy := validate.PasswordPolicy{MinLength: 12, MinScore: 3}
+	givenUsernamePolicy := validate.UsernamePolicy{Reserved: []string{}}
+
+	actual = New(givenLogger, givenJWTSigningKey, givenRepo,
+		WithPasswordPolicy(givenPasswordPolicy), WithUsernamePolicy(givenUsernamePolicy))
+
+	assert.Equal(t, givenPasswordPolicy, actual.policies.password)
+	assert.Equal(t, givenUsernamePolicy, actual.policies.username)
+
+	givenAgePolicy := validate.AgePolicy{MinAge: 13}
+	givenNow := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	givenEmailPolicy := validate.EmailPolicy{ProviderRules: true, Blocked: validate.DisposableDomains()}
+
+	actual = New(givenLogger, givenJWTSigningKey, givenRepo,
+		WithAgePolicy(givenAgePolicy), WithEmailPolicy(givenEmailPolicy), WithClock(func() time.Time { return givenNow }))
+
+	assert.Equal(t, givenAgePolicy, actual.policies.age)
+	assert.Equal(t, givenEmailPolicy, actual.policies.email)
+	assert.Equal(t, givenNow, actual.now())
+	assert.Equal(t, defaultEmailVerificationCooldown, actual.emailVerificationCooldown)
+	assert.Equal(t, defaultEmailVerificationMaxSends, actual.emailVerificationMaxSends)
+	assert.Equal(t, defaultEmailVerificationWindow, actual.emailVerificationWindow)
+	assert.Equal(t, defaultCleanupInterval, actual.cleanupInterval)
+
+	actual = New(givenLogger, givenJWTSigningKey, givenRepo,
+		WithEmailVerificationRateLimit(30*time.Second, 3, 24*time.Hour), WithCleanupInterval(time.Minute),
+		WithTokenTTL(time.Hour), WithEmailVerificationTTL(2*time.Hour))
+
+	assert.Equal(t, 30*time.Second, actual.emailVerificationCooldown)
+	assert.Equal(t, 3, actual.emailVerificationMaxSends)
+	assert.Equal(t, 24*time.Hour, actual.emailVerificationWindow)
+	assert.Equal(t, time.Minute, actual.cleanupInterval)
+	assert.Equal(t, time.Hour, actual.tokenTTL)
+	assert.Equal(t, 2*time.Hour, actual.emailVerificationTTL)
+}
+
+func TestGenerateJWT_claims(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	userID := uuid.NewString()
+
+	svc := DefaultService{jwtSigningKey: "secret"}
+
+	token, err := svc.generateJWT(&repository.User{ID: userID, Username: "jdoe", Role: "user", EmailVerified: true}, "s1", now)
+	require.NoError(t, err)
+
+	// Every claim is serialized, not only the standard ones
+	parser := jwt.Parser{SkipClaimsValidation: true}
+
+	var claims jwt.MapClaims
+	_, err = parser.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) { return []byte("secret"), nil })
+	require.NoError(t, err)
+
+	assert.Equal(t, jwt.MapClaims{
+		"user_id":        userID,
+		"username":       "jdoe",
+		"role":           "user",
+		"email_verified": true,
+		"sid":            "s1",
+		"iat":            float64(now.Unix()),
+		"exp":            float64(now.Add(defaultTokenTTL).Unix()),
+	}, claims)
+}
+
+func TestVerifyToken_clock(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	userID := uuid.NewString()
+
+	svc := DefaultService{
+		clock:         func() time.Time { return now },
+		jwtSigningKey: "secret",
+		repo: &repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				return &repository.User{ID: id, Username: "jdoe", Role: string(RoleUser)}, nil
+			},
+		},
+	}
+
+	token, err := svc.generateJWT(&repository.User{ID: userID, Role: "user"}, "", now)
+	require.NoError(t, err)
+
+	actual, err := svc.VerifyToken(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, &VerifyTokenResponse{ID: userID, Username: "jdoe", Role: string(RoleUser)}, actual)
+
+	now = now.Add(25 * time.Hour)
+
+	_, err = svc.VerifyToken(context.Background(), token)
+	assert.Equal(t, ErrTokenExpired, err)
+}
+
+func TestVerifyToken_stateless(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	userID := uuid.NewString()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	// The repository is never called
+	svc := New(nil, "", &repositoryMock{},
+		WithClock(func() time.Time { return now }),
+		WithECDSASigningKey("2022-06", key),
+		WithStatelessVerification(),
+	)
+
+	token, err := svc.generateJWT(&repository.User{ID: userID, Username: "jdoe", Role: "user", EmailVerified: true}, "s1", now)
+	require.NoError(t, err)
+
+	actual, err := svc.VerifyToken(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, &VerifyTokenResponse{ID: userID, Username: "jdoe", Role: "user", EmailVerified: true, SessionID: "s1"}, actual)
+
+	// Downstream services verify the same token with the public key only
+	v, err := verifier.New([]verifier.Key{{ID: "2022-06", PublicKey: &key.PublicKey}}, verifier.WithClock(func() time.Time { return now }))
+	require.NoError(t, err)
+
+	claims, err := v.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, userID, claims.UserID)
+	assert.Equal(t, "jdoe", claims.Username)
+	assert.Equal(t, "user", claims.Role)
+	assert.True(t, claims.EmailVerified)
+
+	// Tokens signed with the HMAC key or another key id are rejected
+	hmacSvc := DefaultService{clock: func() time.Time { return now }, jwtSigningKey: "secret"}
+
+	hmacToken, err := hmacSvc.generateJWT(&repository.User{ID: userID, Username: "jdoe", Role: "user"}, "", now)
+	require.NoError(t, err)
+
+	_, err = svc.VerifyToken(context.Background(), hmacToken)
+	assert.True(t, errors.Is(err, ErrTokenInvalid), err)
+
+	rotated := New(nil, "", &repositoryMock{}, WithECDSASigningKey("2022-07", key), WithStatelessVerification())
+
+	_, err = rotated.VerifyToken(context.Background(), token)
+	assert.True(t, errors.Is(err, ErrTokenInvalid), err)
+
+	// Unless the previous key is kept for verification
+	nextKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	rotated = New(nil, "", &repositoryMock{},
+		WithClock(func() time.Time { return now }),
+		WithECDSASigningKey("2022-07", nextKey),
+		WithVerificationKeys(verifier.Key{ID: "2022-06", PublicKey: &key.PublicKey}),
+		WithStatelessVerification(),
+	)
+
+	actual, err = rotated.VerifyToken(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, userID, actual.ID)
+
+	// A known key id does not make another key's signature valid
+	forged := jwt.NewWithClaims(verifier.SigningMethod, jwt.MapClaims{"user_id": userID, "exp": now.Add(time.Hour).Unix()})
+	forged.Header["kid"] = "2022-06"
+
+	forgedToken, err := forged.SignedString(nextKey)
+	require.NoError(t, err)
+
+	_, err = rotated.VerifyToken(context.Background(), forgedToken)
+	assert.True(t, errors.Is(err, ErrTokenInvalid), err)
+
+	// Tokens issued before the username was signed are rejected
+	legacy := jwt.NewWithClaims(verifier.SigningMethod, jwt.MapClaims{"user_id": userID, "role": "user", "exp": now.Add(time.Hour).Unix()})
+	legacy.Header["kid"] = "2022-06"
+
+	legacyToken, err := legacy.SignedString(key)
+	require.NoError(t, err)
+
+	_, err = svc.VerifyToken(context.Background(), legacyToken)
+	assert.True(t, errors.Is(err, ErrTokenInvalid), err)
+
+	now = now.Add(25 * time.Hour)
+
+	_, err = svc.VerifyToken(context.Background(), token)
+	assert.Equal(t, ErrTokenExpired, err)
 }
 
 func TestCreate_validation(t *testing.T) {
@@ -93,7 +297,7 @@ func TestCreate(t *testing.T) {
 				},
 			},
 			expectedUser:  nil,
-			expectedError: errAlreadyExists,
+			expectedError: ErrAlreadyExists,
 		},
 		{
 			name:      "user is created",
@@ -106,7 +310,8 @@ func TestCreate(t *testing.T) {
 			givenRepoMock: &repositoryMock{
 				insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
 					assert.NotEmpty(t, in.UserID)
-					assert.NotEmpty(t, in.Code)
+					assert.Len(t, in.TokenHash, 64)
+					assert.True(t, in.CodeHash.Valid)
 					assert.NotEmpty(t, in.CreatedAt)
 					assert.NotEmpty(t, in.ExpiresAt)
 					return nil
@@ -114,6 +319,10 @@ func TestCreate(t *testing.T) {
 				insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
 					assert.NotEmpty(t, user.ID)
 					assert.NotEmpty(t, user.PasswordHash)
+					assert.Equal(t, "en", user.Locale)
+					assert.Equal(t, "jdoe", user.UsernameNormalized)
+					assert.Equal(t, "joedoe@mail.com", user.EmailNormalized)
+					assert.Equal(t, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), user.Birthdate)
 					assert.NotEmpty(t, user.CreatedAt)
 					assert.NotEmpty(t, user.UpdatedAt)
 
@@ -121,7 +330,7 @@ func TestCreate(t *testing.T) {
 						ID:           "123",
 						Fullname:     givenUser.Fullname,
 						Username:     givenUser.Username,
-						Birthdate:    givenUser.Birthdate,
+						Birthdate:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
 						Email:        givenUser.Email,
 						PasswordHash: givenUser.Password,
 						Role:         string(RoleUser),
@@ -134,7 +343,7 @@ func TestCreate(t *testing.T) {
 				ID:            "123",
 				Fullname:      givenUser.Fullname,
 				Username:      givenUser.Username,
-				Birthdate:     givenUser.Birthdate,
+				Birthdate:     Birthdate{year: 2000, month: time.January, day: 1},
 				Email:         givenUser.Email,
 				EmailVerified: false,
 				Role:          RoleUser,
@@ -152,7 +361,7 @@ func TestCreate(t *testing.T) {
 				},
 			},
 			expectedUser:  nil,
-			expectedError: fmt.Errorf("could not insert user: some error"),
+			expectedError: fmt.Errorf("could not insert user: %w", errors.New("some error")),
 		},
 		{
 			name:      "send email verification error still creates an user",
@@ -165,7 +374,8 @@ func TestCreate(t *testing.T) {
 			givenRepoMock: &repositoryMock{
 				insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
 					assert.NotEmpty(t, in.UserID)
-					assert.NotEmpty(t, in.Code)
+					assert.Len(t, in.TokenHash, 64)
+					assert.True(t, in.CodeHash.Valid)
 					assert.NotEmpty(t, in.CreatedAt)
 					assert.NotEmpty(t, in.ExpiresAt)
 					return nil
@@ -173,6 +383,10 @@ func TestCreate(t *testing.T) {
 				insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
 					assert.NotEmpty(t, user.ID)
 					assert.NotEmpty(t, user.PasswordHash)
+					assert.Equal(t, "en", user.Locale)
+					assert.Equal(t, "jdoe", user.UsernameNormalized)
+					assert.Equal(t, "joedoe@mail.com", user.EmailNormalized)
+					assert.Equal(t, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), user.Birthdate)
 					assert.NotEmpty(t, user.CreatedAt)
 					assert.NotEmpty(t, user.UpdatedAt)
 
@@ -180,7 +394,7 @@ func TestCreate(t *testing.T) {
 						ID:           "123",
 						Fullname:     givenUser.Fullname,
 						Username:     givenUser.Username,
-						Birthdate:    givenUser.Birthdate,
+						Birthdate:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
 						Email:        givenUser.Email,
 						PasswordHash: givenUser.Password,
 						Role:         string(RoleUser),
@@ -193,7 +407,7 @@ func TestCreate(t *testing.T) {
 				ID:            "123",
 				Fullname:      givenUser.Fullname,
 				Username:      givenUser.Username,
-				Birthdate:     givenUser.Birthdate,
+				Birthdate:     Birthdate{year: 2000, month: time.January, day: 1},
 				Email:         givenUser.Email,
 				EmailVerified: false,
 				Role:          RoleUser,
@@ -240,7 +454,7 @@ func TestFetchByID(t *testing.T) {
 				},
 			},
 			expectedUser:  nil,
-			expectedError: errNotFound,
+			expectedError: ErrNotFound,
 		},
 		{
 			name:    "select user error",
@@ -251,7 +465,7 @@ func TestFetchByID(t *testing.T) {
 				},
 			},
 			expectedUser:  nil,
-			expectedError: fmt.Errorf("could not select user by id: some error"),
+			expectedError: fmt.Errorf("could not select user by id: %w", errors.New("some error")),
 		},
 	}
 
@@ -298,6 +512,139 @@ func TestFetchByID_validation(t *testing.T) {
 	}
 }
 
+func TestFetchByIDs(t *testing.T) {
+	t.Parallel()
+
+	jdoeID, janeID, unknownID := uuid.NewString(), uuid.NewString(), uuid.NewString()
+
+	testCases := []struct {
+		name          string
+		givenIDs      []string
+		givenRepoMock *repositoryMock
+		expectedIDs   []string
+		expectedUsers map[string]*User
+		expectedError error
+	}{
+		{
+			name:     "existing and unknown users",
+			givenIDs: []string{jdoeID, janeID, jdoeID, unknownID},
+			givenRepoMock: &repositoryMock{
+				selectByIDsFunc: func(ctx context.Context, ids []string) ([]repository.User, error) {
+					return []repository.User{
+						{ID: jdoeID, Username: "jdoe", Role: "user"},
+						{ID: janeID, Username: "jane", Role: "admin"},
+					}, nil
+				},
+			},
+			expectedIDs: []string{jdoeID, janeID, unknownID},
+			expectedUsers: map[string]*User{
+				jdoeID: {ID: jdoeID, Username: "jdoe", Birthdate: newBirthdate(time.Time{}), Role: RoleUser},
+				janeID: {ID: janeID, Username: "jane", Birthdate: newBirthdate(time.Time{}), Role: RoleAdmin},
+			},
+		},
+		{
+			name:     "other forms of ids",
+			givenIDs: []string{strings.ToUpper(jdoeID), "{" + jdoeID + "}", "urn:uuid:" + janeID, janeID},
+			givenRepoMock: &repositoryMock{
+				selectByIDsFunc: func(ctx context.Context, ids []string) ([]repository.User, error) {
+					return []repository.User{
+						{ID: jdoeID, Username: "jdoe", Role: "user"},
+						{ID: janeID, Username: "jane", Role: "admin"},
+					}, nil
+				},
+			},
+			expectedIDs: []string{jdoeID, janeID},
+			expectedUsers: map[string]*User{
+				strings.ToUpper(jdoeID): {ID: jdoeID, Username: "jdoe", Birthdate: newBirthdate(time.Time{}), Role: RoleUser},
+				"{" + jdoeID + "}":      {ID: jdoeID, Username: "jdoe", Birthdate: newBirthdate(time.Time{}), Role: RoleUser},
+				"urn:uuid:" + janeID:    {ID: janeID, Username: "jane", Birthdate: newBirthdate(time.Time{}), Role: RoleAdmin},
+				janeID:                  {ID: janeID, Username: "jane", Birthdate: newBirthdate(time.Time{}), Role: RoleAdmin},
+			},
+		},
+		{
+			name:          "no ids",
+			givenIDs:      nil,
+			givenRepoMock: &repositoryMock{},
+			expectedUsers: map[string]*User{},
+		},
+		{
+			name:     "select users error",
+			givenIDs: []string{jdoeID},
+			givenRepoMock: &repositoryMock{
+				selectByIDsFunc: func(ctx context.Context, ids []string) ([]repository.User, error) {
+					return nil, errors.New("some error")
+				},
+			},
+			expectedIDs:   []string{jdoeID},
+			expectedError: fmt.Errorf("could not select users by ids: %w", errors.New("some error")),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var selectedIDs []string
+			if tc.givenRepoMock.selectByIDsFunc != nil {
+				selectByIDs := tc.givenRepoMock.selectByIDsFunc
+				tc.givenRepoMock.selectByIDsFunc = func(ctx context.Context, ids []string) ([]repository.User, error) {
+					selectedIDs = ids
+					return selectByIDs(ctx, ids)
+				}
+			}
+
+			svc := DefaultService{
+				repo: tc.givenRepoMock,
+			}
+
+			users, err := svc.FetchByIDs(context.Background(), tc.givenIDs)
+			require.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedUsers, users)
+			assert.Equal(t, tc.expectedIDs, selectedIDs)
+		})
+	}
+}
+
+func TestFetchByIDs_validation(t *testing.T) {
+	t.Parallel()
+
+	tooMany := make([]string, maxFetchByIDs+1)
+	for i := range tooMany {
+		tooMany[i] = uuid.NewString()
+	}
+
+	testCases := []struct {
+		name     string
+		givenIDs []string
+	}{
+		{
+			name:     "empty id",
+			givenIDs: []string{uuid.NewString(), ""},
+		},
+		{
+			name:     "invalid id",
+			givenIDs: []string{"%invalid-id%"},
+		},
+		{
+			name:     "too many ids",
+			givenIDs: tooMany,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := DefaultService{}
+
+			_, err := svc.FetchByIDs(context.Background(), tc.givenIDs)
+			assert.Equal(t, string(CodeValidationFailed), errorCode(err))
+		})
+	}
+}
+
 func TestDelete_validation(t *testing.T) {
 	t.Parallel()
 
@@ -396,9 +743,9 @@ func TestGenerateToken_validation(t *testing.T) {
 			expectedError: true,
 		},
 		{
-			name:          "empty password format",
+			name:          "password too long",
 			givenEmail:    "joedoe@mail.com",
-			givenPassword: "123",
+			givenPassword: strings.Repeat("a", 1025),
 			expectedError: true,
 		},
 	}
@@ -462,10 +809,32 @@ func TestGenerateToken(t *testing.T) {
 						PasswordHash: string(givenHash),
 					}, nil
 				},
+				insertSessionFunc: func(ctx context.Context, in repository.Session) error {
+					return nil
+				},
 			},
 			expectedToken: true,
 			expectedError: false,
 		},
+		{
+			name:          "insert session error",
+			givenPassword: password,
+			givenRepoMock: &repositoryMock{
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					return &repository.User{
+						ID:           uuid.New().String(),
+						Role:         string(RoleUser),
+						Email:        email,
+						PasswordHash: string(givenHash),
+					}, nil
+				},
+				insertSessionFunc: func(ctx context.Context, in repository.Session) error {
+					return errors.New("some error")
+				},
+			},
+			expectedToken: false,
+			expectedError: true,
+		},
 		{
 			name:          "password not match",
 			givenPassword: "somepassword&#%123",
@@ -497,6 +866,46 @@ func TestGenerateToken(t *testing.T) {
 	}
 }
 
+func TestGenerateToken_canonicalEmail(t *testing.T) {
+	t.Parallel()
+
+	svc := DefaultService{
+		policies: inputPolicies{email: validate.EmailPolicy{ProviderRules: true}},
+		repo: &repositoryMock{
+			selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+				assert.Equal(t, "johndoe@gmail.com", email)
+				return nil, nil
+			},
+		},
+	}
+
+	_, err := svc.GenerateToken(context.Background(), "John.Doe+news@GMail.com", "password%&123")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestGenerateToken_previousPasswordPolicy(t *testing.T) {
+	t.Parallel()
+
+	// Set when the minimum length was lower than the current one
+	const password = "pass#12"
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+		selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+			return &repository.User{ID: uuid.NewString(), Email: email, PasswordHash: string(hash), Role: "user"}, nil
+		},
+		insertSessionFunc: func(ctx context.Context, in repository.Session) error {
+			return nil
+		},
+	}, WithPasswordPolicy(validate.PasswordPolicy{MinLength: 12, RequiredClasses: validate.ClassUpper}))
+
+	token, err := svc.GenerateToken(context.Background(), "jdoe@mail.com", password)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+}
+
 func TestNewUserFromRepository(t *testing.T) {
 	t.Parallel()
 
@@ -504,7 +913,7 @@ func TestNewUserFromRepository(t *testing.T) {
 		ID:            "123",
 		Fullname:      "John Doe",
 		Username:      "jdoe",
-		Birthdate:     "2000-01-01",
+		Birthdate:     time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
 		Email:         "jdoe@mail.com",
 		EmailVerified: true,
 		PasswordHash:  "password",
@@ -520,7 +929,7 @@ func TestNewUserFromRepository(t *testing.T) {
 			ID:            "123",
 			Fullname:      "John Doe",
 			Username:      "jdoe",
-			Birthdate:     "2000-01-01",
+			Birthdate:     Birthdate{year: 2000, month: time.January, day: 1},
 			Email:         "jdoe@mail.com",
 			EmailVerified: true,
 			Role:          RoleAdmin,
@@ -542,7 +951,7 @@ func TestNewUserFromRepository(t *testing.T) {
 			ID:            "123",
 			Fullname:      "John Doe",
 			Username:      "jdoe",
-			Birthdate:     "2000-01-01",
+			Birthdate:     Birthdate{year: 2000, month: time.January, day: 1},
 			Email:         "jdoe@mail.com",
 			EmailVerified: true,
 			Role:          RoleUser,
@@ -565,27 +974,671 @@ func TestNewUserFromRepository(t *testing.T) {
 	})
 }
 
-func TestRandString(t *testing.T) {
+func TestSendEmailVerification(t *testing.T) {
 	t.Parallel()
 
+	var (
+		sentFrom, sentTo string
+		sentBody         []byte
+		inserted         repository.EmailVerification
+	)
+
+	svc := New(
+		zap.NewNop(),
+		"jwt-secret",
+		&repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				return &repository.User{ID: id, Locale: "pt"}, nil
+			},
+			selectEmailVerificationActivityFunc: func(ctx context.Context, userID, email string, since time.Time) (*repository.EmailVerificationActivity, error) {
+				return &repository.EmailVerificationActivity{}, nil
+			},
+			insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
+				inserted = in
+				assert.Equal(t, defaultEmailVerificationTTL, in.ExpiresAt.Sub(in.CreatedAt))
+				return nil
+			},
+		},
+		WithEmailVerification("test-app", "noreply@test-app.com", "https://test-app.com/verify-email", &emailerMock{
+			sendFunc: func(from, to string, body []byte) error {
+				sentFrom, sentTo, sentBody = from, to, body
+				return nil
+			},
+		}),
+	)
+
+	err := svc.SendEmailVerification(context.Background(), uuid.New().String(), "jdoe", "jdoe@mail.com")
+	require.NoError(t, err)
+
+	assert.Equal(t, "noreply@test-app.com", sentFrom)
+	assert.Equal(t, "jdoe@mail.com", sentTo)
+	assert.Equal(t, "jdoe@mail.com", inserted.Email)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(sentBody))
+	require.NoError(t, err)
+
+	assert.Equal(t, `"test-app" <noreply@test-app.com>`, msg.Header.Get("From"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "test-app verificação de email", subject)
+	assert.NotEmpty(t, msg.Header.Get("Date"))
+	assert.NotEmpty(t, msg.Header.Get("Message-ID"))
+	assert.True(t, strings.HasPrefix(msg.Header.Get("Content-Type"), "multipart/alternative"))
+
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	require.NoError(t, err)
+
+	// Only hashes are stored, the email carries the link token and the code
+	link := regexp.MustCompile(`https://test-app\.com/verify-email/([A-Za-z0-9]+)`).FindSubmatch(body)
+	require.NotNil(t, link)
+	assert.Len(t, link[1], emailVerificationTokenLen)
+	assert.Equal(t, inserted.TokenHash, token.Hash(string(link[1])))
+
+	code := regexp.MustCompile(`\b[0-9]{6}\b`).Find(body)
+	require.NotNil(t, code)
+	assert.Equal(t, inserted.CodeHash.String, token.Hash(string(code)))
+
+	t.Run("user not found", func(t *testing.T) {
+		svc := DefaultService{
+			repo: &repositoryMock{
+				selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+					return nil, nil
+				},
+			},
+		}
+
+		err := svc.SendEmailVerification(context.Background(), uuid.New().String(), "jdoe", "jdoe@mail.com")
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
+func TestCreate_emailVerificationOutbox(t *testing.T) {
+	t.Parallel()
+
+	var insertedTokenHash string
+
+	outbox := email.NewOutbox()
+
+	svc := New(
+		zap.NewNop(),
+		"jwt-secret",
+		&repositoryMock{
+			insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+				return user, nil
+			},
+			selectEmailVerificationActivityFunc: func(ctx context.Context, userID, email string, since time.Time) (*repository.EmailVerificationActivity, error) {
+				return &repository.EmailVerificationActivity{}, nil
+			},
+			insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
+				insertedTokenHash = in.TokenHash
+				return nil
+			},
+		},
+		WithEmailVerification("test-app", "noreply@test-app.com", "https://test-app.com/verify-email", outbox),
+	)
+
+	_, err := svc.Create(context.Background(), CreateUserInput{
+		Fullname:        "John Doe",
+		Username:        "jdoe",
+		Birthdate:       "2000-01-01",
+		Email:           "joedoe@mail.com",
+		Password:        "password#123",
+		ConfirmPassword: "password#123",
+	})
+	require.NoError(t, err)
+
+	msg := outbox.LastMessageTo("joedoe@mail.com")
+	require.NotNil(t, msg)
+
+	assert.Equal(t, "test-app email verification", msg.Subject)
+
+	links := msg.Links()
+	require.Len(t, links, 1)
+
+	verifyURL, err := url.Parse(links[0])
+	require.NoError(t, err)
+
+	assert.Equal(t, insertedTokenHash, token.Hash(path.Base(verifyURL.Path)))
+}
+
+func TestVerificationLink(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenEndpoint string
+		expected      string
+	}{
+		{
+			name:          "absolute url",
+			givenEndpoint: "https://test-app.com/verify-email",
+			expected:      "https://test-app.com/verify-email/abc123",
+		},
+		{
+			name:          "trailing slash",
+			givenEndpoint: "https://test-app.com/verify-email/",
+			expected:      "https://test-app.com/verify-email/abc123",
+		},
+		{
+			name:          "query string",
+			givenEndpoint: "https://test-app.com/verify-email?lang=en",
+			expected:      "https://test-app.com/verify-email/abc123?lang=en",
+		},
+		{
+			name:          "host only",
+			givenEndpoint: "http://test-app:8080",
+			expected:      "http://test-app:8080/abc123",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := verificationLink(tc.givenEndpoint, "abc123")
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	userID := uuid.NewString()
+
+	outstanding := &repository.EmailVerification{
+		TokenHash: token.Hash("link-token"),
+		UserID:    userID,
+		CreatedAt: now.Add(-time.Hour),
+		ExpiresAt: now.Add(time.Hour),
+	}
+
+	testCases := []struct {
+		name                  string
+		givenToken            string
+		givenVerification     *repository.EmailVerification
+		givenConfirmErr       error
+		expectedConfirmedHash string
+		expectedError         error
+	}{
+		{
+			name:                  "verified",
+			givenToken:            "link-token",
+			givenVerification:     outstanding,
+			expectedConfirmedHash: token.Hash("link-token"),
+		},
+		{
+			name:          "empty token",
+			givenToken:    "",
+			expectedError: ErrVerificationInvalid,
+		},
+		{
+			name:              "unknown token",
+			givenToken:        "other-token",
+			givenVerification: nil,
+			expectedError:     ErrVerificationInvalid,
+		},
+		{
+			name:       "already used",
+			givenToken: "link-token",
+			givenVerification: &repository.EmailVerification{
+				TokenHash:  token.Hash("link-token"),
+				UserID:     userID,
+				ExpiresAt:  now.Add(time.Hour),
+				VerifiedAt: sql.NullTime{Time: now.Add(-time.Minute), Valid: true},
+			},
+			expectedError: ErrVerificationInvalid,
+		},
+		{
+			name:       "expired",
+			givenToken: "link-token",
+			givenVerification: &repository.EmailVerification{
+				TokenHash: token.Hash("link-token"),
+				UserID:    userID,
+				ExpiresAt: now,
+			},
+			expectedError: ErrVerificationExpired,
+		},
+		{
+			name:                  "used concurrently",
+			givenToken:            "link-token",
+			givenVerification:     outstanding,
+			givenConfirmErr:       repository.ErrRecordNotFound,
+			expectedConfirmedHash: token.Hash("link-token"),
+			expectedError:         ErrVerificationInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var confirmedHash string
+
+			svc := DefaultService{
+				clock: func() time.Time { return now },
+				repo: &repositoryMock{
+					selectEmailVerificationFunc: func(ctx context.Context, tokenHash string) (*repository.EmailVerification, error) {
+						if tc.givenVerification != nil && tokenHash == tc.givenVerification.TokenHash {
+							return tc.givenVerification, nil
+						}
+						return nil, nil
+					},
+					confirmEmailVerificationFunc: func(ctx context.Context, tokenHash string, verifiedAt time.Time) error {
+						confirmedHash = tokenHash
+						assert.Equal(t, now, verifiedAt)
+						return tc.givenConfirmErr
+					},
+				},
+			}
+
+			err := svc.VerifyEmail(context.Background(), tc.givenToken)
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedConfirmedHash, confirmedHash)
+		})
+	}
+}
+
+func TestVerifyEmailCode(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	userID := uuid.NewString()
+
+	latest := &repository.EmailVerification{
+		TokenHash: token.Hash("link-token"),
+		CodeHash:  sql.NullString{String: token.Hash("012345"), Valid: true},
+		UserID:    userID,
+		ExpiresAt: now.Add(time.Hour),
+	}
+
+	testCases := []struct {
+		name              string
+		givenCode         string
+		givenVerification *repository.EmailVerification
+		givenAttempts     int
+		expectedConfirmed bool
+		expectedError     error
+	}{
+		{
+			name:              "verified",
+			givenCode:         "012345",
+			givenVerification: latest,
+			givenAttempts:     1,
+			expectedConfirmed: true,
+		},
+		{
+			name:              "wrong code",
+			givenCode:         "012346",
+			givenVerification: latest,
+			givenAttempts:     1,
+			expectedError:     ErrVerificationInvalid,
+		},
+		{
+			name:              "last attempt",
+			givenCode:         "012345",
+			givenVerification: latest,
+			givenAttempts:     maxEmailVerificationAttempts,
+			expectedConfirmed: true,
+		},
+		{
+			name:              "too many attempts with the right code",
+			givenCode:         "012345",
+			givenVerification: latest,
+			givenAttempts:     maxEmailVerificationAttempts + 1,
+			expectedError:     ErrVerificationAttemptsExceeded,
+		},
+		{
+			name:              "no outstanding verification",
+			givenCode:         "012345",
+			givenVerification: nil,
+			expectedError:     ErrVerificationInvalid,
+		},
+		{
+			name:      "verification without code",
+			givenCode: "012345",
+			givenVerification: &repository.EmailVerification{
+				TokenHash: token.Hash("link-token"),
+				UserID:    userID,
+				ExpiresAt: now.Add(time.Hour),
+			},
+			expectedError: ErrVerificationInvalid,
+		},
+		{
+			name:      "expired",
+			givenCode: "012345",
+			givenVerification: &repository.EmailVerification{
+				TokenHash: token.Hash("link-token"),
+				CodeHash:  sql.NullString{String: token.Hash("012345"), Valid: true},
+				UserID:    userID,
+				ExpiresAt: now.Add(-time.Second),
+			},
+			expectedError: ErrVerificationExpired,
+		},
+		{
+			name:          "empty code",
+			givenCode:     "",
+			expectedError: ErrVerificationInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var confirmed bool
+
+			svc := DefaultService{
+				clock: func() time.Time { return now },
+				repo: &repositoryMock{
+					selectLatestEmailVerificationFunc: func(ctx context.Context, id string) (*repository.EmailVerification, error) {
+						assert.Equal(t, userID, id)
+						return tc.givenVerification, nil
+					},
+					incrementEmailVerificationAttemptsFunc: func(ctx context.Context, tokenHash string) (int, error) {
+						assert.Equal(t, token.Hash("link-token"), tokenHash)
+						return tc.givenAttempts, nil
+					},
+					confirmEmailVerificationFunc: func(ctx context.Context, tokenHash string, verifiedAt time.Time) error {
+						confirmed = true
+						return nil
+					},
+				},
+			}
+
+			err := svc.VerifyEmailCode(context.Background(), userID, tc.givenCode)
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedConfirmed, confirmed)
+		})
+	}
+}
+
+func TestSendEmailVerification_rateLimit(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		givenActivity repository.EmailVerificationActivity
+		expectedError error
+	}{
+		{
+			name:          "first email",
+			givenActivity: repository.EmailVerificationActivity{},
+		},
+		{
+			name: "after cooldown",
+			givenActivity: repository.EmailVerificationActivity{
+				UserCount:     1,
+				EmailCount:    1,
+				LastCreatedAt: sql.NullTime{Time: now.Add(-time.Minute), Valid: true},
+			},
+		},
+		{
+			name: "within cooldown",
+			givenActivity: repository.EmailVerificationActivity{
+				UserCount:     1,
+				EmailCount:    1,
+				LastCreatedAt: sql.NullTime{Time: now.Add(-30 * time.Second), Valid: true},
+			},
+			expectedError: ErrVerificationRateLimited,
+		},
+		{
+			name: "too many emails to the user",
+			givenActivity: repository.EmailVerificationActivity{
+				UserCount:     3,
+				UserOldest:    sql.NullTime{Time: now.Add(-30 * time.Minute), Valid: true},
+				LastCreatedAt: sql.NullTime{Time: now.Add(-10 * time.Minute), Valid: true},
+			},
+			expectedError: ErrVerificationRateLimited,
+		},
+		{
+			name: "too many emails to the address",
+			givenActivity: repository.EmailVerificationActivity{
+				EmailCount:    3,
+				EmailOldest:   sql.NullTime{Time: now.Add(-30 * time.Minute), Valid: true},
+				LastCreatedAt: sql.NullTime{Time: now.Add(-10 * time.Minute), Valid: true},
+			},
+			expectedError: ErrVerificationRateLimited,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var sent bool
+
+			svc := New(
+				zap.NewNop(),
+				"jwt-secret",
+				&repositoryMock{
+					selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+						return &repository.User{ID: id}, nil
+					},
+					selectEmailVerificationActivityFunc: func(ctx context.Context, userID, email string, since time.Time) (*repository.EmailVerificationActivity, error) {
+						assert.Equal(t, "jdoe@mail.com", email)
+						assert.Equal(t, now.Add(-time.Hour), since)
+						return &tc.givenActivity, nil
+					},
+					insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
+						return nil
+					},
+				},
+				WithClock(func() time.Time { return now }),
+				WithEmailVerificationRateLimit(time.Minute, 3, time.Hour),
+				WithEmailVerification("test-app", "noreply@test-app.com", "https://test-app.com/verify-email", &emailerMock{
+					sendFunc: func(from, to string, body []byte) error {
+						sent = true
+						return nil
+					},
+				}),
+			)
+
+			err := svc.SendEmailVerification(context.Background(), uuid.NewString(), "jdoe", "JDoe@Mail.com")
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedError == nil, sent)
+		})
+	}
+}
+
+// lockingRepositoryMock serializes the inserts of email verifications, like the advisory locks of the repository
+type lockingRepositoryMock struct {
+	*repositoryMock
+	mu sync.Mutex
+}
+
+func (m *lockingRepositoryMock) InsertEmailVerification(
+	ctx context.Context, in repository.EmailVerification, since time.Time,
+	check func(a *repository.EmailVerificationActivity) error,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.repositoryMock.InsertEmailVerification(ctx, in, since, check)
+}
+
+func TestSendEmailVerification_concurrentRateLimit(t *testing.T) {
+	t.Parallel()
+
+	const (
+		callers  = 10
+		maxSends = 3
+	)
+
+	var (
+		mu       sync.Mutex
+		inserted []repository.EmailVerification
+		sent     int32
+	)
+
+	repo := &lockingRepositoryMock{repositoryMock: &repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return &repository.User{ID: id}, nil
+		},
+		selectEmailVerificationActivityFunc: func(ctx context.Context, userID, email string, since time.Time) (*repository.EmailVerificationActivity, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			activity := repository.EmailVerificationActivity{UserCount: len(inserted), EmailCount: len(inserted)}
+			if len(inserted) > 0 {
+				activity.UserOldest = sql.NullTime{Time: inserted[0].CreatedAt, Valid: true}
+				activity.EmailOldest = activity.UserOldest
+			}
+			return &activity, nil
+		},
+		insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
+			mu.Lock()
+			defer mu.Unlock()
+			inserted = append(inserted, in)
+			return nil
+		},
+	}}
+
+	svc := New(
+		zap.NewNop(),
+		"jwt-secret",
+		repo,
+		WithEmailVerificationRateLimit(0, maxSends, time.Hour),
+		WithEmailVerification("test-app", "noreply@test-app.com", "https://test-app.com/verify-email", &emailerMock{
+			sendFunc: func(from, to string, body []byte) error {
+				atomic.AddInt32(&sent, 1)
+				return nil
+			},
+		}),
+	)
+
+	userID := uuid.NewString()
+
+	var (
+		wg      sync.WaitGroup
+		limited int32
+	)
+
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := svc.SendEmailVerification(context.Background(), userID, "jdoe", "jdoe@mail.com")
+			if errorCode(err) == string(CodeVerificationRateLimited) {
+				atomic.AddInt32(&limited, 1)
+				return
+			}
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// Concurrent requests cannot all pass the limit, since it is checked by the insert
+	assert.Equal(t, int32(maxSends), atomic.LoadInt32(&sent))
+	assert.Equal(t, int32(callers-maxSends), atomic.LoadInt32(&limited))
+	assert.Len(t, inserted, maxSends)
+}
+
+func TestGetVerificationStatus(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	userID := uuid.NewString()
+
 	testCases := []struct {
-		name        string
-		givenLength int
+		name              string
+		givenUser         *repository.User
+		givenVerification *repository.EmailVerification
+		givenActivity     repository.EmailVerificationActivity
+		expected          *VerificationStatus
+		expectedError     error
 	}{
 		{
-			name:        "zero length",
-			givenLength: 0,
+			name:      "verified",
+			givenUser: &repository.User{ID: userID, EmailVerified: true},
+			expected:  &VerificationStatus{EmailVerified: true},
+		},
+		{
+			name:      "never sent",
+			givenUser: &repository.User{ID: userID, EmailNormalized: "jdoe@mail.com"},
+			expected:  &VerificationStatus{},
 		},
 		{
-			name:        "non length",
-			givenLength: 10,
+			name:      "pending",
+			givenUser: &repository.User{ID: userID, EmailNormalized: "jdoe@mail.com"},
+			givenVerification: &repository.EmailVerification{
+				CreatedAt: now.Add(-30 * time.Second),
+				ExpiresAt: now.Add(time.Hour),
+			},
+			givenActivity: repository.EmailVerificationActivity{
+				UserCount:     1,
+				EmailCount:    1,
+				LastCreatedAt: sql.NullTime{Time: now.Add(-30 * time.Second), Valid: true},
+			},
+			expected: &VerificationStatus{
+				Pending:    true,
+				ExpiresAt:  now.Add(time.Hour),
+				LastSentAt: now.Add(-30 * time.Second),
+				NextSendAt: now.Add(30 * time.Second),
+			},
+		},
+		{
+			name:      "expired",
+			givenUser: &repository.User{ID: userID, EmailNormalized: "jdoe@mail.com"},
+			givenVerification: &repository.EmailVerification{
+				CreatedAt: now.Add(-25 * time.Hour),
+				ExpiresAt: now.Add(-time.Hour),
+			},
+			expected: &VerificationStatus{LastSentAt: now.Add(-25 * time.Hour)},
+		},
+		{
+			name:          "user not found",
+			expectedError: ErrNotFound,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			actual := randString(tc.givenLength)
-			assert.Equal(t, tc.givenLength, len(actual))
+			svc := DefaultService{
+				clock:                     func() time.Time { return now },
+				emailVerificationCooldown: time.Minute,
+				emailVerificationMaxSends: 5,
+				emailVerificationWindow:   time.Hour,
+				repo: &repositoryMock{
+					selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+						return tc.givenUser, nil
+					},
+					selectLatestEmailVerificationFunc: func(ctx context.Context, id string) (*repository.EmailVerification, error) {
+						return tc.givenVerification, nil
+					},
+					selectEmailVerificationActivityFunc: func(ctx context.Context, id, email string, since time.Time) (*repository.EmailVerificationActivity, error) {
+						assert.Equal(t, "jdoe@mail.com", email)
+						return &tc.givenActivity, nil
+					},
+				},
+			}
+
+			actual, err := svc.GetVerificationStatus(context.Background(), userID)
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expected, actual)
 		})
 	}
+
+	t.Run("invalid id", func(t *testing.T) {
+		_, err := (&DefaultService{}).GetVerificationStatus(context.Background(), "foo")
+		assert.Error(t, err)
+	})
+}
+
+func TestCleanupEmailVerifications(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	svc := DefaultService{
+		clock:                     func() time.Time { return now },
+		emailVerificationCooldown: time.Minute,
+		emailVerificationWindow:   time.Hour,
+		repo: &repositoryMock{
+			deleteExpiredEmailVerificationsFunc: func(ctx context.Context, expiredBefore, createdBefore time.Time) (int, error) {
+				assert.Equal(t, now, expiredBefore)
+				assert.Equal(t, now.Add(-time.Hour), createdBefore)
+				return 3, nil
+			},
+		},
+	}
+
+	deleted, err := svc.CleanupEmailVerifications(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, deleted)
 }
@@ -0,0 +1,182 @@
+package verifier
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// SigningMethod is the signing method of the tokens verified with public keys
+var SigningMethod = jwt.SigningMethodES256
+
+var (
+	// Enumerate verifier errors
+
+	ErrTokenEmpty   = errors.New("token is empty")
+	ErrTokenExpired = errors.New("token is expired")
+	ErrTokenInvalid = errors.New("token is invalid")
+
+	errKeyRequired = errors.New("at least one key is required")
+)
+
+type (
+	// Key is a public key of the users service, identified by the kid header of the tokens it signs
+	Key struct {
+		ID        string
+		PublicKey *ecdsa.PublicKey
+	}
+
+	// Claims are the claims of a verified token
+	Claims struct {
+		UserID        string
+		Username      string
+		Role          string
+		EmailVerified bool
+
+		// SessionID is the session the token was issued with, see users.Service.ListSessions
+		SessionID string
+		IssuedAt  time.Time
+		ExpiresAt time.Time
+	}
+
+	// Verifier verifies the tokens of the users service with its ECDSA public keys, for services
+	// that cannot reach the users database. It trusts the signed claims only: tokens revoked before
+	// they expire, and changes to a user made after a token was issued, are not seen.
+	Verifier struct {
+		keys  map[string]*ecdsa.PublicKey
+		clock func() time.Time
+	}
+
+	Option func(*Verifier)
+)
+
+// WithClock sets the clock tokens expire against, time.Now by default
+func WithClock(now func() time.Time) Option {
+	return func(v *Verifier) {
+		v.clock = now
+	}
+}
+
+// New returns a verifier accepting the tokens signed with any of keys. Passing the previous
+// and the next key of the users service lets tokens verify while its key is rotated.
+func New(keys []Key, opts ...Option) (*Verifier, error) {
+	if len(keys) == 0 {
+		return nil, errKeyRequired
+	}
+
+	v := Verifier{keys: make(map[string]*ecdsa.PublicKey, len(keys)), clock: time.Now}
+	for _, key := range keys {
+		if key.PublicKey == nil {
+			return nil, fmt.Errorf("could not add key %q: public key is nil", key.ID)
+		}
+
+		if _, ok := v.keys[key.ID]; ok {
+			return nil, fmt.Errorf("could not add key %q: duplicate key id", key.ID)
+		}
+		v.keys[key.ID] = key.PublicKey
+	}
+
+	for _, opt := range opts {
+		opt(&v)
+	}
+	return &v, nil
+}
+
+// Verify checks the signature and expiration of a token and returns its claims
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	if token == "" {
+		return nil, ErrTokenEmpty
+	}
+
+	// Expiration is checked below against the verifier clock instead of the parser's
+	parser := jwt.Parser{SkipClaimsValidation: true}
+
+	jwtToken, err := parser.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		// The method is checked explicitly, so that public keys are never used as HMAC secrets
+		if token.Method != SigningMethod {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		keyID, _ := token.Header["kid"].(string)
+
+		key, ok := v.keys[keyID]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id: %q", keyID)
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not parse token: %s: %w", err, ErrTokenInvalid)
+	}
+
+	mapClaims, ok := jwtToken.Claims.(jwt.MapClaims)
+	if !ok || !jwtToken.Valid {
+		return nil, ErrTokenInvalid
+	}
+
+	claims, err := ParseClaims(mapClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.ExpiresAt.Before(v.clock()) {
+		return nil, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+// ParseClaims reads the claims of a token whose signature was verified.
+// The user id, role and expiration are required.
+func ParseClaims(claims jwt.MapClaims) (*Claims, error) {
+	var c Claims
+
+	var ok bool
+	if c.UserID, ok = claims["user_id"].(string); !ok {
+		return nil, fmt.Errorf("could not find user id in token: %w", ErrTokenInvalid)
+	}
+
+	if c.Role, ok = claims["role"].(string); !ok {
+		return nil, fmt.Errorf("could not find role in token: %w", ErrTokenInvalid)
+	}
+
+	expiration, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("could not find expiration in token: %w", ErrTokenInvalid)
+	}
+	c.ExpiresAt = time.Unix(int64(expiration), 0)
+
+	// Tokens issued before these claims were introduced do not carry them
+	c.Username, _ = claims["username"].(string)
+	c.EmailVerified, _ = claims["email_verified"].(bool)
+	c.SessionID, _ = claims["sid"].(string)
+
+	if issuedAt, ok := claims["iat"].(float64); ok {
+		c.IssuedAt = time.Unix(int64(issuedAt), 0)
+	}
+	return &c, nil
+}
+
+// ParsePublicKey parses a PEM encoded ECDSA public key, such as the output of
+// openssl ec -in key.pem -pubout
+func ParsePublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("could not decode PEM block")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse public key: %s", err)
+	}
+
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unexpected public key type: %T", key)
+	}
+	return ecdsaKey, nil
+}
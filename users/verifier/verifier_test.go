@@ -0,0 +1,177 @@
+package verifier
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier_Verify(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	key := generateKey(t)
+	otherKey := generateKey(t)
+
+	validClaims := jwt.MapClaims{
+		"user_id":        "8b3a2f7e-1c2d-4e5f-8a9b-0c1d2e3f4a5b",
+		"username":       "jdoe",
+		"role":           "user",
+		"email_verified": true,
+		"sid":            "0f8e3c1a-2b4d-4c6e-8f0a-1b2c3d4e5f6a",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+
+	sign := func(method jwt.SigningMethod, keyID string, claims jwt.MapClaims, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = keyID
+
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+
+	withoutClaim := func(name string) jwt.MapClaims {
+		claims := jwt.MapClaims{}
+		for k, v := range validClaims {
+			if k != name {
+				claims[k] = v
+			}
+		}
+		return claims
+	}
+
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name           string
+		givenToken     string
+		expectedClaims *Claims
+		expectedError  error
+	}{
+		{
+			name:       "valid token",
+			givenToken: sign(SigningMethod, "2022-06", validClaims, key),
+			expectedClaims: &Claims{
+				UserID:        "8b3a2f7e-1c2d-4e5f-8a9b-0c1d2e3f4a5b",
+				Username:      "jdoe",
+				Role:          "user",
+				EmailVerified: true,
+				SessionID:     "0f8e3c1a-2b4d-4c6e-8f0a-1b2c3d4e5f6a",
+				IssuedAt:      time.Unix(now.Unix(), 0),
+				ExpiresAt:     time.Unix(now.Add(time.Hour).Unix(), 0),
+			},
+		},
+		{
+			name:          "empty token",
+			givenToken:    "",
+			expectedError: ErrTokenEmpty,
+		},
+		{
+			name: "expired token",
+			givenToken: sign(SigningMethod, "2022-06", jwt.MapClaims{
+				"user_id": "1", "role": "user", "exp": now.Add(-time.Second).Unix(),
+			}, key),
+			expectedError: ErrTokenExpired,
+		},
+		{
+			name:          "unknown key id",
+			givenToken:    sign(SigningMethod, "2022-01", validClaims, key),
+			expectedError: ErrTokenInvalid,
+		},
+		{
+			name:          "signed with another key",
+			givenToken:    sign(SigningMethod, "2022-06", validClaims, otherKey),
+			expectedError: ErrTokenInvalid,
+		},
+		{
+			name:          "signed with the public key as HMAC secret",
+			givenToken:    sign(jwt.SigningMethodHS256, "2022-06", validClaims, publicKeyDER),
+			expectedError: ErrTokenInvalid,
+		},
+		{
+			name:          "missing user id",
+			givenToken:    sign(SigningMethod, "2022-06", withoutClaim("user_id"), key),
+			expectedError: ErrTokenInvalid,
+		},
+		{
+			name:          "missing role",
+			givenToken:    sign(SigningMethod, "2022-06", withoutClaim("role"), key),
+			expectedError: ErrTokenInvalid,
+		},
+		{
+			name:          "missing expiration",
+			givenToken:    sign(SigningMethod, "2022-06", withoutClaim("exp"), key),
+			expectedError: ErrTokenInvalid,
+		},
+	}
+
+	v, err := New([]Key{{ID: "2022-06", PublicKey: &key.PublicKey}}, WithClock(func() time.Time { return now }))
+	require.NoError(t, err)
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := v.Verify(tc.givenToken)
+			if tc.expectedError != nil {
+				assert.True(t, errors.Is(err, tc.expectedError), err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedClaims, actual)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	key := generateKey(t)
+
+	_, err := New(nil)
+	assert.Equal(t, errKeyRequired, err)
+
+	_, err = New([]Key{{ID: "1"}})
+	assert.ErrorContains(t, err, "public key is nil")
+
+	_, err = New([]Key{{ID: "1", PublicKey: &key.PublicKey}, {ID: "1", PublicKey: &key.PublicKey}})
+	assert.ErrorContains(t, err, "duplicate key id")
+}
+
+func TestParsePublicKey(t *testing.T) {
+	t.Parallel()
+
+	key := generateKey(t)
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	actual, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	require.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(actual))
+
+	_, err = ParsePublicKey([]byte("not a key"))
+	assert.Error(t, err)
+}
+
+func generateKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return key
+}
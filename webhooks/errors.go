@@ -0,0 +1,27 @@
+package webhooks
+
+type E struct {
+	msg string
+}
+
+func newE(msg string) E {
+	return E{msg: msg}
+}
+
+func (e E) Error() string {
+	return e.msg
+}
+
+var (
+	// Enumerate service errors
+
+	errEndpointNotFound    = newE("webhook endpoint not found")
+	errEventsRequired      = newE("webhook events are required")
+	errEventInvalid        = newE("webhook event is invalid")
+	errURLInvalid          = newE("webhook url must be an absolute http or https url")
+	errSecretLength        = newE("webhook secret must be at least 16 characters")
+	errSignatureMissing    = newE("webhook signature is missing")
+	errSignatureInvalid    = newE("webhook signature is invalid")
+	errTimestampInvalid    = newE("webhook timestamp is invalid")
+	errTimestampOutOfRange = newE("webhook timestamp is outside the tolerance window")
+)
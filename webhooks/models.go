@@ -0,0 +1,154 @@
+package webhooks
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alesr/stdservices/users"
+	"github.com/alesr/stdservices/webhooks/repository"
+)
+
+const (
+	// Enumerate delivery statuses
+
+	DeliveryStatusPending   DeliveryStatus = "pending"
+	DeliveryStatusDelivered DeliveryStatus = "delivered"
+	DeliveryStatusFailed    DeliveryStatus = "failed"
+
+	// EventAll subscribes an endpoint to every user event
+	EventAll users.EventType = "*"
+
+	minSecretLen = 16
+)
+
+// DeliveryStatus represents the state of a webhook delivery
+type DeliveryStatus string
+
+// Endpoint represents a registered webhook endpoint
+type Endpoint struct {
+	ID        string
+	URL       string
+	Secret    string
+	Events    []users.EventType
+	CreatedAt time.Time
+}
+
+// Delivery represents the delivery of an event to an endpoint and its attempts history
+type Delivery struct {
+	ID             string
+	EndpointID     string
+	EventType      users.EventType
+	Payload        string
+	Status         DeliveryStatus
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastAttemptAt  *time.Time
+	LastStatusCode int
+	LastError      string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// RegisterEndpointInput represents the input data for registering a webhook endpoint
+type RegisterEndpointInput struct {
+	URL string
+	// Secret is used to sign payloads. A random secret is generated when empty.
+	Secret string
+	Events []users.EventType
+}
+
+func (in *RegisterEndpointInput) validate() error {
+	u, err := url.Parse(in.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errURLInvalid
+	}
+
+	if in.Secret != "" && len(in.Secret) < minSecretLen {
+		return errSecretLength
+	}
+
+	if len(in.Events) == 0 {
+		return errEventsRequired
+	}
+
+	for _, event := range in.Events {
+		if !validEventType(event) {
+			return errEventInvalid
+		}
+	}
+	return nil
+}
+
+// payload is the JSON document posted to webhook endpoints
+type payload struct {
+	ID        string          `json:"id"`
+	Type      users.EventType `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      payloadData     `json:"data"`
+}
+
+type payloadData struct {
+	UserID string `json:"user_id"`
+}
+
+func validEventType(eventType users.EventType) bool {
+	if eventType == EventAll {
+		return true
+	}
+
+	for _, known := range users.EventTypes {
+		if eventType == known {
+			return true
+		}
+	}
+	return false
+}
+
+func joinEvents(events []users.EventType) string {
+	parts := make([]string, 0, len(events))
+	for _, event := range events {
+		if event == EventAll {
+			return string(EventAll)
+		}
+		parts = append(parts, string(event))
+	}
+	return strings.Join(parts, ",")
+}
+
+func newEndpointFromRepository(e *repository.Endpoint) *Endpoint {
+	var events []users.EventType
+	for _, event := range strings.Split(e.Events, ",") {
+		events = append(events, users.EventType(event))
+	}
+
+	return &Endpoint{
+		ID:        e.ID,
+		URL:       e.URL,
+		Secret:    e.Secret,
+		Events:    events,
+		CreatedAt: e.CreatedAt,
+	}
+}
+
+func newDeliveryFromRepository(d *repository.Delivery) Delivery {
+	delivery := Delivery{
+		ID:             d.ID,
+		EndpointID:     d.EndpointID,
+		EventType:      users.EventType(d.EventType),
+		Payload:        d.Payload,
+		Status:         DeliveryStatus(d.Status),
+		Attempts:       d.Attempts,
+		NextAttemptAt:  d.NextAttemptAt,
+		LastStatusCode: int(d.LastStatusCode.Int64),
+		LastError:      d.LastError.String,
+		CreatedAt:      d.CreatedAt,
+		UpdatedAt:      d.UpdatedAt,
+	}
+
+	if d.LastAttemptAt.Valid {
+		lastAttemptAt := d.LastAttemptAt.Time
+		delivery.LastAttemptAt = &lastAttemptAt
+	}
+	return delivery
+}
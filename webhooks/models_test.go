@@ -0,0 +1,90 @@
+package webhooks
+
+import (
+	"testing"
+
+	"github.com/alesr/stdservices/users"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterEndpointInput_validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		given         RegisterEndpointInput
+		expectedError error
+	}{
+		{
+			name: "valid",
+			given: RegisterEndpointInput{
+				URL:    "https://partner.example.com/hooks",
+				Events: []users.EventType{users.EventUserCreated},
+			},
+			expectedError: nil,
+		},
+		{
+			name: "valid wildcard with secret",
+			given: RegisterEndpointInput{
+				URL:    "http://localhost:8080/hooks",
+				Secret: "0123456789abcdef",
+				Events: []users.EventType{EventAll},
+			},
+			expectedError: nil,
+		},
+		{
+			name: "relative url",
+			given: RegisterEndpointInput{
+				URL:    "/hooks",
+				Events: []users.EventType{users.EventUserCreated},
+			},
+			expectedError: errURLInvalid,
+		},
+		{
+			name: "unsupported scheme",
+			given: RegisterEndpointInput{
+				URL:    "ftp://partner.example.com/hooks",
+				Events: []users.EventType{users.EventUserCreated},
+			},
+			expectedError: errURLInvalid,
+		},
+		{
+			name: "short secret",
+			given: RegisterEndpointInput{
+				URL:    "https://partner.example.com/hooks",
+				Secret: "123",
+				Events: []users.EventType{users.EventUserCreated},
+			},
+			expectedError: errSecretLength,
+		},
+		{
+			name: "missing events",
+			given: RegisterEndpointInput{
+				URL: "https://partner.example.com/hooks",
+			},
+			expectedError: errEventsRequired,
+		},
+		{
+			name: "unknown event",
+			given: RegisterEndpointInput{
+				URL:    "https://partner.example.com/hooks",
+				Events: []users.EventType{"user.exploded"},
+			},
+			expectedError: errEventInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := tc.given.validate()
+			assert.Equal(t, tc.expectedError, actual)
+		})
+	}
+}
+
+func TestJoinEvents(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "user.created,user.deleted", joinEvents([]users.EventType{users.EventUserCreated, users.EventUserDeleted}))
+	assert.Equal(t, "*", joinEvents([]users.EventType{users.EventUserCreated, EventAll}))
+}
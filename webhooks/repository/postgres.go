@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// Enumerate postgresql query strings
+
+	insertEndpointQuery string = `INSERT INTO webhook_endpoints (id,url,secret,events,created_at)
+	VALUES ($1,$2,$3,$4,$5) RETURNING id,url,secret,events,created_at;`
+
+	selectEndpointByIDQuery string = `SELECT id,url,secret,events,created_at
+	FROM webhook_endpoints WHERE id = $1;`
+
+	selectEndpointsByEventQuery string = `SELECT id,url,secret,events,created_at FROM webhook_endpoints
+	WHERE events = '*' OR $1 = ANY(string_to_array(events, ','));`
+
+	deleteEndpointByIDQuery string = "DELETE FROM webhook_endpoints WHERE id = $1;"
+
+	insertDeliveryQuery string = `INSERT INTO webhook_deliveries (id,endpoint_id,event_type,payload,status,
+	attempts,next_attempt_at,created_at,updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9);`
+
+	// claimDueDeliveriesQuery pushes the next attempt of due deliveries forward by a lease
+	// so that concurrent dispatchers do not pick the same deliveries.
+	claimDueDeliveriesQuery string = `UPDATE webhook_deliveries SET next_attempt_at = $2
+	WHERE id IN (
+		SELECT id FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= $1
+		ORDER BY next_attempt_at LIMIT $3 FOR UPDATE SKIP LOCKED
+	) RETURNING id,endpoint_id,event_type,payload,status,attempts,next_attempt_at,
+	last_attempt_at,last_status_code,last_error,created_at,updated_at;`
+
+	updateDeliveryQuery string = `UPDATE webhook_deliveries SET status = $2, attempts = $3, next_attempt_at = $4,
+	last_attempt_at = $5, last_status_code = $6, last_error = $7, updated_at = $8 WHERE id = $1;`
+
+	selectDeliveriesByEndpointIDQuery string = `SELECT id,endpoint_id,event_type,payload,status,attempts,
+	next_attempt_at,last_attempt_at,last_status_code,last_error,created_at,updated_at
+	FROM webhook_deliveries WHERE endpoint_id = $1 ORDER BY created_at DESC LIMIT $2;`
+
+	insertDeadLetterQuery string = `INSERT INTO webhook_dead_letters (delivery_id,endpoint_id,payload,last_error,failed_at)
+	VALUES ($1,$2,$3,$4,$5);`
+)
+
+// Postgres represents a webhooks repository instance with the given database connection
+type Postgres struct{ *sqlx.DB }
+
+// NewPostgres creates a new webhooks repository instance
+func NewPostgres(dbConn *sqlx.DB) *Postgres {
+	return &Postgres{dbConn}
+}
+
+// InsertEndpoint inserts a webhook endpoint and returns the inserted endpoint
+func (p *Postgres) InsertEndpoint(ctx context.Context, e *Endpoint) (*Endpoint, error) {
+	var res Endpoint
+	if err := p.QueryRowContext(
+		ctx, insertEndpointQuery, e.ID, e.URL, e.Secret, e.Events, e.CreatedAt,
+	).Scan(&res.ID, &res.URL, &res.Secret, &res.Events, &res.CreatedAt); err != nil {
+		return nil, fmt.Errorf("could not scan inserted endpoint: %s", err)
+	}
+	return &res, nil
+}
+
+// SelectEndpointByID selects a webhook endpoint by id
+func (p *Postgres) SelectEndpointByID(ctx context.Context, id string) (*Endpoint, error) {
+	var e Endpoint
+	if err := p.QueryRowContext(ctx, selectEndpointByIDQuery, id).Scan(
+		&e.ID, &e.URL, &e.Secret, &e.Events, &e.CreatedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not select endpoint: %s", err)
+	}
+	return &e, nil
+}
+
+// SelectEndpointsByEvent selects the webhook endpoints subscribed to the given event type
+func (p *Postgres) SelectEndpointsByEvent(ctx context.Context, eventType string) ([]Endpoint, error) {
+	rows, err := p.QueryContext(ctx, selectEndpointsByEventQuery, eventType)
+	if err != nil {
+		return nil, fmt.Errorf("could not select endpoints: %s", err)
+	}
+	defer rows.Close()
+
+	var endpoints []Endpoint
+	for rows.Next() {
+		var e Endpoint
+		if err := rows.Scan(&e.ID, &e.URL, &e.Secret, &e.Events, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not scan endpoint: %s", err)
+		}
+		endpoints = append(endpoints, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate endpoints: %s", err)
+	}
+	return endpoints, nil
+}
+
+// DeleteEndpointByID deletes a webhook endpoint and, by cascade, its deliveries
+func (p *Postgres) DeleteEndpointByID(ctx context.Context, id string) error {
+	res, err := p.ExecContext(ctx, deleteEndpointByIDQuery, id)
+	if err != nil {
+		return fmt.Errorf("could not delete endpoint: %s", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %s", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// InsertDelivery inserts a webhook delivery
+func (p *Postgres) InsertDelivery(ctx context.Context, d *Delivery) error {
+	if _, err := p.ExecContext(
+		ctx, insertDeliveryQuery, d.ID, d.EndpointID, d.EventType, d.Payload, d.Status,
+		d.Attempts, d.NextAttemptAt, d.CreatedAt, d.UpdatedAt,
+	); err != nil {
+		return fmt.Errorf("could not insert delivery: %s", err)
+	}
+	return nil
+}
+
+// ClaimDueDeliveries selects up to limit pending deliveries due at now and
+// postpones their next attempt until the given lease expires
+func (p *Postgres) ClaimDueDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]Delivery, error) {
+	rows, err := p.QueryContext(ctx, claimDueDeliveriesQuery, now, leaseUntil, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not claim deliveries: %s", err)
+	}
+	defer rows.Close()
+
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("could not scan claimed deliveries: %s", err)
+	}
+	return deliveries, nil
+}
+
+// UpdateDelivery updates the status and attempt information of a delivery
+func (p *Postgres) UpdateDelivery(ctx context.Context, d *Delivery) error {
+	return updateDelivery(ctx, p, d)
+}
+
+// SelectDeliveriesByEndpointID selects the most recent deliveries of an endpoint
+func (p *Postgres) SelectDeliveriesByEndpointID(ctx context.Context, endpointID string, limit int) ([]Delivery, error) {
+	rows, err := p.QueryContext(ctx, selectDeliveriesByEndpointIDQuery, endpointID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not select deliveries: %s", err)
+	}
+	defer rows.Close()
+
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("could not scan deliveries: %s", err)
+	}
+	return deliveries, nil
+}
+
+// FailDelivery updates a delivery that exhausted its retries and records it as a dead letter,
+// in one transaction so that a failed delivery is never left without its dead letter
+func (p *Postgres) FailDelivery(ctx context.Context, d *Delivery, dl DeadLetter) error {
+	tx, err := p.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	if err := updateDelivery(ctx, tx, d); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(
+		ctx, insertDeadLetterQuery, dl.DeliveryID, dl.EndpointID, dl.Payload, dl.LastError, dl.FailedAt,
+	); err != nil {
+		return fmt.Errorf("could not insert dead letter: %s", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %s", err)
+	}
+	return nil
+}
+
+func updateDelivery(ctx context.Context, db sqlx.ExecerContext, d *Delivery) error {
+	res, err := db.ExecContext(
+		ctx, updateDeliveryQuery, d.ID, d.Status, d.Attempts, d.NextAttemptAt,
+		d.LastAttemptAt, d.LastStatusCode, d.LastError, d.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("could not update delivery: %s", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %s", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func scanDeliveries(rows *sql.Rows) ([]Delivery, error) {
+	var deliveries []Delivery
+	for rows.Next() {
+		var d Delivery
+		if err := rows.Scan(
+			&d.ID, &d.EndpointID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&d.LastAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
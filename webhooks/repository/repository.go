@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrRecordNotFound error = errors.New("record not found")
+
+// Endpoint represents a webhook endpoint in the database table
+type Endpoint struct {
+	ID        string
+	URL       string
+	Secret    string
+	Events    string
+	CreatedAt time.Time
+}
+
+// Delivery represents a webhook delivery in the database table
+type Delivery struct {
+	ID             string
+	EndpointID     string
+	EventType      string
+	Payload        string
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastAttemptAt  sql.NullTime
+	LastStatusCode sql.NullInt64
+	LastError      sql.NullString
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// DeadLetter represents a delivery that exhausted its retries
+type DeadLetter struct {
+	DeliveryID string
+	EndpointID string
+	Payload    string
+	LastError  string
+	FailedAt   time.Time
+}
@@ -0,0 +1,86 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alesr/stdservices/webhooks/repository"
+)
+
+var _ repo = (*repositoryMock)(nil)
+
+type repositoryMock struct {
+	insertEndpointFunc               func(ctx context.Context, e *repository.Endpoint) (*repository.Endpoint, error)
+	selectEndpointByIDFunc           func(ctx context.Context, id string) (*repository.Endpoint, error)
+	selectEndpointsByEventFunc       func(ctx context.Context, eventType string) ([]repository.Endpoint, error)
+	deleteEndpointByIDFunc           func(ctx context.Context, id string) error
+	insertDeliveryFunc               func(ctx context.Context, d *repository.Delivery) error
+	claimDueDeliveriesFunc           func(ctx context.Context, now, leaseUntil time.Time, limit int) ([]repository.Delivery, error)
+	updateDeliveryFunc               func(ctx context.Context, d *repository.Delivery) error
+	selectDeliveriesByEndpointIDFunc func(ctx context.Context, endpointID string, limit int) ([]repository.Delivery, error)
+	failDeliveryFunc                 func(ctx context.Context, d *repository.Delivery, dl repository.DeadLetter) error
+}
+
+func (m *repositoryMock) InsertEndpoint(ctx context.Context, e *repository.Endpoint) (*repository.Endpoint, error) {
+	if m.insertEndpointFunc == nil {
+		return nil, errors.New("repositoryMock.insertEndpointFunc is nil")
+	}
+	return m.insertEndpointFunc(ctx, e)
+}
+
+func (m *repositoryMock) SelectEndpointByID(ctx context.Context, id string) (*repository.Endpoint, error) {
+	if m.selectEndpointByIDFunc == nil {
+		return nil, errors.New("repositoryMock.selectEndpointByIDFunc is nil")
+	}
+	return m.selectEndpointByIDFunc(ctx, id)
+}
+
+func (m *repositoryMock) SelectEndpointsByEvent(ctx context.Context, eventType string) ([]repository.Endpoint, error) {
+	if m.selectEndpointsByEventFunc == nil {
+		return nil, errors.New("repositoryMock.selectEndpointsByEventFunc is nil")
+	}
+	return m.selectEndpointsByEventFunc(ctx, eventType)
+}
+
+func (m *repositoryMock) DeleteEndpointByID(ctx context.Context, id string) error {
+	if m.deleteEndpointByIDFunc == nil {
+		return errors.New("repositoryMock.deleteEndpointByIDFunc is nil")
+	}
+	return m.deleteEndpointByIDFunc(ctx, id)
+}
+
+func (m *repositoryMock) InsertDelivery(ctx context.Context, d *repository.Delivery) error {
+	if m.insertDeliveryFunc == nil {
+		return errors.New("repositoryMock.insertDeliveryFunc is nil")
+	}
+	return m.insertDeliveryFunc(ctx, d)
+}
+
+func (m *repositoryMock) ClaimDueDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]repository.Delivery, error) {
+	if m.claimDueDeliveriesFunc == nil {
+		return nil, errors.New("repositoryMock.claimDueDeliveriesFunc is nil")
+	}
+	return m.claimDueDeliveriesFunc(ctx, now, leaseUntil, limit)
+}
+
+func (m *repositoryMock) UpdateDelivery(ctx context.Context, d *repository.Delivery) error {
+	if m.updateDeliveryFunc == nil {
+		return errors.New("repositoryMock.updateDeliveryFunc is nil")
+	}
+	return m.updateDeliveryFunc(ctx, d)
+}
+
+func (m *repositoryMock) SelectDeliveriesByEndpointID(ctx context.Context, endpointID string, limit int) ([]repository.Delivery, error) {
+	if m.selectDeliveriesByEndpointIDFunc == nil {
+		return nil, errors.New("repositoryMock.selectDeliveriesByEndpointIDFunc is nil")
+	}
+	return m.selectDeliveriesByEndpointIDFunc(ctx, endpointID, limit)
+}
+
+func (m *repositoryMock) FailDelivery(ctx context.Context, d *repository.Delivery, dl repository.DeadLetter) error {
+	if m.failDeliveryFunc == nil {
+		return errors.New("repositoryMock.failDeliveryFunc is nil")
+	}
+	return m.failDeliveryFunc(ctx, d, dl)
+}
@@ -0,0 +1,73 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Enumerate webhook request headers
+
+	HeaderEventID   = "Webhook-Id"
+	HeaderEventType = "Webhook-Event"
+	HeaderTimestamp = "Webhook-Timestamp"
+	HeaderSignature = "Webhook-Signature"
+
+	signatureVersion = "v1"
+)
+
+// Sign returns the signature header value for a payload sent at the given time.
+// The signature is an HMAC-SHA256 over "<unix timestamp>.<body>" keyed with the endpoint secret.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	return signatureVersion + "=" + hex.EncodeToString(computeMAC(secret, timestamp.Unix(), body))
+}
+
+// Verify checks the timestamp and signature headers of a received webhook.
+// Receivers should reject payloads older than tolerance to prevent replays.
+func Verify(secret, timestampHeader, signatureHeader string, body []byte, tolerance time.Duration, now time.Time) error {
+	if signatureHeader == "" {
+		return errSignatureMissing
+	}
+
+	unix, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return errTimestampInvalid
+	}
+
+	sentAt := time.Unix(unix, 0)
+	if now.Sub(sentAt) > tolerance || sentAt.Sub(now) > tolerance {
+		return errTimestampOutOfRange
+	}
+
+	expected := computeMAC(secret, unix, body)
+
+	// Several signatures may be sent during secret rotation
+	for _, candidate := range strings.Split(signatureHeader, ",") {
+		version, sig, ok := strings.Cut(strings.TrimSpace(candidate), "=")
+		if !ok || version != signatureVersion {
+			continue
+		}
+
+		decoded, err := hex.DecodeString(sig)
+		if err != nil {
+			continue
+		}
+
+		if hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return errSignatureInvalid
+}
+
+func computeMAC(secret string, unix int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(unix, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
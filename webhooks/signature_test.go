@@ -0,0 +1,93 @@
+package webhooks
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	t.Parallel()
+
+	givenSecret := "whsec_0123456789abcdef"
+	givenBody := []byte(`{"type":"user.created"}`)
+	givenNow := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	validSignature := Sign(givenSecret, givenNow, givenBody)
+	validTimestamp := strconv.FormatInt(givenNow.Unix(), 10)
+
+	testCases := []struct {
+		name           string
+		givenSecret    string
+		givenTimestamp string
+		givenSignature string
+		givenBody      []byte
+		expectedError  error
+	}{
+		{
+			name:           "valid signature",
+			givenSecret:    givenSecret,
+			givenTimestamp: validTimestamp,
+			givenSignature: validSignature,
+			givenBody:      givenBody,
+			expectedError:  nil,
+		},
+		{
+			name:           "valid signature among rotated ones",
+			givenSecret:    givenSecret,
+			givenTimestamp: validTimestamp,
+			givenSignature: "v1=deadbeef, " + validSignature,
+			givenBody:      givenBody,
+			expectedError:  nil,
+		},
+		{
+			name:           "missing signature",
+			givenSecret:    givenSecret,
+			givenTimestamp: validTimestamp,
+			givenSignature: "",
+			givenBody:      givenBody,
+			expectedError:  errSignatureMissing,
+		},
+		{
+			name:           "invalid timestamp",
+			givenSecret:    givenSecret,
+			givenTimestamp: "yesterday",
+			givenSignature: validSignature,
+			givenBody:      givenBody,
+			expectedError:  errTimestampInvalid,
+		},
+		{
+			name:           "timestamp out of tolerance",
+			givenSecret:    givenSecret,
+			givenTimestamp: strconv.FormatInt(givenNow.Add(-time.Hour).Unix(), 10),
+			givenSignature: validSignature,
+			givenBody:      givenBody,
+			expectedError:  errTimestampOutOfRange,
+		},
+		{
+			name:           "wrong secret",
+			givenSecret:    "whsec_another-secret",
+			givenTimestamp: validTimestamp,
+			givenSignature: validSignature,
+			givenBody:      givenBody,
+			expectedError:  errSignatureInvalid,
+		},
+		{
+			name:           "tampered body",
+			givenSecret:    givenSecret,
+			givenTimestamp: validTimestamp,
+			givenSignature: validSignature,
+			givenBody:      []byte(`{"type":"user.deleted"}`),
+			expectedError:  errSignatureInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := Verify(tc.givenSecret, tc.givenTimestamp, tc.givenSignature, tc.givenBody, 5*time.Minute, givenNow)
+			assert.Equal(t, tc.expectedError, actual)
+		})
+	}
+}
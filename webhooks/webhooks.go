@@ -0,0 +1,382 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alesr/stdservices/pkg/backoff"
+	"github.com/alesr/stdservices/pkg/validate"
+	"github.com/alesr/stdservices/users"
+	"github.com/alesr/stdservices/webhooks/repository"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMaxAttempts    = 8
+	defaultBaseBackoff    = 30 * time.Second
+	defaultMaxBackoff     = 6 * time.Hour
+	defaultPollInterval   = 5 * time.Second
+	defaultBatchSize      = 20
+	defaultClaimLease     = 5 * time.Minute
+	defaultRequestTimeout = 10 * time.Second
+
+	deliveryHistoryLimit = 100
+
+	// maxErrorBodyLen bounds how much of a failed response body is kept as the delivery error
+	maxErrorBodyLen = 512
+
+	userAgent = "stdservices-webhooks/1"
+)
+
+var _ Service = (*DefaultService)(nil)
+
+type (
+	// Service defines the service interface
+	Service interface {
+		// RegisterEndpoint registers an endpoint to receive user events.
+		// A signing secret is generated when the input does not carry one.
+		RegisterEndpoint(ctx context.Context, in RegisterEndpointInput) (*Endpoint, error)
+
+		// DeleteEndpoint deletes an endpoint and its delivery history
+		DeleteEndpoint(ctx context.Context, id string) error
+
+		// ListDeliveries returns the most recent deliveries of an endpoint
+		ListDeliveries(ctx context.Context, endpointID string) ([]Delivery, error)
+
+		// Publish schedules the delivery of a user event to every subscribed endpoint
+		Publish(ctx context.Context, event users.Event) error
+	}
+
+	repo interface {
+		InsertEndpoint(ctx context.Context, e *repository.Endpoint) (*repository.Endpoint, error)
+		SelectEndpointByID(ctx context.Context, id string) (*repository.Endpoint, error)
+		SelectEndpointsByEvent(ctx context.Context, eventType string) ([]repository.Endpoint, error)
+		DeleteEndpointByID(ctx context.Context, id string) error
+		InsertDelivery(ctx context.Context, d *repository.Delivery) error
+		ClaimDueDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]repository.Delivery, error)
+		UpdateDelivery(ctx context.Context, d *repository.Delivery) error
+		SelectDeliveriesByEndpointID(ctx context.Context, endpointID string, limit int) ([]repository.Delivery, error)
+		FailDelivery(ctx context.Context, d *repository.Delivery, dl repository.DeadLetter) error
+	}
+
+	httpClient interface {
+		Do(req *http.Request) (*http.Response, error)
+	}
+)
+
+type ServiceOption func(*DefaultService)
+
+// WithHTTPClient sets the client used to deliver webhooks
+func WithHTTPClient(client httpClient) ServiceOption {
+	return func(s *DefaultService) {
+		s.client = client
+	}
+}
+
+// WithRetryPolicy sets the maximum number of delivery attempts and the exponential backoff bounds
+func WithRetryPolicy(maxAttempts int, baseBackoff, maxBackoff time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.maxAttempts = maxAttempts
+		s.baseBackoff = baseBackoff
+		s.maxBackoff = maxBackoff
+	}
+}
+
+// WithPollInterval sets how often Run looks for due deliveries
+func WithPollInterval(interval time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.pollInterval = interval
+	}
+}
+
+// WithBatchSize sets how many deliveries a single dispatch pass claims
+func WithBatchSize(size int) ServiceOption {
+	return func(s *DefaultService) {
+		s.batchSize = size
+	}
+}
+
+type DefaultService struct {
+	logger       *zap.Logger
+	repo         repo
+	client       httpClient
+	maxAttempts  int
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+	pollInterval time.Duration
+	batchSize    int
+	claimLease   time.Duration
+	now          func() time.Time
+}
+
+// New instantiates a new webhooks service
+func New(logger *zap.Logger, repo repo, opts ...ServiceOption) *DefaultService {
+	service := DefaultService{
+		logger:       logger,
+		repo:         repo,
+		client:       &http.Client{Timeout: defaultRequestTimeout},
+		maxAttempts:  defaultMaxAttempts,
+		baseBackoff:  defaultBaseBackoff,
+		maxBackoff:   defaultMaxBackoff,
+		pollInterval: defaultPollInterval,
+		batchSize:    defaultBatchSize,
+		claimLease:   defaultClaimLease,
+		now:          func() time.Time { return time.Now().UTC() },
+	}
+
+	for _, opt := range opts {
+		opt(&service)
+	}
+	return &service
+}
+
+// RegisterEndpoint registers an endpoint to receive user events
+func (s *DefaultService) RegisterEndpoint(ctx context.Context, in RegisterEndpointInput) (*Endpoint, error) {
+	if err := in.validate(); err != nil {
+		return nil, fmt.Errorf("could not validate register endpoint input: %w", err)
+	}
+
+	secret := in.Secret
+	if secret == "" {
+		generated, err := generateSecret()
+		if err != nil {
+			return nil, fmt.Errorf("could not generate secret: %s", err)
+		}
+		secret = generated
+	}
+
+	inserted, err := s.repo.InsertEndpoint(ctx, &repository.Endpoint{
+		ID:        uuid.NewString(),
+		URL:       in.URL,
+		Secret:    secret,
+		Events:    joinEvents(in.Events),
+		CreatedAt: s.now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not insert endpoint: %s", err)
+	}
+	return newEndpointFromRepository(inserted), nil
+}
+
+// DeleteEndpoint deletes an endpoint and its delivery history
+func (s *DefaultService) DeleteEndpoint(ctx context.Context, id string) error {
+	if err := validate.ID(id); err != nil {
+		return fmt.Errorf("could not validate id: %w", err)
+	}
+
+	if err := s.repo.DeleteEndpointByID(ctx, id); err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return errEndpointNotFound
+		}
+		return fmt.Errorf("could not delete endpoint by id: %s", err)
+	}
+	return nil
+}
+
+// ListDeliveries returns the most recent deliveries of an endpoint
+func (s *DefaultService) ListDeliveries(ctx context.Context, endpointID string) ([]Delivery, error) {
+	if err := validate.ID(endpointID); err != nil {
+		return nil, fmt.Errorf("could not validate id: %w", err)
+	}
+
+	storageDeliveries, err := s.repo.SelectDeliveriesByEndpointID(ctx, endpointID, deliveryHistoryLimit)
+	if err != nil {
+		return nil, fmt.Errorf("could not select deliveries by endpoint id: %s", err)
+	}
+
+	deliveries := make([]Delivery, 0, len(storageDeliveries))
+	for i := range storageDeliveries {
+		deliveries = append(deliveries, newDeliveryFromRepository(&storageDeliveries[i]))
+	}
+	return deliveries, nil
+}
+
+// Publish schedules the delivery of a user event to every subscribed endpoint.
+// It satisfies the users service event publisher, see users.WithEventPublisher.
+func (s *DefaultService) Publish(ctx context.Context, event users.Event) error {
+	endpoints, err := s.repo.SelectEndpointsByEvent(ctx, string(event.Type))
+	if err != nil {
+		return fmt.Errorf("could not select endpoints by event: %s", err)
+	}
+
+	now := s.now()
+
+	for _, endpoint := range endpoints {
+		deliveryID := uuid.NewString()
+
+		body, err := json.Marshal(payload{
+			ID:        deliveryID,
+			Type:      event.Type,
+			CreatedAt: event.OccurredAt,
+			Data:      payloadData{UserID: event.UserID},
+		})
+		if err != nil {
+			return fmt.Errorf("could not marshal payload: %s", err)
+		}
+
+		if err := s.repo.InsertDelivery(ctx, &repository.Delivery{
+			ID:            deliveryID,
+			EndpointID:    endpoint.ID,
+			EventType:     string(event.Type),
+			Payload:       string(body),
+			Status:        string(DeliveryStatusPending),
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}); err != nil {
+			return fmt.Errorf("could not insert delivery: %s", err)
+		}
+	}
+	return nil
+}
+
+// Run dispatches due deliveries every poll interval until the context is canceled
+func (s *DefaultService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Dispatch(ctx); err != nil {
+			s.logger.Error("could not dispatch webhook deliveries", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch attempts every due delivery once and returns how many were attempted.
+// Failed deliveries are rescheduled with exponential backoff, and moved to the
+// dead letter table once the maximum number of attempts is reached.
+func (s *DefaultService) Dispatch(ctx context.Context) (int, error) {
+	now := s.now()
+
+	claimed, err := s.repo.ClaimDueDeliveries(ctx, now, now.Add(s.claimLease), s.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("could not claim due deliveries: %s", err)
+	}
+
+	endpoints := make(map[string]*repository.Endpoint)
+
+	for i := range claimed {
+		delivery := &claimed[i]
+
+		endpoint, ok := endpoints[delivery.EndpointID]
+		if !ok {
+			endpoint, err = s.repo.SelectEndpointByID(ctx, delivery.EndpointID)
+			if err != nil {
+				return i, fmt.Errorf("could not select endpoint by id: %s", err)
+			}
+			endpoints[delivery.EndpointID] = endpoint
+		}
+
+		// The endpoint was deleted after the delivery was claimed
+		if endpoint == nil {
+			continue
+		}
+
+		statusCode, deliverErr := s.deliver(ctx, endpoint, delivery)
+
+		if err := s.recordAttempt(ctx, delivery, statusCode, deliverErr); err != nil {
+			return i, fmt.Errorf("could not record delivery attempt: %s", err)
+		}
+	}
+	return len(claimed), nil
+}
+
+// deliver posts a signed delivery payload to its endpoint
+func (s *DefaultService) deliver(ctx context.Context, endpoint *repository.Endpoint, delivery *repository.Delivery) (int, error) {
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("could not create request: %s", err)
+	}
+
+	timestamp := s.now()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(HeaderEventID, delivery.ID)
+	req.Header.Set(HeaderEventType, delivery.EventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(endpoint.Secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not send request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyLen))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, respBody)
+	}
+	return resp.StatusCode, nil
+}
+
+// recordAttempt updates a delivery after an attempt, rescheduling or dead lettering failures
+func (s *DefaultService) recordAttempt(ctx context.Context, delivery *repository.Delivery, statusCode int, deliverErr error) error {
+	now := s.now()
+
+	delivery.Attempts++
+	delivery.LastAttemptAt = sql.NullTime{Time: now, Valid: true}
+	delivery.LastStatusCode = sql.NullInt64{Int64: int64(statusCode), Valid: statusCode != 0}
+	delivery.UpdatedAt = now
+
+	switch {
+	case deliverErr == nil:
+		delivery.Status = string(DeliveryStatusDelivered)
+		delivery.LastError = sql.NullString{}
+	case delivery.Attempts >= s.maxAttempts:
+		delivery.Status = string(DeliveryStatusFailed)
+		delivery.LastError = sql.NullString{String: deliverErr.Error(), Valid: true}
+	default:
+		delivery.NextAttemptAt = now.Add(backoff.Exponential(s.baseBackoff, s.maxBackoff, delivery.Attempts))
+		delivery.LastError = sql.NullString{String: deliverErr.Error(), Valid: true}
+	}
+
+	if delivery.Status != string(DeliveryStatusFailed) {
+		if err := s.repo.UpdateDelivery(ctx, delivery); err != nil {
+			return fmt.Errorf("could not update delivery: %s", err)
+		}
+		return nil
+	}
+
+	if err := s.repo.FailDelivery(ctx, delivery, repository.DeadLetter{
+		DeliveryID: delivery.ID,
+		EndpointID: delivery.EndpointID,
+		Payload:    delivery.Payload,
+		LastError:  delivery.LastError.String,
+		FailedAt:   now,
+	}); err != nil {
+		return fmt.Errorf("could not move delivery to dead letters: %s", err)
+	}
+
+	s.logger.Warn("webhook delivery moved to dead letters",
+		zap.String("delivery_id", delivery.ID), zap.String("endpoint_id", delivery.EndpointID), zap.Error(deliverErr))
+	return nil
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,341 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users"
+	"github.com/alesr/stdservices/webhooks/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	givenLogger := zap.NewNop()
+	givenRepo := &repositoryMock{}
+	givenClient := &http.Client{}
+
+	actual := New(
+		givenLogger,
+		givenRepo,
+		WithHTTPClient(givenClient),
+		WithRetryPolicy(3, time.Second, time.Minute),
+		WithPollInterval(time.Second),
+		WithBatchSize(5),
+	)
+
+	require.NotNil(t, actual)
+	assert.Equal(t, givenLogger, actual.logger)
+	assert.Equal(t, givenRepo, actual.repo)
+	assert.Equal(t, givenClient, actual.client)
+	assert.Equal(t, 3, actual.maxAttempts)
+	assert.Equal(t, time.Second, actual.baseBackoff)
+	assert.Equal(t, time.Minute, actual.maxBackoff)
+	assert.Equal(t, time.Second, actual.pollInterval)
+	assert.Equal(t, 5, actual.batchSize)
+}
+
+func TestRegisterEndpoint(t *testing.T) {
+	t.Parallel()
+
+	t.Run("secret is generated", func(t *testing.T) {
+		svc := New(zap.NewNop(), &repositoryMock{
+			insertEndpointFunc: func(ctx context.Context, e *repository.Endpoint) (*repository.Endpoint, error) {
+				assert.NotEmpty(t, e.ID)
+				assert.True(t, strings.HasPrefix(e.Secret, "whsec_"))
+				assert.Equal(t, "user.created,user.deleted", e.Events)
+				return e, nil
+			},
+		})
+
+		actual, err := svc.RegisterEndpoint(context.Background(), RegisterEndpointInput{
+			URL:    "https://partner.example.com/hooks",
+			Events: []users.EventType{users.EventUserCreated, users.EventUserDeleted},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "https://partner.example.com/hooks", actual.URL)
+		assert.Equal(t, []users.EventType{users.EventUserCreated, users.EventUserDeleted}, actual.Events)
+		assert.NotEmpty(t, actual.Secret)
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		svc := New(zap.NewNop(), &repositoryMock{})
+
+		_, err := svc.RegisterEndpoint(context.Background(), RegisterEndpointInput{URL: "invalid"})
+		assert.ErrorIs(t, err, errURLInvalid)
+	})
+
+	t.Run("insert error", func(t *testing.T) {
+		svc := New(zap.NewNop(), &repositoryMock{
+			insertEndpointFunc: func(ctx context.Context, e *repository.Endpoint) (*repository.Endpoint, error) {
+				return nil, errors.New("some error")
+			},
+		})
+
+		_, err := svc.RegisterEndpoint(context.Background(), RegisterEndpointInput{
+			URL:    "https://partner.example.com/hooks",
+			Events: []users.EventType{EventAll},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestDeleteEndpoint(t *testing.T) {
+	t.Parallel()
+
+	t.Run("endpoint not found", func(t *testing.T) {
+		svc := New(zap.NewNop(), &repositoryMock{
+			deleteEndpointByIDFunc: func(ctx context.Context, id string) error {
+				return repository.ErrRecordNotFound
+			},
+		})
+
+		err := svc.DeleteEndpoint(context.Background(), uuid.NewString())
+		assert.Equal(t, errEndpointNotFound, err)
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		svc := New(zap.NewNop(), &repositoryMock{})
+
+		err := svc.DeleteEndpoint(context.Background(), "%invalid-id%")
+		assert.Error(t, err)
+	})
+}
+
+func TestListDeliveries(t *testing.T) {
+	t.Parallel()
+
+	givenEndpointID := uuid.NewString()
+	givenTime := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	svc := New(zap.NewNop(), &repositoryMock{
+		selectDeliveriesByEndpointIDFunc: func(ctx context.Context, endpointID string, limit int) ([]repository.Delivery, error) {
+			assert.Equal(t, givenEndpointID, endpointID)
+			assert.Equal(t, deliveryHistoryLimit, limit)
+
+			return []repository.Delivery{
+				{
+					ID:         "1",
+					EndpointID: endpointID,
+					EventType:  string(users.EventUserCreated),
+					Status:     string(DeliveryStatusDelivered),
+					Attempts:   2,
+				},
+			}, nil
+		},
+	})
+	svc.now = func() time.Time { return givenTime }
+
+	actual, err := svc.ListDeliveries(context.Background(), givenEndpointID)
+	require.NoError(t, err)
+	require.Len(t, actual, 1)
+
+	assert.Equal(t, DeliveryStatusDelivered, actual[0].Status)
+	assert.Equal(t, users.EventUserCreated, actual[0].EventType)
+	assert.Equal(t, 2, actual[0].Attempts)
+	assert.Nil(t, actual[0].LastAttemptAt)
+}
+
+func TestPublish(t *testing.T) {
+	t.Parallel()
+
+	givenEvent := users.Event{
+		Type:       users.EventUserCreated,
+		UserID:     uuid.NewString(),
+		OccurredAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	var inserted []*repository.Delivery
+
+	svc := New(zap.NewNop(), &repositoryMock{
+		selectEndpointsByEventFunc: func(ctx context.Context, eventType string) ([]repository.Endpoint, error) {
+			assert.Equal(t, string(users.EventUserCreated), eventType)
+			return []repository.Endpoint{{ID: "a"}, {ID: "b"}}, nil
+		},
+		insertDeliveryFunc: func(ctx context.Context, d *repository.Delivery) error {
+			inserted = append(inserted, d)
+			return nil
+		},
+	})
+
+	err := svc.Publish(context.Background(), givenEvent)
+	require.NoError(t, err)
+	require.Len(t, inserted, 2)
+
+	for _, d := range inserted {
+		assert.Equal(t, string(DeliveryStatusPending), d.Status)
+
+		var p payload
+		require.NoError(t, json.Unmarshal([]byte(d.Payload), &p))
+
+		assert.Equal(t, d.ID, p.ID)
+		assert.Equal(t, givenEvent.Type, p.Type)
+		assert.Equal(t, givenEvent.UserID, p.Data.UserID)
+		assert.True(t, givenEvent.OccurredAt.Equal(p.CreatedAt))
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	t.Parallel()
+
+	givenSecret := "whsec_0123456789abcdef"
+	givenNow := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	newDelivery := func(endpointID string, attempts int) repository.Delivery {
+		return repository.Delivery{
+			ID:            uuid.NewString(),
+			EndpointID:    endpointID,
+			EventType:     string(users.EventUserCreated),
+			Payload:       `{"type":"user.created"}`,
+			Status:        string(DeliveryStatusPending),
+			Attempts:      attempts,
+			NextAttemptAt: givenNow,
+		}
+	}
+
+	t.Run("signed payload is delivered", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			assert.Equal(t, string(users.EventUserCreated), r.Header.Get(HeaderEventType))
+
+			err = Verify(givenSecret, r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature), body, time.Minute, givenNow)
+			assert.NoError(t, err)
+
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		var updated *repository.Delivery
+
+		svc := newDispatchTestService(server, givenNow, givenSecret, newDelivery("endpoint", 0), &updated, nil)
+
+		n, err := svc.Dispatch(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		require.NotNil(t, updated)
+		assert.Equal(t, string(DeliveryStatusDelivered), updated.Status)
+		assert.Equal(t, 1, updated.Attempts)
+		assert.Equal(t, int64(http.StatusNoContent), updated.LastStatusCode.Int64)
+		assert.False(t, updated.LastError.Valid)
+	})
+
+	t.Run("failed delivery is rescheduled with backoff", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		var updated *repository.Delivery
+
+		svc := newDispatchTestService(server, givenNow, givenSecret, newDelivery("endpoint", 1), &updated, func(in repository.DeadLetter) {
+			t.Fatal("unexpected dead letter")
+		})
+
+		_, err := svc.Dispatch(context.Background())
+		require.NoError(t, err)
+
+		require.NotNil(t, updated)
+		assert.Equal(t, string(DeliveryStatusPending), updated.Status)
+		assert.Equal(t, 2, updated.Attempts)
+		assert.Equal(t, givenNow.Add(2*time.Second), updated.NextAttemptAt)
+		assert.Equal(t, int64(http.StatusInternalServerError), updated.LastStatusCode.Int64)
+		assert.Contains(t, updated.LastError.String, "boom")
+	})
+
+	t.Run("exhausted delivery is dead lettered", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		var (
+			updated    *repository.Delivery
+			deadLetter *repository.DeadLetter
+		)
+
+		delivery := newDelivery("endpoint", 2)
+
+		svc := newDispatchTestService(server, givenNow, givenSecret, delivery, &updated, func(in repository.DeadLetter) {
+			deadLetter = &in
+		})
+
+		_, err := svc.Dispatch(context.Background())
+		require.NoError(t, err)
+
+		require.NotNil(t, updated)
+		assert.Equal(t, string(DeliveryStatusFailed), updated.Status)
+		assert.Equal(t, 3, updated.Attempts)
+
+		require.NotNil(t, deadLetter)
+		assert.Equal(t, delivery.ID, deadLetter.DeliveryID)
+		assert.Equal(t, delivery.Payload, deadLetter.Payload)
+		assert.NotEmpty(t, deadLetter.LastError)
+	})
+
+	t.Run("deleted endpoint is skipped", func(t *testing.T) {
+		svc := New(zap.NewNop(), &repositoryMock{
+			claimDueDeliveriesFunc: func(ctx context.Context, now, leaseUntil time.Time, limit int) ([]repository.Delivery, error) {
+				return []repository.Delivery{newDelivery("endpoint", 0)}, nil
+			},
+			selectEndpointByIDFunc: func(ctx context.Context, id string) (*repository.Endpoint, error) {
+				return nil, nil
+			},
+		})
+
+		n, err := svc.Dispatch(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+}
+
+func newDispatchTestService(
+	server *httptest.Server,
+	now time.Time,
+	secret string,
+	delivery repository.Delivery,
+	updated **repository.Delivery,
+	onDeadLetter func(in repository.DeadLetter),
+) *DefaultService {
+	svc := New(
+		zap.NewNop(),
+		&repositoryMock{
+			claimDueDeliveriesFunc: func(ctx context.Context, now, leaseUntil time.Time, limit int) ([]repository.Delivery, error) {
+				return []repository.Delivery{delivery}, nil
+			},
+			selectEndpointByIDFunc: func(ctx context.Context, id string) (*repository.Endpoint, error) {
+				return &repository.Endpoint{ID: id, URL: server.URL, Secret: secret, Events: "*"}, nil
+			},
+			updateDeliveryFunc: func(ctx context.Context, d *repository.Delivery) error {
+				*updated = d
+				return nil
+			},
+			failDeliveryFunc: func(ctx context.Context, d *repository.Delivery, dl repository.DeadLetter) error {
+				*updated = d
+				if onDeadLetter != nil {
+					onDeadLetter(dl)
+				}
+				return nil
+			},
+		},
+		WithHTTPClient(server.Client()),
+		WithRetryPolicy(3, time.Second, time.Minute),
+	)
+	svc.now = func() time.Time { return now }
+	return svc
+}